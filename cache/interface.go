@@ -25,6 +25,8 @@ import (
 var cache Cache
 
 type Cache interface {
+	Ping() error
+
 	Set(key string, value []byte, ttl int64) error
 
 	Get(key string) ([]byte, error)
@@ -61,6 +63,19 @@ type Cache interface {
 
 	ZRange(key string, start, stop int64, withScores bool) ([][]byte, error)
 	ZRemRangeByScore(key string, start, stop int64) (int64, error)
+
+	XAdd(key string, maxLen int64, value []byte) (string, error)
+	XGroupCreate(key, group, startId string) error
+	XReadGroup(key, group, consumer, id string, count int64) ([]string, [][]byte, error)
+	XAck(key, group string, ids ...string) (int64, error)
+	XRange(key, start, end string, count int64) ([]string, [][]byte, error)
+	XPending(key, group, id string) (int64, error)
+	XClaim(key, group, consumer string, minIdle int64, count int64) ([]string, error)
+
+	Eval(script string, keys []string, args ...interface{}) (interface{}, error)
+
+	Publish(channel string, message []byte) error
+	Subscribe(channels []string, handle func(channel string, message []byte)) (func(), error)
 }
 
 func NewCache(cfg interface{}) {
@@ -69,6 +84,7 @@ func NewCache(cfg interface{}) {
 	cache = redisCache
 }
 
+func Ping() error                                   { return cache.Ping() }
 func Set(key string, value []byte, ttl int64) error { return cache.Set(key, value, ttl) }
 func Get(key string) ([]byte, error)                { return cache.Get(key) }
 func Del(key string) error                          { return cache.Del(key) }
@@ -128,3 +144,47 @@ func ZRange(key string, start, stop int64, withScores bool) ([][]byte, error) {
 func ZRemRangeByScore(key string, start, stop int64) (int64, error) {
 	return cache.ZRemRangeByScore(key, start, stop)
 }
+
+func XAdd(key string, maxLen int64, value []byte) (string, error) {
+	return cache.XAdd(key, maxLen, value)
+}
+func XGroupCreate(key, group, startId string) error {
+	return cache.XGroupCreate(key, group, startId)
+}
+func XReadGroup(key, group, consumer, id string, count int64) ([]string, [][]byte, error) {
+	return cache.XReadGroup(key, group, consumer, id, count)
+}
+func XAck(key, group string, ids ...string) (int64, error) {
+	return cache.XAck(key, group, ids...)
+}
+func XRange(key, start, end string, count int64) ([]string, [][]byte, error) {
+	return cache.XRange(key, start, end, count)
+}
+
+// XPending returns how many times the pending message id has been delivered to the consumers of group,
+// it's 0 if the message isn't pending
+func XPending(key, group, id string) (int64, error) {
+	return cache.XPending(key, group, id)
+}
+
+// XClaim takes over the pending messages of the other consumers in the group which have been idle for minIdle
+// milliseconds, the ids claimed are returned
+func XClaim(key, group, consumer string, minIdle int64, count int64) ([]string, error) {
+	return cache.XClaim(key, group, consumer, minIdle, count)
+}
+
+// Eval runs the lua script atomically, the script is cached by the server after the first call
+func Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	return cache.Eval(script, keys, args...)
+}
+
+// Publish sends the message to all the subscribers of channel, it isn't kept if there is no subscriber
+func Publish(channel string, message []byte) error {
+	return cache.Publish(channel, message)
+}
+
+// Subscribe handles the messages of channels in a goroutine until the returned func is called,
+// the channels are subscribed again after the connection is broken
+func Subscribe(channels []string, handle func(channel string, message []byte)) (func(), error) {
+	return cache.Subscribe(channels, handle)
+}
@@ -23,7 +23,10 @@ import (
 	"fmt"
 	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/metrics"
 	"github.com/garyburd/redigo/redis"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -54,15 +57,35 @@ func (impl *RedisCacheImpl) Initialize(cfg interface{}) {
 			}
 
 			if err != nil {
-				log.Fatal(err.Error())
+				log.Errorf("redis,dial err:%s", err.Error())
 				return nil, err
 			}
 
-			return c, nil
+			return &timedConn{Conn: c}, nil
 		},
 	}
 }
 
+// timedConn observes the latency of the commands in metrics
+type timedConn struct {
+	redis.Conn
+}
+
+func (c *timedConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	//the pool flushes the connection by the empty command
+	if "" == commandName {
+		return c.Conn.Do(commandName, args...)
+	}
+	start := time.Now()
+	reply, err := c.Conn.Do(commandName, args...)
+	command := strings.ToLower(commandName)
+	metrics.ObserveSince(metrics.RedisDuration.WithLabelValues(command), start)
+	if nil != err {
+		metrics.RedisErrors.WithLabelValues(command).Inc()
+	}
+	return reply, err
+}
+
 func (impl *RedisCacheImpl) Get(key string) ([]byte, error) {
 	//log.Info("[REDIS-GET] key : " + key)
 
@@ -84,6 +107,15 @@ func (impl *RedisCacheImpl) Get(key string) ([]byte, error) {
 	}
 }
 
+// Ping checks whether the redis server is reachable
+func (impl *RedisCacheImpl) Ping() error {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("ping")
+	return err
+}
+
 func (impl *RedisCacheImpl) Exists(key string) (bool, error) {
 
 	//log.Info("[REDIS-Exists] key : " + key)
@@ -507,3 +539,274 @@ func (impl *RedisCacheImpl) SMembers(key string) ([][]byte, error) {
 	}
 	return res, err
 }
+
+func (impl *RedisCacheImpl) XAdd(key string, maxLen int64, value []byte) (string, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	vs := []interface{}{key}
+	if maxLen > 0 {
+		vs = append(vs, "MAXLEN", "~", maxLen)
+	}
+	vs = append(vs, "*", streamDataField, value)
+	reply, err := redis.String(conn.Do("xadd", vs...))
+	if nil != err {
+		log.Errorf(" key:%s, err:%s", key, err.Error())
+	}
+	return reply, err
+}
+
+func (impl *RedisCacheImpl) XGroupCreate(key, group, startId string) error {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("xgroup", "CREATE", key, group, startId, "MKSTREAM")
+	if nil != err && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	if nil != err {
+		log.Errorf(" key:%s, group:%s, err:%s", key, group, err.Error())
+	}
+	return err
+}
+
+func (impl *RedisCacheImpl) XReadGroup(key, group, consumer, id string, count int64) ([]string, [][]byte, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("xreadgroup", "GROUP", group, consumer, "COUNT", count, "STREAMS", key, id)
+	if nil != err {
+		log.Errorf(" key:%s, group:%s, err:%s", key, group, err.Error())
+		return []string{}, [][]byte{}, err
+	} else if nil == reply {
+		return []string{}, [][]byte{}, nil
+	}
+	//reply: [[key, [[id, [field, value]], ...]]]
+	streams := reply.([]interface{})
+	if len(streams) <= 0 {
+		return []string{}, [][]byte{}, nil
+	}
+	stream := streams[0].([]interface{})
+	return parseStreamEntries(stream[1])
+}
+
+func (impl *RedisCacheImpl) XAck(key, group string, ids ...string) (int64, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	vs := []interface{}{key, group}
+	for _, id := range ids {
+		vs = append(vs, id)
+	}
+	reply, err := redis.Int64(conn.Do("xack", vs...))
+	if nil != err {
+		log.Errorf(" key:%s, group:%s, err:%s", key, group, err.Error())
+	}
+	return reply, err
+}
+
+func (impl *RedisCacheImpl) XRange(key, start, end string, count int64) ([]string, [][]byte, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("xrange", key, start, end, "COUNT", count)
+	if nil != err {
+		log.Errorf(" key:%s, err:%s", key, err.Error())
+		return []string{}, [][]byte{}, err
+	}
+	return parseStreamEntries(reply)
+}
+
+func (impl *RedisCacheImpl) XPending(key, group, id string) (int64, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	//pending: [[id, consumer, idle milliseconds, delivered count]]
+	pending, err := redis.Values(conn.Do("xpending", key, group, id, id, 1))
+	if nil != err {
+		log.Errorf(" key:%s, group:%s, err:%s", key, group, err.Error())
+		return 0, err
+	}
+	if len(pending) <= 0 {
+		return 0, nil
+	}
+	fields, err := redis.Values(pending[0], nil)
+	if nil != err || len(fields) < 4 {
+		return 0, errors.New("invalid pending entry")
+	}
+	return redis.Int64(fields[3], nil)
+}
+
+func (impl *RedisCacheImpl) XClaim(key, group, consumer string, minIdle int64, count int64) ([]string, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	//pending: [[id, consumer, idle milliseconds, delivered count], ...]
+	pending, err := redis.Values(conn.Do("xpending", key, group, "-", "+", count))
+	if nil != err {
+		log.Errorf(" key:%s, group:%s, err:%s", key, group, err.Error())
+		return []string{}, err
+	}
+	vs := []interface{}{key, group, consumer, minIdle}
+	for _, entry := range pending {
+		fields, err := redis.Values(entry, nil)
+		if nil != err || len(fields) < 3 {
+			return []string{}, errors.New("invalid pending entry")
+		}
+		id, _ := redis.String(fields[0], nil)
+		owner, _ := redis.String(fields[1], nil)
+		idle, _ := redis.Int64(fields[2], nil)
+		if owner != consumer && idle >= minIdle {
+			vs = append(vs, id)
+		}
+	}
+	if len(vs) <= 4 {
+		return []string{}, nil
+	}
+	vs = append(vs, "JUSTID")
+	ids, err := redis.Strings(conn.Do("xclaim", vs...))
+	if nil != err {
+		log.Errorf(" key:%s, group:%s, err:%s", key, group, err.Error())
+	}
+	return ids, err
+}
+
+const streamDataField = "data"
+
+//entries: [[id, [field, value, ...]], ...], only the value of streamDataField is returned
+func parseStreamEntries(reply interface{}) ([]string, [][]byte, error) {
+	ids := []string{}
+	values := [][]byte{}
+	if nil == reply {
+		return ids, values, nil
+	}
+	entries, err := redis.Values(reply, nil)
+	if nil != err {
+		return ids, values, err
+	}
+	for _, entry := range entries {
+		fields, err := redis.Values(entry, nil)
+		if nil != err || len(fields) < 2 {
+			return ids, values, errors.New("invalid stream entry")
+		}
+		id, _ := redis.String(fields[0], nil)
+		//the entry has been deleted when it is pending, but the id is still returned
+		if nil == fields[1] {
+			ids = append(ids, id)
+			values = append(values, []byte{})
+			continue
+		}
+		kvs, err := redis.ByteSlices(fields[1], nil)
+		if nil != err {
+			return ids, values, err
+		}
+		var value []byte
+		for i := 0; i+1 < len(kvs); i += 2 {
+			if string(kvs[i]) == streamDataField {
+				value = kvs[i+1]
+			}
+		}
+		ids = append(ids, id)
+		values = append(values, value)
+	}
+	return ids, values, nil
+}
+
+func (impl *RedisCacheImpl) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	keysAndArgs := []interface{}{}
+	for _, key := range keys {
+		keysAndArgs = append(keysAndArgs, key)
+	}
+	keysAndArgs = append(keysAndArgs, args...)
+	reply, err := redis.NewScript(len(keys), script).Do(conn, keysAndArgs...)
+	if nil != err {
+		log.Errorf(" keys:%v, err:%s", keys, err.Error())
+	}
+	return reply, err
+}
+
+func (impl *RedisCacheImpl) Publish(channel string, message []byte) error {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("publish", channel, message)
+	if nil != err {
+		log.Errorf(" channel:%s, err:%s", channel, err.Error())
+	}
+	return err
+}
+
+const resubscribeInterval = time.Second
+
+// Subscribe holds a connection of the pool for the channels, the messages published while it is reconnecting are lost
+func (impl *RedisCacheImpl) Subscribe(channels []string, handle func(channel string, message []byte)) (func(), error) {
+	vs := []interface{}{}
+	for _, channel := range channels {
+		vs = append(vs, channel)
+	}
+	psc, err := impl.subscribe(vs)
+	if nil != err {
+		return nil, err
+	}
+
+	var mtx sync.Mutex
+	stopChan := make(chan struct{})
+	go func() {
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				handle(v.Channel, v.Data)
+			case error:
+				psc.Close()
+				select {
+				case <-stopChan:
+					return
+				default:
+				}
+				log.Errorf("redis,subscribe channels:%v, err:%s", channels, v.Error())
+				for {
+					select {
+					case <-stopChan:
+						return
+					case <-time.After(resubscribeInterval):
+					}
+					next, err := impl.subscribe(vs)
+					if nil != err {
+						continue
+					}
+					mtx.Lock()
+					select {
+					case <-stopChan:
+						mtx.Unlock()
+						next.Close()
+						return
+					default:
+					}
+					psc = next
+					mtx.Unlock()
+					break
+				}
+			}
+		}
+	}()
+
+	return func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		close(stopChan)
+		psc.Close()
+	}, nil
+}
+
+func (impl *RedisCacheImpl) subscribe(channels []interface{}) (redis.PubSubConn, error) {
+	psc := redis.PubSubConn{Conn: impl.pool.Get()}
+	if err := psc.Subscribe(channels...); nil != err {
+		log.Errorf(" channels:%v, err:%s", channels, err.Error())
+		psc.Close()
+		return psc, err
+	}
+	return psc, nil
+}
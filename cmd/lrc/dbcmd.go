@@ -0,0 +1,129 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Loopring/relay/cmd/utils"
+	"github.com/Loopring/relay/dao"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func dbCommands() cli.Command {
+	configFlag := cli.StringFlag{
+		Name:  "config,c",
+		Usage: "config file",
+	}
+	c := cli.Command{
+		Name:     "db",
+		Usage:    "manage the schema of database",
+		Category: "database commands:",
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:   "migrate",
+				Usage:  "apply the pending migrations",
+				Action: migrateDb,
+				Flags: []cli.Flag{
+					configFlag,
+					cli.IntFlag{
+						Name:  "to",
+						Usage: "the version migrated to, all the pending migrations are applied if it's 0",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "rollback",
+				Usage:  "revert the latest migrations, the first one which creates the tables can't be reverted",
+				Action: rollbackDb,
+				Flags: []cli.Flag{
+					configFlag,
+					cli.IntFlag{
+						Name:  "steps",
+						Usage: "the number of migrations to revert",
+						Value: 1,
+					},
+				},
+			},
+			cli.Command{
+				Name:   "status",
+				Usage:  "list the migrations and whether they have been applied",
+				Action: dbStatus,
+				Flags: []cli.Flag{
+					configFlag,
+				},
+			},
+		},
+	}
+	return c
+}
+
+func newRdsService(ctx *cli.Context) *dao.RdsServiceImpl {
+	globalConfig := utils.SetGlobalConfig(ctx)
+	return dao.NewRdsService(globalConfig.Mysql)
+}
+
+func migrateDb(ctx *cli.Context) {
+	rds := newRdsService(ctx)
+	done, err := rds.MigrateUp(ctx.Int("to"))
+	for _, m := range done {
+		fmt.Fprintf(ctx.App.Writer, "migrated up to %d %s \n", m.Version, m.Name)
+	}
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	if len(done) == 0 {
+		fmt.Fprintf(ctx.App.Writer, "no pending migrations \n")
+	}
+}
+
+func rollbackDb(ctx *cli.Context) {
+	steps := ctx.Int("steps")
+	if steps <= 0 {
+		utils.ExitWithErr(ctx.App.Writer, errors.New("steps must be greater than 0"))
+	}
+	rds := newRdsService(ctx)
+	done, err := rds.MigrateDown(steps)
+	for _, m := range done {
+		fmt.Fprintf(ctx.App.Writer, "migrated down from %d %s \n", m.Version, m.Name)
+	}
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+}
+
+func dbStatus(ctx *cli.Context) {
+	rds := newRdsService(ctx)
+	list, err := rds.MigrationStatus()
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	for _, status := range list {
+		appliedAt := "pending"
+		if status.Applied {
+			appliedAt = "applied at " + time.Unix(status.AppliedAt, 0).Format(time.RFC3339)
+		}
+		fmt.Fprintf(ctx.App.Writer, "%d\t%s\t%s \n", status.Version, status.Name, appliedAt)
+	}
+	if err := rds.CheckSchemaVersion(); nil != err && dao.ErrPendingMigrations != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+}
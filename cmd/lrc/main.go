@@ -41,6 +41,9 @@ func main() {
 
 	app.Commands = []cli.Command{
 		accountCommands(),
+		dbCommands(),
+		orderAuthCommands(),
+		trendCommands(),
 	}
 
 	sort.Sort(cli.CommandsByName(app.Commands))
@@ -19,11 +19,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Loopring/relay/cmd/utils"
 	"github.com/Loopring/relay/config"
@@ -49,18 +52,33 @@ func startNode(ctx *cli.Context) error {
 
 	var n *node.Node
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
-	signal.Notify(signalChan, os.Kill)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for {
-			select {
-			case sig := <-signalChan:
-				log.Infof("captured %s, exiting...\n", sig.String())
-				if nil != n {
-					n.Stop()
-				}
-				os.Exit(1)
+		sig := <-signalChan
+		if nil == n {
+			log.Infof("captured %s, exiting...", sig.String())
+			os.Exit(1)
+		}
+		//exits immediately if it's captured again while stopping
+		go func() {
+			sig := <-signalChan
+			log.Infof("captured %s again, exiting...", sig.String())
+			os.Exit(1)
+		}()
+
+		timeout := time.Duration(globalConfig.ShutdownTimeout) * time.Second
+		if timeout <= 0 {
+			timeout = node.DEFAULT_SHUTDOWN_TIMEOUT
+		}
+		log.Infof("captured %s, stopping in %s...", sig.String(), timeout.String())
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := n.Stop(ctx); nil != err {
+			log.Errorf("failed to stop node gracefully, err:%s", err.Error())
+			if nil != logger {
+				logger.Sync()
 			}
+			os.Exit(1)
 		}
 	}()
 
@@ -73,6 +91,7 @@ func startNode(ctx *cli.Context) error {
 	log.Info("started")
 
 	n.Wait()
+	log.Info("stopped")
 	return nil
 }
 
@@ -0,0 +1,201 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Loopring/relay/cmd/utils"
+	"github.com/Loopring/relay/crypto"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/node"
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const orderAuthBatchSize = 500
+
+func orderAuthCommands() cli.Command {
+	c := cli.Command{
+		Name:     "orderauth",
+		Usage:    "manage the auth private keys of orders saved in mysql",
+		Category: "order auth commands:",
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:   "migrate",
+				Usage:  "seal the plaintext auth private keys with the master key in config",
+				Action: migrateOrderAuthKeys,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "config,c",
+						Usage: "config file",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "rekey",
+				Usage:  "seal the auth private keys again with the master key in config",
+				Action: rekeyOrderAuthKeys,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "config,c",
+						Usage: "config file",
+					},
+					cli.StringFlag{
+						Name:  "old-key-file",
+						Usage: "the file of old master key",
+					},
+					cli.StringFlag{
+						Name:  "old-keystore-account",
+						Usage: "the keystore account of old master key",
+					},
+					cli.StringFlag{
+						Name:  "old-passphrase",
+						Usage: "passphrase of the old keystore account",
+					},
+				},
+			},
+		},
+	}
+	return c
+}
+
+func migrateOrderAuthKeys(ctx *cli.Context) {
+	rds, newCipher := prepareOrderAuth(ctx)
+	resealOrderAuthKeys(ctx, rds, nil, newCipher)
+}
+
+func rekeyOrderAuthKeys(ctx *cli.Context) {
+	rds, newCipher := prepareOrderAuth(ctx)
+
+	var (
+		oldKey []byte
+		err    error
+	)
+	if file := ctx.String("old-key-file"); "" != file {
+		oldKey, err = crypto.LoadMasterKeyFile(file)
+	} else if account := ctx.String("old-keystore-account"); "" != account {
+		if !common.IsHexAddress(account) {
+			utils.ExitWithErr(ctx.App.Writer, fmt.Errorf("invalid keystore account:%s", account))
+		}
+		globalConfig := utils.SetGlobalConfig(ctx)
+		passphrase := ctx.String("old-passphrase")
+		if "" == passphrase {
+			if passphrase, err = getPassphraseFromTeminal(false, ctx.App.Writer); nil != err {
+				utils.ExitWithErr(ctx.App.Writer, err)
+			}
+		}
+		oldKey, err = crypto.LoadKeystoreMasterKey(globalConfig.Keystore.Keydir, common.HexToAddress(account), passphrase)
+	} else {
+		err = errors.New("old-key-file or old-keystore-account is required")
+	}
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+
+	oldCipher, err := crypto.NewEnvelopeCipher(oldKey)
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	if oldCipher.KeyId() == newCipher.KeyId() {
+		utils.ExitWithErr(ctx.App.Writer, errors.New("the old master key is the same as the new one"))
+	}
+	resealOrderAuthKeys(ctx, rds, oldCipher, newCipher)
+}
+
+func prepareOrderAuth(ctx *cli.Context) (*dao.RdsServiceImpl, *crypto.EnvelopeCipher) {
+	globalConfig := utils.SetGlobalConfig(ctx)
+	masterKey, err := node.LoadOrderAuthMasterKey(globalConfig)
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	if nil == masterKey {
+		utils.ExitWithErr(ctx.App.Writer, errors.New("the master key isn't configured in order_auth"))
+	}
+	c, err := crypto.NewEnvelopeCipher(masterKey)
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	crypto.InitializeAuthKeyCipher(c)
+
+	rds := dao.NewRdsService(globalConfig.Mysql)
+	if err := rds.CheckSchemaVersion(); nil != err {
+		utils.ExitWithErr(ctx.App.Writer, fmt.Errorf("%s, run `lrc db migrate` first", err.Error()))
+	}
+	return rds, c
+}
+
+// resealOrderAuthKeys seals the plaintext keys and the keys sealed by oldCipher with the cipher in crypto,
+// the keys already sealed by newCipher are skipped so it can be run again after failure.
+func resealOrderAuthKeys(ctx *cli.Context, rds *dao.RdsServiceImpl, oldCipher, newCipher *crypto.EnvelopeCipher) {
+	var sealed, skipped, failed int
+	lastId := 0
+	for {
+		orders, err := rds.GetOrdersWithAuthKey(lastId, orderAuthBatchSize)
+		if nil != err {
+			utils.ExitWithErr(ctx.App.Writer, err)
+		}
+		if len(orders) == 0 {
+			break
+		}
+
+		for _, order := range orders {
+			lastId = order.ID
+			orderHash := common.HexToHash(order.OrderHash)
+
+			var plaintext string
+			if !crypto.IsSealed(order.PrivateKey) {
+				plaintext = order.PrivateKey
+			} else if _, err := newCipher.Open(order.PrivateKey, orderHash.Bytes()); nil == err {
+				skipped++
+				continue
+			} else if nil == oldCipher {
+				fmt.Fprintf(ctx.App.Writer, "order:%s can't be opened by current master key, err:%s \n", order.OrderHash, err.Error())
+				failed++
+				continue
+			} else if key, err := oldCipher.Open(order.PrivateKey, orderHash.Bytes()); nil != err {
+				fmt.Fprintf(ctx.App.Writer, "order:%s can't be opened by old master key, err:%s \n", order.OrderHash, err.Error())
+				failed++
+				continue
+			} else {
+				plaintext = common.ToHex(key)
+			}
+
+			authKey, err := crypto.NewPrivateKeyCrypto(false, plaintext)
+			if nil != err {
+				fmt.Fprintf(ctx.App.Writer, "order:%s has invalid auth private key, err:%s \n", order.OrderHash, err.Error())
+				failed++
+				continue
+			}
+			value, err := crypto.SealAuthPrivateKey(orderHash, authKey)
+			if nil != err {
+				utils.ExitWithErr(ctx.App.Writer, err)
+			}
+			if updated, err := rds.UpdateOrderAuthKey(order.ID, order.PrivateKey, value); nil != err {
+				utils.ExitWithErr(ctx.App.Writer, err)
+			} else if updated {
+				sealed++
+			} else {
+				skipped++
+			}
+		}
+	}
+	fmt.Fprintf(ctx.App.Writer, "master key:%s, sealed:%d, skipped:%d, failed:%d \n", newCipher.KeyId(), sealed, skipped, failed)
+}
@@ -0,0 +1,110 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Loopring/relay/cmd/utils"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market"
+	"github.com/Loopring/relay/market/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func trendCommands() cli.Command {
+	c := cli.Command{
+		Name:     "trends",
+		Usage:    "manage the candles of markets",
+		Category: "trends commands:",
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:   "backfill",
+				Usage:  "rebuild the candles from the fills, such as after a fork or an outage",
+				Action: backfillCandles,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "config,c",
+						Usage: "config file",
+					},
+					cli.StringFlag{
+						Name:  "from",
+						Usage: "the time rebuilt from, unix seconds or RFC3339",
+					},
+					cli.StringFlag{
+						Name:  "to",
+						Usage: "the time rebuilt to, unix seconds or RFC3339, it's now if not set",
+					},
+					cli.StringFlag{
+						Name:  "market",
+						Usage: "the market rebuilt, such as LRC-WETH, all the markets are rebuilt if not set",
+					},
+				},
+			},
+		},
+	}
+	return c
+}
+
+func backfillCandles(ctx *cli.Context) {
+	if !ctx.IsSet("from") {
+		utils.ExitWithErr(ctx.App.Writer, errors.New("from must be set"))
+	}
+	from, err := parseTime(ctx.String("from"))
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	to := time.Now().Unix()
+	if ctx.IsSet("to") {
+		if to, err = parseTime(ctx.String("to")); nil != err {
+			utils.ExitWithErr(ctx.App.Writer, err)
+		}
+	}
+
+	globalConfig := utils.SetGlobalConfig(ctx)
+	logger := log.Initialize(globalConfig.Log)
+	defer func() {
+		if nil != logger {
+			logger.Sync()
+		}
+	}()
+	util.Initialize(globalConfig.Market)
+	rds := dao.NewRdsService(globalConfig.Mysql)
+
+	saved, err := market.BackfillCandles(rds, ctx.String("market"), from, to)
+	fmt.Fprintf(ctx.App.Writer, "%d candles rebuilt \n", saved)
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+}
+
+func parseTime(s string) (int64, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); nil == err {
+		return ts, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if nil != err {
+		return 0, fmt.Errorf("invalid time:%s, it should be unix seconds or RFC3339", s)
+	}
+	return t.Unix(), nil
+}
@@ -88,6 +88,12 @@ type GlobalConfig struct {
 	MarketCap      MarketCapOptions
 	UserManager    UserManagerOptions
 	AccountManager AccountManagerOptions
+	EventBus       EventBusOptions
+	OrderAuth      OrderAuthOptions
+	Metrics        MetricsOptions
+	Admin          AdminOptions
+
+	ShutdownTimeout int64 //seconds to wait for the services to stop after SIGTERM, default is 30
 }
 
 type AccountManagerOptions struct {
@@ -95,11 +101,33 @@ type AccountManagerOptions struct {
 }
 
 type JsonrpcOptions struct {
-	Port string
+	Port            string
+	AllowedOrigins  []string //cors is disabled if it's empty
+	AuthRequired    bool     //the anonymous requests are only allowed to read if it's true
+	SignatureWindow int64    //seconds the timestamp of a signed request may differ from the relay
+	RealIpHeader    string   //header set by the proxy in front of the relay, the remote address is used if it's empty
+	TrustedProxies  int      //the number of proxies appending to RealIpHeader in front of the relay, default is 1
+	IpRate          float64  //requests per second allowed for each anonymous ip, it isn't limited if <= 0
+	IpBurst         int64
+	ApiKeys         []ApiKeyOptions
+}
+
+type ApiKeyOptions struct {
+	Name   string
+	Key    string
+	Secret string   //the requests must be signed with it if it's set
+	Scopes []string //read, trade or admin
+	Rate   float64  //requests per second allowed for the key, it isn't limited if <= 0
+	Burst  int64
 }
 
 type WebsocketOptions struct {
-	Port string
+	Port             string   //the port of socket.io
+	StreamPort       string   //the port of the plain websocket streaming, it's disabled if empty
+	AllowedOrigins   []string //the origins allowed to connect the stream, any origin is allowed if it's empty
+	MaxSubscriptions int      //subscriptions allowed for each connection of the stream
+	PushAdapter      string   //local or redis, the pushes of socket.io are shared between the relays by redis
+	WebsocketOnly    bool     //socket.io only accepts the websocket transport, so the relays need no sticky session
 }
 
 func (c *GlobalConfig) defaultConfig() {
@@ -107,9 +135,13 @@ func (c *GlobalConfig) defaultConfig() {
 }
 
 type OrderManagerOptions struct {
-	CutoffCacheExpireTime int64
-	CutoffCacheCleanTime  int64
-	DustOrderValue        int64
+	CutoffCacheExpireTime  int64
+	CutoffCacheCleanTime   int64
+	DustOrderValue         int64
+	OrderBookCheckInterval int64 //seconds between reconciling the in-memory order book with db, it isn't checked if <= 0
+	OrderBookFromDb        bool  //the orders are read from db instead of the in-memory order book, it's always set in miner mode
+	ExpireSweepInterval    int64 //seconds between sweeping the expired orders, it isn't swept if <= 0
+	ExpireSweepBatchSize   int
 }
 
 type IpfsOptions struct {
@@ -136,7 +168,10 @@ type ExtractorOptions struct {
 	StartBlockNumber   *big.Int
 	EndBlockNumber     *big.Int
 	ConfirmBlockNumber uint64
-	ForkWaitingTime    int64
+	ReorgWindow        int //the number of the latest block headers kept to find the common ancestor of a reorg
+	PrefetchBlocks     int //the number of blocks fetched and decoded ahead of the one being dispatched
+	DecodeWorkers      int
+	MaxRetries         int //the failed transaction is dropped after retrying so many times
 	Debug              bool
 	Open               bool
 }
@@ -147,6 +182,15 @@ type KeyStoreOptions struct {
 	ScryptP int
 }
 
+// OrderAuthOptions is the master key of the order auth private keys saved in mysql,
+// it's read from MasterKeyFile, or derived from KeystoreAccount in the keystore if the file is empty.
+// The relays sharing the new orders by the event bus need it, the auth private keys are only published sealed.
+type OrderAuthOptions struct {
+	MasterKeyFile      string
+	KeystoreAccount    string
+	KeystorePassphrase string
+}
+
 type ProtocolOptions struct {
 	Address          map[string]string
 	ImplAbi          string
@@ -182,10 +226,11 @@ type PercentMinerAddress struct {
 }
 
 type NormalMinerAddress struct {
-	Address         string
-	MaxPendingTtl   int   //if a tx is still pending after MaxPendingTtl blocks, the nonce used by it will be used again.
-	MaxPendingCount int64 //this addr will be used to send tx again until the count of pending txs belows MaxPendingCount.
-	GasPriceLimit   int64 //the max gas price
+	Address             string
+	MaxPendingTtl       int   //if a tx is still pending after MaxPendingTtl blocks, the nonce used by it will be used again.
+	MaxPendingCount     int64 //this addr will be used to send tx again until the count of pending txs belows MaxPendingCount.
+	GasPriceLimit       int64 //the max gas price
+	GasPriceBumpPercent int64 //the percent of gas price raised when a pending tx is replaced, it should not be less than 10
 }
 
 type MinerOptions struct {
@@ -195,11 +240,13 @@ type MinerOptions struct {
 	WalletSplit           float64
 	NormalMiners          []NormalMinerAddress  //
 	PercentMiners         []PercentMinerAddress //
-	TimingMatcher         *TimingMatcher
+	Matcher               string                //the name of matcher strategy: timing, event_driven or batch_auction, default is timing
+	TimingMatcher         *TimingMatcher        //it's also used by the other matchers
 	RateRatioCVSThreshold int64
-	MinGasLimit           int64
-	MaxGasLimit           int64
+	MinGasLimit           int64 //the min gas price of ring in wei, the gas of ring is estimated by the evaluator
+	MaxGasLimit           int64 //the max gas price of ring in wei
 	FeeReceipt            string
+	SimulateRing          string //how to deal with the ring reverted by eth_call before submitting: drop or quarantine, rings aren't simulated if empty
 }
 
 type MarketOptions struct {
@@ -229,14 +276,31 @@ type GatewayFiltersOptions struct {
 	PowFilter struct {
 		Difficulty string
 	}
+	//the filters run in this order, the default chain is used if it's empty
+	Chain []GatewayFilterOptions
+}
+
+type GatewayFilterOptions struct {
+	Name    string
+	Disable bool
+	Params  map[string]string
+	Markets map[string]map[string]string //market -> params, overrides Params for the orders of the market
 }
 
 type GateWayOptions struct {
 	IsBroadcast      bool
 	MaxBroadcastTime int
+	MaxBatchOrders   int //orders submitted or soft cancelled in a request at most
+	DepthLength      int //levels of depth returned if the length isn't queried
+	MaxDepthLength   int
+	DepthPrecision   int            //decimals of the price of levels if the precision isn't queried
+	DepthPrecisions  map[string]int //market -> precision, overrides DepthPrecision for the market
 }
 
+// MysqlOptions is the options of the rds service, the name is kept for the existing configs.
+// Driver is one of mysql, postgres and sqlite3, DbName is the file of database for sqlite3.
 type MysqlOptions struct {
+	Driver             string
 	Hostname           string
 	Port               string
 	User               string
@@ -246,6 +310,8 @@ type MysqlOptions struct {
 	MaxOpenConnections int
 	MaxIdleConnections int
 	ConnMaxLifetime    int
+	SslMode            string
+	AutoMigrate        bool
 	Debug              bool
 }
 
@@ -258,6 +324,30 @@ type RedisOptions struct {
 	MaxActive   int
 }
 
+type EventBusOptions struct {
+	Transport     string            //local or redis, the events are only shared between processes by redis
+	Group         string            //processes in the same group share the events, each of them is handled by one process. mode_hostname will be used if it's empty, so each host receives all the events
+	Consumer      string            //the stable name of this process in the group, hostname will be used if it's empty
+	Topics        []string          //the topics shared with other processes
+	MaxLen        int64             //the approximate max length of each topic
+	PollInterval  int64             //milliseconds
+	ReplayOffsets map[string]string //topic -> offset, the events will be replayed from the offset after start
+	ClaimIdle     int64             //seconds, the pending events of another consumer idle for it are claimed, default is 60
+	MaxDeliveries int64             //the event failed to be handled this many times is moved to the dead letter topic, default is 5
+}
+
+// MetricsOptions is the http endpoint of prometheus metrics, the health checks and the admin api,
+// it isn't started if Port is empty
+type MetricsOptions struct {
+	Port string
+	Path string
+}
+
+// AdminOptions is the bearer token of the admin api served with the metrics, the api is disabled if Token is empty
+type AdminOptions struct {
+	Token string
+}
+
 type UserManagerOptions struct {
 	WhiteListOpen            bool
 	WhiteListCacheExpireTime int64
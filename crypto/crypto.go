@@ -143,7 +143,12 @@ func NewPrivateKeyCrypto(homestead bool, privateKeyHex string) (EthPrivateKeyCry
 	}
 }
 
+// UnmarshalText accepts the empty text returned by MarshalText when there's no private key
 func (h *EthPrivateKeyCrypto) UnmarshalText(input []byte) error {
+	if len(input) == 0 {
+		*h = EthPrivateKeyCrypto{}
+		return nil
+	}
 	privateKeyHex := string(input)
 	if privateKey, err := toECDSA(privateKeyHex); nil != err {
 		return err
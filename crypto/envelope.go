@@ -0,0 +1,243 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	envelopePrefix  = "enc:"
+	envelopeVersion = byte(1)
+	masterKeyLength = 32
+	keyIdLength     = 4
+	nonceLength     = 12
+	dataKeyLength   = 32
+)
+
+// the master key derived from a keystore account is bound to this label
+var keystoreMasterKeyLabel = []byte("loopring relay order auth master key")
+
+// EnvelopeCipher encrypts every value with its own random data key by AES-GCM,
+// the data key is encrypted by the master key and saved with the value.
+// sealed value: "enc:" + base64(version | keyId | nonce | encrypted data key | nonce | encrypted value)
+type EnvelopeCipher struct {
+	keyId  []byte
+	master cipher.AEAD
+}
+
+func NewEnvelopeCipher(masterKey []byte) (*EnvelopeCipher, error) {
+	if len(masterKey) != masterKeyLength {
+		return nil, fmt.Errorf("the length of master key must be %d bytes", masterKeyLength)
+	}
+	master, err := newGCM(masterKey)
+	if nil != err {
+		return nil, err
+	}
+	c := &EnvelopeCipher{}
+	c.master = master
+	hash := sha256.Sum256(masterKey)
+	c.keyId = hash[:keyIdLength]
+	return c, nil
+}
+
+// KeyId identifies the master key, it's used to find the rows sealed by another master key
+func (c *EnvelopeCipher) KeyId() string {
+	return common.Bytes2Hex(c.keyId)
+}
+
+// Seal encrypts plaintext, aad must be the same when it's opened
+func (c *EnvelopeCipher) Seal(plaintext, aad []byte) (string, error) {
+	dataKey := make([]byte, dataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, dataKey); nil != err {
+		return "", err
+	}
+	data, err := newGCM(dataKey)
+	if nil != err {
+		return "", err
+	}
+
+	header := append([]byte{envelopeVersion}, c.keyId...)
+	keyNonce, err := randomNonce()
+	if nil != err {
+		return "", err
+	}
+	encryptedKey := c.master.Seal(nil, keyNonce, dataKey, header)
+	dataNonce, err := randomNonce()
+	if nil != err {
+		return "", err
+	}
+	encryptedData := data.Seal(nil, dataNonce, plaintext, aad)
+
+	buf := bytes.NewBuffer(header)
+	buf.Write(keyNonce)
+	buf.Write(encryptedKey)
+	buf.Write(dataNonce)
+	buf.Write(encryptedData)
+	return envelopePrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Open decrypts the value returned by Seal
+func (c *EnvelopeCipher) Open(sealed string, aad []byte) ([]byte, error) {
+	if !IsSealed(sealed) {
+		return nil, errors.New("the value isn't sealed")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, envelopePrefix))
+	if nil != err {
+		return nil, err
+	}
+	encryptedKeyLength := dataKeyLength + c.master.Overhead()
+	headerLength := 1 + keyIdLength
+	if len(raw) < headerLength+nonceLength+encryptedKeyLength+nonceLength {
+		return nil, errors.New("the sealed value is too short")
+	}
+	if raw[0] != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version:%d", raw[0])
+	}
+	header := raw[:headerLength]
+	if !bytes.Equal(header[1:], c.keyId) {
+		return nil, fmt.Errorf("the value is sealed by master key:%s, current:%s", common.Bytes2Hex(header[1:]), c.KeyId())
+	}
+
+	raw = raw[headerLength:]
+	keyNonce, raw := raw[:nonceLength], raw[nonceLength:]
+	encryptedKey, raw := raw[:encryptedKeyLength], raw[encryptedKeyLength:]
+	dataNonce, encryptedData := raw[:nonceLength], raw[nonceLength:]
+
+	dataKey, err := c.master.Open(nil, keyNonce, encryptedKey, header)
+	if nil != err {
+		return nil, err
+	}
+	data, err := newGCM(dataKey)
+	if nil != err {
+		return nil, err
+	}
+	return data.Open(nil, dataNonce, encryptedData, aad)
+}
+
+// IsSealed returns false for the values saved as plaintext before
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if nil != err {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomNonce() ([]byte, error) {
+	nonce := make([]byte, nonceLength)
+	_, err := io.ReadFull(rand.Reader, nonce)
+	return nonce, err
+}
+
+// LoadMasterKeyFile reads a hex encoded 32 bytes key
+func LoadMasterKeyFile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	hexKey := strings.TrimPrefix(strings.TrimSpace(string(content)), "0x")
+	if !common.IsHex("0x" + hexKey) {
+		return nil, fmt.Errorf("master key file:%s isn't hex", path)
+	}
+	key := common.Hex2Bytes(hexKey)
+	if len(key) != masterKeyLength {
+		return nil, fmt.Errorf("the length of master key in file:%s must be %d bytes", path, masterKeyLength)
+	}
+	return key, nil
+}
+
+// LoadKeystoreMasterKey derives the master key from the private key of a keystore account
+func LoadKeystoreMasterKey(keydir string, address common.Address, passphrase string) ([]byte, error) {
+	ks := keystore.NewKeyStore(keydir, keystore.StandardScryptN, keystore.StandardScryptP)
+	account, err := ks.Find(accounts.Account{Address: address})
+	if nil != err {
+		return nil, err
+	}
+	keyJson, err := ioutil.ReadFile(account.URL.Path)
+	if nil != err {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(keyJson, passphrase)
+	if nil != err {
+		return nil, err
+	}
+	hash := sha256.New()
+	hash.Write(keystoreMasterKeyLabel)
+	hash.Write(common.LeftPadBytes(key.PrivateKey.D.Bytes(), 32))
+	return hash.Sum(nil), nil
+}
+
+var authKeyCipher *EnvelopeCipher
+
+// InitializeAuthKeyCipher sets the cipher of the order auth private keys,
+// they are saved as plaintext if it's nil
+func InitializeAuthKeyCipher(c *EnvelopeCipher) {
+	authKeyCipher = c
+}
+
+func AuthKeyCipher() *EnvelopeCipher {
+	return authKeyCipher
+}
+
+// SealAuthPrivateKey encrypts the auth private key of order, the order hash is the additional data
+// so that the sealed key can't be moved to another order.
+func SealAuthPrivateKey(orderHash common.Hash, authKey EthPrivateKeyCrypto) (string, error) {
+	if nil == authKey.privateKey {
+		return "", nil
+	}
+	if nil == authKeyCipher {
+		text, err := authKey.MarshalText()
+		return string(text), err
+	}
+	return authKeyCipher.Seal(common.LeftPadBytes(authKey.privateKey.D.Bytes(), 32), orderHash.Bytes())
+}
+
+// OpenAuthPrivateKey decrypts the value saved by SealAuthPrivateKey, the plaintext keys are also accepted
+func OpenAuthPrivateKey(orderHash common.Hash, value string) (EthPrivateKeyCrypto, error) {
+	if !IsSealed(value) {
+		return NewPrivateKeyCrypto(false, value)
+	}
+	if nil == authKeyCipher {
+		return EthPrivateKeyCrypto{}, errors.New("the auth private key is sealed but the master key isn't loaded")
+	}
+	key, err := authKeyCipher.Open(value, orderHash.Bytes())
+	if nil != err {
+		return EthPrivateKeyCrypto{}, err
+	}
+	return NewPrivateKeyCrypto(false, common.Bytes2Hex(key))
+}
@@ -0,0 +1,132 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package crypto_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/Loopring/relay/crypto"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newTestCipher(t *testing.T, seed byte) *crypto.EnvelopeCipher {
+	c, err := crypto.NewEnvelopeCipher(bytes.Repeat([]byte{seed}, 32))
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	return c
+}
+
+func TestEnvelopeCipher_SealOpen(t *testing.T) {
+	c := newTestCipher(t, 1)
+	plaintext := []byte("auth private key")
+	aad := common.HexToHash("0x01").Bytes()
+
+	sealed, err := c.Seal(plaintext, aad)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	if !crypto.IsSealed(sealed) || strings.Contains(sealed, string(plaintext)) {
+		t.Fatalf("the value isn't sealed:%s", sealed)
+	}
+	if again, _ := c.Seal(plaintext, aad); again == sealed {
+		t.Fatalf("every value should be sealed with its own data key and nonce")
+	}
+
+	if opened, err := c.Open(sealed, aad); nil != err || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("the sealed value should be opened, err:%v", err)
+	}
+	if _, err := newTestCipher(t, 1).Open(sealed, aad); nil != err {
+		t.Fatalf("the value should be opened by the same master key, err:%s", err.Error())
+	}
+
+	tests := []struct {
+		name   string
+		cipher *crypto.EnvelopeCipher
+		sealed string
+		aad    []byte
+	}{
+		{name: "wrong aad", cipher: c, sealed: sealed, aad: common.HexToHash("0x02").Bytes()},
+		{name: "wrong key id", cipher: newTestCipher(t, 2), sealed: sealed, aad: aad},
+		{name: "tampered", cipher: c, sealed: tamper(t, sealed), aad: aad},
+		{name: "too short", cipher: c, sealed: "enc:" + base64.StdEncoding.EncodeToString([]byte{1, 2, 3}), aad: aad},
+		{name: "plaintext", cipher: c, sealed: string(plaintext), aad: aad},
+	}
+	for _, test := range tests {
+		if _, err := test.cipher.Open(test.sealed, test.aad); nil == err {
+			t.Errorf("%s: the value shouldn't be opened", test.name)
+		}
+	}
+
+	if _, err := newTestCipher(t, 2).Open(sealed, aad); nil == err || !strings.Contains(err.Error(), c.KeyId()) {
+		t.Fatalf("the key id of the master key should be reported, err:%v", err)
+	}
+}
+
+// tamper flips the last byte of the encrypted value
+func tamper(t *testing.T, sealed string) string {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, "enc:"))
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	raw[len(raw)-1] ^= 0xff
+	return "enc:" + base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestSealAuthPrivateKey(t *testing.T) {
+	defer crypto.InitializeAuthKeyCipher(nil)
+	authKey, err := crypto.NewPrivateKeyCrypto(false, "0xd1d194d90e52aeae4cd3a727b1dbb6ea5f1de8d5379827acc5f358bf1b0acba9")
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	orderHash := common.HexToHash("0x01")
+
+	//the keys saved as plaintext before the master key is loaded are still accepted
+	crypto.InitializeAuthKeyCipher(nil)
+	plaintext, err := crypto.SealAuthPrivateKey(orderHash, authKey)
+	if nil != err || crypto.IsSealed(plaintext) {
+		t.Fatalf("the key should be plaintext without master key, err:%v", err)
+	}
+
+	crypto.InitializeAuthKeyCipher(newTestCipher(t, 1))
+	sealed, err := crypto.SealAuthPrivateKey(orderHash, authKey)
+	if nil != err || !crypto.IsSealed(sealed) {
+		t.Fatalf("the key should be sealed, err:%v", err)
+	}
+	for _, value := range []string{sealed, plaintext} {
+		if opened, err := crypto.OpenAuthPrivateKey(orderHash, value); nil != err || opened.Address() != authKey.Address() {
+			t.Fatalf("the key should be opened, err:%v", err)
+		}
+	}
+	if _, err := crypto.OpenAuthPrivateKey(common.HexToHash("0x02"), sealed); nil == err {
+		t.Fatalf("the key sealed for another order shouldn't be opened")
+	}
+
+	crypto.InitializeAuthKeyCipher(newTestCipher(t, 2))
+	if _, err := crypto.OpenAuthPrivateKey(orderHash, sealed); nil == err {
+		t.Fatalf("the key sealed by another master key shouldn't be opened")
+	}
+	crypto.InitializeAuthKeyCipher(nil)
+	if _, err := crypto.OpenAuthPrivateKey(orderHash, sealed); nil == err {
+		t.Fatalf("the sealed key shouldn't be opened without master key")
+	}
+}
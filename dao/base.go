@@ -51,5 +51,5 @@ func (s *RdsServiceImpl) Save(item interface{}) error {
 
 // find all items in table where primary key > 0
 func (s *RdsServiceImpl) FindAll(item interface{}) error {
-	return s.db.Table("lpr_orders").Find(item, s.db.Where("id > ", 0)).Error
+	return s.db.Where("id > ?", 0).Find(item).Error
 }
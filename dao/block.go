@@ -72,6 +72,13 @@ func (s *RdsServiceImpl) FindLatestBlock() (*Block, error) {
 	return &block, err
 }
 
+// FindLatestBlocks returns the latest blocks which aren't forked, sorted by block number desc
+func (s *RdsServiceImpl) FindLatestBlocks(length int) ([]Block, error) {
+	var list []Block
+	err := s.db.Where("fork = ?", false).Order("block_number desc").Limit(length).Find(&list).Error
+	return list, err
+}
+
 func (s *RdsServiceImpl) SetForkBlock(from, to int64) error {
 	return s.db.Model(&Block{}).Where("block_number > ? and block_number <= ?", from, to).Update("fork", true).Error
 }
@@ -0,0 +1,81 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import "github.com/jinzhu/gorm"
+
+// Candle is the ohlcv of a market in [Start, Start+Resolution), the prices and volumes are decimal strings
+// so they're kept exactly. Vol is in the quote token and Amount is in the base token, the same as Trend.
+type Candle struct {
+	ID         int    `gorm:"column:id;primary_key;"`
+	Market     string `gorm:"column:market;type:varchar(42);unique_index:market_resolution_start"`
+	Resolution int64  `gorm:"column:resolution;type:bigint;unique_index:market_resolution_start"`
+	Start      int64  `gorm:"column:start;type:bigint;unique_index:market_resolution_start"`
+	Open       string `gorm:"column:open;type:varchar(80)"`
+	High       string `gorm:"column:high;type:varchar(80)"`
+	Low        string `gorm:"column:low;type:varchar(80)"`
+	Close      string `gorm:"column:close;type:varchar(80)"`
+	Vol        string `gorm:"column:vol;type:varchar(80)"`
+	Amount     string `gorm:"column:amount;type:varchar(80)"`
+	Count      int64  `gorm:"column:count;type:bigint"`
+	UpdateTime int64  `gorm:"column:update_time;type:bigint"`
+}
+
+func createCandleTableUp(db *gorm.DB) error {
+	if db.HasTable(candleTable.tableName(db)) {
+		return nil
+	}
+	return db.Table(candleTable.tableName(db)).CreateTable(candleTable.model).Error
+}
+
+func createCandleTableDown(db *gorm.DB) error {
+	return dropFrozenTables(db, []frozenTable{candleTable})
+}
+
+// SaveCandle inserts the candle or replaces the one of the same market, resolution and start
+func (s *RdsServiceImpl) SaveCandle(candle *Candle) error {
+	var existed Candle
+	err := s.db.Where("market = ? and resolution = ? and start = ?", candle.Market, candle.Resolution, candle.Start).First(&existed).Error
+	if nil == err {
+		candle.ID = existed.ID
+		return s.db.Save(candle).Error
+	} else if gorm.ErrRecordNotFound != err {
+		return err
+	}
+	candle.ID = 0
+	return s.db.Create(candle).Error
+}
+
+// GetCandles returns the candles started in [start, end) in order of start
+func (s *RdsServiceImpl) GetCandles(market string, resolution, start, end int64) ([]Candle, error) {
+	var candles []Candle
+	err := s.db.Where("market = ? and resolution = ? and start >= ? and start < ?", market, resolution, start, end).
+		Order("start asc").
+		Find(&candles).Error
+	return candles, err
+}
+
+// DeleteCandles deletes the candles started in [start, end) of all the markets if market is empty
+func (s *RdsServiceImpl) DeleteCandles(market string, resolution, start, end int64) error {
+	db := s.db.Where("resolution = ? and start >= ? and start < ?", resolution, start, end)
+	if "" != market {
+		db = db.Where("market = ?", market)
+	}
+	return db.Delete(&Candle{}).Error
+}
@@ -18,10 +18,14 @@
 
 package dao
 
-import "qiniupkg.com/x/errors.v7"
+import (
+	"qiniupkg.com/x/errors.v7"
+	"time"
+)
 
 const (
-	TrendUpdateType = "last_trend__proof_time"
+	TrendUpdateType         = "last_trend__proof_time"
+	ExtractorRetryQueueType = "extractor_retry_queue"
 )
 
 // common check point table
@@ -31,6 +35,7 @@ type CheckPoint struct {
 	CheckPoint   int64  `gorm:"column:check_point;type:bigint"`
 	CreateTime   int64  `gorm:"column:create_time;type:bigint"`
 	ModifyTime   int64  `gorm:"column:modify_time;type:bigint"`
+	Content      string `gorm:"column:content;type:text"`
 }
 
 func (s *RdsServiceImpl) QueryCheckPointByType(businessType string) (point CheckPoint, err error) {
@@ -43,3 +48,20 @@ func (s *RdsServiceImpl) QueryCheckPointByType(businessType string) (point Check
 		return points[0], nil
 	}
 }
+
+// SaveCheckPoint creates or updates the check point of businessType
+func (s *RdsServiceImpl) SaveCheckPoint(businessType string, checkPoint int64, content string) error {
+	now := time.Now().Unix()
+	var point CheckPoint
+	if err := s.db.Where("business_type = ?", businessType).First(&point).Error; err != nil {
+		point = CheckPoint{BusinessType: businessType, CheckPoint: checkPoint, CreateTime: now, ModifyTime: now, Content: content}
+		return s.db.Create(&point).Error
+	}
+
+	items := map[string]interface{}{
+		"check_point": checkPoint,
+		"modify_time": now,
+		"content":     content,
+	}
+	return s.db.Model(&CheckPoint{}).Where("id = ?", point.ID).Update(items).Error
+}
@@ -19,13 +19,22 @@
 package dao
 
 import (
+	"fmt"
 	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/log"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"time"
 )
 
+const (
+	DRIVER_MYSQL    = "mysql"
+	DRIVER_POSTGRES = "postgres"
+	DRIVER_SQLITE   = "sqlite3"
+)
+
 type PageResult struct {
 	Data      []interface{} `json:"data"`
 	PageIndex int           `json:"pageIndex"`
@@ -41,59 +50,76 @@ type RdsServiceImpl struct {
 func NewRdsService(options config.MysqlOptions) *RdsServiceImpl {
 	impl := &RdsServiceImpl{}
 	impl.options = options
+	if "" == impl.options.Driver {
+		impl.options.Driver = DRIVER_MYSQL
+	}
 
 	gorm.DefaultTableNameHandler = func(db *gorm.DB, defaultTableName string) string {
 		return options.TablePrefix + defaultTableName
 	}
 
-	url := options.User + ":" + options.Password + "@tcp(" + options.Hostname + ":" + options.Port + ")/" + options.DbName + "?charset=utf8&parseTime=True"
-	db, err := gorm.Open("mysql", url)
+	url, err := dataSource(impl.options)
+	if err != nil {
+		log.Fatalf("rds connection error:%s", err.Error())
+	}
+	db, err := gorm.Open(impl.options.Driver, url)
 	if err != nil {
-		log.Fatalf("mysql connection error:%s", err.Error())
+		log.Fatalf("%s connection error:%s", impl.options.Driver, err.Error())
 	}
 
 	db.DB().SetConnMaxLifetime(time.Duration(options.ConnMaxLifetime) * time.Second)
 	db.DB().SetMaxIdleConns(options.MaxIdleConnections)
 	db.DB().SetMaxOpenConns(options.MaxOpenConnections)
+	if DRIVER_SQLITE == impl.options.Driver {
+		// sqlite allows only one writer, and every connection has its own database in memory
+		db.DB().SetMaxOpenConns(1)
+	}
 
 	db.LogMode(options.Debug)
+	registerMetricsCallbacks(db)
 
 	impl.db = db
 
 	return impl
 }
 
-func (s *RdsServiceImpl) Prepare() {
-	var tables []interface{}
-
-	// create tables if not exists
-	tables = append(tables, &Order{})
-	tables = append(tables, &Block{})
-	tables = append(tables, &RingMinedEvent{})
-	tables = append(tables, &FillEvent{})
-	tables = append(tables, &CancelEvent{})
-	tables = append(tables, &CutOffEvent{})
-	tables = append(tables, &CutOffPairEvent{})
-	tables = append(tables, &Trend{})
-	tables = append(tables, &WhiteList{})
-	tables = append(tables, &RingSubmitInfo{})
-	tables = append(tables, &FilledOrder{})
-	tables = append(tables, &Transaction{})
-	tables = append(tables, &TransactionEntity{})
-	tables = append(tables, &TransactionView{})
-	tables = append(tables, &CheckPoint{})
-	//tables = append(tables, &RingMinedMethod{})
-
-	for _, t := range tables {
-		if ok := s.db.HasTable(t); !ok {
-			if err := s.db.CreateTable(t).Error; err != nil {
-				log.Fatalf("create mysql table error:%s", err.Error())
-			}
+func dataSource(options config.MysqlOptions) (string, error) {
+	switch options.Driver {
+	case DRIVER_MYSQL:
+		return options.User + ":" + options.Password + "@tcp(" + options.Hostname + ":" + options.Port + ")/" + options.DbName + "?charset=utf8&parseTime=True", nil
+	case DRIVER_POSTGRES:
+		sslMode := options.SslMode
+		if "" == sslMode {
+			sslMode = "disable"
 		}
+		return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", options.Hostname, options.Port, options.User, options.Password, options.DbName, sslMode), nil
+	case DRIVER_SQLITE:
+		return options.DbName + "?_busy_timeout=5000", nil
+	default:
+		return "", fmt.Errorf("unsupported driver:%s", options.Driver)
 	}
+}
 
-	// auto migrate to keep schema update to date
-	// AutoMigrate will ONLY create tables, missing columns and missing indexes,
-	// and WON'T change existing column's type or delete unused columns to protect your data
-	s.db.AutoMigrate(tables...)
+// Ping checks whether the database is reachable
+func (s *RdsServiceImpl) Ping() error {
+	return s.db.DB().Ping()
+}
+
+// quote returns the column name quoted by the dialect, it's required by the names reserved in some of the databases
+func (s *RdsServiceImpl) quote(column string) string {
+	return s.db.Dialect().Quote(column)
+}
+
+// Prepare checks the version of schema, the pending migrations are applied if AutoMigrate is set,
+// otherwise they must be applied by `lrc db migrate` before start.
+func (s *RdsServiceImpl) Prepare() {
+	err := s.CheckSchemaVersion()
+	if ErrPendingMigrations == err && s.options.AutoMigrate {
+		_, err = s.MigrateUp(0)
+	}
+	if ErrPendingMigrations == err {
+		log.Fatalf("dao,schema version of the database is behind %d, run `lrc db migrate` first", LatestSchemaVersion())
+	} else if nil != err {
+		log.Fatalf("dao,schema version check error:%s", err.Error())
+	}
 }
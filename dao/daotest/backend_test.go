@@ -0,0 +1,71 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package daotest_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/dao/daotest"
+	"github.com/Loopring/relay/log"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+}
+
+func TestSqliteConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relay_dao")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := config.MysqlOptions{Driver: dao.DRIVER_SQLITE, DbName: filepath.Join(dir, "relay.db"), TablePrefix: "lpr_", AutoMigrate: true}
+	daotest.RunConformance(t, dao.NewRdsService(options))
+}
+
+// the config of postgres and mysql is read from the file in env, the tables are created with a new prefix
+// so the database can be reused
+func TestPostgresConformance(t *testing.T) {
+	runConfigConformance(t, "RELAY_TEST_POSTGRES_CONFIG", dao.DRIVER_POSTGRES)
+}
+
+func TestMysqlConformance(t *testing.T) {
+	runConfigConformance(t, "RELAY_TEST_MYSQL_CONFIG", dao.DRIVER_MYSQL)
+}
+
+func runConfigConformance(t *testing.T, env, driver string) {
+	file := os.Getenv(env)
+	if "" == file {
+		t.Skipf("%s isn't set", env)
+	}
+	options := config.LoadConfig(file).Mysql
+	options.Driver = driver
+	options.AutoMigrate = true
+	options.TablePrefix = fmt.Sprintf("conformance_%d_", time.Now().Unix())
+	daotest.RunConformance(t, dao.NewRdsService(options))
+}
@@ -0,0 +1,509 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package daotest is the conformance suite of dao.RdsService, every storage backend must pass it.
+package daotest
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// RunConformance runs the suite against rds, the database must be empty and AutoMigrate must be set
+func RunConformance(t *testing.T, rds dao.RdsService) {
+	rds.Prepare()
+	// Prepare is called on every start
+	rds.Prepare()
+
+	t.Run("Ping", func(t *testing.T) {
+		if err := rds.Ping(); nil != err {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Migration", func(t *testing.T) { testMigration(t, rds) })
+	t.Run("Order", func(t *testing.T) { testOrder(t, rds) })
+	t.Run("OrderAuthKey", func(t *testing.T) { testOrderAuthKey(t, rds) })
+	t.Run("ExpireOrder", func(t *testing.T) { testExpireOrder(t, rds) })
+	t.Run("SoftCancelOrder", func(t *testing.T) { testSoftCancelOrder(t, rds) })
+	t.Run("Block", func(t *testing.T) { testBlock(t, rds) })
+	t.Run("RingSubmitInfo", func(t *testing.T) { testRingSubmitInfo(t, rds) })
+	t.Run("Fill", func(t *testing.T) { testFill(t, rds) })
+	t.Run("Trend", func(t *testing.T) { testTrend(t, rds) })
+	t.Run("Candle", func(t *testing.T) { testCandle(t, rds) })
+	t.Run("WhiteList", func(t *testing.T) { testWhiteList(t, rds) })
+	t.Run("CheckPoint", func(t *testing.T) { testCheckPoint(t, rds) })
+}
+
+var (
+	protocol = common.HexToAddress("0x8d8812b72d1e4ffCeC158D25f56748b7d67c1e78")
+	delegate = common.HexToAddress("0x17233e07c67d086464fD408148c3ABB56245FA64")
+	lrc      = common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")
+	weth     = common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	ownerA   = common.HexToAddress("0x1b978a1D302335a6F2Ebe4B8823B5E17c3C84135")
+	ownerB   = common.HexToAddress("0xb1018949b241D76A1AB2094f473E9bEfeAbB5Ead")
+)
+
+func newOrder(hash common.Hash, owner common.Address, status types.OrderStatus, validUntil int64, price float64) *dao.Order {
+	now := time.Now().Unix()
+	order := &dao.Order{}
+	order.Protocol = protocol.Hex()
+	order.DelegateAddress = delegate.Hex()
+	order.Owner = owner.Hex()
+	order.OrderHash = hash.Hex()
+	order.TokenS = lrc.Hex()
+	order.TokenB = weth.Hex()
+	order.AmountS = "1000000000000000000000"
+	order.AmountB = "1000000000000000000"
+	order.DealtAmountS = "0"
+	order.DealtAmountB = "0"
+	order.CancelledAmountS = "0"
+	order.CancelledAmountB = "0"
+	order.SplitAmountS = "0"
+	order.SplitAmountB = "0"
+	order.LrcFee = "10000000000000000000"
+	order.CreateTime = now
+	order.ValidSince = now - 3600
+	order.ValidUntil = validUntil
+	order.Price = price
+	order.Status = uint8(status)
+	order.Market = "LRC-WETH"
+	order.Side = "sell"
+	order.OrderType = types.ORDER_TYPE_MARKET
+	return order
+}
+
+func mustAdd(t *testing.T, rds dao.RdsService, item interface{}) {
+	if err := rds.Add(item); nil != err {
+		t.Fatalf("add %T error:%s", item, err.Error())
+	}
+}
+
+func testMigration(t *testing.T, rds dao.RdsService) {
+	if err := rds.CheckSchemaVersion(); nil != err {
+		t.Fatalf("CheckSchemaVersion error after Prepare:%s", err.Error())
+	}
+	if version, err := rds.SchemaVersion(); nil != err || version != dao.LatestSchemaVersion() {
+		t.Fatalf("SchemaVersion should be %d, got:%d err:%v", dao.LatestSchemaVersion(), version, err)
+	}
+
+	if done, err := rds.MigrateDown(1); nil != err || len(done) != 1 || done[0].Version != dao.LatestSchemaVersion() {
+		t.Fatalf("MigrateDown should revert the latest migration, got:%d err:%v", len(done), err)
+	}
+	if err := rds.CheckSchemaVersion(); dao.ErrPendingMigrations != err {
+		t.Fatalf("CheckSchemaVersion should return ErrPendingMigrations, got:%v", err)
+	}
+	if done, err := rds.MigrateUp(0); nil != err || len(done) != 1 {
+		t.Fatalf("MigrateUp should apply the reverted migration, got:%d err:%v", len(done), err)
+	}
+
+	unknown := &dao.SchemaMigration{Version: dao.LatestSchemaVersion() + 1000, Name: "unknown"}
+	mustAdd(t, rds, unknown)
+	if err := rds.CheckSchemaVersion(); nil == err || dao.ErrPendingMigrations == err {
+		t.Fatalf("CheckSchemaVersion should refuse the unknown version, got:%v", err)
+	}
+	if _, err := rds.MigrateUp(0); nil == err {
+		t.Fatalf("MigrateUp should refuse the unknown version")
+	}
+	if err := rds.Del(unknown); nil != err {
+		t.Fatalf("delete unknown version error:%s", err.Error())
+	}
+
+	list, err := rds.MigrationStatus()
+	if nil != err || len(list) != dao.LatestSchemaVersion() {
+		t.Fatalf("MigrationStatus should return %d migrations, got:%d err:%v", dao.LatestSchemaVersion(), len(list), err)
+	}
+	for _, status := range list {
+		if !status.Applied {
+			t.Fatalf("migration %d(%s) isn't applied", status.Version, status.Name)
+		}
+	}
+
+	//migration 1 can't be reverted, the tables adopted by it are kept
+	if done, err := rds.MigrateDown(dao.LatestSchemaVersion()); nil == err || len(done) != dao.LatestSchemaVersion()-1 {
+		t.Fatalf("MigrateDown should revert the migrations except the first one, got:%d err:%v", len(done), err)
+	}
+	if version, err := rds.SchemaVersion(); nil != err || version != 1 {
+		t.Fatalf("SchemaVersion should be 1, got:%d err:%v", version, err)
+	}
+	//the tables are created from the frozen models again, the live models used by the tests below must fit in them
+	if done, err := rds.MigrateUp(0); nil != err || len(done) != dao.LatestSchemaVersion()-1 {
+		t.Fatalf("MigrateUp should apply the reverted migrations, got:%d err:%v", len(done), err)
+	}
+}
+
+func testOrder(t *testing.T, rds dao.RdsService) {
+	now := time.Now().Unix()
+	hashes := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")}
+	mustAdd(t, rds, newOrder(hashes[0], ownerA, types.ORDER_NEW, now+3600, 0.001))
+	mustAdd(t, rds, newOrder(hashes[1], ownerA, types.ORDER_PARTIAL, now+3600, 0.0012345678))
+	mustAdd(t, rds, newOrder(hashes[2], ownerB, types.ORDER_FINISHED, now+3600, 0.002))
+
+	order, err := rds.GetOrderByHash(hashes[1])
+	if nil != err {
+		t.Fatalf("GetOrderByHash error:%s", err.Error())
+	}
+	if order.Owner != ownerA.Hex() || order.AmountS != "1000000000000000000000" || order.Status != uint8(types.ORDER_PARTIAL) {
+		t.Fatalf("GetOrderByHash returns different order:%+v", order)
+	}
+	if fmt.Sprintf("%.10f", order.Price) != "0.0012345678" {
+		t.Fatalf("price isn't kept, got:%v", order.Price)
+	}
+	if _, err := rds.GetOrderByHash(common.HexToHash("0x04")); nil == err {
+		t.Fatalf("GetOrderByHash should return error for the order not existed")
+	}
+
+	orders, err := rds.GetOrdersByHash([]string{hashes[0].Hex(), hashes[2].Hex()})
+	if nil != err || len(orders) != 2 {
+		t.Fatalf("GetOrdersByHash should return 2 orders, got:%d err:%v", len(orders), err)
+	}
+
+	var all []dao.Order
+	if err := rds.FindAll(&all); nil != err || len(all) != 3 {
+		t.Fatalf("FindAll should return 3 orders, got:%d err:%v", len(all), err)
+	}
+
+	book, err := rds.GetOrderBook(delegate, lrc, weth, 10)
+	if nil != err || len(book) != 2 {
+		t.Fatalf("GetOrderBook should return 2 orders, got:%d err:%v", len(book), err)
+	}
+	if book[0].OrderHash != hashes[1].Hex() {
+		t.Fatalf("GetOrderBook should be sorted by price desc, got:%s", book[0].OrderHash)
+	}
+
+	count, err := rds.CountOpenOrders(ownerA, []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL})
+	if nil != err || count != 2 {
+		t.Fatalf("CountOpenOrders should return 2, got:%d err:%v", count, err)
+	}
+
+	page, err := rds.OrderPageQuery(map[string]interface{}{"owner": ownerA.Hex()}, []int{}, 1, 1)
+	if nil != err || page.Total != 2 || len(page.Data) != 1 {
+		t.Fatalf("OrderPageQuery should return 1 of 2 orders, got:%d of %d err:%v", len(page.Data), page.Total, err)
+	}
+
+	if err := rds.UpdateOrderWhileFill(hashes[0], types.ORDER_PARTIAL, big.NewInt(100), big.NewInt(1), big.NewInt(2), big.NewInt(0), big.NewInt(10)); nil != err {
+		t.Fatalf("UpdateOrderWhileFill error:%s", err.Error())
+	}
+	if err := rds.UpdateOrderWhileCancel(hashes[1], types.ORDER_CANCEL, big.NewInt(5), big.NewInt(0), big.NewInt(11)); nil != err {
+		t.Fatalf("UpdateOrderWhileCancel error:%s", err.Error())
+	}
+	filled, _ := rds.GetOrderByHash(hashes[0])
+	if filled.Status != uint8(types.ORDER_PARTIAL) || filled.DealtAmountS != "100" || filled.UpdatedBlock != 10 {
+		t.Fatalf("UpdateOrderWhileFill isn't saved:%+v", filled)
+	}
+	cancelled, _ := rds.GetOrderByHash(hashes[1])
+	if cancelled.Status != uint8(types.ORDER_CANCEL) || cancelled.CancelledAmountS != "5" {
+		t.Fatalf("UpdateOrderWhileCancel isn't saved:%+v", cancelled)
+	}
+
+	if err := rds.SetCutOffOrders([]common.Hash{hashes[0]}, big.NewInt(12)); nil != err {
+		t.Fatalf("SetCutOffOrders error:%s", err.Error())
+	}
+	cutoff, _ := rds.GetOrderByHash(hashes[0])
+	if cutoff.Status != uint8(types.ORDER_CUTOFF) {
+		t.Fatalf("SetCutOffOrders isn't saved, status:%d", cutoff.Status)
+	}
+}
+
+func testOrderAuthKey(t *testing.T, rds dao.RdsService) {
+	now := time.Now().Unix()
+	for i := 0; i < 3; i++ {
+		order := newOrder(common.BigToHash(big.NewInt(int64(100+i))), ownerB, types.ORDER_NEW, now+3600, 0.001)
+		if i != 1 {
+			order.PrivateKey = fmt.Sprintf("0x%064x", i+1)
+		}
+		mustAdd(t, rds, order)
+	}
+	if err := rds.WidenOrderAuthKeyColumn(); nil != err {
+		t.Fatalf("WidenOrderAuthKeyColumn error:%s", err.Error())
+	}
+
+	orders, err := rds.GetOrdersWithAuthKey(0, 1)
+	if nil != err || len(orders) != 1 {
+		t.Fatalf("GetOrdersWithAuthKey should return 1 order, got:%d err:%v", len(orders), err)
+	}
+	next, err := rds.GetOrdersWithAuthKey(orders[0].ID, 10)
+	if nil != err || len(next) != 1 || next[0].ID <= orders[0].ID {
+		t.Fatalf("GetOrdersWithAuthKey should return the next order, got:%d err:%v", len(next), err)
+	}
+
+	// the sealed key is longer than the column created for the plaintext key
+	sealed := "enc:" + strings.Repeat("a", 200)
+	if updated, err := rds.UpdateOrderAuthKey(orders[0].ID, "changed by others", sealed); nil != err || updated {
+		t.Fatalf("UpdateOrderAuthKey shouldn't update the changed key, updated:%t err:%v", updated, err)
+	}
+	if updated, err := rds.UpdateOrderAuthKey(orders[0].ID, orders[0].PrivateKey, sealed); nil != err || !updated {
+		t.Fatalf("UpdateOrderAuthKey should update the key, updated:%t err:%v", updated, err)
+	}
+	order, _ := rds.GetOrderByHash(common.HexToHash(orders[0].OrderHash))
+	if order.PrivateKey != sealed {
+		t.Fatalf("the sealed key isn't kept, length:%d", len(order.PrivateKey))
+	}
+}
+
+func testExpireOrder(t *testing.T, rds dao.RdsService) {
+	now := time.Now().Unix()
+	hash := common.HexToHash("0x0200")
+	owner := common.HexToAddress("0x0200")
+	mustAdd(t, rds, newOrder(hash, owner, types.ORDER_NEW, now-60, 0.001))
+
+	openedStatus := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	orders, err := rds.GetExpiredOrders(now, openedStatus, 10)
+	if nil != err || len(orders) != 1 || orders[0].OrderHash != hash.Hex() {
+		t.Fatalf("GetExpiredOrders should return the expired order, got:%d err:%v", len(orders), err)
+	}
+	//the amounts of the order past valid_until are released even if the order isn't swept
+	if frozen, err := rds.GetFrozenAmount(owner, lrc, openedStatus, delegate); nil != err || len(frozen) != 0 {
+		t.Fatalf("the order past valid_until shouldn't be frozen, got:%d err:%v", len(frozen), err)
+	}
+	if fees, err := rds.GetFrozenLrcFee(owner, openedStatus); nil != err || len(fees) != 0 {
+		t.Fatalf("the lrc fee of the order past valid_until shouldn't be frozen, got:%d err:%v", len(fees), err)
+	}
+	if expired, err := rds.ExpireOrder(hash, openedStatus, now); nil != err || !expired {
+		t.Fatalf("ExpireOrder should expire the order, expired:%t err:%v", expired, err)
+	}
+	if frozen, err := rds.GetFrozenAmount(owner, lrc, openedStatus, delegate); nil != err || len(frozen) != 0 {
+		t.Fatalf("the expired order shouldn't be frozen, got:%d err:%v", len(frozen), err)
+	}
+	if fees, err := rds.GetFrozenLrcFee(owner, []types.OrderStatus{}); nil != err || len(fees) != 0 {
+		t.Fatalf("nothing should be frozen without status, got:%d err:%v", len(fees), err)
+	}
+	if expired, err := rds.ExpireOrder(hash, openedStatus, now); nil != err || expired {
+		t.Fatalf("ExpireOrder shouldn't expire the order twice, expired:%t err:%v", expired, err)
+	}
+}
+
+func testSoftCancelOrder(t *testing.T, rds dao.RdsService) {
+	now := time.Now().Unix()
+	hash := common.HexToHash("0x0300")
+	mustAdd(t, rds, newOrder(hash, ownerA, types.ORDER_PARTIAL, now+3600, 0.001))
+
+	openedStatus := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	if cancelled, err := rds.SoftCancelOrder(hash, ownerB, openedStatus); nil != err || cancelled {
+		t.Fatalf("SoftCancelOrder shouldn't cancel the order of others, cancelled:%t err:%v", cancelled, err)
+	}
+	if cancelled, err := rds.SoftCancelOrder(hash, ownerA, openedStatus); nil != err || !cancelled {
+		t.Fatalf("SoftCancelOrder should cancel the order, cancelled:%t err:%v", cancelled, err)
+	}
+	if cancelled, err := rds.SoftCancelOrder(hash, ownerA, openedStatus); nil != err || cancelled {
+		t.Fatalf("SoftCancelOrder shouldn't cancel the order twice, cancelled:%t err:%v", cancelled, err)
+	}
+	order, _ := rds.GetOrderByHash(hash)
+	if order.Status != uint8(types.ORDER_SOFT_CANCEL) {
+		t.Fatalf("the status should be soft cancel, got:%d", order.Status)
+	}
+}
+
+func testBlock(t *testing.T, rds dao.RdsService) {
+	for i := int64(1); i <= 3; i++ {
+		block := &dao.Block{}
+		block.BlockNumber = i
+		block.BlockHash = common.BigToHash(big.NewInt(i)).Hex()
+		block.ParentHash = common.BigToHash(big.NewInt(i - 1)).Hex()
+		block.CreateTime = 1000 + i
+		if err := rds.SaveBlock(block); nil != err {
+			t.Fatalf("SaveBlock error:%s", err.Error())
+		}
+	}
+
+	latest, err := rds.FindLatestBlock()
+	if nil != err || latest.BlockNumber != 3 {
+		t.Fatalf("FindLatestBlock should return block 3, got:%d err:%v", latest.BlockNumber, err)
+	}
+	if block, err := rds.FindBlockByHash(common.BigToHash(big.NewInt(2))); nil != err || block.BlockNumber != 2 {
+		t.Fatalf("FindBlockByHash should return block 2, err:%v", err)
+	}
+
+	if err := rds.SetForkBlock(1, 3); nil != err {
+		t.Fatalf("SetForkBlock error:%s", err.Error())
+	}
+	blocks, err := rds.FindLatestBlocks(10)
+	if nil != err || len(blocks) != 1 || blocks[0].BlockNumber != 1 {
+		t.Fatalf("FindLatestBlocks should only return block 1 after fork, got:%d err:%v", len(blocks), err)
+	}
+}
+
+func testRingSubmitInfo(t *testing.T, rds dao.RdsService) {
+	miner := common.HexToAddress("0x4bad3053d574cd54513babe21db3f09bea1d387d")
+	ringhash := common.HexToHash("0x01")
+	txHashes := []common.Hash{common.HexToHash("0xa1"), common.HexToHash("0xa2"), common.HexToHash("0xa3")}
+	info := &dao.RingSubmitInfo{RingHash: ringhash.Hex(), Miner: miner.Hex(), ProtocolTxHash: txHashes[0].Hex(), ProtocolNonce: "1", Status: int(types.TX_STATUS_PENDING)}
+	mustAdd(t, rds, info)
+	for i := 1; i < len(txHashes); i++ {
+		replacement := &dao.RingSubmitInfo{}
+		*replacement = *info
+		replacement.ID = 0
+		replacement.ProtocolTxHash = txHashes[i].Hex()
+		replacement.ReplacedTxHash = txHashes[i-1].Hex()
+		if err := rds.ReplaceRingSubmitInfo(replacement); nil != err {
+			t.Fatalf("ReplaceRingSubmitInfo error:%s", err.Error())
+		}
+	}
+
+	infos, err := rds.GetPendingRingSubmitInfos(miner)
+	if nil != err || len(infos) != 1 || infos[0].ProtocolTxHash != txHashes[2].Hex() {
+		t.Fatalf("only the latest tx should be pending, got:%d err:%v", len(infos), err)
+	}
+	if latest, err := rds.GetRingForSubmitByHash(ringhash); nil != err || latest.ProtocolTxHash != txHashes[2].Hex() {
+		t.Fatalf("GetRingForSubmitByHash should return the latest tx, got:%s err:%v", latest.ProtocolTxHash, err)
+	}
+
+	//the first tx is mined before its replacements
+	result := &types.RingSubmitResultEvent{RingHash: ringhash, TxHash: txHashes[0], Status: types.TX_STATUS_SUCCESS}
+	if err := rds.UpdateRingSubmitInfoResult(result); nil != err {
+		t.Fatalf("UpdateRingSubmitInfoResult error:%s", err.Error())
+	}
+	if infos, err := rds.GetPendingRingSubmitInfos(miner); nil != err || len(infos) != 0 {
+		t.Fatalf("the replacements of the mined tx shouldn't be pending, got:%d err:%v", len(infos), err)
+	}
+	if mined, err := rds.GetRingForSubmitByHash(ringhash); nil != err || mined.ProtocolTxHash != txHashes[0].Hex() {
+		t.Fatalf("GetRingForSubmitByHash should return the mined tx, got:%s err:%v", mined.ProtocolTxHash, err)
+	}
+}
+
+func testFill(t *testing.T, rds dao.RdsService) {
+	txHash := common.HexToHash("0x0300").Hex()
+	for i := int64(0); i < 3; i++ {
+		fill := &dao.FillEvent{}
+		fill.Protocol = protocol.Hex()
+		fill.DelegateAddress = delegate.Hex()
+		fill.Owner = ownerA.Hex()
+		fill.RingHash = common.HexToHash("0x0301").Hex()
+		fill.TxHash = txHash
+		fill.FillIndex = i
+		fill.BlockNumber = 20 + i
+		fill.CreateTime = 2000 + i
+		fill.OrderHash = common.BigToHash(big.NewInt(300 + i)).Hex()
+		fill.AmountS = "1"
+		fill.AmountB = "2"
+		fill.Market = "LRC-WETH"
+		mustAdd(t, rds, fill)
+	}
+
+	if fill, err := rds.FindFillEvent(txHash, 1); nil != err || fill.BlockNumber != 21 {
+		t.Fatalf("FindFillEvent should return the fill of index 1, err:%v", err)
+	}
+	page, err := rds.FillsPageQuery(map[string]interface{}{"market": "LRC-WETH"}, 1, 2)
+	if nil != err || page.Total != 3 || len(page.Data) != 2 {
+		t.Fatalf("FillsPageQuery should return 2 of 3 fills, got:%d of %d err:%v", len(page.Data), page.Total, err)
+	}
+	if fills, err := rds.QueryRecentFills("LRC-WETH", "", 2001, 2002); nil != err || len(fills) != 2 {
+		t.Fatalf("QueryRecentFills should return 2 fills, got:%d err:%v", len(fills), err)
+	}
+	if fills, err := rds.GetFillsByTime("", 2001, 2002); nil != err || len(fills) != 1 || fills[0].FillIndex != 1 {
+		t.Fatalf("GetFillsByTime should return the fill of index 1, got:%d err:%v", len(fills), err)
+	}
+
+	forked, err := rds.GetFillForkEvents(20, 22)
+	if nil != err || len(forked) != 2 {
+		t.Fatalf("GetFillForkEvents should return 2 fills, got:%d err:%v", len(forked), err)
+	}
+	if err := rds.RollBackFill(20, 22); nil != err {
+		t.Fatalf("RollBackFill error:%s", err.Error())
+	}
+	if fills, err := rds.FindFillsByRingHash(common.HexToHash("0x0301")); nil != err || len(fills) != 1 {
+		t.Fatalf("FindFillsByRingHash should return 1 fill after rollback, got:%d err:%v", len(fills), err)
+	}
+}
+
+func testCandle(t *testing.T, rds dao.RdsService) {
+	for i := int64(0); i < 3; i++ {
+		candle := &dao.Candle{Market: "LRC-WETH", Resolution: 60, Start: 60 * i, Open: "0.001", High: "0.002", Low: "0.0005", Close: "0.0015", Vol: "1.5", Amount: "1000", Count: 2}
+		if err := rds.SaveCandle(candle); nil != err {
+			t.Fatalf("SaveCandle error:%s", err.Error())
+		}
+	}
+	replaced := &dao.Candle{Market: "LRC-WETH", Resolution: 60, Start: 60, Open: "0.001", High: "0.003", Low: "0.001", Close: "0.003", Vol: "3.000000000000000001", Amount: "2000", Count: 3}
+	if err := rds.SaveCandle(replaced); nil != err {
+		t.Fatalf("SaveCandle error:%s", err.Error())
+	}
+
+	candles, err := rds.GetCandles("LRC-WETH", 60, 0, 180)
+	if nil != err || len(candles) != 3 {
+		t.Fatalf("GetCandles should return 3 candles, got:%d err:%v", len(candles), err)
+	}
+	if candles[1].Vol != "3.000000000000000001" || candles[1].Count != 3 {
+		t.Fatalf("the candle should be replaced exactly, got:%+v", candles[1])
+	}
+
+	if err := rds.DeleteCandles("", 60, 60, 180); nil != err {
+		t.Fatalf("DeleteCandles error:%s", err.Error())
+	}
+	if candles, err := rds.GetCandles("LRC-WETH", 60, 0, 180); nil != err || len(candles) != 1 || candles[0].Start != 0 {
+		t.Fatalf("GetCandles should return the candle not deleted, got:%d err:%v", len(candles), err)
+	}
+}
+
+func testTrend(t *testing.T, rds dao.RdsService) {
+	for i := int64(0); i < 3; i++ {
+		trend := &dao.Trend{}
+		trend.Market = "LRC-WETH"
+		trend.Intervals = "1Hr"
+		trend.Start = 3600 * i
+		trend.End = 3600*(i+1) - 1
+		trend.Open = 0.001
+		trend.Close = 0.002
+		trend.High = 0.003
+		trend.Low = 0.0005
+		trend.Vol = 10
+		trend.Amount = 10000
+		mustAdd(t, rds, trend)
+	}
+
+	if trends, err := rds.TrendQueryByTime("1Hr", "LRC-WETH", 3600, 7199); nil != err || len(trends) != 1 {
+		t.Fatalf("TrendQueryByTime should return 1 trend, got:%d err:%v", len(trends), err)
+	}
+	if trends, err := rds.TrendQueryByInterval("1Hr", "LRC-WETH", 0, 7199); nil != err || len(trends) != 2 {
+		t.Fatalf("TrendQueryByInterval should return 2 trends, got:%d err:%v", len(trends), err)
+	}
+	trends, err := rds.TrendQueryLatest(dao.Trend{Market: "LRC-WETH", Intervals: "1Hr"}, 1, 10)
+	if nil != err || len(trends) != 3 || trends[0].Start != 7200 {
+		t.Fatalf("TrendQueryLatest should return 3 trends sorted by start desc, got:%d err:%v", len(trends), err)
+	}
+}
+
+func testWhiteList(t *testing.T, rds dao.RdsService) {
+	mustAdd(t, rds, &dao.WhiteList{Owner: ownerA.Hex(), CreateTime: 1})
+	mustAdd(t, rds, &dao.WhiteList{Owner: ownerB.Hex(), CreateTime: 1, IsDeleted: true})
+
+	if list, err := rds.GetWhiteList(); nil != err || len(list) != 1 {
+		t.Fatalf("GetWhiteList should return 1 user, got:%d err:%v", len(list), err)
+	}
+	if _, err := rds.FindWhiteListUserByAddress(ownerB); nil == err {
+		t.Fatalf("FindWhiteListUserByAddress shouldn't return the deleted user")
+	}
+}
+
+func testCheckPoint(t *testing.T, rds dao.RdsService) {
+	if err := rds.SaveCheckPoint(dao.ExtractorRetryQueueType, 1, "[]"); nil != err {
+		t.Fatalf("SaveCheckPoint error:%s", err.Error())
+	}
+	if err := rds.SaveCheckPoint(dao.ExtractorRetryQueueType, 2, `[{"txHash":"0x01"}]`); nil != err {
+		t.Fatalf("SaveCheckPoint error:%s", err.Error())
+	}
+	point, err := rds.QueryCheckPointByType(dao.ExtractorRetryQueueType)
+	if nil != err || point.CheckPoint != 2 || point.Content != `[{"txHash":"0x01"}]` {
+		t.Fatalf("QueryCheckPointByType should return the latest check point, got:%+v err:%v", point, err)
+	}
+}
@@ -178,6 +178,18 @@ func (s *RdsServiceImpl) QueryRecentFills(market, owner string, start int64, end
 	return
 }
 
+// GetFillsByTime returns the fills not forked in [start, end) of all the markets if market is empty,
+// they're in order of time and log index.
+func (s *RdsServiceImpl) GetFillsByTime(market string, start, end int64) ([]FillEvent, error) {
+	var fills []FillEvent
+	db := s.db.Where("create_time >= ? and create_time < ?", start, end).Where("fork = ?", false)
+	if "" != market {
+		db = db.Where("market = ?", market)
+	}
+	err := db.Order("create_time asc, block_number asc, log_index asc").Find(&fills).Error
+	return fills, err
+}
+
 func buildTimeQueryString(start, end int64) string {
 	rst := ""
 	if start != 0 && end == 0 {
@@ -26,8 +26,14 @@ import (
 )
 
 type RdsService interface {
-	// create tables
+	// check the version of schema and apply the migrations
 	Prepare()
+	CheckSchemaVersion() error
+	SchemaVersion() (int, error)
+	MigrationStatus() ([]MigrationStatus, error)
+	MigrateUp(version int) ([]Migration, error)
+	MigrateDown(steps int) ([]Migration, error)
+	Ping() error
 
 	// base functions
 	Add(item interface{}) error
@@ -45,6 +51,7 @@ type RdsService interface {
 	GetOrderByHash(orderhash common.Hash) (*Order, error)
 	GetOrdersByHash(orderhashs []string) (map[string]Order, error)
 	MarkMinerOrders(filterOrderhashs []string, blockNumber int64) error
+	GetOrdersForBook(filterStatus []types.OrderStatus) ([]Order, error)
 	GetOrdersForMiner(protocol, tokenS, tokenB string, length int, filterStatus []types.OrderStatus, reservedTime, startBlockNumber, endBlockNumber int64) ([]*Order, error)
 	GetCutoffOrders(owner common.Address, cutoffTime *big.Int) ([]Order, error)
 	GetCutoffPairOrders(owner, token1, token2 common.Address, cutoffTime *big.Int) ([]Order, error)
@@ -52,20 +59,29 @@ type RdsService interface {
 	GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]Order, error)
 	OrderPageQuery(query map[string]interface{}, statusList []int, pageIndex, pageSize int) (PageResult, error)
 	UpdateBroadcastTimeByHash(hash string, bt int) error
+	GetExpiredOrders(expireTime int64, statusSet []types.OrderStatus, length int) ([]Order, error)
+	ExpireOrder(orderhash common.Hash, statusSet []types.OrderStatus, expireTime int64) (bool, error)
+	SoftCancelOrder(orderhash common.Hash, owner common.Address, statusSet []types.OrderStatus) (bool, error)
 	UpdateOrderWhileRollbackCutoff(orderhash common.Hash, status types.OrderStatus, blockNumber *big.Int) error
 	UpdateOrderWhileFill(hash common.Hash, status types.OrderStatus, dealtAmountS, dealtAmountB, splitAmountS, splitAmountB, blockNumber *big.Int) error
 	UpdateOrderWhileCancel(hash common.Hash, status types.OrderStatus, cancelledAmountS, cancelledAmountB, blockNumber *big.Int) error
 	GetFrozenAmount(owner common.Address, token common.Address, statusSet []types.OrderStatus, delegateAddress common.Address) ([]Order, error)
 	GetFrozenLrcFee(owner common.Address, statusSet []types.OrderStatus) ([]Order, error)
+	CountOpenOrders(owner common.Address, statusSet []types.OrderStatus) (int, error)
+	GetOrdersWithAuthKey(fromId, length int) ([]Order, error)
+	UpdateOrderAuthKey(id int, oldValue, newValue string) (bool, error)
+	WidenOrderAuthKeyColumn() error
 
 	// block table
 	FindBlockByHash(blockhash common.Hash) (*Block, error)
 	FindLatestBlock() (*Block, error)
+	FindLatestBlocks(length int) ([]Block, error)
 	SetForkBlock(from, to int64) error
 	SaveBlock(latest *Block) error
 
 	// fill event table
 	FindFillEvent(txhash string, FillIndex int64) (*FillEvent, error)
+	GetFillsByTime(market string, start, end int64) ([]FillEvent, error)
 	QueryRecentFills(mkt, owner string, start int64, end int64) (fills []FillEvent, err error)
 	GetFillForkEvents(from, to int64) ([]FillEvent, error)
 	RollBackFill(from, to int64) error
@@ -94,6 +110,11 @@ type RdsService interface {
 	TrendQueryByInterval(intervals, market string, start, end int64) (trends []Trend, err error)
 	TrendQueryForProof(mkt string, interval string, start int64) (trends []Trend, err error)
 
+	// candle table
+	SaveCandle(candle *Candle) error
+	GetCandles(market string, resolution, start, end int64) ([]Candle, error)
+	DeleteCandles(market string, resolution, start, end int64) error
+
 	// white list
 	GetWhiteList() ([]WhiteList, error)
 	FindWhiteListUserByAddress(address common.Address) (*WhiteList, error)
@@ -104,8 +125,10 @@ type RdsService interface {
 	//UpdateRingSubmitInfoFailed(ringhashs []common.Hash, err string) error
 
 	UpdateRingSubmitInfoResult(submitResult *types.RingSubmitResultEvent) error
+	ReplaceRingSubmitInfo(info *RingSubmitInfo) error
 	GetRingForSubmitByHash(ringhash common.Hash) (RingSubmitInfo, error)
 	GetRingHashesByTxHash(txHash common.Hash) ([]*RingSubmitInfo, error)
+	GetPendingRingSubmitInfos(miner common.Address) ([]*RingSubmitInfo, error)
 	RingMinedPageQuery(query map[string]interface{}, pageIndex, pageSize int) (res PageResult, err error)
 	GetRingminedMethods(lastId int, limit int) ([]RingMinedEvent, error)
 	GetFilledOrderByRinghash(ringhash common.Hash) ([]*FilledOrder, error)
@@ -133,4 +156,5 @@ type RdsService interface {
 
 	// checkpoint
 	QueryCheckPointByType(businessType string) (point CheckPoint, err error)
+	SaveCheckPoint(businessType string, checkPoint int64, content string) error
 }
@@ -0,0 +1,71 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"time"
+
+	"github.com/Loopring/relay/metrics"
+	"github.com/jinzhu/gorm"
+)
+
+const metricsStartTime = "metrics:start_time"
+
+// registerMetricsCallbacks observes the latency of queries by the callbacks of gorm,
+// they wrap the whole chain of each operation including the transaction gorm begins.
+func registerMetricsCallbacks(db *gorm.DB) {
+	callbacks := db.Callback()
+
+	callbacks.Create().Before("gorm:begin_transaction").Register("metrics:before_create", startQueryTimer)
+	callbacks.Create().After("gorm:commit_or_rollback_transaction").Register("metrics:after_create", observeQuery("create"))
+
+	callbacks.Update().Before("gorm:assign_updating_attributes").Register("metrics:before_update", startQueryTimer)
+	callbacks.Update().After("gorm:commit_or_rollback_transaction").Register("metrics:after_update", observeQuery("update"))
+
+	callbacks.Delete().Before("gorm:begin_transaction").Register("metrics:before_delete", startQueryTimer)
+	callbacks.Delete().After("gorm:commit_or_rollback_transaction").Register("metrics:after_delete", observeQuery("delete"))
+
+	callbacks.Query().Before("gorm:query").Register("metrics:before_query", startQueryTimer)
+	callbacks.Query().After("gorm:after_query").Register("metrics:after_query", observeQuery("query"))
+
+	callbacks.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", startQueryTimer)
+	callbacks.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", observeQuery("row_query"))
+}
+
+func startQueryTimer(scope *gorm.Scope) {
+	scope.InstanceSet(metricsStartTime, time.Now())
+}
+
+func observeQuery(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.InstanceGet(metricsStartTime)
+		if !ok {
+			return
+		}
+		start, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+		table := scope.TableName()
+		metrics.ObserveSince(metrics.RdsDuration.WithLabelValues(operation, table), start)
+		if scope.HasError() && gorm.ErrRecordNotFound != scope.DB().Error {
+			metrics.RdsErrors.WithLabelValues(operation, table).Inc()
+		}
+	}
+}
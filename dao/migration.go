@@ -0,0 +1,235 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Loopring/relay/log"
+	"github.com/jinzhu/gorm"
+)
+
+// Migration changes the schema from Version-1 to Version, Down reverts it
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(db *gorm.DB) error
+	Down    func(db *gorm.DB) error
+}
+
+// SchemaMigration is a row of the table schema_migrations, it's saved when the migration has been applied
+type SchemaMigration struct {
+	Version   int    `gorm:"column:version;primary_key;auto_increment:false"`
+	Name      string `gorm:"column:name;type:varchar(128)"`
+	AppliedAt int64  `gorm:"column:applied_at;type:bigint"`
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt int64
+}
+
+var (
+	ErrUnknownSchemaVersion = errors.New("unknown schema version")
+	ErrPendingMigrations    = errors.New("pending migrations")
+)
+
+var migrations = make(map[int]Migration)
+
+// RegisterMigration is called in init, the versions must be continuous from 1
+func RegisterMigration(m Migration) {
+	if _, exists := migrations[m.Version]; exists {
+		panic(fmt.Sprintf("migration version:%d has been registered", m.Version))
+	}
+	migrations[m.Version] = m
+}
+
+func sortedMigrations() []Migration {
+	list := []Migration{}
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// LatestSchemaVersion is the version of schema this binary works with
+func LatestSchemaVersion() int {
+	list := sortedMigrations()
+	if len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].Version
+}
+
+func (s *RdsServiceImpl) appliedMigrations() (map[int]SchemaMigration, error) {
+	applied := make(map[int]SchemaMigration)
+	if !s.db.HasTable(&SchemaMigration{}) {
+		if err := s.db.CreateTable(&SchemaMigration{}).Error; nil != err {
+			return applied, err
+		}
+		return applied, nil
+	}
+
+	var list []SchemaMigration
+	if err := s.db.Order("version asc").Find(&list).Error; nil != err {
+		return applied, err
+	}
+	for _, m := range list {
+		applied[m.Version] = m
+	}
+	return applied, nil
+}
+
+// SchemaVersion returns the latest version applied, it's 0 if nothing has been applied
+func (s *RdsServiceImpl) SchemaVersion() (int, error) {
+	applied, err := s.appliedMigrations()
+	if nil != err {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// MigrationStatus returns the registered migrations and the applied ones unknown by this binary
+func (s *RdsServiceImpl) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if nil != err {
+		return nil, err
+	}
+
+	list := []MigrationStatus{}
+	for _, m := range sortedMigrations() {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = row.AppliedAt
+			delete(applied, m.Version)
+		}
+		list = append(list, status)
+	}
+	for _, row := range applied {
+		list = append(list, MigrationStatus{Version: row.Version, Name: row.Name, Applied: true, AppliedAt: row.AppliedAt})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// CheckSchemaVersion returns ErrUnknownSchemaVersion if the db has been migrated by a newer binary,
+// and ErrPendingMigrations if some of the migrations haven't been applied.
+func (s *RdsServiceImpl) CheckSchemaVersion() error {
+	list, err := s.MigrationStatus()
+	if nil != err {
+		return err
+	}
+	pending := false
+	for _, status := range list {
+		if _, ok := migrations[status.Version]; !ok {
+			return fmt.Errorf("%s:%d(%s), the latest known version is %d", ErrUnknownSchemaVersion.Error(), status.Version, status.Name, LatestSchemaVersion())
+		}
+		if !status.Applied {
+			pending = true
+		}
+	}
+	if pending {
+		return ErrPendingMigrations
+	}
+	return nil
+}
+
+// MigrateUp applies the pending migrations until version, all of them are applied if version <= 0
+func (s *RdsServiceImpl) MigrateUp(version int) ([]Migration, error) {
+	done := []Migration{}
+	applied, err := s.appliedMigrations()
+	if nil != err {
+		return done, err
+	}
+	for v := range applied {
+		if _, ok := migrations[v]; !ok {
+			return done, fmt.Errorf("%s:%d", ErrUnknownSchemaVersion.Error(), v)
+		}
+	}
+
+	for _, m := range sortedMigrations() {
+		if version > 0 && m.Version > version {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.runMigration(m, true); nil != err {
+			return done, fmt.Errorf("migrate up to %d(%s) error:%s", m.Version, m.Name, err.Error())
+		}
+		log.Infof("dao,schema migrated up to %d(%s)", m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the latest steps migrations
+func (s *RdsServiceImpl) MigrateDown(steps int) ([]Migration, error) {
+	done := []Migration{}
+	applied, err := s.appliedMigrations()
+	if nil != err {
+		return done, err
+	}
+
+	list := sortedMigrations()
+	for i := len(list) - 1; i >= 0 && len(done) < steps; i-- {
+		m := list[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := s.runMigration(m, false); nil != err {
+			return done, fmt.Errorf("migrate down from %d(%s) error:%s", m.Version, m.Name, err.Error())
+		}
+		log.Infof("dao,schema migrated down from %d(%s)", m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// runMigration runs the migration and saves the version. It isn't run in a transaction because mysql commits ddl
+// implicitly, so Up should be safe to run again after it fails halfway.
+func (s *RdsServiceImpl) runMigration(m Migration, up bool) error {
+	if up {
+		if err := m.Up(s.db); nil != err {
+			return err
+		}
+		return s.db.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().Unix()}).Error
+	}
+
+	if nil == m.Down {
+		return fmt.Errorf("migration %d(%s) can't be reverted", m.Version, m.Name)
+	}
+	if err := m.Down(s.db); nil != err {
+		return err
+	}
+	return s.db.Where("version = ?", m.Version).Delete(&SchemaMigration{}).Error
+}
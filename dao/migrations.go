@@ -0,0 +1,44 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import "github.com/jinzhu/gorm"
+
+// the migrations of schema, a released migration must not be changed, add a new one instead
+func init() {
+	//the tables of migration 1 may be adopted from the production databases, so they are never dropped by rollback
+	RegisterMigration(Migration{Version: 1, Name: "create_tables", Up: createTablesUp})
+	RegisterMigration(Migration{Version: 2, Name: "widen_order_priv_key", Up: widenOrderAuthKeyColumn, Down: widenOrderAuthKeyDown})
+	RegisterMigration(Migration{Version: 3, Name: "create_candles", Up: createCandleTableUp, Down: createCandleTableDown})
+}
+
+// createTablesUp creates the tables from the models frozen in migration 1
+func createTablesUp(db *gorm.DB) error {
+	for _, t := range initialTables() {
+		if err := t.createOrAdopt(db); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// the sealed auth private keys don't fit in the old length, so the column is kept
+func widenOrderAuthKeyDown(db *gorm.DB) error {
+	return nil
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/types"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
 	"math/big"
 	"strconv"
 	"strings"
@@ -40,7 +41,7 @@ type Order struct {
 	DelegateAddress       string  `gorm:"column:delegate_address;type:varchar(42)"`
 	Owner                 string  `gorm:"column:owner;type:varchar(42)"`
 	AuthAddress           string  `gorm:"column:auth_address;type:varchar(42)"`
-	PrivateKey            string  `gorm:"column:priv_key;type:varchar(128)"`
+	PrivateKey            string  `gorm:"column:priv_key;type:varchar(512)"`
 	WalletAddress         string  `gorm:"column:wallet_address;type:varchar(42)"`
 	OrderHash             string  `gorm:"column:order_hash;type:varchar(82)"`
 	TokenS                string  `gorm:"column:token_s;type:varchar(42)"`
@@ -52,8 +53,8 @@ type Order struct {
 	ValidUntil            int64   `gorm:"column:valid_until;type:bigint"`
 	LrcFee                string  `gorm:"column:lrc_fee;type:varchar(40)"`
 	BuyNoMoreThanAmountB  bool    `gorm:"column:buy_nomore_than_amountb"`
-	MarginSplitPercentage uint8   `gorm:"column:margin_split_percentage;type:tinyint(4)"`
-	V                     uint8   `gorm:"column:v;type:tinyint(4)"`
+	MarginSplitPercentage uint8   `gorm:"column:margin_split_percentage;type:smallint"`
+	V                     uint8   `gorm:"column:v;type:smallint"`
 	R                     string  `gorm:"column:r;type:varchar(66)"`
 	S                     string  `gorm:"column:s;type:varchar(66)"`
 	PowNonce              uint64  `gorm:"column:pow_nonce;type:bigint"`
@@ -65,12 +66,12 @@ type Order struct {
 	CancelledAmountB      string  `gorm:"column:cancelled_amount_b;type:varchar(40)"`
 	SplitAmountS          string  `gorm:"column:split_amount_s;type:varchar(40)"`
 	SplitAmountB          string  `gorm:"column:split_amount_b;type:varchar(40)"`
-	Status                uint8   `gorm:"column:status;type:tinyint(4)"`
+	Status                uint8   `gorm:"column:status;type:smallint"`
 	MinerBlockMark        int64   `gorm:"column:miner_block_mark;type:bigint"`
 	BroadcastTime         int     `gorm:"column:broadcast_time;type:bigint"`
 	Market                string  `gorm:"column:market;type:varchar(40)"`
-	Side                  string  `gorm:"column:side;type:varchar(40)`
-	OrderType             string  `gorm:"column:order_type;type:varchar(40)`
+	Side                  string  `gorm:"column:side;type:varchar(40)"`
+	OrderType             string  `gorm:"column:order_type;type:varchar(40)"`
 }
 
 // convert types/orderState to dao/order
@@ -92,8 +93,14 @@ func (o *Order) ConvertDown(state *types.OrderState) error {
 	o.DelegateAddress = src.DelegateAddress.Hex()
 	o.Owner = src.Owner.Hex()
 
-	auth, _ := src.AuthPrivateKey.MarshalText()
-	o.PrivateKey = string(auth)
+	// the auth private key is sealed by the master key, it's kept if the order was loaded from db
+	if auth, err := crypto.SealAuthPrivateKey(src.Hash, src.AuthPrivateKey); nil != err {
+		return fmt.Errorf("dao order convert down seal auth private key error:%s", err.Error())
+	} else if "" != auth {
+		o.PrivateKey = auth
+	} else {
+		o.PrivateKey = src.SealedAuthPrivateKey
+	}
 	o.AuthAddress = src.AuthAddr.Hex()
 	o.WalletAddress = src.WalletAddress.Hex()
 
@@ -144,11 +151,8 @@ func (o *Order) ConvertUp(state *types.OrderState) error {
 	if len(o.AuthAddress) > 0 {
 		state.RawOrder.AuthAddr = common.HexToAddress(o.AuthAddress)
 	}
-	if len(o.PrivateKey) > 0 {
-		authPrivateKey, err := crypto.NewPrivateKeyCrypto(false, o.PrivateKey); if err == nil {
-			state.RawOrder.AuthPrivateKey = authPrivateKey
-		}
-	}
+	// the auth private key isn't opened here, the miner opens it before signing the ring
+	state.RawOrder.SealedAuthPrivateKey = o.PrivateKey
 	state.RawOrder.WalletAddress = common.HexToAddress(o.WalletAddress)
 
 	state.RawOrder.BuyNoMoreThanAmountB = o.BuyNoMoreThanAmountB
@@ -226,6 +230,24 @@ func (s *RdsServiceImpl) GetOrdersForMiner(protocol, tokenS, tokenB string, leng
 	return list, err
 }
 
+func (s *RdsServiceImpl) GetOrdersForBook(filterStatus []types.OrderStatus) ([]Order, error) {
+	var (
+		list []Order
+		err  error
+	)
+
+	if len(filterStatus) < 1 {
+		return list, errors.New("should filter cutoff and finished orders")
+	}
+
+	err = s.db.Where("status not in (?) ", filterStatus).
+		Where("order_type = ? ", types.ORDER_TYPE_MARKET).
+		Find(&list).
+		Error
+
+	return list, err
+}
+
 func (s *RdsServiceImpl) GetOrdersByHash(orderhashs []string) (map[string]Order, error) {
 	var (
 		list []Order
@@ -465,6 +487,37 @@ func (s *RdsServiceImpl) UpdateOrderWhileCancel(hash common.Hash, status types.O
 	return s.db.Model(&Order{}).Where("order_hash = ?", hash.Hex()).Update(items).Error
 }
 
+func (s *RdsServiceImpl) GetExpiredOrders(expireTime int64, statusSet []types.OrderStatus, length int) ([]Order, error) {
+	var (
+		list []Order
+		err  error
+	)
+
+	err = s.db.Where("valid_until < ?", expireTime).
+		Where("status in (?)", statusSet).
+		Order("valid_until asc").
+		Limit(length).
+		Find(&list).Error
+
+	return list, err
+}
+
+// ExpireOrder only updates the order that hasn't been changed by others, it returns false if the order isn't updated
+func (s *RdsServiceImpl) ExpireOrder(orderhash common.Hash, statusSet []types.OrderStatus, expireTime int64) (bool, error) {
+	db := s.db.Model(&Order{}).
+		Where("order_hash = ? and status in (?) and valid_until < ?", orderhash.Hex(), statusSet, expireTime).
+		Update("status", uint8(types.ORDER_EXPIRE))
+	return db.RowsAffected > 0, db.Error
+}
+
+// SoftCancelOrder only updates the order of owner in statusSet, it returns false if the order isn't updated
+func (s *RdsServiceImpl) SoftCancelOrder(orderhash common.Hash, owner common.Address, statusSet []types.OrderStatus) (bool, error) {
+	db := s.db.Model(&Order{}).
+		Where("order_hash = ? and owner = ? and status in (?)", orderhash.Hex(), owner.Hex(), statusSet).
+		Update("status", uint8(types.ORDER_SOFT_CANCEL))
+	return db.RowsAffected > 0, db.Error
+}
+
 func (s *RdsServiceImpl) UpdateOrderWhileRollbackCutoff(orderhash common.Hash, status types.OrderStatus, blockNumber *big.Int) error {
 	items := map[string]interface{}{
 		"status":        uint8(status),
@@ -478,6 +531,10 @@ func (s *RdsServiceImpl) GetFrozenAmount(owner common.Address, token common.Addr
 		list []Order
 		err  error
 	)
+	if len(statusSet) == 0 {
+		return list, nil
+	}
+	//the orders past valid_until aren't frozen even if they haven't been swept into ORDER_EXPIRE
 	now := time.Now().Unix()
 	err = s.db.Model(&Order{}).
 		Where("token_s = ? and owner = ? and delegate_address = ? and status in "+buildStatusInSet(statusSet), token.Hex(), owner.Hex(), delegateAddress.Hex()).
@@ -493,6 +550,9 @@ func (s *RdsServiceImpl) GetFrozenLrcFee(owner common.Address, statusSet []types
 		err  error
 	)
 
+	if len(statusSet) == 0 {
+		return list, nil
+	}
 	now := time.Now().Unix()
 	err = s.db.Model(&Order{}).
 		Where("lrc_fee > 0 and owner = ? and status in "+buildStatusInSet(statusSet), owner.Hex()).
@@ -515,3 +575,62 @@ func buildStatusInSet(statusSet []types.OrderStatus) string {
 	result += ")"
 	return result
 }
+
+func (s *RdsServiceImpl) CountOpenOrders(owner common.Address, statusSet []types.OrderStatus) (int, error) {
+	var count int
+	err := s.db.Model(&Order{}).
+		Where("owner = ? and status in (?)", owner.Hex(), statusSet).
+		Where("valid_until >= ?", time.Now().Unix()).
+		Count(&count).Error
+	return count, err
+}
+
+// GetOrdersWithAuthKey returns the orders which have auth private key and id > fromId in ascending order of id
+func (s *RdsServiceImpl) GetOrdersWithAuthKey(fromId, length int) ([]Order, error) {
+	var list []Order
+	err := s.db.Where("id > ? and priv_key <> ?", fromId, "").
+		Order("id asc").
+		Limit(length).
+		Find(&list).Error
+	return list, err
+}
+
+// UpdateOrderAuthKey replaces the auth private key only if it hasn't been changed since it was read
+func (s *RdsServiceImpl) UpdateOrderAuthKey(id int, oldValue, newValue string) (bool, error) {
+	res := s.db.Model(&Order{}).Where("id = ? and priv_key = ?", id, oldValue).Update("priv_key", newValue)
+	return res.RowsAffected > 0, res.Error
+}
+
+// WidenOrderAuthKeyColumn changes the length of priv_key created before the keys were sealed
+func (s *RdsServiceImpl) WidenOrderAuthKeyColumn() error {
+	return widenOrderAuthKeyColumn(s.db)
+}
+
+// sqlite doesn't limit the length of varchar
+func widenOrderAuthKeyColumn(db *gorm.DB) error {
+	var (
+		length int
+		schema string
+	)
+	switch db.Dialect().GetName() {
+	case DRIVER_MYSQL:
+		schema = "table_schema = database()"
+	case DRIVER_POSTGRES:
+		schema = "table_catalog = current_database()"
+	default:
+		return nil
+	}
+
+	tableName := db.NewScope(&Order{}).TableName()
+	row := db.Raw("select character_maximum_length from information_schema.columns where "+schema+" and table_name = ? and column_name = ?", tableName, "priv_key").Row()
+	if err := row.Scan(&length); nil != err {
+		return err
+	}
+	if length >= 512 {
+		return nil
+	}
+	if DRIVER_POSTGRES == db.Dialect().GetName() {
+		return db.Exec("alter table " + db.Dialect().Quote(tableName) + " alter column priv_key type varchar(512)").Error
+	}
+	return db.Model(&Order{}).ModifyColumn("priv_key", "varchar(512)").Error
+}
@@ -129,6 +129,8 @@ type RingSubmitInfo struct {
 	ProtocolGasPrice string `gorm:"column:protocol_gas_price;type:varchar(50)"`
 	ProtocolUsedGas  string `gorm:"column:protocol_used_gas;type:varchar(50)"`
 	ProtocolTxHash   string `gorm:"column:protocol_tx_hash;type:varchar(82)"`
+	ProtocolNonce    string `gorm:"column:protocol_nonce;type:varchar(50)"`
+	ReplacedTxHash   string `gorm:"column:replaced_tx_hash;type:varchar(82)"` //the pending tx replaced by this one
 
 	Status      int       `gorm:"column:status;type:int"`
 	RingIndex   string    `gorm:"column:ring_index;type:varchar(50)"`
@@ -157,6 +159,7 @@ func (info *RingSubmitInfo) ConvertDown(typesInfo *types.RingSubmitInfo, err err
 	info.ProtocolGasPrice = getBigIntString(typesInfo.ProtocolGasPrice)
 	info.Miner = typesInfo.Miner.Hex()
 	info.ProtocolTxHash = typesInfo.SubmitTxHash.Hex()
+	info.ProtocolNonce = getBigIntString(typesInfo.ProtocolNonce)
 	if nil != err {
 		info.Err = err.Error()
 	}
@@ -175,6 +178,10 @@ func (info *RingSubmitInfo) ConvertUp(typesInfo *types.RingSubmitInfo) error {
 	typesInfo.ProtocolGasPrice = new(big.Int)
 	typesInfo.ProtocolGasPrice.SetString(info.ProtocolGasPrice, 0)
 	typesInfo.SubmitTxHash = common.HexToHash(info.ProtocolTxHash)
+	if "" != info.ProtocolNonce {
+		typesInfo.ProtocolNonce = new(big.Int)
+		typesInfo.ProtocolNonce.SetString(info.ProtocolNonce, 0)
+	}
 	typesInfo.Miner = common.HexToAddress(info.Miner)
 	return nil
 }
@@ -208,7 +215,28 @@ func (s *RdsServiceImpl) UpdateRingSubmitInfoResult(submitResult *types.RingSubm
 		items["err"] = submitResult.Err.Error()
 	}
 	dbForUpdate := s.db.Model(&RingSubmitInfo{}).Where("ringhash = ? and protocol_tx_hash = ? ", submitResult.RingHash.Hex(), submitResult.TxHash.Hex())
-	return dbForUpdate.Update(items).Error
+	if err := dbForUpdate.Update(items).Error; nil != err {
+		return err
+	}
+	//the other txs of the ring are replaced by the one mined, no matter which of them is sent first
+	dbForReplaced := s.db.Model(&RingSubmitInfo{}).Where("ringhash = ? and protocol_tx_hash <> ? and status = ?", submitResult.RingHash.Hex(), submitResult.TxHash.Hex(), uint8(types.TX_STATUS_PENDING))
+	return dbForReplaced.Update("status", uint8(types.TX_STATUS_REPLACED)).Error
+}
+
+// ReplaceRingSubmitInfo saves the tx replacing info.ReplacedTxHash, and marks the replaced one in the same transaction,
+// so it won't be loaded as a pending tx again
+func (s *RdsServiceImpl) ReplaceRingSubmitInfo(info *RingSubmitInfo) error {
+	tx := s.db.Begin()
+	dbForReplaced := tx.Model(&RingSubmitInfo{}).Where("ringhash = ? and protocol_tx_hash = ? and status = ?", info.RingHash, info.ReplacedTxHash, uint8(types.TX_STATUS_PENDING))
+	if err := dbForReplaced.Update("status", uint8(types.TX_STATUS_REPLACED)).Error; nil != err {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Create(info).Error; nil != err {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
 //func (s *RdsServiceImpl) UpdateRingSubmitInfoProtocolTxHash(ringhash common.Hash, txHash string) error {
@@ -217,7 +245,8 @@ func (s *RdsServiceImpl) UpdateRingSubmitInfoResult(submitResult *types.RingSubm
 //}
 
 func (s *RdsServiceImpl) GetRingForSubmitByHash(ringhash common.Hash) (ringForSubmit RingSubmitInfo, err error) {
-	err = s.db.Where("ringhash = ? ", ringhash.Hex()).First(&ringForSubmit).Error
+	//the latest tx of the ring, the ones replaced by it are skipped
+	err = s.db.Where("ringhash = ? and status <> ?", ringhash.Hex(), uint8(types.TX_STATUS_REPLACED)).Order("id desc").First(&ringForSubmit).Error
 	return
 }
 
@@ -234,6 +263,21 @@ func (s *RdsServiceImpl) GetRingHashesByTxHash(txHash common.Hash) ([]*RingSubmi
 	return infos, err
 }
 
+//the pending txs that can be replaced
+func (s *RdsServiceImpl) GetPendingRingSubmitInfos(miner common.Address) ([]*RingSubmitInfo, error) {
+	var (
+		err   error
+		infos []*RingSubmitInfo
+	)
+
+	err = s.db.Where("miner = ? and status = ? and protocol_nonce <> '' ", miner.Hex(), uint8(types.TX_STATUS_PENDING)).
+		Order("id asc").
+		Find(&infos).
+		Error
+
+	return infos, err
+}
+
 func (s *RdsServiceImpl) UpdateRingSubmitInfoSubmitUsedGas(txHash string, usedGas *big.Int) error {
 	dbForUpdate := s.db.Model(&RingSubmitInfo{}).Where("protocol_tx_hash = ?", txHash)
 	return dbForUpdate.Update("protocol_used_gas", getBigIntString(usedGas)).Error
@@ -41,7 +41,7 @@ type RingMinedEvent struct {
 	TradeAmount        int    `gorm:"column:trade_amount" json:"tradeAmount"`
 	Time               int64  `gorm:"column:time;type:bigint" json:"timestamp"`
 	Fork               bool   `gorm:"column:fork"`
-	Status             uint8  `gorm:"column:status;type:tinyint(4)"`
+	Status             uint8  `gorm:"column:status;type:smallint"`
 	GasLimit           string `gorm:"column:gas_limit;type:varchar(50)"`
 	GasUsed            string `gorm:"column:gas_used;type:varchar(50)"`
 	GasPrice           string `gorm:"column:gas_price;type:varchar(50)"`
@@ -162,7 +162,7 @@ type RingMinedMethod struct {
 	DelegateAddress string `gorm:"column:delegate_address;type:varchar(42)" json:"delegateAddress"`
 	TxHash          string `gorm:"column:tx_hash;type:varchar(82)" json:"txHash"`
 	BlockNumber     int64  `gorm:"column:block_number;type:bigint" json:"blockNumber"`
-	Status          uint8  `gorm:"column:status;type:tinyint(4)"`
+	Status          uint8  `gorm:"column:status;type:smallint"`
 	GasLimit        string `gorm:"column:gas_limit;type:varchar(50)"`
 	GasUsed         string `gorm:"column:gas_used;type:varchar(50)"`
 	GasPrice        string `gorm:"column:gas_price;type:varchar(50)"`
@@ -51,12 +51,12 @@ func (s *RdsServiceImpl) TrendQueryLatest(query Trend, pageIndex, pageSize int)
 }
 
 func (s *RdsServiceImpl) TrendQueryByTime(intervals, market string, start, end int64) (trends []Trend, err error) {
-	err = s.db.Model(&Trend{}).Where("intervals = ? and market = ? and start = ? and end = ?", intervals, market, start, end).Order("start desc").Find(&trends).Error
+	err = s.db.Model(&Trend{}).Where("intervals = ? and market = ? and start = ? and "+s.quote("end")+" = ?", intervals, market, start, end).Order("start desc").Find(&trends).Error
 	return
 }
 
 func (s *RdsServiceImpl) TrendQueryByInterval(intervals, market string, start, end int64) (trends []Trend, err error) {
-	err = s.db.Model(&Trend{}).Where("intervals = ? and market = ? and start >= ? and end <= ?", intervals, market, start, end).Order("start").Find(&trends).Error
+	err = s.db.Model(&Trend{}).Where("intervals = ? and market = ? and start >= ? and "+s.quote("end")+" <= ?", intervals, market, start, end).Order("start").Find(&trends).Error
 	return
 }
 
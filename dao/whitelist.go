@@ -37,7 +37,7 @@ func (s *RdsServiceImpl) GetWhiteList() ([]WhiteList, error) {
 		err  error
 	)
 
-	err = s.db.Where("is_deleted = false").Find(&list).Error
+	err = s.db.Where("is_deleted = ?", false).Find(&list).Error
 
 	return list, err
 }
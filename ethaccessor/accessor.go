@@ -33,6 +33,14 @@ import (
 
 var accessor *ethNodeAccessor
 
+// Clients returns the status of the eth nodes configured
+func Clients() []ClientStatus {
+	if nil == accessor {
+		return []ClientStatus{}
+	}
+	return accessor.ClientStatus()
+}
+
 func BlockNumber(result interface{}) error {
 	return accessor.RetryCall("latest", 5, result, "eth_blockNumber")
 }
@@ -53,6 +61,10 @@ func Call(result interface{}, ethCall *CallArg, blockNumber string) error {
 	return accessor.RetryCall(blockNumber, 2, result, "eth_call", ethCall, blockNumber)
 }
 
+func EstimateGasOfCall(result interface{}, ethCall *CallArg, blockNumber string) error {
+	return accessor.RetryCall(blockNumber, 2, result, "eth_estimateGas", ethCall)
+}
+
 func GetBlockByNumber(result interface{}, blockNumber *big.Int, withObject bool) error {
 	return accessor.RetryCall(blockNumber.String(), 2, result, "eth_getBlockByNumber", fmt.Sprintf("%#x", blockNumber), withObject)
 }
@@ -117,6 +129,10 @@ func SignAndSendTransaction(sender common.Address, to common.Address, gas, gasPr
 	return accessor.ContractSendTransactionByData("latest", sender, to, gas, gasPrice, value, callData, needPreExe)
 }
 
+func SignAndSendTransactionWithNonce(sender common.Address, to common.Address, gas, gasPrice, value, nonce *big.Int, callData []byte) (string, error) {
+	return accessor.ContractSendTransactionWithNonce(sender, to, gas, gasPrice, value, nonce, callData)
+}
+
 func ContractSendTransactionMethod(routeParam string, a *abi.ABI, contractAddress common.Address) func(sender common.Address, methodName string, gas, gasPrice, value *big.Int, args ...interface{}) (string, error) {
 	return accessor.ContractSendTransactionMethod(routeParam, a, contractAddress)
 }
@@ -346,5 +362,12 @@ func Initialize(accessorOptions config.AccessorOptions, commonOptions config.Com
 
 func IncludeGasPriceEvaluator() {
 	accessor.gasPriceEvaluator = &GasPriceEvaluator{}
+	accessor.gasPriceEvaluator.stopChan = make(chan bool)
 	accessor.gasPriceEvaluator.start()
 }
+
+func ExcludeGasPriceEvaluator() {
+	if nil != accessor.gasPriceEvaluator {
+		accessor.gasPriceEvaluator.stop()
+	}
+}
@@ -43,6 +43,7 @@ const (
 type MutilClient struct {
 	clients       map[string]*RpcClient
 	downedClients map[string]*RpcClient
+	mtx           sync.RWMutex
 }
 
 type RpcClient struct {
@@ -51,6 +52,13 @@ type RpcClient struct {
 	blockNumber *big.Int
 }
 
+// ClientStatus is the state of an eth node reported by the admin api
+type ClientStatus struct {
+	Url         string `json:"url"`
+	BlockNumber string `json:"blockNumber"`
+	Downed      bool   `json:"downed"`
+}
+
 type SyncingResult struct {
 	StartingBlock types.Big
 	CurrentBlock  types.Big
@@ -71,7 +79,11 @@ func NewMutilClient(urls []string) *MutilClient {
 func (mc *MutilClient) newRpcClient(url string) {
 	rpcClient := &RpcClient{}
 	rpcClient.url = url
-	if client, err := rpc.DialHTTP(url); nil != err {
+	client, err := rpc.DialHTTP(url)
+
+	mc.mtx.Lock()
+	defer mc.mtx.Unlock()
+	if nil != err {
 		log.Errorf("rpc.Dail err : %s, url:%s", err.Error(), url)
 		mc.downedClients[url] = rpcClient
 	} else {
@@ -80,6 +92,41 @@ func (mc *MutilClient) newRpcClient(url string) {
 	}
 }
 
+// ClientStatus returns all the eth nodes, the ones failed to dial or to get block number are downed
+func (mc *MutilClient) ClientStatus() []ClientStatus {
+	mc.mtx.RLock()
+	defer mc.mtx.RUnlock()
+
+	list := []ClientStatus{}
+	for url, client := range mc.clients {
+		status := ClientStatus{Url: url}
+		if nil != client.blockNumber {
+			status.BlockNumber = client.blockNumber.String()
+		}
+		_, status.Downed = mc.downedClients[url]
+		list = append(list, status)
+	}
+	for url := range mc.downedClients {
+		if _, exists := mc.clients[url]; !exists {
+			list = append(list, ClientStatus{Url: url, Downed: true})
+		}
+	}
+	return list
+}
+
+func (mc *MutilClient) usableClients(blockNumber *big.Int) []string {
+	mc.mtx.RLock()
+	defer mc.mtx.RUnlock()
+
+	urls := []string{}
+	for url, client := range mc.clients {
+		if _, exists := mc.downedClients[url]; !exists && (nil == client.blockNumber || client.blockNumber.Cmp(blockNumber) >= 0) {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
 func (mc *MutilClient) bestClient(routeParam string) *RpcClient {
 	//latest,pending
 
@@ -89,10 +136,11 @@ func (mc *MutilClient) bestClient(routeParam string) *RpcClient {
 		mc.BlockNumber(&blockNumber)
 	} else if strings.Contains(routeParam, ":") {
 		//specific node
-		for _, c := range mc.clients {
-			if routeParam == c.url {
-				return c
-			}
+		mc.mtx.RLock()
+		c, exists := mc.clients[routeParam]
+		mc.mtx.RUnlock()
+		if exists {
+			return c
 		}
 	} else {
 		var blockNumberForRouteBig *big.Int
@@ -108,34 +156,31 @@ func (mc *MutilClient) bestClient(routeParam string) *RpcClient {
 	urls, _ := mc.useageClient(blockNumber.BigInt().String())
 
 	for _, url := range urls {
-		if _, exists := mc.clients[url]; !exists {
+		mc.mtx.RLock()
+		_, exists := mc.clients[url]
+		mc.mtx.RUnlock()
+		if !exists {
 			mc.newRpcClient(url)
 		}
 	}
 
 	if len(urls) <= 0 {
-		for url, client := range mc.clients {
-			if _, exists := mc.downedClients[url]; !exists && (nil == client.blockNumber || client.blockNumber.Cmp(blockNumber.BigInt()) >= 0) {
-				urls = append(urls, url)
-			}
-		}
+		urls = mc.usableClients(blockNumber.BigInt())
 	}
 
 	if len(urls) == 0 {
 		log.Debugf("len(urls) == 0")
 		mc.syncBlockNumber()
-		for url, client := range mc.clients {
-			if _, exists := mc.downedClients[url]; !exists && (nil == client.blockNumber || client.blockNumber.Cmp(blockNumber.BigInt()) >= 0) {
-				urls = append(urls, url)
-			}
-		}
+		urls = mc.usableClients(blockNumber.BigInt())
 		log.Debugf("after syncBlockNumber len(urls) == %d", len(urls))
 	}
 
 	if len(urls) > 0 {
 		idx := 0
 		idx = rand.Intn(len(urls))
+		mc.mtx.RLock()
 		client := mc.clients[urls[idx]]
+		mc.mtx.RUnlock()
 		return client
 	} else {
 		return nil
@@ -143,13 +188,24 @@ func (mc *MutilClient) bestClient(routeParam string) *RpcClient {
 }
 
 func (mc *MutilClient) syncBlockNumber() {
+	mc.mtx.RLock()
+	clients := []*RpcClient{}
 	for _, client := range mc.clients {
+		clients = append(clients, client)
+	}
+	mc.mtx.RUnlock()
+
+	for _, client := range clients {
 		var blockNumber types.Big
-		if err := client.client.Call(&blockNumber, "eth_blockNumber"); nil != err {
+		err := client.client.Call(&blockNumber, "eth_blockNumber")
+		mc.mtx.Lock()
+		if nil != err {
 			mc.downedClients[client.url] = client
+			mc.mtx.Unlock()
 		} else {
 			delete(mc.downedClients, client.url)
 			client.blockNumber = blockNumber.BigInt()
+			mc.mtx.Unlock()
 			blockNumberStr := blockNumber.BigInt().String()
 			cache.SAdd(USAGE_CLIENT_BLOCK+blockNumberStr, cacheDuration, []byte(client.url))
 			cache.ZAdd(BLOCKS, int64(0), []byte(blockNumberStr), []byte(blockNumberStr))
@@ -84,7 +84,7 @@ func (e *GasPriceEvaluator) start() {
 }
 
 func (e *GasPriceEvaluator) stop() {
-	e.stopChan <- true
+	close(e.stopChan)
 }
 
 type gasPrices []*big.Int
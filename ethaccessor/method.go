@@ -393,6 +393,33 @@ func (accessor *ethNodeAccessor) ContractSendTransactionByData(routeParam string
 	return txHash, nil
 }
 
+//it is used to replace a pending tx, the nonce of sender isn't changed
+func (accessor *ethNodeAccessor) ContractSendTransactionWithNonce(sender common.Address, to common.Address, gas, gasPrice, value, nonce *big.Int, callData []byte) (string, error) {
+	if nil == gasPrice || gasPrice.Cmp(big.NewInt(0)) <= 0 {
+		return "", errors.New("gasPrice must be setted.")
+	}
+	if nil == gas || gas.Cmp(big.NewInt(0)) <= 0 {
+		return "", errors.New("gas must be setted.")
+	}
+	if nil == nonce {
+		return "", errors.New("nonce must be setted.")
+	}
+	if value == nil {
+		value = big.NewInt(0)
+	}
+	var txHash string
+	transaction := ethTypes.NewTransaction(nonce.Uint64(),
+		common.HexToAddress(to.Hex()),
+		value,
+		gas,
+		gasPrice,
+		callData)
+	if err := accessor.SignAndSendTransaction(&txHash, sender, transaction); nil != err {
+		return "", err
+	}
+	return txHash, nil
+}
+
 //gas, gasPrice can be set to nil
 func (accessor *ethNodeAccessor) ContractSendTransactionMethod(routeParam string, a *abi.ABI, contractAddress common.Address) func(sender common.Address, methodName string, gas, gasPrice, value *big.Int, args ...interface{}) (string, error) {
 	return func(sender common.Address, methodName string, gas, gasPrice, value *big.Int, args ...interface{}) (string, error) {
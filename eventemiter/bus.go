@@ -0,0 +1,172 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package eventemitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+	"os"
+	"time"
+)
+
+// the bus shares the events of registered topics with other processes through the transport,
+// the events are dispatched to the watchers of this process before they are published.
+type eventBus struct {
+	transport     Transport
+	source        string
+	group         string
+	consumer      string
+	topics        map[string]bool
+	replayOffsets map[string]string
+	stopFuncs     []func()
+}
+
+var bus *eventBus
+
+//source is used to skip the events published by this process, it's unique for each process even if the consumer is the same
+type envelope struct {
+	Source string          `json:"source"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func NewTransport(options config.EventBusOptions) (Transport, error) {
+	switch options.Transport {
+	case TRANSPORT_LOCAL:
+		return NewMemoryTransport(int(options.MaxDeliveries)), nil
+	case TRANSPORT_REDIS:
+		return NewRedisStreamTransport(options.MaxLen, time.Duration(options.PollInterval)*time.Millisecond, time.Duration(options.ClaimIdle)*time.Second, options.MaxDeliveries), nil
+	default:
+		return nil, fmt.Errorf("unsupported transport:%s", options.Transport)
+	}
+}
+
+// InitializeBus makes Emit publish the events of options.Topics, the group and consumer of options must be set.
+// Each group receives all the events, and the consumers in a group share them, so the processes which need
+// every event should be in different groups. The consumer should be stable to resume its pending events after restart.
+func InitializeBus(transport Transport, options config.EventBusOptions) error {
+	if "" == options.Group || "" == options.Consumer {
+		return fmt.Errorf("the group and consumer of eventbus must be set")
+	}
+	b := &eventBus{}
+	b.transport = transport
+	b.group = options.Group
+	b.consumer = options.Consumer
+	hostname, _ := os.Hostname()
+	b.source = fmt.Sprintf("%s_%d_%d", hostname, os.Getpid(), time.Now().UnixNano())
+	b.topics = make(map[string]bool)
+	for _, topic := range options.Topics {
+		if !IsRegisteredTopic(topic) {
+			return fmt.Errorf("the data type of topic:%s hasn't been registered", topic)
+		}
+		b.topics[topic] = true
+	}
+	b.replayOffsets = options.ReplayOffsets
+	b.stopFuncs = []func(){}
+	bus = b
+	return nil
+}
+
+// StartBus replays the configured offsets and starts to consume the events published by other processes,
+// it should be called after all watchers are registered.
+func StartBus() error {
+	if nil == bus {
+		return nil
+	}
+	for topic, offset := range bus.replayOffsets {
+		if err := Replay(topic, offset); nil != err {
+			return err
+		}
+	}
+	for topic := range bus.topics {
+		stopFunc, err := bus.transport.Consume(topic, bus.group, bus.consumer, bus.handleMessage)
+		if nil != err {
+			return err
+		}
+		bus.stopFuncs = append(bus.stopFuncs, stopFunc)
+	}
+	return nil
+}
+
+func StopBus() {
+	if nil == bus {
+		return
+	}
+	for _, stop := range bus.stopFuncs {
+		stop()
+	}
+	bus = nil
+}
+
+// Replay dispatches the events from the offset to the watchers of this process, including the events published by itself.
+func Replay(topic, fromOffset string) error {
+	if nil == bus {
+		return fmt.Errorf("eventbus hasn't been initialized")
+	}
+	return bus.transport.Replay(topic, fromOffset, func(msg *Message) error {
+		if _, eventData, err := decodeMessage(msg); nil != err {
+			log.Errorf("eventemitter, replay topic:%s, offset:%s, err:%s", msg.Topic, msg.Offset, err.Error())
+			return nil
+		} else {
+			return dispatch(msg.Topic, eventData)
+		}
+	})
+}
+
+func (b *eventBus) publish(topic string, eventData EventData) {
+	if _, exists := b.topics[topic]; !exists {
+		return
+	}
+	data, err := EncodeEvent(topic, eventData)
+	if nil != err {
+		log.Errorf("eventemitter, publish topic:%s, err:%s", topic, err.Error())
+		return
+	}
+	envelopeData, err := json.Marshal(&envelope{Source: b.source, Data: data})
+	if nil != err {
+		log.Errorf("eventemitter, publish topic:%s, err:%s", topic, err.Error())
+		return
+	}
+	if _, err := b.transport.Publish(topic, envelopeData); nil != err {
+		log.Errorf("eventemitter, publish topic:%s, err:%s", topic, err.Error())
+	}
+}
+
+func (b *eventBus) handleMessage(msg *Message) error {
+	source, eventData, err := decodeMessage(msg)
+	if nil != err {
+		log.Errorf("eventemitter, topic:%s, offset:%s, err:%s", msg.Topic, msg.Offset, err.Error())
+		return nil
+	}
+	//it has been dispatched when it was emitted
+	if source == b.source {
+		return nil
+	}
+	return dispatch(msg.Topic, eventData)
+}
+
+func decodeMessage(msg *Message) (string, EventData, error) {
+	env := &envelope{}
+	if err := json.Unmarshal(msg.Data, env); nil != err {
+		return "", nil, err
+	}
+	eventData, err := DecodeEvent(msg.Topic, env.Data)
+	return env.Source, eventData, err
+}
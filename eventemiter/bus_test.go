@@ -0,0 +1,225 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package eventemitter_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/crypto"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestEncodeEvent(t *testing.T) {
+	fill := &types.OrderFilledEvent{}
+	fill.Protocol = common.HexToAddress("0x01")
+	fill.From = common.HexToAddress("0x02")
+	fill.BlockNumber = big.NewInt(100)
+	fill.OrderHash = common.HexToHash("0x03")
+	fill.AmountS = big.NewInt(1000)
+
+	data, err := eventemitter.EncodeEvent(eventemitter.OrderFilled, fill)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	eventData, err := eventemitter.DecodeEvent(eventemitter.OrderFilled, data)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	decoded := eventData.(*types.OrderFilledEvent)
+	if decoded.Protocol != fill.Protocol || decoded.From != fill.From || decoded.OrderHash != fill.OrderHash || decoded.AmountS.Cmp(fill.AmountS) != 0 {
+		t.Fatalf("decoded event:%#v is different from %#v", decoded, fill)
+	}
+
+	if _, err := eventemitter.EncodeEvent(eventemitter.OrderFilled, types.BlockEvent{}); nil == err {
+		t.Fatalf("the type of event data should be checked")
+	}
+	if _, err := eventemitter.EncodeEvent("UnknownTopic", fill); nil == err {
+		t.Fatalf("the topic which isn't registered can't be encoded")
+	}
+}
+
+func newTestOrderState(t *testing.T) *types.OrderState {
+	authKey, err := crypto.NewPrivateKeyCrypto(false, "0xd1d194d90e52aeae4cd3a727b1dbb6ea5f1de8d5379827acc5f358bf1b0acba9")
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	state := &types.OrderState{}
+	order := &state.RawOrder
+	order.Hash = common.HexToHash("0x01")
+	order.AuthPrivateKey = authKey
+	order.AuthAddr = authKey.Address()
+	order.AmountS = big.NewInt(100)
+	order.AmountB = big.NewInt(10)
+	order.ValidSince = big.NewInt(1)
+	order.ValidUntil = big.NewInt(2)
+	return state
+}
+
+func TestEncodeEvent_OrderState(t *testing.T) {
+	defer crypto.InitializeAuthKeyCipher(nil)
+	plaintext := []byte("d1d194d90e52aeae4cd3a727b1dbb6ea5f1de8d5379827acc5f358bf1b0acba9")
+
+	//the key isn't published without master key, it's loaded from db
+	crypto.InitializeAuthKeyCipher(nil)
+	state := newTestOrderState(t)
+	data, err := eventemitter.EncodeEvent(eventemitter.Miner_NewOrderState, state)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	if bytes.Contains(data, plaintext) {
+		t.Fatalf("the plaintext auth private key is published")
+	}
+	eventData, err := eventemitter.DecodeEvent(eventemitter.Miner_NewOrderState, data)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	if decoded := eventData.(*types.OrderState); "" != decoded.RawOrder.SealedAuthPrivateKey || decoded.RawOrder.AuthAddr != state.RawOrder.AuthAddr {
+		t.Fatalf("decoded order:%#v is different from %#v", decoded.RawOrder, state.RawOrder)
+	}
+
+	masterKey := bytes.Repeat([]byte{1}, 32)
+	c, err := crypto.NewEnvelopeCipher(masterKey)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	crypto.InitializeAuthKeyCipher(c)
+	if data, err = eventemitter.EncodeEvent(eventemitter.Miner_NewOrderState, state); nil != err {
+		t.Fatal(err.Error())
+	}
+	if bytes.Contains(data, plaintext) {
+		t.Fatalf("the plaintext auth private key is published")
+	}
+	if state.RawOrder.AuthPrivateKey.Address() != state.RawOrder.AuthAddr {
+		t.Fatalf("the order published shouldn't be changed")
+	}
+	if eventData, err = eventemitter.DecodeEvent(eventemitter.Miner_NewOrderState, data); nil != err {
+		t.Fatal(err.Error())
+	}
+	decoded := eventData.(*types.OrderState)
+	authKey, err := crypto.OpenAuthPrivateKey(decoded.RawOrder.Hash, decoded.RawOrder.SealedAuthPrivateKey)
+	if nil != err || authKey.Address() != state.RawOrder.AuthAddr {
+		t.Fatalf("the sealed auth private key should be opened, err:%v", err)
+	}
+}
+
+func TestMemoryTransport_Bus(t *testing.T) {
+	transport := eventemitter.NewMemoryTransport(0)
+	options := config.EventBusOptions{Group: "relay", Consumer: "relay_1", Topics: []string{eventemitter.Block_New}}
+	if err := eventemitter.InitializeBus(transport, options); nil != err {
+		t.Fatal(err.Error())
+	}
+	defer eventemitter.StopBus()
+
+	received := make(chan *types.BlockEvent, 10)
+	watcher := &eventemitter.Watcher{Concurrent: false, Handle: func(eventData eventemitter.EventData) error {
+		received <- eventData.(*types.BlockEvent)
+		return nil
+	}}
+	eventemitter.On(eventemitter.Block_New, watcher)
+	defer eventemitter.Un(eventemitter.Block_New, watcher)
+	if err := eventemitter.StartBus(); nil != err {
+		t.Fatal(err.Error())
+	}
+
+	//the event emitted by this process is only dispatched once
+	eventemitter.Emit(eventemitter.Block_New, &types.BlockEvent{BlockNumber: big.NewInt(1)})
+	//the event published by another process
+	data, _ := eventemitter.EncodeEvent(eventemitter.Block_New, &types.BlockEvent{BlockNumber: big.NewInt(2)})
+	envelope, _ := json.Marshal(map[string]interface{}{"source": "miner_1", "data": json.RawMessage(data)})
+	if _, err := transport.Publish(eventemitter.Block_New, envelope); nil != err {
+		t.Fatal(err.Error())
+	}
+	//the event published by the process of the same consumer before restart isn't skipped
+	data, _ = eventemitter.EncodeEvent(eventemitter.Block_New, &types.BlockEvent{BlockNumber: big.NewInt(3)})
+	envelope, _ = json.Marshal(map[string]interface{}{"source": options.Consumer, "data": json.RawMessage(data)})
+	if _, err := transport.Publish(eventemitter.Block_New, envelope); nil != err {
+		t.Fatal(err.Error())
+	}
+
+	for _, number := range []int64{1, 2, 3} {
+		select {
+		case e := <-received:
+			if e.BlockNumber.Int64() != number {
+				t.Fatalf("expected block:%d, got:%d", number, e.BlockNumber.Int64())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("block:%d hasn't been received", number)
+		}
+	}
+	select {
+	case e := <-received:
+		t.Fatalf("block:%d has been received twice", e.BlockNumber.Int64())
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	//replay dispatches all events from the offset
+	if err := eventemitter.Replay(eventemitter.Block_New, "1"); nil != err {
+		t.Fatal(err.Error())
+	}
+	if len(received) != 3 {
+		t.Fatalf("expected 3 replayed events, got %d", len(received))
+	}
+}
+
+func TestMemoryTransport_DeadLetter(t *testing.T) {
+	transport := eventemitter.NewMemoryTransport(2)
+	topic := "DeadLetterTest"
+	transport.Publish(topic, []byte("bad"))
+	transport.Publish(topic, []byte("good"))
+
+	deliveries := make(chan string, 10)
+	stop, err := transport.Consume(topic, "relay", "relay_1", func(msg *eventemitter.Message) error {
+		deliveries <- string(msg.Data)
+		if "bad" == string(msg.Data) {
+			return fmt.Errorf("can't handle message:%s", msg.Offset)
+		}
+		return nil
+	})
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	defer stop()
+
+	//the bad message is delivered twice, and then it's moved to the dead letter topic
+	for _, expected := range []string{"bad", "bad", "good"} {
+		select {
+		case data := <-deliveries:
+			if data != expected {
+				t.Fatalf("expected message:%s, got:%s", expected, data)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message:%s hasn't been delivered", expected)
+		}
+	}
+	dead := []string{}
+	transport.Replay(eventemitter.DeadLetterTopic(topic), "1", func(msg *eventemitter.Message) error {
+		dead = append(dead, string(msg.Data))
+		return nil
+	})
+	if len(dead) != 1 || "bad" != dead[0] {
+		t.Fatalf("the bad message should be in the dead letter topic, got:%v", dead)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package eventemitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Loopring/relay/crypto"
+	"github.com/Loopring/relay/types"
+	"reflect"
+	"sync"
+)
+
+//the type of event data of each topic, only the registered topics can be sent to other processes
+var topicTypes map[string]reflect.Type
+var topicMtx *sync.RWMutex
+
+var orderStateType = reflect.TypeOf(&types.OrderState{})
+
+// busOrderState is the order state sent to other processes, the plaintext auth private key is never published.
+// only the key sealed by the master key is carried, it's opened by the miner before signing the ring.
+type busOrderState struct {
+	State                *types.OrderState `json:"state"`
+	SealedAuthPrivateKey string            `json:"sealedAuthPrivateKey,omitempty"`
+}
+
+func RegisterTopic(topic string, prototype EventData) {
+	topicMtx.Lock()
+	defer topicMtx.Unlock()
+	topicTypes[topic] = reflect.TypeOf(prototype)
+}
+
+func IsRegisteredTopic(topic string) bool {
+	topicMtx.RLock()
+	defer topicMtx.RUnlock()
+	_, exists := topicTypes[topic]
+	return exists
+}
+
+func EncodeEvent(topic string, eventData EventData) ([]byte, error) {
+	topicMtx.RLock()
+	typ, exists := topicTypes[topic]
+	topicMtx.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("topic:%s hasn't been registered", topic)
+	}
+	if reflect.TypeOf(eventData) != typ {
+		return nil, fmt.Errorf("topic:%s requires %s, but got %T", topic, typ.String(), eventData)
+	}
+	if state, ok := eventData.(*types.OrderState); ok {
+		return encodeOrderState(state)
+	}
+	return json.Marshal(eventData)
+}
+
+func encodeOrderState(state *types.OrderState) ([]byte, error) {
+	stateCopy := *state
+	order := &stateCopy.RawOrder
+	sealed := order.SealedAuthPrivateKey
+	if authKey, err := crypto.SealAuthPrivateKey(order.Hash, order.AuthPrivateKey); nil != err {
+		return nil, err
+	} else if "" != authKey {
+		sealed = authKey
+	}
+	order.AuthPrivateKey = crypto.EthPrivateKeyCrypto{}
+	order.SealedAuthPrivateKey = ""
+
+	//the key isn't carried if the master key isn't loaded, it can be loaded from db
+	if !crypto.IsSealed(sealed) {
+		sealed = ""
+	}
+	return json.Marshal(&busOrderState{State: &stateCopy, SealedAuthPrivateKey: sealed})
+}
+
+func decodeOrderState(data []byte) (*types.OrderState, error) {
+	msg := &busOrderState{}
+	if err := json.Unmarshal(data, msg); nil != err {
+		return nil, err
+	}
+	if nil == msg.State {
+		return nil, fmt.Errorf("order state is missing")
+	}
+	msg.State.RawOrder.SealedAuthPrivateKey = msg.SealedAuthPrivateKey
+	return msg.State, nil
+}
+
+func DecodeEvent(topic string, data []byte) (EventData, error) {
+	topicMtx.RLock()
+	typ, exists := topicTypes[topic]
+	topicMtx.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("topic:%s hasn't been registered", topic)
+	}
+	if typ == orderStateType {
+		return decodeOrderState(data)
+	}
+	if typ.Kind() == reflect.Ptr {
+		eventData := reflect.New(typ.Elem())
+		if err := json.Unmarshal(data, eventData.Interface()); nil != err {
+			return nil, err
+		}
+		return eventData.Interface(), nil
+	} else {
+		eventData := reflect.New(typ)
+		if err := json.Unmarshal(data, eventData.Interface()); nil != err {
+			return nil, err
+		}
+		return eventData.Elem().Interface(), nil
+	}
+}
+
+func init() {
+	topicTypes = make(map[string]reflect.Type)
+	topicMtx = &sync.RWMutex{}
+
+	RegisterTopic(NewOrder, &types.OrderState{})
+	RegisterTopic(Miner_NewOrderState, &types.OrderState{})
+	RegisterTopic(OrderFilled, &types.OrderFilledEvent{})
+	RegisterTopic(CancelOrder, &types.OrderCancelledEvent{})
+	RegisterTopic(CutoffAll, &types.CutoffEvent{})
+	RegisterTopic(CutoffPair, &types.CutoffPairEvent{})
+	RegisterTopic(OrderExpired, &types.OrderExpiredEvent{})
+	RegisterTopic(OrderSoftCancelled, &types.OrderSoftCancelledEvent{})
+	RegisterTopic(Block_New, &types.BlockEvent{})
+	RegisterTopic(Block_End, &types.BlockEvent{})
+	RegisterTopic(ChainReorg, &types.ChainReorgEvent{})
+	RegisterTopic(DepthUpdated, types.DepthUpdateEvent{})
+	RegisterTopic(BalanceUpdated, types.BalanceUpdateEvent{})
+	RegisterTopic(OrderUpdated, types.OrderUpdateEvent{})
+}
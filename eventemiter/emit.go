@@ -19,8 +19,11 @@
 package eventemitter
 
 import (
+	"context"
 	"github.com/Loopring/relay/log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //todo:more stronger if it has cache, but, the more the nearer to eventsourcing
@@ -42,6 +45,8 @@ const (
 	CancelOrder         = "CancelOrder"
 	CutoffAll           = "Cutoff"
 	CutoffPair          = "CutoffPair"
+	OrderExpired        = "OrderExpired"
+	OrderSoftCancelled  = "OrderSoftCancelled"
 	TokenRegistered     = "TokenRegistered"
 	TokenUnRegistered   = "TokenUnRegistered"
 	RingHashSubmitted   = "RingHashSubmitted"
@@ -71,7 +76,7 @@ const (
 
 	// Extractor
 	SyncChainComplete = "SyncChainComplete"
-	ChainForkDetected = "ChainForkDetected"
+	ChainReorg        = "ChainReorg"
 	ExtractorWarning  = "ExtractorWarning"
 
 	// Transaction
@@ -84,6 +89,8 @@ const (
 	PortfolioUpdated      = "PortfolioUpdated"
 	BalanceUpdated        = "BalanceUpdated"
 	DepthUpdated          = "DepthUpdated"
+	DepthDiffUpdated      = "DepthDiffUpdated"
+	OrderUpdated          = "OrderUpdated"
 	TransactionUpdated    = "TransactionUpdated"
 )
 
@@ -91,6 +98,11 @@ const (
 var watchers map[string][]*Watcher
 var mtx *sync.Mutex
 
+//the number of handlers in process, including the concurrent ones nobody waits for
+var handling int64
+
+const drainPollInterval = 10 * time.Millisecond
+
 type EventData interface{}
 
 type Watcher struct {
@@ -119,26 +131,62 @@ func On(topic string, watcher *Watcher) {
 	watchers[topic] = append(watchers[topic], watcher)
 }
 
-func Emit(topic string, eventData EventData) {
+//Emit returns the last error of the watchers which are not concurrent, the event is published to the bus anyway
+func Emit(topic string, eventData EventData) error {
+	err := dispatch(topic, eventData)
+	if nil != bus {
+		bus.publish(topic, eventData)
+	}
+	return err
+}
+
+//dispatch the event to the watchers in this process, returns the last error of the watchers which are not concurrent
+func dispatch(topic string, eventData EventData) error {
 	//should limit the count of watchers
 	var wg sync.WaitGroup
-	for _, ob := range watchers[topic] {
+	var errMtx sync.Mutex
+	var lastErr error
+	mtx.Lock()
+	obs := watchers[topic]
+	mtx.Unlock()
+	for _, ob := range obs {
+		atomic.AddInt64(&handling, 1)
 		if ob.Concurrent {
-			go ob.Handle(eventData)
+			go func(ob *Watcher) {
+				defer atomic.AddInt64(&handling, -1)
+				ob.Handle(eventData)
+			}(ob)
 		} else {
 			wg.Add(1)
 			go func(ob *Watcher) {
 				//
 				defer func() {
+					atomic.AddInt64(&handling, -1)
 					wg.Add(-1)
 				}()
 				if err := ob.Handle(eventData); err != nil {
 					log.Errorf(err.Error())
+					errMtx.Lock()
+					lastErr = err
+					errMtx.Unlock()
 				}
 			}(ob)
 		}
 	}
 	wg.Wait()
+	return lastErr
+}
+
+// Drain waits until the handlers in process have returned, it should be called after the services emitting events are stopped
+func Drain(ctx context.Context) error {
+	for atomic.LoadInt64(&handling) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(drainPollInterval):
+		}
+	}
+	return nil
 }
 
 //todo: impl it
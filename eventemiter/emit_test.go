@@ -19,11 +19,21 @@
 package eventemitter_test
 
 import (
+	"context"
+	"errors"
+	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"go.uber.org/zap"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+}
+
 type ForkEvent struct {
 	Name string
 }
@@ -49,3 +59,50 @@ func TestEmit(t *testing.T) {
 
 	time.Sleep(time.Duration(100000000))
 }
+
+func TestEmit_Error(t *testing.T) {
+	failed := &eventemitter.Watcher{Concurrent: false, Handle: func(event eventemitter.EventData) error {
+		return errors.New("failed")
+	}}
+	ignored := &eventemitter.Watcher{Concurrent: true, Handle: func(event eventemitter.EventData) error {
+		return errors.New("ignored")
+	}}
+	eventemitter.On(eventemitter.Block_New, ignored)
+	defer eventemitter.Un(eventemitter.Block_New, ignored)
+	if err := eventemitter.Emit(eventemitter.Block_New, ForkEvent{Name: "concurrent"}); nil != err {
+		t.Fatalf("the errors of the concurrent watchers should be ignored, err:%s", err.Error())
+	}
+
+	eventemitter.On(eventemitter.Block_New, failed)
+	defer eventemitter.Un(eventemitter.Block_New, failed)
+	if err := eventemitter.Emit(eventemitter.Block_New, ForkEvent{Name: "failed"}); nil == err {
+		t.Fatalf("the error of the watcher should be returned")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var handled int32
+	release := make(chan bool)
+	watcher := &eventemitter.Watcher{Concurrent: true, Handle: func(event eventemitter.EventData) error {
+		<-release
+		atomic.StoreInt32(&handled, 1)
+		return nil
+	}}
+	eventemitter.On(eventemitter.Block_End, watcher)
+	defer eventemitter.Un(eventemitter.Block_End, watcher)
+	eventemitter.Emit(eventemitter.Block_End, ForkEvent{Name: "drain"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := eventemitter.Drain(ctx); context.DeadlineExceeded != err {
+		t.Fatalf("the handler in process should block drain, err:%v", err)
+	}
+
+	close(release)
+	if err := eventemitter.Drain(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if 1 != atomic.LoadInt32(&handled) {
+		t.Fatalf("drain returned before the handler")
+	}
+}
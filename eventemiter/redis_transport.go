@@ -0,0 +1,186 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package eventemitter
+
+import (
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/log"
+	"time"
+)
+
+const (
+	StreamPrefix    = "eventemitter_stream_"
+	streamReadCount = 100
+)
+
+// RedisStreamTransport publishes events to redis streams, each topic is a stream,
+// and the offsets of consumers are kept by the consumer groups of redis.
+// The pending messages of a consumer idle for claimIdle are taken over by the other consumers of the group.
+type RedisStreamTransport struct {
+	maxLen        int64
+	pollInterval  time.Duration
+	claimIdle     time.Duration
+	maxDeliveries int64
+}
+
+func NewRedisStreamTransport(maxLen int64, pollInterval, claimIdle time.Duration, maxDeliveries int64) *RedisStreamTransport {
+	t := &RedisStreamTransport{}
+	t.maxLen = maxLen
+	t.maxDeliveries = maxDeliveries
+	if t.maxDeliveries <= 0 {
+		t.maxDeliveries = DEFAULT_MAX_DELIVERIES
+	}
+	t.pollInterval = pollInterval
+	if t.pollInterval <= 0 {
+		t.pollInterval = 100 * time.Millisecond
+	}
+	t.claimIdle = claimIdle
+	if t.claimIdle <= 0 {
+		t.claimIdle = time.Minute
+	}
+	return t
+}
+
+func (t *RedisStreamTransport) streamKey(topic string) string {
+	return StreamPrefix + topic
+}
+
+func (t *RedisStreamTransport) Publish(topic string, data []byte) (string, error) {
+	return cache.XAdd(t.streamKey(topic), t.maxLen, data)
+}
+
+func (t *RedisStreamTransport) Consume(topic, group, consumer string, handle func(msg *Message) error) (func(), error) {
+	key := t.streamKey(topic)
+	//the new group only receives the messages published after it is created
+	if err := cache.XGroupCreate(key, group, "$"); nil != err {
+		return nil, err
+	}
+
+	stopChan := make(chan bool)
+	//after restart or failed, read the pending messages of this consumer before the new ones
+	readPending := true
+	//the messages left pending by the consumers which have been stopped are claimed on start, and then periodically
+	lastClaimed := time.Time{}
+	claimFunc := func() {
+		if time.Since(lastClaimed) < t.claimIdle {
+			return
+		}
+		lastClaimed = time.Now()
+		ids, err := cache.XClaim(key, group, consumer, int64(t.claimIdle/time.Millisecond), streamReadCount)
+		if nil != err {
+			return
+		}
+		if len(ids) > 0 {
+			log.Infof("eventemitter, topic:%s, consumer:%s claimed %d pending messages", topic, consumer, len(ids))
+			readPending = true
+		}
+	}
+	consumeFunc := func() bool {
+		id := ">"
+		if readPending {
+			id = "0"
+		}
+		ids, values, err := cache.XReadGroup(key, group, consumer, id, streamReadCount)
+		if nil != err {
+			return false
+		}
+		if readPending && len(ids) <= 0 {
+			readPending = false
+			return true
+		}
+		for idx, offset := range ids {
+			msg := &Message{Topic: topic, Offset: offset, Data: values[idx]}
+			if len(msg.Data) > 0 {
+				if err := handle(msg); nil != err && !t.deadLetter(key, group, msg, err) {
+					readPending = true
+					return false
+				}
+			}
+			if _, err := cache.XAck(key, group, offset); nil != err {
+				readPending = true
+				return false
+			}
+		}
+		return len(ids) >= streamReadCount
+	}
+
+	go func() {
+		for {
+			claimFunc()
+			//continue to read without waiting if there are more messages
+			for consumeFunc() {
+			}
+			select {
+			case <-time.After(t.pollInterval):
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stopChan)
+	}, nil
+}
+
+// deadLetter moves the message to the dead letter stream if it has been delivered maxDeliveries times,
+// the delivery counter of redis is increased each time the pending message is read again or claimed.
+// It returns false if the message should be retried.
+func (t *RedisStreamTransport) deadLetter(key, group string, msg *Message, handleErr error) bool {
+	deliveries, err := cache.XPending(key, group, msg.Offset)
+	if nil != err {
+		log.Errorf("eventemitter, topic:%s, offset:%s, err:%s, get deliveries err:%s", msg.Topic, msg.Offset, handleErr.Error(), err.Error())
+		return false
+	}
+	log.Errorf("eventemitter, topic:%s, offset:%s, deliveries:%d, err:%s", msg.Topic, msg.Offset, deliveries, handleErr.Error())
+	if deliveries < t.maxDeliveries {
+		return false
+	}
+	deadOffset, err := cache.XAdd(t.streamKey(DeadLetterTopic(msg.Topic)), t.maxLen, msg.Data)
+	if nil != err {
+		log.Errorf("eventemitter, topic:%s, offset:%s can't be moved to the dead letter stream, err:%s", msg.Topic, msg.Offset, err.Error())
+		return false
+	}
+	log.Errorf("eventemitter, topic:%s, offset:%s has been moved to %s, offset:%s", msg.Topic, msg.Offset, DeadLetterTopic(msg.Topic), deadOffset)
+	return true
+}
+
+func (t *RedisStreamTransport) Replay(topic, fromOffset string, handle func(msg *Message) error) error {
+	key := t.streamKey(topic)
+	start := fromOffset
+	for {
+		ids, values, err := cache.XRange(key, start, "+", streamReadCount)
+		if nil != err {
+			return err
+		}
+		for idx, offset := range ids {
+			//the start is inclusive, skip it when reading the next page
+			if offset == start && start != fromOffset {
+				continue
+			}
+			if err := handle(&Message{Topic: topic, Offset: offset, Data: values[idx]}); nil != err {
+				return err
+			}
+		}
+		if len(ids) < streamReadCount {
+			return nil
+		}
+		start = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,176 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package eventemitter
+
+import (
+	"github.com/Loopring/relay/log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	TRANSPORT_LOCAL = "local"
+	TRANSPORT_REDIS = "redis"
+
+	DEFAULT_MAX_DELIVERIES = 5
+)
+
+// DeadLetterTopic keeps the messages of topic which failed to be handled too many times, they can be replayed after fixed
+func DeadLetterTopic(topic string) string {
+	return topic + "_DeadLetter"
+}
+
+type Message struct {
+	Topic  string
+	Offset string
+	Data   []byte
+}
+
+// Transport carries the serialized events between processes.
+// Messages are delivered at least once, a group only commits the offset of a message after it has been handled.
+// A message failed to be handled maxDeliveries times is committed and moved to DeadLetterTopic, so that the
+// following messages are still consumed.
+type Transport interface {
+	Publish(topic string, data []byte) (offset string, err error)
+
+	Consume(topic, group, consumer string, handle func(msg *Message) error) (stopFunc func(), err error)
+
+	// Replay delivers the messages from the offset, the offsets of groups are not changed
+	Replay(topic, fromOffset string, handle func(msg *Message) error) error
+}
+
+// MemoryTransport keeps messages in process, the offset is the sequence number of message in the topic.
+type MemoryTransport struct {
+	mtx           sync.Mutex
+	messages      map[string][]*Message
+	offsets       map[string]int
+	notifies      map[string][]chan bool
+	retryInterval time.Duration
+	maxDeliveries int
+}
+
+func NewMemoryTransport(maxDeliveries int) *MemoryTransport {
+	t := &MemoryTransport{}
+	t.messages = make(map[string][]*Message)
+	t.offsets = make(map[string]int)
+	t.notifies = make(map[string][]chan bool)
+	t.retryInterval = time.Second
+	t.maxDeliveries = maxDeliveries
+	if t.maxDeliveries <= 0 {
+		t.maxDeliveries = DEFAULT_MAX_DELIVERIES
+	}
+	return t
+}
+
+func (t *MemoryTransport) Publish(topic string, data []byte) (string, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	msg := &Message{Topic: topic, Data: data}
+	msg.Offset = strconv.Itoa(len(t.messages[topic]) + 1)
+	t.messages[topic] = append(t.messages[topic], msg)
+	for _, notify := range t.notifies[topic] {
+		select {
+		case notify <- true:
+		default:
+		}
+	}
+	return msg.Offset, nil
+}
+
+func (t *MemoryTransport) Consume(topic, group, consumer string, handle func(msg *Message) error) (func(), error) {
+	notify := make(chan bool, 1)
+	stopChan := make(chan bool)
+	t.mtx.Lock()
+	t.notifies[topic] = append(t.notifies[topic], notify)
+	t.mtx.Unlock()
+
+	offsetKey := topic + "_" + group
+	//the deliveries of the first message which hasn't been handled
+	deliveries := 0
+	deliver := func() {
+		t.mtx.Lock()
+		offset := t.offsets[offsetKey]
+		pending := t.messages[topic][offset:]
+		t.mtx.Unlock()
+		for _, msg := range pending {
+			if err := handle(msg); nil != err {
+				deliveries++
+				log.Errorf("eventemitter, topic:%s, offset:%s, deliveries:%d, err:%s", topic, msg.Offset, deliveries, err.Error())
+				if deliveries < t.maxDeliveries {
+					return
+				}
+				deadOffset, _ := t.Publish(DeadLetterTopic(topic), msg.Data)
+				log.Errorf("eventemitter, topic:%s, offset:%s has been moved to %s, offset:%s", topic, msg.Offset, DeadLetterTopic(topic), deadOffset)
+			}
+			deliveries = 0
+			t.mtx.Lock()
+			t.offsets[offsetKey] = offset + 1
+			t.mtx.Unlock()
+			offset = offset + 1
+		}
+	}
+
+	go func() {
+		deliver()
+		for {
+			select {
+			case <-notify:
+				deliver()
+			case <-time.After(t.retryInterval):
+				deliver()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		t.mtx.Lock()
+		notifies := []chan bool{}
+		for _, n := range t.notifies[topic] {
+			if n != notify {
+				notifies = append(notifies, n)
+			}
+		}
+		t.notifies[topic] = notifies
+		t.mtx.Unlock()
+		close(stopChan)
+	}, nil
+}
+
+func (t *MemoryTransport) Replay(topic, fromOffset string, handle func(msg *Message) error) error {
+	start, err := strconv.Atoi(fromOffset)
+	if nil != err {
+		return err
+	}
+	if start < 1 {
+		start = 1
+	}
+	t.mtx.Lock()
+	messages := t.messages[topic]
+	t.mtx.Unlock()
+	for idx := start - 1; idx < len(messages); idx++ {
+		if err := handle(messages[idx]); nil != err {
+			return err
+		}
+	}
+	return nil
+}
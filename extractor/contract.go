@@ -956,6 +956,11 @@ func (processor *AbiProcessor) handleWethWithdrawalEvent(input eventemitter.Even
 }
 
 func (processor *AbiProcessor) handleEthTransfer(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, time *big.Int) error {
+	eventemitter.Emit(eventemitter.EthTransferEvent, processor.ethTransferEvent(tx, receipt, time))
+	return nil
+}
+
+func (processor *AbiProcessor) ethTransferEvent(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, time *big.Int) *types.TransferEvent {
 	var dst types.TransferEvent
 
 	dst.From = common.HexToAddress(tx.From)
@@ -977,9 +982,7 @@ func (processor *AbiProcessor) handleEthTransfer(tx *ethaccessor.Transaction, re
 
 	log.Debugf("extractor,tx:%s handleEthTransfer from:%s, to:%s, value:%s, gasUsed:%s, status:%d", tx.Hash, tx.From, tx.To, tx.Value.BigInt().String(), dst.GasUsed.String(), dst.Status)
 
-	eventemitter.Emit(eventemitter.EthTransferEvent, &dst)
-
-	return nil
+	return &dst
 }
 
 func (processor *AbiProcessor) getGasAndStatus(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt) (*big.Int, types.TxStatus) {
@@ -19,16 +19,20 @@
 package extractor
 
 import (
+	"context"
 	"fmt"
 	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/ethaccessor"
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/metrics"
 	"github.com/Loopring/relay/types"
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"math/big"
+	"reflect"
 	"sort"
 	"sync"
 	"time"
@@ -39,44 +43,48 @@ import (
 */
 
 const (
-	defaultEndBlockNumber  = 1000000000
-	defaultForkWaitingTime = 10
+	defaultEndBlockNumber = 1000000000
 )
 
 type ExtractorService interface {
-	Start()
-	Stop()
-	ForkProcess(block *types.Block) error
+	Start(ctx context.Context) error
+	// Stop waits until the block in process has been extracted
+	Stop(ctx context.Context) error
+	ForkProcess(block *types.Block) (bool, error)
+	Pause()
+	Resume()
+	Paused() bool
+	Synced() bool
 }
 
 // TODO(fukun):不同的channel，应当交给orderbook统一进行后续处理，可以将channel作为函数返回值、全局变量、参数等方式
 type ExtractorServiceImpl struct {
 	options          config.ExtractorOptions
-	detector         *forkDetector
+	detector         *reorgDetector
 	processor        *AbiProcessor
 	dao              dao.RdsService
-	stop             chan bool
+	stop             chan struct{}
+	stopOnce         *sync.Once
+	done             chan struct{}
 	lock             sync.RWMutex
 	startBlockNumber *big.Int
 	endBlockNumber   *big.Int
-	iterator         *ethaccessor.BlockIterator
+	fetcher          *blockFetcher
+	retryQueue       *retryQueue
 	pendingTxWatcher *eventemitter.Watcher
 	syncComplete     bool
 	forkComplete     bool
+	paused           bool
 }
 
 func NewExtractorService(options config.ExtractorOptions, db dao.RdsService) *ExtractorServiceImpl {
 	var l ExtractorServiceImpl
 
-	if options.ForkWaitingTime <= 0 {
-		options.ForkWaitingTime = defaultForkWaitingTime
-	}
-
 	l.options = options
 	l.dao = db
 	l.processor = newAbiProcessor(db, &options)
-	l.detector = newForkDetector(db, l.options.StartBlockNumber)
-	l.stop = make(chan bool, 1)
+	l.detector = newReorgDetector(db, l.options.StartBlockNumber, l.options.ReorgWindow)
+	l.retryQueue = newRetryQueue(db, l.options.MaxRetries)
 	l.setBlockNumberRange()
 
 	l.pendingTxWatcher = &eventemitter.Watcher{Concurrent: false, Handle: l.WatchingPendingTransaction}
@@ -85,66 +93,131 @@ func NewExtractorService(options config.ExtractorOptions, db dao.RdsService) *Ex
 	return &l
 }
 
-func (l *ExtractorServiceImpl) Start() {
+func (l *ExtractorServiceImpl) Start(ctx context.Context) error {
 	if !l.options.Open {
-		return
+		return nil
 	}
 
 	log.Infof("extractor start from block:%s...", l.startBlockNumber.String())
-	l.syncComplete = false
+	l.setSyncComplete(false)
 
-	l.iterator = ethaccessor.NewBlockIterator(l.startBlockNumber, l.endBlockNumber, true, l.options.ConfirmBlockNumber)
+	l.stop = make(chan struct{})
+	l.stopOnce = &sync.Once{}
+	l.done = make(chan struct{})
+	l.fetcher = newBlockFetcher(l, l.startBlockNumber, l.endBlockNumber)
 	go func() {
+		defer close(l.done)
 		for {
 			select {
 			case <-l.stop:
 				return
 			default:
-				if err := l.ProcessBlock(); nil != err {
+				if l.Paused() {
+					time.Sleep(1 * time.Second)
+					continue
+				}
+				if err := l.ProcessBlock(); errFetchStopped == err {
+					return
+				} else if nil != err {
 					log.Error(err.Error())
 					time.Sleep(1 * time.Second)
 				}
 			}
 		}
 	}()
+	return nil
 }
 
-func (l *ExtractorServiceImpl) Stop() {
-	if !l.options.Open {
-		return
+func (l *ExtractorServiceImpl) Stop(ctx context.Context) error {
+	if !l.options.Open || nil == l.done {
+		return nil
 	}
 
-	l.stop <- true
+	l.halt()
+	select {
+	case <-l.done:
+		log.Infof("extractor,stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// 重启(分叉)时先关停subscribeEvents，然后关
-func (l *ExtractorServiceImpl) ForkProcess(currentBlock *types.Block) error {
-	forkEvent, err := l.detector.Detect(currentBlock)
-	if err != nil {
-		l.Warning(err)
-		return err
+// halt signals the loop to exit after the block in process, it doesn't wait so it can be called in the loop
+func (l *ExtractorServiceImpl) halt() {
+	if nil == l.stopOnce {
+		return
 	}
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+}
 
-	if forkEvent == nil {
-		return nil
+// Pause stops extracting after the block in process, the blocks fetched ahead are kept
+func (l *ExtractorServiceImpl) Pause() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.paused = true
+	log.Infof("extractor,paused")
+}
+
+func (l *ExtractorServiceImpl) Resume() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.paused = false
+	log.Infof("extractor,resumed")
+}
+
+func (l *ExtractorServiceImpl) Paused() bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.paused
+}
+
+// Synced returns true after the extractor has caught up with the chain, it's always true if the extractor isn't open
+func (l *ExtractorServiceImpl) Synced() bool {
+	if !l.options.Open {
+		return true
 	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.syncComplete
+}
 
-	log.Debugf("extractor,detected chain fork, from :%d to %d", forkEvent.ForkBlock.Int64(), forkEvent.DetectedBlock.Int64())
+func (l *ExtractorServiceImpl) setSyncComplete(complete bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.syncComplete = complete
+}
 
-	l.Stop()
+// ForkProcess returns true if the chain has been reorganized, the consumers roll back the orphaned blocks
+// while handling ChainReorg, then the fetcher is rewound to extract the canonical blocks after the common ancestor.
+func (l *ExtractorServiceImpl) ForkProcess(currentBlock *types.Block) (bool, error) {
+	reorgEvent, err := l.detector.Detect(currentBlock)
+	if err != nil {
+		l.Warning(err)
+		return false, err
+	}
 
-	// emit event
-	eventemitter.Emit(eventemitter.ChainForkDetected, forkEvent)
+	if reorgEvent == nil {
+		return false, nil
+	}
 
-	// reset start blockNumber
-	l.startBlockNumber = new(big.Int).Add(forkEvent.ForkBlock, big.NewInt(1))
+	log.Infof("extractor,detected chain reorg, blocks from %s to %s are orphaned, %d canonical blocks until %s",
+		reorgEvent.OrphanedFrom.String(), reorgEvent.OrphanedTo.String(), len(reorgEvent.CanonicalBlocks), reorgEvent.DetectedBlock.String())
+	metrics.ExtractorReorgs.Inc()
+	metrics.ExtractorOrphanedBlocks.Add(float64(reorgEvent.OrphanedTo.Int64() - reorgEvent.OrphanedFrom.Int64() + 1))
 
-	// waiting for the eth node catch up
-	time.Sleep(time.Duration(l.options.ForkWaitingTime) * time.Second)
+	// emit event, it returns after all the consumers have rolled back
+	eventemitter.Emit(eventemitter.ChainReorg, reorgEvent)
 
-	l.Start()
+	// extract the canonical blocks again
+	l.startBlockNumber = new(big.Int).Set(reorgEvent.OrphanedFrom)
+	l.fetcher.reset(l.startBlockNumber)
+	l.retryQueue.Drop(l.startBlockNumber.Int64())
+	l.retryQueue.Save(reorgEvent.CommonAncestor.Int64())
 
-	return fmt.Errorf("extractor,detected chain fork")
+	return true, nil
 }
 
 func (l *ExtractorServiceImpl) Sync(blockNumber *big.Int) {
@@ -155,7 +228,7 @@ func (l *ExtractorServiceImpl) Sync(blockNumber *big.Int) {
 	currentBlockNumber := new(big.Int).Add(blockNumber, big.NewInt(int64(l.options.ConfirmBlockNumber)))
 	if syncBlock.BigInt().Cmp(currentBlockNumber) <= 0 {
 		eventemitter.Emit(eventemitter.SyncChainComplete, syncBlock)
-		l.syncComplete = true
+		l.setSyncComplete(true)
 		log.Info("extractor,Sync chain block complete!")
 	} else {
 		log.Debugf("extractor,chain block syncing... ")
@@ -164,7 +237,7 @@ func (l *ExtractorServiceImpl) Sync(blockNumber *big.Int) {
 
 // Warning 当发生严重错误时需关停extractor，并通知其他模块
 func (l *ExtractorServiceImpl) Warning(err error) {
-	l.Stop()
+	l.halt()
 	log.Warnf("extractor, warning:%s", err.Error())
 	var event types.ExtractorWarningEvent
 	eventemitter.Emit(eventemitter.ExtractorWarning, &event)
@@ -175,14 +248,18 @@ func (l *ExtractorServiceImpl) WatchingPendingTransaction(input eventemitter.Eve
 	return l.ProcessPendingTransaction(tx)
 }
 
+// ProcessBlock is the dispatch stage of the pipeline, the events of the block fetched and decoded ahead
+// are emitted in order of transaction and log index.
 func (l *ExtractorServiceImpl) ProcessBlock() error {
-	inter, err := l.iterator.Next()
-	if err != nil {
-		return fmt.Errorf("extractor,iterator next error:%s", err.Error())
+	fetched, err := l.fetcher.Next()
+	if errFetchStopped == err {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("extractor,fetch block error:%s", err.Error())
 	}
 
 	// get current block
-	block := inter.(*ethaccessor.BlockWithTxAndReceipt)
+	block := fetched.block
 	log.Infof("extractor,get block:%s->%s, transaction number:%d", block.Number.BigInt().String(), block.Hash.Hex(), len(block.Transactions))
 
 	currentBlock := &types.Block{}
@@ -191,21 +268,23 @@ func (l *ExtractorServiceImpl) ProcessBlock() error {
 	currentBlock.BlockHash = block.Hash
 	currentBlock.CreateTime = block.Timestamp.Int64()
 
+	// detect chain reorg, the block will be extracted again after the canonical blocks before it
+	if reorged, err := l.ForkProcess(currentBlock); err != nil {
+		return err
+	} else if reorged {
+		return nil
+	}
+
 	// convert and save block
 	var entity dao.Block
 	entity.ConvertDown(currentBlock)
 	l.dao.SaveBlock(&entity)
 
 	// sync block on chain
-	if l.syncComplete == false {
+	if !l.Synced() {
 		l.Sync(block.Number.BigInt())
 	}
 
-	// detect chain fork
-	if err := l.ForkProcess(currentBlock); err != nil {
-		return err
-	}
-
 	// emit new block
 	blockEvent := &types.BlockEvent{}
 	blockEvent.BlockNumber = block.Number.BigInt()
@@ -213,15 +292,50 @@ func (l *ExtractorServiceImpl) ProcessBlock() error {
 	blockEvent.BlockTime = block.Timestamp.Int64()
 	eventemitter.Emit(eventemitter.Block_New, blockEvent)
 
-	if len(block.Transactions) > 0 {
-		for idx, transaction := range block.Transactions {
-			receipt := block.Receipts[idx]
-			l.debug("extractor,tx:%s", transaction.Hash)
-			l.ProcessMinedTransaction(&transaction, &receipt, block.Timestamp.BigInt())
+	blockNumber := block.Number.BigInt().Int64()
+	queueLen := l.retryQueue.Len()
+	for _, decoded := range fetched.txs {
+		l.debug("extractor,tx:%s", decoded.tx.Hash)
+		if nil != decoded.err {
+			log.Errorf("extractor,tx:%s decode error:%s, it will be retried later", decoded.tx.Hash, decoded.err.Error())
+			l.retryQueue.Add(decoded.tx.Hash, blockNumber, block.Timestamp.Int64())
+			continue
 		}
+		//the events have been handled by the other watchers and published to the bus, the tx isn't retried for the failed watcher
+		if err := dispatch(decoded.items); nil != err {
+			log.Errorf("extractor,tx:%s dispatch error:%s", decoded.tx.Hash, err.Error())
+		}
+	}
+	if l.retryQueue.Len() != queueLen {
+		l.retryQueue.Save(blockNumber)
 	}
+	l.retryQueue.Retry(blockNumber, l.retryTransaction)
 
 	eventemitter.Emit(eventemitter.Block_End, blockEvent)
+
+	metrics.ExtractorBlockNumber.Set(float64(blockNumber))
+	l.fetcher.observeLag()
+	return nil
+}
+
+// retryTransaction gets the transaction and receipt from the node again and dispatches its events
+func (l *ExtractorServiceImpl) retryTransaction(retry *retryTransaction) error {
+	tx := &ethaccessor.Transaction{}
+	receipt := &ethaccessor.TransactionReceipt{}
+	if err := ethaccessor.GetTransactionByHash(tx, retry.TxHash, "latest"); err != nil {
+		return err
+	}
+	if err := ethaccessor.GetTransactionReceipt(receipt, retry.TxHash, "latest"); err != nil {
+		return err
+	}
+	items, err := l.decodeMinedTransaction(tx, receipt, big.NewInt(retry.BlockTime))
+	if nil != err {
+		return err
+	}
+	//only the failure of fetching and decoding is retried, the events are never emitted twice
+	if err := dispatch(items); nil != err {
+		log.Errorf("extractor,retried tx:%s dispatch error:%s", retry.TxHash, err.Error())
+	}
 	return nil
 }
 
@@ -238,42 +352,75 @@ func (l *ExtractorServiceImpl) ProcessPendingTransaction(tx *ethaccessor.Transac
 }
 
 func (l *ExtractorServiceImpl) ProcessMinedTransaction(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) error {
+	items, err := l.decodeMinedTransaction(tx, receipt, blockTime)
+	if err != nil {
+		return err
+	}
+	return dispatch(items)
+}
+
+func (l *ExtractorServiceImpl) ProcessMethod(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) error {
+	items, err := l.decodeMethod(tx, receipt, blockTime)
+	if err != nil {
+		return err
+	}
+	return dispatch(items)
+}
+
+func (l *ExtractorServiceImpl) ProcessEvent(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) error {
+	items, err := l.decodeEvent(tx, receipt, blockTime)
+	if err != nil {
+		return err
+	}
+	return dispatch(items)
+}
+
+// decodeMinedTransaction is safe to be called concurrently, nothing is emitted
+func (l *ExtractorServiceImpl) decodeMinedTransaction(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) ([]dispatchItem, error) {
 	l.debug("extractor,process mined transaction,tx:%s status :%s,logs:%d", tx.Hash, receipt.Status.BigInt().String(), len(receipt.Logs))
 
+	if receipt.TransactionHash != "" && common.HexToHash(receipt.TransactionHash) != common.HexToHash(tx.Hash) {
+		return nil, fmt.Errorf("receipt:%s doesn't belong to tx:%s", receipt.TransactionHash, tx.Hash)
+	}
+
 	if l.processor.SupportedEvents(receipt) {
-		return l.ProcessEvent(tx, receipt, blockTime)
+		return l.decodeEvent(tx, receipt, blockTime)
 	}
 
 	if l.processor.SupportedMethod(tx) {
-		return l.ProcessMethod(tx, receipt, blockTime)
+		return l.decodeMethod(tx, receipt, blockTime)
 	}
 
-	return l.processor.handleEthTransfer(tx, receipt, blockTime)
+	return []dispatchItem{{topic: eventemitter.EthTransferEvent, data: l.processor.ethTransferEvent(tx, receipt, blockTime)}}, nil
 }
 
-func (l *ExtractorServiceImpl) ProcessMethod(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) error {
+func (l *ExtractorServiceImpl) decodeMethod(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) ([]dispatchItem, error) {
 	method, ok := l.processor.GetMethod(tx)
 	if !ok {
 		l.debug("extractor,process method,tx:%s,unsupported contract method", tx.Hash)
-		return nil
+		return nil, nil
 	}
 
+	// the method inputs are unpacked by the watcher, each tx needs its own one
+	method.Method = newContractData(method.Method)
 	gas, status := l.processor.getGasAndStatus(tx, receipt)
 	method.FullFilled(tx, gas, blockTime, status, method.Name)
-	eventemitter.Emit(method.Id, method)
 
-	return nil
+	return []dispatchItem{{topic: method.Id, data: method}}, nil
 }
 
-func (l *ExtractorServiceImpl) ProcessEvent(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) error {
+func (l *ExtractorServiceImpl) decodeEvent(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) ([]dispatchItem, error) {
 	methodName := l.processor.GetMethodName(tx)
 
 	// 如果是submitRing的相关事件，必须保证fill在前，transfer在后
-	if ethaccessor.TxIsSubmitRing(methodName) && len(receipt.Logs) > 1 {
-		sort.SliceStable(receipt.Logs, func(i, j int) bool {
+	logs := receipt.Logs
+	if ethaccessor.TxIsSubmitRing(methodName) && len(logs) > 1 {
+		logs = make([]ethaccessor.Log, len(receipt.Logs))
+		copy(logs, receipt.Logs)
+		sort.SliceStable(logs, func(i, j int) bool {
 			cmpEventName := ethaccessor.EVENT_RING_MINED
 
-			evti, _ := l.processor.GetEvent(receipt.Logs[i])
+			evti, _ := l.processor.GetEvent(logs[i])
 
 			if evti.Name == cmpEventName {
 				return true
@@ -282,26 +429,29 @@ func (l *ExtractorServiceImpl) ProcessEvent(tx *ethaccessor.Transaction, receipt
 		})
 	}
 
-	for _, evtLog := range receipt.Logs {
+	items := []dispatchItem{}
+	for idx := range logs {
+		evtLog := logs[idx]
 		event, ok := l.processor.GetEvent(evtLog)
 		if !ok {
 			l.debug("extractor,process event,tx:%s,unsupported contract event", tx.Hash)
 			continue
 		}
 
+		// the events are decoded concurrently, each log needs its own one
+		event.Event = newContractData(event.Event)
 		data := hexutil.MustDecode(evtLog.Data)
 		if nil != data && len(data) > 0 {
 			if err := event.CAbi.Unpack(event.Event, event.Name, data, abi.SEL_UNPACK_EVENT); nil != err {
-				log.Errorf("extractor,process event,tx:%s unpack event error:%s", tx.Hash, err.Error())
-				continue
+				return nil, fmt.Errorf("unpack event:%s of log:%d error:%s", event.Name, evtLog.LogIndex.Int64(), err.Error())
 			}
 		}
 
 		event.FullFilled(tx, &evtLog, receipt.GasUsed.BigInt(), blockTime, methodName)
-		eventemitter.Emit(event.Id.Hex(), event)
+		items = append(items, dispatchItem{topic: event.Id.Hex(), data: event})
 	}
 
-	return nil
+	return items, nil
 }
 
 func (l *ExtractorServiceImpl) setBlockNumberRange() {
@@ -329,3 +479,11 @@ func (l *ExtractorServiceImpl) debug(template string, args ...interface{}) {
 		log.Debugf(template, args...)
 	}
 }
+
+// newContractData creates a zero value with the same type as the prototype of contract event or method
+func newContractData(prototype interface{}) interface{} {
+	if nil == prototype {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(prototype).Elem()).Interface()
+}
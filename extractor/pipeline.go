@@ -0,0 +1,234 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/metrics"
+	"github.com/Loopring/relay/types"
+)
+
+const (
+	defaultPrefetchBlocks = 16
+	defaultDecodeWorkers  = 8
+	blockPollInterval     = 5 * time.Second
+	latestRefreshInterval = 30 * time.Second //the latest block number is refreshed while syncing, so the lag in metrics is accurate
+)
+
+var (
+	errFetchFinished = errors.New("finished")
+	errFetchStopped  = errors.New("stopped")
+)
+
+// dispatchItem is an event decoded from a transaction, it's emitted in the dispatch stage
+type dispatchItem struct {
+	topic string
+	data  eventemitter.EventData
+}
+
+type decodedTransaction struct {
+	tx    *ethaccessor.Transaction
+	items []dispatchItem
+	err   error
+}
+
+type fetchedBlock struct {
+	number *big.Int
+	block  *ethaccessor.BlockWithTxAndReceipt
+	txs    []decodedTransaction
+	err    error
+}
+
+// blockFetcher is the first two stages of the pipeline, the blocks ahead of the one being dispatched
+// are fetched and decoded concurrently, Next returns them in order of block number.
+type blockFetcher struct {
+	extractor    *ExtractorServiceImpl
+	nextNumber   *big.Int
+	endNumber    *big.Int
+	confirms     uint64
+	ahead        int
+	latestNumber uint64
+	latestTime   time.Time
+	pending      []chan *fetchedBlock
+	decodeSem    chan bool
+	quit         <-chan struct{}
+	latestBlock  func() (uint64, error)
+	fullBlock    func(number *big.Int) (*ethaccessor.BlockWithTxAndReceipt, error)
+}
+
+func newBlockFetcher(extractor *ExtractorServiceImpl, startNumber, endNumber *big.Int) *blockFetcher {
+	fetcher := &blockFetcher{}
+	fetcher.extractor = extractor
+	fetcher.quit = extractor.stop
+	fetcher.nextNumber = new(big.Int).Set(startNumber)
+	fetcher.endNumber = endNumber
+	fetcher.confirms = extractor.options.ConfirmBlockNumber
+	fetcher.ahead = extractor.options.PrefetchBlocks
+	if fetcher.ahead <= 0 {
+		fetcher.ahead = defaultPrefetchBlocks
+	}
+	workers := extractor.options.DecodeWorkers
+	if workers <= 0 {
+		workers = defaultDecodeWorkers
+	}
+	fetcher.decodeSem = make(chan bool, workers)
+	fetcher.latestBlock = getLatestBlockNumber
+	fetcher.fullBlock = getFullBlock
+	return fetcher
+}
+
+// Next waits until the next block has been confirmed, fetched and decoded, it returns errFetchStopped
+// if the extractor is stopped while waiting for the chain.
+func (fetcher *blockFetcher) Next() (*fetchedBlock, error) {
+	for {
+		if err := fetcher.schedule(); nil != err {
+			return nil, err
+		}
+		if len(fetcher.pending) > 0 {
+			break
+		}
+		if nil != fetcher.endNumber && fetcher.endNumber.Sign() > 0 && fetcher.endNumber.Cmp(fetcher.nextNumber) < 0 {
+			return nil, errFetchFinished
+		}
+		select {
+		case <-fetcher.quit:
+			return nil, errFetchStopped
+		case <-time.After(blockPollInterval):
+		}
+	}
+
+	res := <-fetcher.pending[0]
+	fetcher.pending = fetcher.pending[1:]
+	if nil != res.err {
+		fetcher.reset(res.number)
+		return nil, res.err
+	}
+	return res, nil
+}
+
+// reset drops the blocks fetched ahead, the fetcher starts from number again
+func (fetcher *blockFetcher) reset(number *big.Int) {
+	fetcher.pending = []chan *fetchedBlock{}
+	fetcher.nextNumber = new(big.Int).Set(number)
+}
+
+// schedule starts fetching the confirmed blocks until there are ahead blocks in flight
+func (fetcher *blockFetcher) schedule() error {
+	if len(fetcher.pending) >= fetcher.ahead {
+		return nil
+	}
+	if fetcher.nextNumber.Uint64()+fetcher.confirms > fetcher.latestNumber || time.Since(fetcher.latestTime) > latestRefreshInterval {
+		latestNumber, err := fetcher.latestBlock()
+		if nil != err {
+			return err
+		}
+		fetcher.latestNumber = latestNumber
+		fetcher.latestTime = time.Now()
+		metrics.ExtractorChainBlockNumber.Set(float64(fetcher.latestNumber))
+		fetcher.observeLag()
+	}
+
+	for len(fetcher.pending) < fetcher.ahead && fetcher.nextNumber.Uint64()+fetcher.confirms <= fetcher.latestNumber {
+		if nil != fetcher.endNumber && fetcher.endNumber.Sign() > 0 && fetcher.endNumber.Cmp(fetcher.nextNumber) < 0 {
+			break
+		}
+		resChan := make(chan *fetchedBlock, 1)
+		go fetcher.fetch(new(big.Int).Set(fetcher.nextNumber), resChan)
+		fetcher.pending = append(fetcher.pending, resChan)
+		fetcher.nextNumber.Add(fetcher.nextNumber, big.NewInt(1))
+	}
+	return nil
+}
+
+// observeLag sets the lag between the latest block of the node and the last one returned by Next
+func (fetcher *blockFetcher) observeLag() {
+	current := new(big.Int).Sub(fetcher.nextNumber, big.NewInt(int64(len(fetcher.pending)+1)))
+	if lag := int64(fetcher.latestNumber) - current.Int64(); lag > 0 {
+		metrics.ExtractorBlockLag.Set(float64(lag))
+	} else {
+		metrics.ExtractorBlockLag.Set(0)
+	}
+}
+
+func (fetcher *blockFetcher) fetch(number *big.Int, resChan chan *fetchedBlock) {
+	res := &fetchedBlock{number: number}
+	defer func() {
+		resChan <- res
+	}()
+
+	block, err := fetcher.fullBlock(number)
+	if nil != err {
+		res.err = err
+		return
+	}
+	res.block = block
+	res.txs = make([]decodedTransaction, len(res.block.Transactions))
+
+	blockTime := res.block.Timestamp.BigInt()
+	var wg sync.WaitGroup
+	for idx := range res.block.Transactions {
+		wg.Add(1)
+		fetcher.decodeSem <- true
+		go func(idx int) {
+			defer func() {
+				<-fetcher.decodeSem
+				wg.Done()
+			}()
+			tx := &res.block.Transactions[idx]
+			receipt := &res.block.Receipts[idx]
+			items, err := fetcher.extractor.decodeMinedTransaction(tx, receipt, blockTime)
+			res.txs[idx] = decodedTransaction{tx: tx, items: items, err: err}
+		}(idx)
+	}
+	wg.Wait()
+}
+
+func getLatestBlockNumber() (uint64, error) {
+	var blockNumber types.Big
+	if err := ethaccessor.BlockNumber(&blockNumber); nil != err {
+		return 0, err
+	}
+	return blockNumber.Uint64(), nil
+}
+
+func getFullBlock(number *big.Int) (*ethaccessor.BlockWithTxAndReceipt, error) {
+	inter, err := ethaccessor.GetFullBlock(number, true)
+	if nil != err {
+		return nil, err
+	}
+	return inter.(*ethaccessor.BlockWithTxAndReceipt), nil
+}
+
+// dispatch emits the items in order, the watchers which are not concurrent have finished when it returns.
+// All the items are emitted even if some watchers failed, the last error of them is returned.
+func dispatch(items []dispatchItem) error {
+	var lastErr error
+	for _, item := range items {
+		if err := eventemitter.Emit(item.topic, item.data); nil != err {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,154 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/types"
+)
+
+func newTestFetcher(start, end int64, latest uint64) (*blockFetcher, *int) {
+	l := &ExtractorServiceImpl{}
+	l.options = config.ExtractorOptions{ConfirmBlockNumber: 2, PrefetchBlocks: 4}
+	l.stop = make(chan struct{})
+
+	var mtx sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	fetcher := newBlockFetcher(l, big.NewInt(start), big.NewInt(end))
+	fetcher.latestBlock = func() (uint64, error) {
+		return latest, nil
+	}
+	fetcher.fullBlock = func(number *big.Int) (*ethaccessor.BlockWithTxAndReceipt, error) {
+		mtx.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mtx.Unlock()
+		defer func() {
+			mtx.Lock()
+			inFlight--
+			mtx.Unlock()
+		}()
+
+		//the later blocks are fetched faster
+		time.Sleep(time.Duration(4-number.Int64()%4) * 5 * time.Millisecond)
+		block := &ethaccessor.BlockWithTxAndReceipt{}
+		block.Number = *types.NewBigPtr(number)
+		return block, nil
+	}
+	return fetcher, &maxInFlight
+}
+
+func TestBlockFetcher_Order(t *testing.T) {
+	fetcher, maxInFlight := newTestFetcher(10, 20, 30)
+	for n := int64(10); n <= 20; n++ {
+		res, err := fetcher.Next()
+		if nil != err {
+			t.Fatalf("fetch block:%d err:%s", n, err.Error())
+		}
+		if res.number.Int64() != n || res.block.Number.Int64() != n {
+			t.Fatalf("block:%d should be returned, got:%s", n, res.number.String())
+		}
+	}
+	if _, err := fetcher.Next(); errFetchFinished != err {
+		t.Fatalf("the fetcher should be finished after the end block, err:%v", err)
+	}
+	if *maxInFlight > 4 {
+		t.Fatalf("at most 4 blocks should be fetched ahead, got:%d", *maxInFlight)
+	}
+}
+
+func TestBlockFetcher_Confirms(t *testing.T) {
+	fetcher, _ := newTestFetcher(10, 0, 13)
+	for n := int64(10); n <= 11; n++ {
+		if res, err := fetcher.Next(); nil != err || res.number.Int64() != n {
+			t.Fatalf("block:%d should be returned, err:%v", n, err)
+		}
+	}
+
+	//block 12 isn't confirmed, Next waits until the extractor is stopped
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(fetcher.extractor.stop)
+	}()
+	if _, err := fetcher.Next(); errFetchStopped != err {
+		t.Fatalf("the unconfirmed block shouldn't be returned, err:%v", err)
+	}
+}
+
+func TestBlockFetcher_Reset(t *testing.T) {
+	fetcher, _ := newTestFetcher(10, 15, 30)
+	fullBlock := fetcher.fullBlock
+	failed := false
+	fetcher.fullBlock = func(number *big.Int) (*ethaccessor.BlockWithTxAndReceipt, error) {
+		if 12 == number.Int64() && !failed {
+			failed = true
+			return nil, errors.New("not found")
+		}
+		return fullBlock(number)
+	}
+
+	expected := []int64{10, 11, -1, 12, 13, 14, 15}
+	for _, n := range expected {
+		res, err := fetcher.Next()
+		if n < 0 {
+			if nil == err {
+				t.Fatalf("the error of block:12 should be returned")
+			}
+			continue
+		}
+		if nil != err || res.number.Int64() != n {
+			t.Fatalf("block:%d should be returned, err:%v", n, err)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	topic := "ExtractorDispatchTest"
+	var handled int32
+	failed := &eventemitter.Watcher{Concurrent: false, Handle: func(eventData eventemitter.EventData) error {
+		atomic.AddInt32(&handled, 1)
+		if "fail" == eventData.(string) {
+			return errors.New("failed")
+		}
+		return nil
+	}}
+	eventemitter.On(topic, failed)
+	defer eventemitter.Un(topic, failed)
+
+	if err := dispatch([]dispatchItem{{topic: topic, data: "ok"}}); nil != err {
+		t.Fatalf("dispatch shouldn't fail, err:%s", err.Error())
+	}
+	if err := dispatch([]dispatchItem{{topic: topic, data: "fail"}, {topic: topic, data: "ok"}}); nil == err {
+		t.Fatalf("the error of the watcher should be returned")
+	}
+	if 3 != atomic.LoadInt32(&handled) {
+		t.Fatalf("all the items should be dispatched, handled:%d", handled)
+	}
+}
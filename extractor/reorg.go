@@ -0,0 +1,223 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const defaultReorgWindow = 128
+
+// blockWindow is a ring buffer of the latest block headers, the headers are continuous and end with tip
+type blockWindow struct {
+	blocks []*types.Block
+	tip    *types.Block
+	count  int
+}
+
+func newBlockWindow(size int) *blockWindow {
+	if size <= 0 {
+		size = defaultReorgWindow
+	}
+	w := &blockWindow{}
+	w.blocks = make([]*types.Block, size)
+	return w
+}
+
+func (w *blockWindow) index(number *big.Int) int {
+	return int(new(big.Int).Mod(number, big.NewInt(int64(len(w.blocks)))).Int64())
+}
+
+// push appends the child of tip, the window is restarted from block if it isn't the next one
+func (w *blockWindow) push(block *types.Block) {
+	if nil == w.tip || new(big.Int).Add(w.tip.BlockNumber, big.NewInt(1)).Cmp(block.BlockNumber) != 0 {
+		w.count = 0
+	}
+	w.blocks[w.index(block.BlockNumber)] = block
+	w.tip = block
+	if w.count < len(w.blocks) {
+		w.count++
+	}
+}
+
+// get returns nil if the block of number isn't in the window
+func (w *blockWindow) get(number *big.Int) *types.Block {
+	if nil == w.tip || number.Cmp(w.tip.BlockNumber) > 0 || number.Cmp(w.oldest()) < 0 {
+		return nil
+	}
+	return w.blocks[w.index(number)]
+}
+
+func (w *blockWindow) oldest() *big.Int {
+	return new(big.Int).Sub(w.tip.BlockNumber, big.NewInt(int64(w.count-1)))
+}
+
+// after returns the blocks after number in ascending order
+func (w *blockWindow) after(number *big.Int) []types.Block {
+	list := []types.Block{}
+	if nil == w.tip {
+		return list
+	}
+	for n := new(big.Int).Add(number, big.NewInt(1)); n.Cmp(w.tip.BlockNumber) <= 0; n.Add(n, big.NewInt(1)) {
+		if block := w.get(n); nil != block {
+			list = append(list, *block)
+		}
+	}
+	return list
+}
+
+// rollback drops the blocks after ancestor, ancestor becomes the tip
+func (w *blockWindow) rollback(ancestor *types.Block) {
+	local := w.get(ancestor.BlockNumber)
+	if nil == local || local.BlockHash != ancestor.BlockHash {
+		w.tip = nil
+		w.push(ancestor)
+		return
+	}
+	w.count -= int(new(big.Int).Sub(w.tip.BlockNumber, ancestor.BlockNumber).Int64())
+	w.tip = local
+}
+
+// reorgDetector keeps the headers of the latest blocks which have been extracted,
+// the common ancestor of a reorg is found by walking the parents of the new block until one is in the window.
+type reorgDetector struct {
+	db          dao.RdsService
+	window      *blockWindow
+	blockByHash func(hash common.Hash) (*types.Block, error)
+}
+
+func newReorgDetector(db dao.RdsService, startBlockConfig *big.Int, windowSize int) *reorgDetector {
+	detector := &reorgDetector{}
+	detector.db = db
+	detector.window = newBlockWindow(windowSize)
+	detector.blockByHash = getBlockHeaderByHash
+
+	if models, err := detector.db.FindLatestBlocks(len(detector.window.blocks)); err == nil && len(models) > 0 {
+		for i := len(models) - 1; i >= 0; i-- {
+			block := &types.Block{}
+			models[i].ConvertUp(block)
+			detector.window.push(block)
+		}
+		return detector
+	}
+
+	var block ethaccessor.Block
+	if err := ethaccessor.GetBlockByNumber(&block, startBlockConfig, false); err != nil {
+		log.Fatalf("extractor,reorg detector can not find init block:%s", startBlockConfig.String())
+	}
+
+	initBlock := &types.Block{}
+	initBlock.BlockNumber = block.Number.BigInt()
+	initBlock.BlockHash = block.Hash
+	initBlock.CreateTime = block.Timestamp.BigInt().Int64()
+	initBlock.ParentHash = block.ParentHash
+	detector.window.push(initBlock)
+
+	model := &dao.Block{}
+	model.ConvertDown(initBlock)
+	detector.db.SaveBlock(model)
+
+	return detector
+}
+
+// Detect returns a ChainReorgEvent if currentBlock isn't the child of the latest block,
+// the blocks after the common ancestor are removed from the window and marked as forked in db.
+func (detector *reorgDetector) Detect(currentBlock *types.Block) (*types.ChainReorgEvent, error) {
+	// filter invalid block
+	if types.IsZeroHash(currentBlock.ParentHash) || types.IsZeroHash(currentBlock.BlockHash) {
+		return nil, fmt.Errorf("extractor,reorg detector find invalid block:%s", currentBlock.BlockNumber.String())
+	}
+
+	tip := detector.window.tip
+	if nil == tip || tip.BlockHash == currentBlock.ParentHash {
+		detector.window.push(currentBlock)
+		return nil, nil
+	}
+	if tip.BlockHash == currentBlock.BlockHash {
+		return nil, nil
+	}
+
+	ancestor, canonicalBlocks, err := detector.findCommonAncestor(currentBlock)
+	if err != nil {
+		return nil, fmt.Errorf("extractor,find common ancestor of block:%s failed :%s,node should be shut down...", currentBlock.BlockHash.Hex(), err.Error())
+	}
+
+	event := &types.ChainReorgEvent{}
+	event.CommonAncestor = ancestor.BlockNumber
+	event.CommonAncestorHash = ancestor.BlockHash
+	event.OrphanedFrom = new(big.Int).Add(ancestor.BlockNumber, big.NewInt(1))
+	event.OrphanedTo = tip.BlockNumber
+	event.OrphanedBlocks = detector.window.after(ancestor.BlockNumber)
+	event.CanonicalBlocks = canonicalBlocks
+	event.DetectedBlock = currentBlock.BlockNumber
+	event.DetectedHash = currentBlock.BlockHash
+
+	// mark orphaned blocks in database
+	if err := detector.db.SetForkBlock(event.CommonAncestor.Int64(), event.OrphanedTo.Int64()); err != nil {
+		return nil, fmt.Errorf("extractor,reorg detector mark blocks after %s failed, you should mark it manual, err:%s", ancestor.BlockHash.Hex(), err.Error())
+	}
+	detector.window.rollback(ancestor)
+
+	return event, nil
+}
+
+// findCommonAncestor walks the parents of block on chain, it returns the ancestor in the window
+// and the canonical blocks between the ancestor and block in ascending order.
+func (detector *reorgDetector) findCommonAncestor(block *types.Block) (*types.Block, []types.Block, error) {
+	canonicalBlocks := []types.Block{}
+	oldest := detector.window.oldest()
+	parentHash := block.ParentHash
+	for {
+		parent, err := detector.blockByHash(parentHash)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if local := detector.window.get(parent.BlockNumber); nil != local && local.BlockHash == parent.BlockHash {
+			return local, canonicalBlocks, nil
+		}
+		if parent.BlockNumber.Cmp(oldest) <= 0 {
+			return nil, nil, fmt.Errorf("the reorg is deeper than the window of %d blocks", len(detector.window.blocks))
+		}
+
+		canonicalBlocks = append([]types.Block{*parent}, canonicalBlocks...)
+		parentHash = parent.ParentHash
+	}
+}
+
+func getBlockHeaderByHash(hash common.Hash) (*types.Block, error) {
+	var ethBlock ethaccessor.Block
+	if err := ethaccessor.GetBlockByHash(&ethBlock, hash.Hex(), false); err != nil {
+		return nil, err
+	}
+
+	block := &types.Block{}
+	block.BlockNumber = ethBlock.Number.BigInt()
+	block.BlockHash = ethBlock.Hash
+	block.ParentHash = ethBlock.ParentHash
+	block.CreateTime = ethBlock.Timestamp.BigInt().Int64()
+	return block, nil
+}
@@ -0,0 +1,230 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// testBlock is the block of number in fork, the blocks of fork 0 are the local ones
+func testBlock(fork, number int64) *types.Block {
+	block := &types.Block{}
+	block.BlockNumber = big.NewInt(number)
+	block.BlockHash = common.BigToHash(big.NewInt(fork*1000000 + number))
+	block.ParentHash = common.BigToHash(big.NewInt(fork*1000000 + number - 1))
+	return block
+}
+
+// testForkBlock is a block of fork whose chain leaves fork 0 after the block of from
+func testForkBlock(fork, from, number int64) *types.Block {
+	if number <= from {
+		return testBlock(0, number)
+	}
+	block := testBlock(fork, number)
+	if number == from+1 {
+		block.ParentHash = testBlock(0, from).BlockHash
+	}
+	return block
+}
+
+func blockNumbers(blocks []types.Block) string {
+	numbers := []int64{}
+	for _, block := range blocks {
+		numbers = append(numbers, block.BlockNumber.Int64())
+	}
+	return fmt.Sprint(numbers)
+}
+
+func testWindow(size int, from, to int64) *blockWindow {
+	w := newBlockWindow(size)
+	for n := from; n <= to; n++ {
+		w.push(testBlock(0, n))
+	}
+	return w
+}
+
+func TestBlockWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		window func() *blockWindow
+		tip    int64
+		oldest int64
+		after  int64
+		blocks string
+	}{
+		{
+			name:   "continuous",
+			window: func() *blockWindow { return testWindow(4, 10, 12) },
+			tip:    12, oldest: 10, after: 9, blocks: "[10 11 12]",
+		},
+		{
+			name: "push with a gap",
+			window: func() *blockWindow {
+				w := testWindow(4, 10, 12)
+				w.push(testBlock(0, 14))
+				return w
+			},
+			tip: 14, oldest: 14, after: 9, blocks: "[14]",
+		},
+		{
+			name:   "after across wrap-around",
+			window: func() *blockWindow { return testWindow(4, 10, 15) },
+			tip:    15, oldest: 12, after: 11, blocks: "[12 13 14 15]",
+		},
+		{
+			name:   "after inside wrap-around",
+			window: func() *blockWindow { return testWindow(4, 10, 15) },
+			tip:    15, oldest: 12, after: 13, blocks: "[14 15]",
+		},
+		{
+			name: "rollback to an ancestor inside the window",
+			window: func() *blockWindow {
+				w := testWindow(4, 10, 15)
+				w.rollback(testBlock(0, 13))
+				return w
+			},
+			tip: 13, oldest: 12, after: 9, blocks: "[12 13]",
+		},
+		{
+			name: "rollback to an ancestor outside the window",
+			window: func() *blockWindow {
+				w := testWindow(4, 10, 15)
+				w.rollback(testBlock(0, 8))
+				return w
+			},
+			tip: 8, oldest: 8, after: 0, blocks: "[8]",
+		},
+		{
+			name: "rollback to an ancestor which isn't the local one",
+			window: func() *blockWindow {
+				w := testWindow(4, 10, 15)
+				w.rollback(testBlock(1, 13))
+				return w
+			},
+			tip: 13, oldest: 13, after: 0, blocks: "[13]",
+		},
+		{
+			name: "push after rollback",
+			window: func() *blockWindow {
+				w := testWindow(4, 10, 15)
+				w.rollback(testBlock(0, 13))
+				w.push(testForkBlock(1, 13, 14))
+				return w
+			},
+			tip: 14, oldest: 12, after: 11, blocks: "[12 13 14]",
+		},
+	}
+
+	for _, test := range tests {
+		w := test.window()
+		if w.tip.BlockNumber.Int64() != test.tip {
+			t.Errorf("%s: tip should be %d, got:%d", test.name, test.tip, w.tip.BlockNumber.Int64())
+		}
+		if w.oldest().Int64() != test.oldest {
+			t.Errorf("%s: oldest should be %d, got:%d", test.name, test.oldest, w.oldest().Int64())
+		}
+		if blocks := blockNumbers(w.after(big.NewInt(test.after))); blocks != test.blocks {
+			t.Errorf("%s: blocks after %d should be %s, got:%s", test.name, test.after, test.blocks, blocks)
+		}
+		if nil != w.get(big.NewInt(test.oldest-1)) || nil != w.get(big.NewInt(test.tip+1)) {
+			t.Errorf("%s: the blocks out of the window shouldn't be returned", test.name)
+		}
+	}
+}
+
+type forkBlockRds struct {
+	dao.RdsService
+	from, to int64
+}
+
+func (rds *forkBlockRds) SetForkBlock(from, to int64) error {
+	rds.from = from
+	rds.to = to
+	return nil
+}
+
+func TestReorgDetector_Detect(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   *types.Block
+		err       bool
+		ancestor  int64
+		orphaned  string
+		canonical string
+		tip       int64
+	}{
+		{name: "child of tip", current: testBlock(0, 16), tip: 16},
+		{name: "tip again", current: testBlock(0, 15), tip: 15},
+		{name: "invalid block", current: &types.Block{BlockNumber: big.NewInt(16)}, err: true, tip: 15},
+		{name: "reorg of tip", current: testForkBlock(1, 14, 16), ancestor: 14, orphaned: "[15]", canonical: "[15]", tip: 14},
+		{name: "reorg inside the window", current: testForkBlock(1, 12, 16), ancestor: 12, orphaned: "[13 14 15]", canonical: "[13 14 15]", tip: 12},
+		{name: "reorg deeper than the window", current: testForkBlock(1, 10, 16), err: true, tip: 15},
+	}
+
+	for _, test := range tests {
+		rds := &forkBlockRds{}
+		detector := &reorgDetector{db: rds, window: testWindow(4, 8, 15)}
+		detector.blockByHash = func(hash common.Hash) (*types.Block, error) {
+			for n := int64(8); n <= 16; n++ {
+				if block := testForkBlock(1, test.ancestor, n); block.BlockHash == hash {
+					return block, nil
+				}
+				if block := testForkBlock(1, 10, n); block.BlockHash == hash {
+					return block, nil
+				}
+			}
+			return nil, fmt.Errorf("block:%s not found", hash.Hex())
+		}
+
+		event, err := detector.Detect(test.current)
+		if test.err != (nil != err) {
+			t.Errorf("%s: unexpected err:%v", test.name, err)
+		}
+		if tip := detector.window.tip.BlockNumber.Int64(); tip != test.tip {
+			t.Errorf("%s: tip should be %d, got:%d", test.name, test.tip, tip)
+		}
+		if "" == test.orphaned {
+			if nil != event {
+				t.Errorf("%s: no reorg should be detected, got ancestor:%s", test.name, event.CommonAncestor.String())
+			}
+			continue
+		}
+		if nil == event {
+			t.Fatalf("%s: the reorg should be detected", test.name)
+		}
+		if event.CommonAncestor.Int64() != test.ancestor || event.CommonAncestorHash != testBlock(0, test.ancestor).BlockHash {
+			t.Errorf("%s: common ancestor should be %d, got:%s", test.name, test.ancestor, event.CommonAncestor.String())
+		}
+		if blocks := blockNumbers(event.OrphanedBlocks); blocks != test.orphaned {
+			t.Errorf("%s: orphaned blocks should be %s, got:%s", test.name, test.orphaned, blocks)
+		}
+		if blocks := blockNumbers(event.CanonicalBlocks); blocks != test.canonical {
+			t.Errorf("%s: canonical blocks should be %s, got:%s", test.name, test.canonical, blocks)
+		}
+		if rds.from != test.ancestor || rds.to != 15 {
+			t.Errorf("%s: the blocks from %d to 15 should be forked, got:%d-%d", test.name, test.ancestor, rds.from, rds.to)
+		}
+	}
+}
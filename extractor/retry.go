@@ -0,0 +1,123 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"encoding/json"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+)
+
+const defaultMaxRetries = 5
+
+type retryTransaction struct {
+	TxHash      string `json:"txHash"`
+	BlockNumber int64  `json:"blockNumber"`
+	BlockTime   int64  `json:"blockTime"`
+	Retries     int    `json:"retries"`
+	NextBlock   int64  `json:"nextBlock"` //the tx is retried after this block has been dispatched
+}
+
+// retryQueue keeps the transactions failed to be fetched or decoded, it's saved in the check point table
+// so that the transactions are still retried after restart. The interval of retries is doubled every time.
+type retryQueue struct {
+	db         dao.RdsService
+	maxRetries int
+	txs        []*retryTransaction
+}
+
+func newRetryQueue(db dao.RdsService, maxRetries int) *retryQueue {
+	queue := &retryQueue{}
+	queue.db = db
+	queue.maxRetries = maxRetries
+	if queue.maxRetries <= 0 {
+		queue.maxRetries = defaultMaxRetries
+	}
+	queue.txs = []*retryTransaction{}
+
+	if point, err := db.QueryCheckPointByType(dao.ExtractorRetryQueueType); nil == err && "" != point.Content {
+		if err := json.Unmarshal([]byte(point.Content), &queue.txs); nil != err {
+			log.Errorf("extractor,load retry queue error:%s", err.Error())
+		}
+	}
+	return queue
+}
+
+func (queue *retryQueue) Len() int {
+	return len(queue.txs)
+}
+
+func (queue *retryQueue) Add(txHash string, blockNumber, blockTime int64) {
+	queue.txs = append(queue.txs, &retryTransaction{TxHash: txHash, BlockNumber: blockNumber, BlockTime: blockTime, NextBlock: blockNumber + 1})
+}
+
+// Drop removes the transactions of the orphaned blocks, they are extracted again with the canonical blocks
+func (queue *retryQueue) Drop(fromBlock int64) {
+	txs := []*retryTransaction{}
+	for _, tx := range queue.txs {
+		if tx.BlockNumber < fromBlock {
+			txs = append(txs, tx)
+		}
+	}
+	queue.txs = txs
+}
+
+// Retry calls handle for the transactions that are due at blockNumber, the queue is saved if it's changed
+func (queue *retryQueue) Retry(blockNumber int64, handle func(tx *retryTransaction) error) {
+	changed := false
+	txs := []*retryTransaction{}
+	for _, tx := range queue.txs {
+		if tx.NextBlock > blockNumber {
+			txs = append(txs, tx)
+			continue
+		}
+
+		changed = true
+		err := handle(tx)
+		if nil == err {
+			log.Infof("extractor,tx:%s has been extracted after %d retries", tx.TxHash, tx.Retries+1)
+			continue
+		}
+
+		tx.Retries++
+		if tx.Retries >= queue.maxRetries {
+			log.Errorf("extractor,tx:%s of block:%d is dropped after %d retries, err:%s", tx.TxHash, tx.BlockNumber, tx.Retries, err.Error())
+			continue
+		}
+		tx.NextBlock = blockNumber + int64(1)<<uint(tx.Retries)
+		txs = append(txs, tx)
+	}
+	queue.txs = txs
+
+	if changed {
+		queue.Save(blockNumber)
+	}
+}
+
+func (queue *retryQueue) Save(blockNumber int64) {
+	data, err := json.Marshal(queue.txs)
+	if nil != err {
+		log.Errorf("extractor,marshal retry queue error:%s", err.Error())
+		return
+	}
+	if err := queue.db.SaveCheckPoint(dao.ExtractorRetryQueueType, blockNumber, string(data)); nil != err {
+		log.Errorf("extractor,save retry queue error:%s", err.Error())
+	}
+}
@@ -0,0 +1,132 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+}
+
+type checkPointRds struct {
+	dao.RdsService
+	points map[string]dao.CheckPoint
+}
+
+func (rds *checkPointRds) QueryCheckPointByType(businessType string) (dao.CheckPoint, error) {
+	point, ok := rds.points[businessType]
+	if !ok {
+		return point, errors.New("record not found")
+	}
+	return point, nil
+}
+
+func (rds *checkPointRds) SaveCheckPoint(businessType string, checkPoint int64, content string) error {
+	rds.points[businessType] = dao.CheckPoint{BusinessType: businessType, CheckPoint: checkPoint, Content: content}
+	return nil
+}
+
+func TestRetryQueue_Backoff(t *testing.T) {
+	rds := &checkPointRds{points: map[string]dao.CheckPoint{}}
+	queue := newRetryQueue(rds, 3)
+	queue.Add("0x01", 100, 1000)
+
+	//the interval is doubled after each failure, the tx fails at 101 and 103, then it is extracted at 107
+	results := []struct {
+		block   int64
+		handled bool
+		err     error
+		len     int
+	}{
+		{block: 100, handled: false, len: 1},
+		{block: 101, handled: true, err: errors.New("failed"), len: 1},
+		{block: 102, handled: false, len: 1},
+		{block: 103, handled: true, err: errors.New("failed"), len: 1},
+		{block: 106, handled: false, len: 1},
+		{block: 107, handled: true, len: 0},
+	}
+	for _, res := range results {
+		handled := false
+		queue.Retry(res.block, func(tx *retryTransaction) error {
+			handled = true
+			if "0x01" != tx.TxHash || 100 != tx.BlockNumber || 1000 != tx.BlockTime {
+				t.Fatalf("unexpected tx:%#v", tx)
+			}
+			return res.err
+		})
+		if handled != res.handled || queue.Len() != res.len {
+			t.Fatalf("block:%d, handled should be %t, got:%t, len should be %d, got:%d", res.block, res.handled, handled, res.len, queue.Len())
+		}
+	}
+}
+
+func TestRetryQueue_MaxRetries(t *testing.T) {
+	rds := &checkPointRds{points: map[string]dao.CheckPoint{}}
+	queue := newRetryQueue(rds, 2)
+	queue.Add("0x01", 100, 1000)
+
+	failed := func(tx *retryTransaction) error {
+		return errors.New("failed")
+	}
+	queue.Retry(101, failed)
+	if 1 != queue.Len() {
+		t.Fatalf("the tx should be retried again")
+	}
+	queue.Retry(103, failed)
+	if 0 != queue.Len() {
+		t.Fatalf("the tx should be dropped after 2 retries")
+	}
+}
+
+func TestRetryQueue_Persistence(t *testing.T) {
+	rds := &checkPointRds{points: map[string]dao.CheckPoint{}}
+	queue := newRetryQueue(rds, 5)
+	queue.Add("0x01", 100, 1000)
+	queue.Add("0x02", 105, 1050)
+	queue.Save(105)
+	queue.Retry(101, func(tx *retryTransaction) error {
+		return errors.New("failed")
+	})
+
+	if point := rds.points[dao.ExtractorRetryQueueType]; 101 != point.CheckPoint {
+		t.Fatalf("the queue should be saved at block 101, got:%d", point.CheckPoint)
+	}
+
+	loaded := newRetryQueue(rds, 5)
+	if 2 != loaded.Len() {
+		t.Fatalf("the txs should be loaded after restart, got:%d", loaded.Len())
+	}
+	if tx := loaded.txs[0]; "0x01" != tx.TxHash || 1 != tx.Retries || 103 != tx.NextBlock {
+		t.Fatalf("the retries of the tx should be loaded, got:%#v", tx)
+	}
+
+	//the txs of the orphaned blocks are dropped
+	loaded.Drop(103)
+	if 1 != loaded.Len() || "0x01" != loaded.txs[0].TxHash {
+		t.Fatalf("the tx of block 105 should be dropped, got:%d", loaded.Len())
+	}
+}
@@ -0,0 +1,250 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_DEPTH_LENGTH     = 50
+	DEFAULT_MAX_DEPTH_LENGTH = 200
+	DEFAULT_DEPTH_PRECISION  = 10
+	MAX_DEPTH_PRECISION      = 18
+
+	depthOrdersPerLevel = 2   //the orders fetched for each level, some of them are aggregated into the same level
+	depthSnapshotTTL    = 10  //seconds a book is snapshotted without being calculated again
+	depthBookIdleTime   = 600 //seconds a book is kept without being snapshotted
+)
+
+// DepthDiff is the levels changed from PrevSequence to Sequence, a level removed has zero amount and size.
+// The levels are of the max length, so a client keeping a shorter book should cut it after applying the diff.
+type DepthDiff struct {
+	DelegateAddress string `json:"delegateAddress"`
+	Market          string `json:"market"`
+	Precision       int    `json:"precision"`
+	PrevSequence    int64  `json:"prevSequence"`
+	Sequence        int64  `json:"sequence"`
+	Depth           AskBid `json:"depth"`
+}
+
+type depthKey struct {
+	delegateAddress string
+	market          string
+	precision       int
+}
+
+type depthBook struct {
+	sequence   int64
+	updateTime int64
+	accessTime int64
+	depth      AskBid
+}
+
+// DepthFeed keeps the depths snapshotted recently at the max length, they are calculated again on DepthUpdated
+// and the levels changed are emitted as DepthDiff on DepthDiffUpdated. The sequences are increased across
+// the books of the feed, so a client can find the diffs missed by PrevSequence.
+type DepthFeed struct {
+	mtx       sync.Mutex
+	books     map[depthKey]*depthBook
+	sequence  int64
+	calculate func(key depthKey, length int) (AskBid, error)
+	now       func() time.Time
+}
+
+func newDepthFeed(calculate func(key depthKey, length int) (AskBid, error)) *DepthFeed {
+	f := &DepthFeed{}
+	f.books = make(map[depthKey]*depthBook)
+	f.calculate = calculate
+	f.now = time.Now
+	//it isn't reused after restart
+	f.sequence = f.now().UnixNano()
+	return f
+}
+
+// Snapshot returns the depth of the first length levels and its sequence
+func (f *DepthFeed) Snapshot(key depthKey, length int) (AskBid, int64, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	book, exists := f.books[key]
+	if !exists || f.now().Unix()-book.updateTime >= depthSnapshotTTL {
+		var err error
+		if book, err = f.refresh(key); nil != err {
+			return AskBid{Buy: [][]string{}, Sell: [][]string{}}, 0, err
+		}
+	}
+	book.accessTime = f.now().Unix()
+	return AskBid{Buy: cutDepth(book.depth.Buy, length, false), Sell: cutDepth(book.depth.Sell, length, true)}, book.sequence, nil
+}
+
+func (f *DepthFeed) handleDepthUpdated(input eventemitter.EventData) error {
+	event := input.(types.DepthUpdateEvent)
+	if !common.IsHexAddress(event.DelegateAddress) {
+		return nil
+	}
+	delegateAddress := common.HexToAddress(event.DelegateAddress).Hex()
+	market := strings.ToUpper(event.Market)
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for key, book := range f.books {
+		if f.now().Unix()-book.accessTime >= depthBookIdleTime {
+			delete(f.books, key)
+			continue
+		}
+		if key.delegateAddress == delegateAddress && key.market == market {
+			if _, err := f.refresh(key); nil != err {
+				log.Errorf("gateway,refresh depth of %s err:%s", market, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// refresh calculates the book again and emits the levels changed, the mutex must be held
+func (f *DepthFeed) refresh(key depthKey) (*depthBook, error) {
+	depth, err := f.calculate(key, maxDepthLength())
+	if nil != err {
+		return nil, err
+	}
+	now := f.now().Unix()
+	book, exists := f.books[key]
+	if !exists {
+		f.sequence++
+		book = &depthBook{sequence: f.sequence, accessTime: now}
+		f.books[key] = book
+	} else {
+		diff := AskBid{Buy: diffDepth(book.depth.Buy, depth.Buy), Sell: diffDepth(book.depth.Sell, depth.Sell)}
+		if len(diff.Buy) > 0 || len(diff.Sell) > 0 {
+			f.sequence++
+			eventemitter.Emit(eventemitter.DepthDiffUpdated, &DepthDiff{
+				DelegateAddress: key.delegateAddress,
+				Market:          key.market,
+				Precision:       key.precision,
+				PrevSequence:    book.sequence,
+				Sequence:        f.sequence,
+				Depth:           diff,
+			})
+			book.sequence = f.sequence
+		}
+	}
+	book.depth = depth
+	book.updateTime = now
+	return book, nil
+}
+
+// diffDepth returns the levels of current different from previous, the levels are sorted by price desc
+func diffDepth(previous, current [][]string) [][]string {
+	levels := make(map[string][]string)
+	for _, level := range previous {
+		levels[level[0]] = level
+	}
+	diff := [][]string{}
+	for _, level := range current {
+		prevLevel, exists := levels[level[0]]
+		if !exists || prevLevel[1] != level[1] || prevLevel[2] != level[2] {
+			diff = append(diff, level)
+		}
+		delete(levels, level[0])
+	}
+	for price := range levels {
+		diff = append(diff, []string{price, "0", "0"})
+	}
+	sortDepth(diff)
+	return diff
+}
+
+func sortDepth(depth [][]string) {
+	sort.Slice(depth, func(i, j int) bool {
+		priceI, _ := new(big.Rat).SetString(depth[i][0])
+		priceJ, _ := new(big.Rat).SetString(depth[j][0])
+		return priceI.Cmp(priceJ) > 0
+	})
+}
+
+// cutDepth keeps the best length levels, they are the lowest of asks and the highest of bids
+func cutDepth(depth [][]string, length int, isAsk bool) [][]string {
+	if length >= len(depth) {
+		return depth
+	}
+	if isAsk {
+		return depth[len(depth)-length:]
+	}
+	return depth[:length]
+}
+
+// roundPrice formats the price with precision decimals, the price of ask is rounded up and bid down,
+// so the orders of a level can be filled at its price.
+func roundPrice(price *big.Rat, precision int, isAsk bool) string {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	scaled := new(big.Rat).Mul(price, new(big.Rat).SetInt(scale))
+	ticks, remainder := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if isAsk && remainder.Sign() > 0 {
+		ticks.Add(ticks, big.NewInt(1))
+	}
+	return new(big.Rat).SetFrac(ticks, scale).FloatString(precision)
+}
+
+func maxDepthLength() int {
+	if gateway.maxDepthLength > 0 {
+		return gateway.maxDepthLength
+	}
+	return DEFAULT_MAX_DEPTH_LENGTH
+}
+
+// depthOptionsOf returns the length and precision of depth query, the defaults of market are used if they're 0
+func depthOptionsOf(market string, length, precision int) (int, int, error) {
+	if length <= 0 {
+		length = gateway.depthLength
+		if length <= 0 {
+			length = DEFAULT_DEPTH_LENGTH
+		}
+	}
+	if maxLength := maxDepthLength(); length > maxLength {
+		length = maxLength
+	}
+
+	if precision < 0 || precision > MAX_DEPTH_PRECISION {
+		return 0, 0, fmt.Errorf("precision should be in [0, %d]", MAX_DEPTH_PRECISION)
+	}
+	return length, depthPrecisionOrDefault(market, precision), nil
+}
+
+func depthPrecisionOrDefault(market string, precision int) int {
+	if precision > 0 {
+		return precision
+	}
+	if precision, exists := gateway.depthPrecisions[strings.ToUpper(market)]; exists && precision > 0 {
+		return precision
+	}
+	if gateway.depthPrecision > 0 {
+		return gateway.depthPrecision
+	}
+	return DEFAULT_DEPTH_PRECISION
+}
@@ -0,0 +1,95 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestRoundPrice(t *testing.T) {
+	price := big.NewRat(1, 3)
+	if ask := roundPrice(price, 4, true); "0.3334" != ask {
+		t.Errorf("the price of ask should be rounded up, got:%s", ask)
+	}
+	if bid := roundPrice(price, 4, false); "0.3333" != bid {
+		t.Errorf("the price of bid should be rounded down, got:%s", bid)
+	}
+	if exact := roundPrice(big.NewRat(1, 4), 2, true); "0.25" != exact {
+		t.Errorf("the exact price shouldn't be rounded, got:%s", exact)
+	}
+}
+
+func TestDepthFeedDiff(t *testing.T) {
+	delegate := common.HexToAddress("0x17233e07c67d086464fd408148c3abb56245fa64").Hex()
+	key := depthKey{delegateAddress: delegate, market: "LRC-WETH", precision: 4}
+	depth := AskBid{
+		Buy:  [][]string{{"0.0010", "1", "1000"}, {"0.0009", "2", "2000"}},
+		Sell: [][]string{{"0.0012", "3", "2500"}, {"0.0011", "1", "900"}},
+	}
+	feed := newDepthFeed(func(k depthKey, length int) (AskBid, error) {
+		return depth, nil
+	})
+
+	diffs := []*DepthDiff{}
+	watcher := &eventemitter.Watcher{Concurrent: false, Handle: func(input eventemitter.EventData) error {
+		diffs = append(diffs, input.(*DepthDiff))
+		return nil
+	}}
+	eventemitter.On(eventemitter.DepthDiffUpdated, watcher)
+	defer eventemitter.Un(eventemitter.DepthDiffUpdated, watcher)
+
+	snapshot, sequence, err := feed.Snapshot(key, 1)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(snapshot.Buy) != 1 || "0.0010" != snapshot.Buy[0][0] || len(snapshot.Sell) != 1 || "0.0011" != snapshot.Sell[0][0] {
+		t.Fatalf("the snapshot should keep the best level, got:%v", snapshot)
+	}
+
+	depth = AskBid{
+		Buy:  [][]string{{"0.0010", "1.5", "1500"}},
+		Sell: [][]string{{"0.0012", "3", "2500"}, {"0.0011", "1", "900"}},
+	}
+	feed.handleDepthUpdated(types.DepthUpdateEvent{DelegateAddress: delegate, Market: "lrc-weth"})
+	if len(diffs) != 1 {
+		t.Fatalf("a diff should be emitted, got:%d", len(diffs))
+	}
+	diff := diffs[0]
+	if diff.PrevSequence != sequence || diff.Sequence <= sequence {
+		t.Errorf("the diff should follow the snapshot:%d, got:%d-%d", sequence, diff.PrevSequence, diff.Sequence)
+	}
+	expected := [][]string{{"0.0010", "1.5", "1500"}, {"0.0009", "0", "0"}}
+	if !reflect.DeepEqual(diff.Depth.Buy, expected) || len(diff.Depth.Sell) != 0 {
+		t.Errorf("the diff should only have the bids changed, got:%v", diff.Depth)
+	}
+
+	feed.handleDepthUpdated(types.DepthUpdateEvent{DelegateAddress: delegate, Market: "LRC-WETH"})
+	if len(diffs) != 1 {
+		t.Errorf("no diff should be emitted if the depth isn't changed")
+	}
+	if _, current, _ := feed.Snapshot(key, 1); current != diff.Sequence {
+		t.Errorf("the snapshot should be of the latest sequence:%d, got:%d", diff.Sequence, current)
+	}
+}
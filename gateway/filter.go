@@ -0,0 +1,359 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/ordermanager"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	FILTER_POW              = "pow"
+	FILTER_BASE             = "base"
+	FILTER_SIGN             = "sign"
+	FILTER_TOKEN            = "token"
+	FILTER_CUTOFF           = "cutoff"
+	FILTER_BALANCE          = "balance"
+	FILTER_OPEN_ORDER_LIMIT = "open_order_limit"
+	FILTER_TOKEN_DENY       = "token_deny"
+)
+
+//the chain used if gateway_filters.chain isn't set, it's the same as before the chain can be configured
+var defaultFilterChain = []string{FILTER_POW, FILTER_BASE, FILTER_SIGN, FILTER_TOKEN, FILTER_CUTOFF}
+
+// Filter checks the order submitted to the gateway, the order is rejected if any filter returns false.
+// The error should be a *FilterError so that loopring_validateOrder can report the code and threshold.
+type Filter interface {
+	Name() string
+	Filter(o *types.Order) (bool, error)
+}
+
+//the dependencies shared by all filters
+type FilterContext struct {
+	Options        *config.GatewayFiltersOptions
+	OrderManager   ordermanager.OrderManager
+	AccountManager *market.AccountManager
+	MarketCap      marketcap.MarketCapProvider
+}
+
+type FilterCreator func(ctx *FilterContext, params *FilterParams) (Filter, error)
+
+var filterCreators map[string]FilterCreator
+var filterMtx sync.RWMutex
+
+// RegisterFilter makes a filter available by name in gateway_filters.chain, it should be called before gateway.Initialize.
+func RegisterFilter(name string, creator FilterCreator) {
+	filterMtx.Lock()
+	defer filterMtx.Unlock()
+	if nil == filterCreators {
+		filterCreators = make(map[string]FilterCreator)
+	}
+	filterCreators[name] = creator
+}
+
+func RegisteredFilters() []string {
+	filterMtx.RLock()
+	defer filterMtx.RUnlock()
+	names := []string{}
+	for name := range filterCreators {
+		names = append(names, name)
+	}
+	return names
+}
+
+func NewFilter(name string, ctx *FilterContext, params *FilterParams) (Filter, error) {
+	filterMtx.RLock()
+	creator, exists := filterCreators[name]
+	filterMtx.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("filter:%s hasn't been registered", name)
+	}
+	return creator(ctx, params)
+}
+
+// NewFilterChain creates the filters in the order of options.Chain, the disabled ones are skipped
+func NewFilterChain(ctx *FilterContext) ([]Filter, error) {
+	chain := ctx.Options.Chain
+	if len(chain) == 0 {
+		for _, name := range defaultFilterChain {
+			chain = append(chain, config.GatewayFilterOptions{Name: name})
+		}
+	}
+
+	filters := []Filter{}
+	for _, filterOptions := range chain {
+		if filterOptions.Disable {
+			log.Infof("gateway, filter:%s is disabled", filterOptions.Name)
+			continue
+		}
+		filter, err := NewFilter(filterOptions.Name, ctx, NewFilterParams(filterOptions))
+		if nil != err {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// FilterParams holds the params of a filter in the chain, the params of the market of the order override the default ones
+type FilterParams struct {
+	params  map[string]string
+	markets map[string]map[string]string
+}
+
+func NewFilterParams(options config.GatewayFilterOptions) *FilterParams {
+	p := &FilterParams{}
+	p.params = make(map[string]string)
+	p.markets = make(map[string]map[string]string)
+	for k, v := range options.Params {
+		p.params[k] = v
+	}
+	for mkt, params := range options.Markets {
+		mkt = strings.ToUpper(mkt)
+		p.markets[mkt] = make(map[string]string)
+		for k, v := range params {
+			p.markets[mkt][k] = v
+		}
+	}
+	return p
+}
+
+func (p *FilterParams) Get(market, key string) (string, bool) {
+	if nil == p {
+		return "", false
+	}
+	if params, exists := p.markets[strings.ToUpper(market)]; exists {
+		if value, exists := params[key]; exists {
+			return value, true
+		}
+	}
+	value, exists := p.params[key]
+	return value, exists
+}
+
+func (p *FilterParams) String(market, key, defaultValue string) string {
+	if value, exists := p.Get(market, key); exists {
+		return value
+	}
+	return defaultValue
+}
+
+func (p *FilterParams) Int64(market, key string, defaultValue int64) int64 {
+	value, exists := p.Get(market, key)
+	if !exists {
+		return defaultValue
+	}
+	res, err := strconv.ParseInt(strings.TrimSpace(value), 0, 64)
+	if nil != err {
+		log.Errorf("gateway, filter param:%s=%s isn't an integer, use %d instead", key, value, defaultValue)
+		return defaultValue
+	}
+	return res
+}
+
+func (p *FilterParams) Float64(market, key string, defaultValue float64) float64 {
+	value, exists := p.Get(market, key)
+	if !exists {
+		return defaultValue
+	}
+	res, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if nil != err {
+		log.Errorf("gateway, filter param:%s=%s isn't a number, use %f instead", key, value, defaultValue)
+		return defaultValue
+	}
+	return res
+}
+
+// orderMarket returns the market of the order, it's empty if the tokens don't make up a supported market
+func orderMarket(o *types.Order) string {
+	if "" != o.Market {
+		return o.Market
+	}
+	mkt, err := util.WrapMarketByAddress(o.TokenS.Hex(), o.TokenB.Hex())
+	if nil != err {
+		return ""
+	}
+	return mkt
+}
+
+func init() {
+	RegisterFilter(FILTER_POW, func(ctx *FilterContext, params *FilterParams) (Filter, error) {
+		difficulty := params.String("", "difficulty", ctx.Options.PowFilter.Difficulty)
+		return &PowFilter{Difficulty: types.HexToBigint(difficulty)}, nil
+	})
+	RegisterFilter(FILTER_BASE, func(ctx *FilterContext, params *FilterParams) (Filter, error) {
+		options := ctx.Options.BaseFilter
+		baseFilter := &BaseFilter{
+			MinLrcFee:             big.NewInt(options.MinLrcFee),
+			MinLrcHold:            options.MinLrcHold,
+			MaxPrice:              big.NewInt(options.MaxPrice),
+			MinSplitPercentage:    options.MinSplitPercentage,
+			MaxSplitPercentage:    options.MaxSplitPercentage,
+			MinTokeSAmount:        make(map[string]*big.Int),
+			MinTokenSUsdAmount:    options.MinTokenSUsdAmount,
+			MaxValidSinceInterval: options.MaxValidSinceInterval,
+			Params:                params,
+		}
+		for k, v := range options.MinTokeSAmount {
+			minAmount := big.NewInt(0)
+			amount, succ := minAmount.SetString(v, 10)
+			if succ {
+				baseFilter.MinTokeSAmount[k] = amount
+			}
+		}
+		return baseFilter, nil
+	})
+	RegisterFilter(FILTER_SIGN, func(ctx *FilterContext, params *FilterParams) (Filter, error) {
+		return &SignFilter{}, nil
+	})
+	RegisterFilter(FILTER_TOKEN, func(ctx *FilterContext, params *FilterParams) (Filter, error) {
+		return &TokenFilter{}, nil
+	})
+	RegisterFilter(FILTER_CUTOFF, func(ctx *FilterContext, params *FilterParams) (Filter, error) {
+		return &CutoffFilter{om: ctx.OrderManager}, nil
+	})
+	RegisterFilter(FILTER_BALANCE, func(ctx *FilterContext, params *FilterParams) (Filter, error) {
+		f := &BalanceFilter{MinRatio: params.Float64("", "min_ratio", 1.0), Params: params}
+		f.balanceAndAllowance = ctx.AccountManager.GetBalanceAndAllowance
+		return f, nil
+	})
+	RegisterFilter(FILTER_OPEN_ORDER_LIMIT, func(ctx *FilterContext, params *FilterParams) (Filter, error) {
+		maxOpenOrders := params.Int64("", "max_open_orders", 0)
+		if maxOpenOrders <= 0 {
+			return nil, fmt.Errorf("filter:%s needs a positive max_open_orders", FILTER_OPEN_ORDER_LIMIT)
+		}
+		return &OpenOrderLimitFilter{om: ctx.OrderManager, MaxOpenOrders: maxOpenOrders, Params: params}, nil
+	})
+	RegisterFilter(FILTER_TOKEN_DENY, func(ctx *FilterContext, params *FilterParams) (Filter, error) {
+		f := &DenyTokenFilter{DeniedTokens: make(map[common.Address]bool)}
+		for _, token := range strings.Split(params.String("", "tokens", ""), ",") {
+			token = strings.TrimSpace(token)
+			if "" == token {
+				continue
+			}
+			if common.IsHexAddress(token) {
+				f.DeniedTokens[common.HexToAddress(token)] = true
+			} else if addr := util.AliasToAddress(strings.ToUpper(token)); addr != types.NilAddress {
+				f.DeniedTokens[addr] = true
+			} else {
+				return nil, fmt.Errorf("filter:%s, token:%s isn't supported", FILTER_TOKEN_DENY, token)
+			}
+		}
+		return f, nil
+	})
+}
+
+// BalanceFilter rejects the order if the owner's balance or allowance of tokenS is less than AmountS * MinRatio
+type BalanceFilter struct {
+	MinRatio float64
+	Params   *FilterParams
+
+	balanceAndAllowance func(owner, token, spender common.Address) (*big.Int, *big.Int, error)
+}
+
+func (f *BalanceFilter) Name() string {
+	return "Balance"
+}
+
+func (f *BalanceFilter) Filter(o *types.Order) (bool, error) {
+	minRatio := f.Params.Float64(orderMarket(o), "min_ratio", f.MinRatio)
+	threshold := fmt.Sprintf("MinRatio:%f", minRatio)
+
+	balance, allowance, err := f.balanceAndAllowance(o.Owner, o.TokenS, o.DelegateAddress)
+	if nil != err {
+		return false, newFilterError(BALANCE_60503, threshold, "gateway,balance filter,get balance and allowance of owner:%s err:%s", o.Owner.Hex(), err.Error())
+	}
+
+	required, _ := new(big.Float).Mul(new(big.Float).SetInt(o.AmountS), big.NewFloat(minRatio)).Int(nil)
+	if nil == balance || balance.Cmp(required) < 0 {
+		return false, newFilterError(BALANCE_60501, threshold, "gateway,balance filter,balance of owner:%s is less than %s", o.Owner.Hex(), required.String())
+	}
+	if nil == allowance || allowance.Cmp(required) < 0 {
+		return false, newFilterError(BALANCE_60502, threshold, "gateway,balance filter,allowance of owner:%s is less than %s", o.Owner.Hex(), required.String())
+	}
+	return true, nil
+}
+
+// OpenOrderLimitFilter rejects the order if the owner has MaxOpenOrders orders that can still be filled
+type OpenOrderLimitFilter struct {
+	om            ordermanager.OrderManager
+	MaxOpenOrders int64
+	Params        *FilterParams
+}
+
+func (f *OpenOrderLimitFilter) Name() string {
+	return "OpenOrderLimit"
+}
+
+func (f *OpenOrderLimitFilter) Filter(o *types.Order) (bool, error) {
+	maxOpenOrders := f.Params.Int64(orderMarket(o), "max_open_orders", f.MaxOpenOrders)
+	threshold := fmt.Sprintf("MaxOpenOrders:%d", maxOpenOrders)
+
+	count, err := f.om.GetOpenOrderCount(o.Owner)
+	if nil != err {
+		return false, newFilterError(OPEN_ORDER_60602, threshold, "gateway,open order limit filter,count orders of owner:%s err:%s", o.Owner.Hex(), err.Error())
+	}
+	if int64(count) >= maxOpenOrders {
+		return false, newFilterError(OPEN_ORDER_60601, threshold, "gateway,open order limit filter,owner:%s has %d open orders", o.Owner.Hex(), count)
+	}
+	return true, nil
+}
+
+// DenyTokenFilter rejects the order if tokenS or tokenB is denied by the token list or by DeniedTokens
+type DenyTokenFilter struct {
+	DeniedTokens map[common.Address]bool
+}
+
+func (f *DenyTokenFilter) Name() string {
+	return "TokenDeny"
+}
+
+func (f *DenyTokenFilter) Filter(o *types.Order) (bool, error) {
+	if f.isDenied(o.TokenS) {
+		return false, newFilterError(TOKEN_DENY_60701, "", "gateway,token deny filter,tokenS:%s is denied", o.TokenS.Hex())
+	}
+	if f.isDenied(o.TokenB) {
+		return false, newFilterError(TOKEN_DENY_60702, "", "gateway,token deny filter,tokenB:%s is denied", o.TokenB.Hex())
+	}
+	return true, nil
+}
+
+func (f *DenyTokenFilter) isDenied(token common.Address) bool {
+	if f.DeniedTokens[token] {
+		return true
+	}
+	for _, v := range util.AllTokens {
+		if v.Protocol == token && v.Deny {
+			return true
+		}
+	}
+	return false
+}
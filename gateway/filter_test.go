@@ -0,0 +1,303 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/ordermanager"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	filterTestLrc    = common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")
+	filterTestWeth   = common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	filterTestDenied = common.HexToAddress("0x0000000000000000000000000000000000000bad")
+)
+
+type openOrderCountManager struct {
+	ordermanager.OrderManager
+	count int
+	err   error
+}
+
+func (om *openOrderCountManager) GetOpenOrderCount(owner common.Address) (int, error) {
+	return om.count, om.err
+}
+
+func filterNames(filters []Filter) string {
+	names := []string{}
+	for _, f := range filters {
+		names = append(names, f.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func filterCode(err error) string {
+	if filterErr, ok := err.(*FilterError); ok {
+		return filterErr.Code
+	}
+	return ""
+}
+
+func filterThreshold(err error) string {
+	if filterErr, ok := err.(*FilterError); ok {
+		return filterErr.Threshold
+	}
+	return ""
+}
+
+func TestNewFilterChain(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain []config.GatewayFilterOptions
+		names string
+		err   bool
+	}{
+		{name: "default chain", chain: nil, names: "Pow,Base,Sign,Token,Cutoff"},
+		{
+			name: "configured order",
+			chain: []config.GatewayFilterOptions{
+				{Name: FILTER_TOKEN_DENY},
+				{Name: FILTER_CUTOFF},
+				{Name: FILTER_BALANCE},
+				{Name: FILTER_OPEN_ORDER_LIMIT, Params: map[string]string{"max_open_orders": "10"}},
+				{Name: FILTER_SIGN},
+			},
+			names: "TokenDeny,Cutoff,Balance,OpenOrderLimit,Sign",
+		},
+		{
+			name:  "disabled",
+			chain: []config.GatewayFilterOptions{{Name: FILTER_SIGN}, {Name: FILTER_CUTOFF, Disable: true}, {Name: FILTER_TOKEN}},
+			names: "Sign,Token",
+		},
+		{
+			name:  "all disabled",
+			chain: []config.GatewayFilterOptions{{Name: FILTER_SIGN, Disable: true}},
+			names: "",
+		},
+		{name: "unknown filter", chain: []config.GatewayFilterOptions{{Name: FILTER_SIGN}, {Name: "unknown"}}, err: true},
+		{name: "disabled unknown filter", chain: []config.GatewayFilterOptions{{Name: "unknown", Disable: true}}, names: ""},
+		{name: "open order limit without max", chain: []config.GatewayFilterOptions{{Name: FILTER_OPEN_ORDER_LIMIT}}, err: true},
+		{name: "open order limit with bad max", chain: []config.GatewayFilterOptions{{Name: FILTER_OPEN_ORDER_LIMIT, Params: map[string]string{"max_open_orders": "ten"}}}, err: true},
+		{name: "deny unsupported token", chain: []config.GatewayFilterOptions{{Name: FILTER_TOKEN_DENY, Params: map[string]string{"tokens": "NOTATOKEN"}}}, err: true},
+	}
+
+	for _, test := range tests {
+		options := &config.GatewayFiltersOptions{Chain: test.chain}
+		filters, err := NewFilterChain(&FilterContext{Options: options})
+		if test.err != (nil != err) {
+			t.Errorf("%s: unexpected err:%v", test.name, err)
+			continue
+		}
+		if !test.err && filterNames(filters) != test.names {
+			t.Errorf("%s: filters should be %s, got:%s", test.name, test.names, filterNames(filters))
+		}
+	}
+
+	if _, err := NewFilterChain(&FilterContext{Options: &config.GatewayFiltersOptions{Chain: []config.GatewayFilterOptions{{Name: "unknown"}}}}); nil == err || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("the unknown filter should be reported, err:%v", err)
+	}
+}
+
+func TestFilterParams(t *testing.T) {
+	params := NewFilterParams(config.GatewayFilterOptions{
+		Params: map[string]string{"max": "10", "ratio": "0.5", "name": "default", "bad": "ten"},
+		Markets: map[string]map[string]string{
+			"lrc-weth": {"max": "20", "ratio": "0.8"},
+			"RDN-WETH": {"max": "abc", "ratio": "x", "name": "rdn"},
+		},
+	})
+
+	tests := []struct {
+		name   string
+		params *FilterParams
+		market string
+		i      int64
+		f      float64
+		s      string
+	}{
+		{name: "default", params: params, market: "", i: 10, f: 0.5, s: "default"},
+		{name: "other market", params: params, market: "BAR-WETH", i: 10, f: 0.5, s: "default"},
+		{name: "market override", params: params, market: "LRC-WETH", i: 20, f: 0.8, s: "default"},
+		{name: "market in lower case", params: params, market: "lrc-weth", i: 20, f: 0.8, s: "default"},
+		{name: "bad value of market", params: params, market: "RDN-WETH", i: 1, f: 1, s: "rdn"},
+		{name: "nil params", params: nil, market: "LRC-WETH", i: 1, f: 1, s: "none"},
+	}
+	for _, test := range tests {
+		if i := test.params.Int64(test.market, "max", 1); i != test.i {
+			t.Errorf("%s: int should be %d, got:%d", test.name, test.i, i)
+		}
+		if f := test.params.Float64(test.market, "ratio", 1); f != test.f {
+			t.Errorf("%s: float should be %f, got:%f", test.name, test.f, f)
+		}
+		if s := test.params.String(test.market, "name", "none"); s != test.s {
+			t.Errorf("%s: string should be %s, got:%s", test.name, test.s, s)
+		}
+	}
+
+	if i := params.Int64("", "bad", 3); 3 != i {
+		t.Errorf("the bad value should fall back to the default, got:%d", i)
+	}
+	if i := params.Int64("", "missing", 3); 3 != i {
+		t.Errorf("the missing value should fall back to the default, got:%d", i)
+	}
+}
+
+func TestBalanceFilter(t *testing.T) {
+	options := config.GatewayFilterOptions{
+		Name:    FILTER_BALANCE,
+		Params:  map[string]string{"min_ratio": "0.5"},
+		Markets: map[string]map[string]string{"LRC-WETH": {"min_ratio": "2"}, "RDN-WETH": {"min_ratio": "bad"}},
+	}
+	tests := []struct {
+		name      string
+		market    string
+		balance   int64 //-1 is nil
+		allowance int64
+		err       error
+		code      string
+		threshold string
+	}{
+		{name: "enough", balance: 50, allowance: 50, threshold: "MinRatio:0.500000"},
+		{name: "balance less than required", balance: 49, allowance: 50, code: BALANCE_60501, threshold: "MinRatio:0.500000"},
+		{name: "nil balance", balance: -1, allowance: 50, code: BALANCE_60501, threshold: "MinRatio:0.500000"},
+		{name: "allowance less than required", balance: 50, allowance: 49, code: BALANCE_60502, threshold: "MinRatio:0.500000"},
+		{name: "nil allowance", balance: 50, allowance: -1, code: BALANCE_60502, threshold: "MinRatio:0.500000"},
+		{name: "failed to get balance", err: errors.New("timeout"), code: BALANCE_60503, threshold: "MinRatio:0.500000"},
+		{name: "market override", market: "LRC-WETH", balance: 100, allowance: 200, code: BALANCE_60501, threshold: "MinRatio:2.000000"},
+		{name: "market override passed", market: "LRC-WETH", balance: 200, allowance: 200, threshold: "MinRatio:2.000000"},
+		{name: "bad value of market", market: "RDN-WETH", balance: 50, allowance: 50, threshold: "MinRatio:0.500000"},
+	}
+
+	for _, test := range tests {
+		filter, err := NewFilter(FILTER_BALANCE, &FilterContext{Options: &config.GatewayFiltersOptions{}}, NewFilterParams(options))
+		if nil != err {
+			t.Fatal(err.Error())
+		}
+		f := filter.(*BalanceFilter)
+		f.balanceAndAllowance = func(owner, token, spender common.Address) (*big.Int, *big.Int, error) {
+			if token != filterTestLrc || spender != filterTestDenied {
+				t.Errorf("%s: the balance and allowance of tokenS to delegate should be checked", test.name)
+			}
+			if nil != test.err {
+				return nil, nil, test.err
+			}
+			var balance, allowance *big.Int
+			if test.balance >= 0 {
+				balance = big.NewInt(test.balance)
+			}
+			if test.allowance >= 0 {
+				allowance = big.NewInt(test.allowance)
+			}
+			return balance, allowance, nil
+		}
+
+		order := &types.Order{TokenS: filterTestLrc, TokenB: filterTestWeth, DelegateAddress: filterTestDenied, AmountS: big.NewInt(100), Market: test.market}
+		passed, err := f.Filter(order)
+		if passed != ("" == test.code) || filterCode(err) != test.code {
+			t.Errorf("%s: code should be %q, got passed:%t err:%v", test.name, test.code, passed, err)
+		}
+		if nil != err && filterThreshold(err) != test.threshold {
+			t.Errorf("%s: threshold should be %s, got:%s", test.name, test.threshold, filterThreshold(err))
+		}
+	}
+}
+
+func TestOpenOrderLimitFilter(t *testing.T) {
+	options := config.GatewayFilterOptions{
+		Name:    FILTER_OPEN_ORDER_LIMIT,
+		Params:  map[string]string{"max_open_orders": "3"},
+		Markets: map[string]map[string]string{"LRC-WETH": {"max_open_orders": "5"}, "RDN-WETH": {"max_open_orders": "many"}},
+	}
+	tests := []struct {
+		name      string
+		market    string
+		count     int
+		err       error
+		code      string
+		threshold string
+	}{
+		{name: "under limit", count: 2},
+		{name: "at limit", count: 3, code: OPEN_ORDER_60601, threshold: "MaxOpenOrders:3"},
+		{name: "over limit", count: 4, code: OPEN_ORDER_60601, threshold: "MaxOpenOrders:3"},
+		{name: "failed to count", err: errors.New("db error"), code: OPEN_ORDER_60602, threshold: "MaxOpenOrders:3"},
+		{name: "market override", market: "LRC-WETH", count: 4},
+		{name: "at limit of market", market: "LRC-WETH", count: 5, code: OPEN_ORDER_60601, threshold: "MaxOpenOrders:5"},
+		{name: "bad value of market", market: "RDN-WETH", count: 3, code: OPEN_ORDER_60601, threshold: "MaxOpenOrders:3"},
+	}
+
+	for _, test := range tests {
+		ctx := &FilterContext{Options: &config.GatewayFiltersOptions{}, OrderManager: &openOrderCountManager{count: test.count, err: test.err}}
+		f, err := NewFilter(FILTER_OPEN_ORDER_LIMIT, ctx, NewFilterParams(options))
+		if nil != err {
+			t.Fatal(err.Error())
+		}
+		passed, err := f.Filter(&types.Order{Market: test.market})
+		if passed != ("" == test.code) || filterCode(err) != test.code {
+			t.Errorf("%s: code should be %q, got passed:%t err:%v", test.name, test.code, passed, err)
+		}
+		if nil != err && filterThreshold(err) != test.threshold {
+			t.Errorf("%s: threshold should be %s, got:%s", test.name, test.threshold, filterThreshold(err))
+		}
+	}
+}
+
+func TestDenyTokenFilter(t *testing.T) {
+	allTokens := util.AllTokens
+	defer func() { util.AllTokens = allTokens }()
+	util.AllTokens = map[string]types.Token{
+		"LRC":  {Protocol: filterTestLrc, Symbol: "LRC"},
+		"WETH": {Protocol: filterTestWeth, Symbol: "WETH"},
+		"SCAM": {Protocol: common.HexToAddress("0x5ca3"), Symbol: "SCAM", Deny: true},
+	}
+
+	options := config.GatewayFilterOptions{Name: FILTER_TOKEN_DENY, Params: map[string]string{"tokens": " lrc , " + filterTestDenied.Hex() + ","}}
+	f, err := NewFilter(FILTER_TOKEN_DENY, &FilterContext{Options: &config.GatewayFiltersOptions{}}, NewFilterParams(options))
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+
+	other := common.HexToAddress("0x0123")
+	tests := []struct {
+		name   string
+		tokenS common.Address
+		tokenB common.Address
+		code   string
+	}{
+		{name: "not denied", tokenS: filterTestWeth, tokenB: other},
+		{name: "tokenS denied by alias", tokenS: filterTestLrc, tokenB: filterTestWeth, code: TOKEN_DENY_60701},
+		{name: "tokenB denied by address", tokenS: filterTestWeth, tokenB: filterTestDenied, code: TOKEN_DENY_60702},
+		{name: "tokenS denied by token list", tokenS: common.HexToAddress("0x5ca3"), tokenB: filterTestWeth, code: TOKEN_DENY_60701},
+		{name: "tokenB denied by token list", tokenS: other, tokenB: common.HexToAddress("0x5ca3"), code: TOKEN_DENY_60702},
+		{name: "both denied", tokenS: filterTestLrc, tokenB: filterTestDenied, code: TOKEN_DENY_60701},
+	}
+	for _, test := range tests {
+		passed, err := f.Filter(&types.Order{TokenS: test.tokenS, TokenB: test.tokenB})
+		if passed != ("" == test.code) || filterCode(err) != test.code {
+			t.Errorf("%s: code should be %q, got passed:%t err:%v", test.name, test.code, passed, err)
+		}
+	}
+}
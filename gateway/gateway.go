@@ -28,11 +28,13 @@ import (
 	"github.com/Loopring/relay/market"
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/metrics"
 	"github.com/Loopring/relay/ordermanager"
 	"github.com/Loopring/relay/types"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
 	"qiniupkg.com/x/errors.v7"
+	"strings"
 	"time"
 )
 
@@ -44,14 +46,15 @@ type Gateway struct {
 	maxBroadcastTime int
 	ipfsPubService   IPFSPubService
 	marketCap        marketcap.MarketCapProvider
+	maxBatchOrders   int
+	depthLength      int
+	maxDepthLength   int
+	depthPrecision   int
+	depthPrecisions  map[string]int
 }
 
 var gateway Gateway
 
-type Filter interface {
-	filter(o *types.Order) (bool, error)
-}
-
 func Initialize(filterOptions *config.GatewayFiltersOptions, options *config.GateWayOptions, ipfsOptions *config.IpfsOptions, om ordermanager.OrderManager, marketCap marketcap.MarketCapProvider, am market.AccountManager) {
 	// add gateway watcher
 	gatewayWatcher := &eventemitter.Watcher{Concurrent: false, Handle: HandleOrder}
@@ -61,43 +64,23 @@ func Initialize(filterOptions *config.GatewayFiltersOptions, options *config.Gat
 	//gateway.ipfsPubService = NewIPFSPubService(ipfsOptions)
 
 	gateway.marketCap = marketCap
-
-	// new pow filter
-	powFilter := &PowFilter{Difficulty: types.HexToBigint(filterOptions.PowFilter.Difficulty)}
-
-	// new base filter
-	baseFilter := &BaseFilter{
-		MinLrcFee:             big.NewInt(filterOptions.BaseFilter.MinLrcFee),
-		MinLrcHold:            filterOptions.BaseFilter.MinLrcHold,
-		MaxPrice:              big.NewInt(filterOptions.BaseFilter.MaxPrice),
-		MinSplitPercentage:    filterOptions.BaseFilter.MinSplitPercentage,
-		MaxSplitPercentage:    filterOptions.BaseFilter.MaxSplitPercentage,
-		MinTokeSAmount:        make(map[string]*big.Int),
-		MinTokenSUsdAmount:    filterOptions.BaseFilter.MinTokenSUsdAmount,
-		MaxValidSinceInterval: filterOptions.BaseFilter.MaxValidSinceInterval,
-	}
-	for k, v := range filterOptions.BaseFilter.MinTokeSAmount {
-		minAmount := big.NewInt(0)
-		amount, succ := minAmount.SetString(v, 10)
-		if succ {
-			baseFilter.MinTokeSAmount[k] = amount
-		}
+	gateway.maxBatchOrders = options.MaxBatchOrders
+	if gateway.maxBatchOrders <= 0 {
+		gateway.maxBatchOrders = DEFAULT_MAX_BATCH_ORDERS
+	}
+	gateway.depthLength = options.DepthLength
+	gateway.maxDepthLength = options.MaxDepthLength
+	gateway.depthPrecision = options.DepthPrecision
+	gateway.depthPrecisions = make(map[string]int)
+	for market, precision := range options.DepthPrecisions {
+		gateway.depthPrecisions[strings.ToUpper(market)] = precision
 	}
 
-	// new token filter
-	tokenFilter := &TokenFilter{}
-
-	// new sign filter
-	signFilter := &SignFilter{}
-
-	// new cutoff filter
-	cutoffFilter := &CutoffFilter{om: om}
-
-	gateway.filters = append(gateway.filters, powFilter)
-	gateway.filters = append(gateway.filters, baseFilter)
-	gateway.filters = append(gateway.filters, signFilter)
-	gateway.filters = append(gateway.filters, tokenFilter)
-	gateway.filters = append(gateway.filters, cutoffFilter)
+	filters, err := NewFilterChain(&FilterContext{Options: filterOptions, OrderManager: om, AccountManager: &gateway.am, MarketCap: marketCap})
+	if nil != err {
+		log.Fatalf("gateway, build filter chain err:%s", err.Error())
+	}
+	gateway.filters = filters
 }
 
 func HandleInputOrder(input eventemitter.EventData) (orderHash string, err error) {
@@ -118,12 +101,14 @@ func HandleInputOrder(input eventemitter.EventData) (orderHash string, err error
 		}
 
 		for _, v := range gateway.filters {
-			valid, err := v.filter(order)
+			valid, err := v.Filter(order)
 			if !valid {
+				metrics.GatewayFilterRejections.WithLabelValues(v.Name()).Inc()
 				log.Errorf(err.Error())
 				return orderHash, err
 			}
 		}
+		metrics.GatewayOrders.WithLabelValues(orderMarket(order)).Inc()
 		state = &types.OrderState{}
 		state.RawOrder = *order
 		//broadcastTime = 0
@@ -196,97 +181,113 @@ type BaseFilter struct {
 	MinTokeSAmount        map[string]*big.Int
 	MinTokenSUsdAmount    float64
 	MaxValidSinceInterval int64
+	Params                *FilterParams
+}
+
+func (f *BaseFilter) Name() string {
+	return "Base"
 }
 
-func (f *BaseFilter) filter(o *types.Order) (bool, error) {
+func (f *BaseFilter) Filter(o *types.Order) (bool, error) {
 	const (
 		addrLength = 20
 		hashLength = 32
 	)
 
+	//the params of the market override the base_filter options
+	mkt := orderMarket(o)
+	minLrcHold := f.Params.Int64(mkt, "min_lrc_hold", f.MinLrcHold)
+	minSplitPercentage := f.Params.Float64(mkt, "min_split_percentage", f.MinSplitPercentage)
+	maxSplitPercentage := f.Params.Float64(mkt, "max_split_percentage", f.MaxSplitPercentage)
+	minTokenSUsdAmount := f.Params.Float64(mkt, "min_tokenS_usd_amount", f.MinTokenSUsdAmount)
+	maxValidSinceInterval := f.Params.Int64(mkt, "max_valid_since_interval", f.MaxValidSinceInterval)
+
 	if o.TokenB != util.AliasToAddress("LRC") {
 		balances, err := gateway.am.GetBalanceWithSymbolResult(o.Owner)
 
 		if err != nil {
-			return false, fmt.Errorf("gateway,base filter,owner holds lrc less than %d ", f.MinLrcHold)
+			return false, newFilterError(BASE_60101, fmt.Sprintf("MinLrcHold:%d", minLrcHold), "gateway,base filter,owner holds lrc less than %d ", minLrcHold)
 		}
 
 		if b, ok := balances["LRC"]; ok {
-			lrcHold := big.NewInt(f.MinLrcHold)
+			lrcHold := big.NewInt(minLrcHold)
 			lrcHold = lrcHold.Mul(lrcHold, util.AllTokens["LRC"].Decimals)
 			if b.Cmp(lrcHold) < 1 {
-				return false, fmt.Errorf("gateway,base filter,owner holds lrc less than %d ", f.MinLrcHold)
+				return false, newFilterError(BASE_60101, fmt.Sprintf("MinLrcHold:%d", minLrcHold), "gateway,base filter,owner holds lrc less than %d ", minLrcHold)
 			}
 
 		} else {
-			return false, fmt.Errorf("gateway,base filter,owner holds lrc less than %d ", f.MinLrcHold)
+			return false, newFilterError(BASE_60101, fmt.Sprintf("MinLrcHold:%d", minLrcHold), "gateway,base filter,owner holds lrc less than %d ", minLrcHold)
 		}
 
 	}
 
 	if len(o.Hash) != hashLength {
-		return false, fmt.Errorf("gateway,base filter,order %s length error", o.Hash.Hex())
+		return false, newFilterError(BASE_60102, "", "gateway,base filter,order %s length error", o.Hash.Hex())
 	}
 	if len(o.TokenB) != addrLength {
-		return false, fmt.Errorf("gateway,base filter,order %s tokenB %s address length error", o.Hash.Hex(), o.TokenB.Hex())
+		return false, newFilterError(BASE_60102, "", "gateway,base filter,order %s tokenB %s address length error", o.Hash.Hex(), o.TokenB.Hex())
 	}
 	if len(o.TokenS) != addrLength {
-		return false, fmt.Errorf("gateway,base filter,order %s tokenS %s address length error", o.Hash.Hex(), o.TokenS.Hex())
+		return false, newFilterError(BASE_60102, "", "gateway,base filter,order %s tokenS %s address length error", o.Hash.Hex(), o.TokenS.Hex())
 	}
 	if o.TokenB == o.TokenS {
-		return false, fmt.Errorf("gateway,base filter,order %s tokenB == tokenS", o.Hash.Hex())
+		return false, newFilterError(BASE_60103, "", "gateway,base filter,order %s tokenB == tokenS", o.Hash.Hex())
 	}
 	if len(o.Owner) != addrLength {
-		return false, fmt.Errorf("gateway,base filter,order %s owner %s address length error", o.Hash.Hex(), o.Owner.Hex())
+		return false, newFilterError(BASE_60102, "", "gateway,base filter,order %s owner %s address length error", o.Hash.Hex(), o.Owner.Hex())
 	}
 	if len(o.Protocol) != addrLength {
-		return false, fmt.Errorf("gateway,base filter,order %s protocol %s address length error", o.Hash.Hex(), o.Owner.Hex())
+		return false, newFilterError(BASE_60102, "", "gateway,base filter,order %s protocol %s address length error", o.Hash.Hex(), o.Owner.Hex())
+	}
+	if nil == o.Price {
+		return false, newFilterError(BASE_60104, "MaxPrice:"+f.MaxPrice.String(), "gateway,base filter,order %s price hasn't been generated", o.Hash.Hex())
 	}
 	if o.Price.Cmp(new(big.Rat).SetFrac(f.MaxPrice, big.NewInt(1))) > 0 || o.Price.Cmp(new(big.Rat).SetFrac(big.NewInt(1), f.MaxPrice)) < 0 {
-		return false, fmt.Errorf("dao order convert down,price out of range")
+		return false, newFilterError(BASE_60104, "MaxPrice:"+f.MaxPrice.String(), "dao order convert down,price out of range")
 	}
 
 	now := time.Now().Unix()
 
 	// validSince check
-	if o.ValidSince.Int64()-f.MaxValidSinceInterval > now {
-		return false, fmt.Errorf("valid since is too small, order must be valid before %d second timestamp", now-f.MaxValidSinceInterval)
+	if o.ValidSince.Int64()-maxValidSinceInterval > now {
+		return false, newFilterError(BASE_60105, fmt.Sprintf("MaxValidSinceInterval:%d", maxValidSinceInterval), "valid since is too small, order must be valid before %d second timestamp", now-maxValidSinceInterval)
 	}
 
 	// validUntil check
 	if o.ValidUntil.Int64() < now {
-		return false, fmt.Errorf("order expired, please check validUntil")
+		return false, newFilterError(BASE_60106, fmt.Sprintf("ValidUntil:%d", now), "order expired, please check validUntil")
 	}
 
 	// MarginSplitPercentage range check
-	if float64(o.MarginSplitPercentage)/100.0 < f.MinSplitPercentage || float64(o.MarginSplitPercentage)/100.0 > f.MaxSplitPercentage {
-		return false, fmt.Errorf("margin split percentage out of range")
+	if float64(o.MarginSplitPercentage)/100.0 < minSplitPercentage || float64(o.MarginSplitPercentage)/100.0 > maxSplitPercentage {
+		return false, newFilterError(BASE_60107, fmt.Sprintf("MinSplitPercentage:%f, MaxSplitPercentage:%f", minSplitPercentage, maxSplitPercentage), "margin split percentage out of range")
 	}
 
 	// tokenS min amount check
 	tokenS, err := util.AddressToToken(o.TokenS)
 	if err != nil {
-		return false, fmt.Errorf("tokenS is not support now")
+		return false, newFilterError(BASE_60108, "", "tokenS is not support now")
 	}
 
 	if minAmount, ok := f.MinTokeSAmount[tokenS.Symbol]; ok && o.AmountS.Cmp(minAmount) < 0 {
-		return false, fmt.Errorf("tokenS amount is too small")
+		return false, newFilterError(BASE_60109, "MinTokeSAmount:"+minAmount.String(), "tokenS amount is too small")
 	}
 
 	// USD min amount check
 	tokenSPrice, err := gateway.marketCap.GetMarketCapByCurrency(o.TokenS, "USD")
 	if err != nil || tokenSPrice == nil {
-		return false, fmt.Errorf("get price error. please retry later")
+		return false, newFilterError(BASE_60110, "", "get price error. please retry later")
 	}
 	tokenSFloatPrice, _ := tokenSPrice.Float64()
 	if tokenSFloatPrice <= 0 {
-		return false, fmt.Errorf("get zero token s price. symbol : " + tokenS.Symbol)
+		return false, newFilterError(BASE_60110, "", "get zero token s price. symbol : %s", tokenS.Symbol)
 	}
 
 	amountDivDecimal, _ := new(big.Rat).SetFrac(o.AmountS, tokenS.Decimals).Float64()
 	usdAmount := amountDivDecimal * tokenSFloatPrice
-	if usdAmount < f.MinTokenSUsdAmount {
-		return false, fmt.Errorf("tokenS usd amount is too small, price:%f, amount:%f, value:%f, usdMinValue:%f", tokenSFloatPrice, amountDivDecimal, usdAmount, f.MinTokenSUsdAmount)
+	if usdAmount < minTokenSUsdAmount {
+		return false, newFilterError(BASE_60111, fmt.Sprintf("MinTokenSUsdAmount:%f", minTokenSUsdAmount), "tokenS usd amount is too small, price:%f, amount:%f, value:%f, usdMinValue:%f", tokenSFloatPrice, amountDivDecimal, usdAmount, minTokenSUsdAmount)
 	}
 
 	return true, nil
@@ -295,13 +296,17 @@ func (f *BaseFilter) filter(o *types.Order) (bool, error) {
 type SignFilter struct {
 }
 
-func (f *SignFilter) filter(o *types.Order) (bool, error) {
+func (f *SignFilter) Name() string {
+	return "Sign"
+}
+
+func (f *SignFilter) Filter(o *types.Order) (bool, error) {
 	o.Hash = o.GenerateHash()
 
 	if addr, err := o.SignerAddress(); nil != err {
-		return false, err
+		return false, newFilterError(SIGN_60201, "", "%s", err.Error())
 	} else if addr != o.Owner {
-		return false, fmt.Errorf("gateway,sign filter,o.Owner %s and signeraddress %s are not match", o.Owner.Hex(), addr.Hex())
+		return false, newFilterError(SIGN_60202, "", "gateway,sign filter,o.Owner %s and signeraddress %s are not match", o.Owner.Hex(), addr.Hex())
 	}
 
 	return true, nil
@@ -312,7 +317,11 @@ type TokenFilter struct {
 	DeniedTokens map[common.Address]bool
 }
 
-func (f *TokenFilter) filter(o *types.Order) (bool, error) {
+func (f *TokenFilter) Name() string {
+	return "Token"
+}
+
+func (f *TokenFilter) Filter(o *types.Order) (bool, error) {
 	supportTokenS := false
 	supportTokenB := false
 	for _, v := range util.AllTokens {
@@ -325,10 +334,10 @@ func (f *TokenFilter) filter(o *types.Order) (bool, error) {
 	}
 
 	if !supportTokenS {
-		return false, fmt.Errorf("gateway,token filter,tokenS:%s do not supported", o.TokenS.Hex())
+		return false, newFilterError(TOKEN_60301, "", "gateway,token filter,tokenS:%s do not supported", o.TokenS.Hex())
 	}
 	if !supportTokenB {
-		return false, fmt.Errorf("gateway,token filter,tokenB:%s do not supported", o.TokenB.Hex())
+		return false, newFilterError(TOKEN_60302, "", "gateway,token filter,tokenB:%s do not supported", o.TokenB.Hex())
 	}
 
 	return true, nil
@@ -338,10 +347,14 @@ type CutoffFilter struct {
 	om ordermanager.OrderManager
 }
 
+func (f *CutoffFilter) Name() string {
+	return "Cutoff"
+}
+
 // 如果订单接收在cutoff(cancel)事件之后，则该订单直接过滤
-func (f *CutoffFilter) filter(o *types.Order) (bool, error) {
+func (f *CutoffFilter) Filter(o *types.Order) (bool, error) {
 	if f.om.IsOrderCutoff(o.Protocol, o.Owner, o.TokenS, o.TokenB, o.ValidSince) {
-		return false, fmt.Errorf("gateway,cutoff filter order:%s should be cutoff", o.Owner.Hex())
+		return false, newFilterError(CUTOFF_60401, "", "gateway,cutoff filter order:%s should be cutoff", o.Owner.Hex())
 	}
 
 	return true, nil
@@ -351,16 +364,20 @@ type PowFilter struct {
 	Difficulty *big.Int
 }
 
-func (f *PowFilter) filter(o *types.Order) (bool, error) {
+func (f *PowFilter) Name() string {
+	return "Pow"
+}
+
+func (f *PowFilter) Filter(o *types.Order) (bool, error) {
 
 	if o.PowNonce <= 0 {
-		return false, fmt.Errorf("invalid pow nonce")
+		return false, newFilterError(POW_60001, "Difficulty:"+types.BigintToHex(f.Difficulty), "invalid pow nonce")
 	}
 
 	pow := GetPow(o.V, o.R, o.S, o.PowNonce)
 
 	if pow.Cmp(f.Difficulty) < 0 {
-		return false, fmt.Errorf("invalid pow")
+		return false, newFilterError(POW_60002, "Difficulty:"+types.BigintToHex(f.Difficulty), "invalid pow")
 	}
 	return true, nil
 }
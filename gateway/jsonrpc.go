@@ -19,42 +19,51 @@
 package gateway
 
 import (
+	"context"
 	"fmt"
+	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/metrics"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/rs/cors"
 	"net"
 	"net/http"
+	"reflect"
+	"strings"
 )
 
+const jsonrpcNamespace = "loopring"
+
 func (*JsonrpcServiceImpl) Ping(val string, val2 int) (res string, err error) {
 	res = "pong for first connect, meaning server is OK"
 	return
 }
 
 type JsonrpcService interface {
-	Start(port string)
-	Stop()
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
 }
 
 type JsonrpcServiceImpl struct {
-	port          string
+	options       config.JsonrpcOptions
 	walletService *WalletServiceImpl
+	guard         *JsonrpcGuard
+	httpServer    *http.Server
 }
 
-func NewJsonrpcService(port string, walletService *WalletServiceImpl) *JsonrpcServiceImpl {
+func NewJsonrpcService(options config.JsonrpcOptions, walletService *WalletServiceImpl) *JsonrpcServiceImpl {
 	l := &JsonrpcServiceImpl{}
-	l.port = port
+	l.options = options
 	l.walletService = walletService
+	l.guard = NewJsonrpcGuard(options, NewRedisRateLimiter())
 	return l
 }
 
-func (j *JsonrpcServiceImpl) Start() {
+func (j *JsonrpcServiceImpl) Start(ctx context.Context) error {
 
 	handler := rpc.NewServer()
-	if err := handler.RegisterName("loopring", j.walletService); err != nil {
-		fmt.Println(err)
-		return
+	if err := handler.RegisterName(jsonrpcNamespace, j.walletService); err != nil {
+		return err
 	}
 
 	var (
@@ -62,19 +71,43 @@ func (j *JsonrpcServiceImpl) Start() {
 		err      error
 	)
 
-	if listener, err = net.Listen("tcp", ":"+j.port); err != nil {
-		return
+	if listener, err = net.Listen("tcp", ":"+j.options.Port); err != nil {
+		return err
 	}
-	//httpServer := rpc.NewHTTPServer([]string{"*"}, handler)
-	httpServer := &http.Server{Handler: newCorsHandler(handler, []string{"*"})}
-	//httpServer.Handler = newCorsHandler(handler, []string{"*"})
-	go httpServer.Serve(listener)
-	log.Info(fmt.Sprintf("HTTP endpoint opened on " + j.port))
+	//the preflight requests are answered by cors before authenticated
+	guarded := newCorsHandler(j.guard.Handler(handler), j.options.AllowedOrigins)
+	j.httpServer = &http.Server{Handler: metrics.JsonrpcHandler(guarded, jsonrpcMethods(j.walletService))}
+	go func() {
+		if err := j.httpServer.Serve(listener); nil != err && http.ErrServerClosed != err {
+			log.Errorf("jsonrpc,serve err:%s", err.Error())
+		}
+	}()
+	log.Info(fmt.Sprintf("HTTP endpoint opened on " + j.options.Port))
+
+	return nil
+}
 
-	return
+// Stop closes the listener and waits for the requests in process until ctx is done
+func (j *JsonrpcServiceImpl) Stop(ctx context.Context) error {
+	if nil == j.httpServer {
+		return nil
+	}
+	return j.httpServer.Shutdown(ctx)
+}
+
+// jsonrpcMethods returns the names of methods registered by rpc, it's the namespace and the method name
+// with the first character lowercased
+func jsonrpcMethods(service interface{}) map[string]bool {
+	methods := make(map[string]bool)
+	serviceType := reflect.TypeOf(service)
+	for i := 0; i < serviceType.NumMethod(); i++ {
+		name := serviceType.Method(i).Name
+		methods[jsonrpcNamespace+"_"+strings.ToLower(name[:1])+name[1:]] = true
+	}
+	return methods
 }
 
-func newCorsHandler(srv *rpc.Server, allowedOrigins []string) http.Handler {
+func newCorsHandler(srv http.Handler, allowedOrigins []string) http.Handler {
 	// disable CORS support if user has not specified a custom CORS configuration
 	if len(allowedOrigins) == 0 {
 		return srv
@@ -0,0 +1,323 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/metrics"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SCOPE_READ  = "read"
+	SCOPE_TRADE = "trade"
+	SCOPE_ADMIN = "admin"
+
+	API_KEY_HEADER       = "X-Api-Key"
+	API_TIMESTAMP_HEADER = "X-Api-Timestamp"
+	API_SIGNATURE_HEADER = "X-Api-Signature"
+
+	DEFAULT_SIGNATURE_WINDOW = 30
+
+	REJECTED_UNAUTHORIZED = "unauthorized"
+	REJECTED_FORBIDDEN    = "forbidden"
+	REJECTED_RATE_LIMITED = "rate_limited"
+
+	maxJsonrpcBodyLength = 1024 * 128
+)
+
+// the methods changing the state of relay need the trade scope, the others only need read
+var tradeMethods = map[string]bool{
+	jsonrpcNamespace + "_submitOrder":                true,
+	jsonrpcNamespace + "_submitOrders":               true,
+	jsonrpcNamespace + "_softCancelOrders":           true,
+	jsonrpcNamespace + "_submitRingForP2P":           true,
+	jsonrpcNamespace + "_notifyTransactionSubmitted": true,
+	jsonrpcNamespace + "_unlockWallet":               true,
+}
+
+var rejectionCodes = map[string]int{
+	REJECTED_UNAUTHORIZED: -32001,
+	REJECTED_FORBIDDEN:    -32003,
+	REJECTED_RATE_LIMITED: -32005,
+}
+
+var (
+	errUnknownApiKey     = errors.New("unknown api key")
+	errMissingSignature  = errors.New("the request must be signed with the secret of api key")
+	errExpiredTimestamp  = errors.New("the timestamp of request is invalid or out of window")
+	errInvalidSignature  = errors.New("invalid signature")
+	errRequestTooLarge   = errors.New("request too large")
+	errScopeNotPermitted = errors.New("the method isn't permitted by the scopes")
+	errRateLimited       = errors.New("rate limit exceeded")
+)
+
+func methodScope(method string) string {
+	if tradeMethods[method] {
+		return SCOPE_TRADE
+	}
+	return SCOPE_READ
+}
+
+type apiKey struct {
+	name   string
+	secret []byte
+	scopes map[string]bool
+	rate   float64
+	burst  int64
+}
+
+// permits returns whether the key has the scope, admin includes all the others
+func (k *apiKey) permits(scope string) bool {
+	return k.scopes[SCOPE_ADMIN] || k.scopes[scope]
+}
+
+type jsonrpcCall struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+}
+
+type jsonrpcRejection struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Error   struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// JsonrpcGuard authenticates the api keys, checks the scopes of methods called and limits the rate
+// of each key, the anonymous requests are limited by ip.
+type JsonrpcGuard struct {
+	options config.JsonrpcOptions
+	keys    map[string]*apiKey
+	limiter RateLimiter
+	now     func() time.Time
+}
+
+func NewJsonrpcGuard(options config.JsonrpcOptions, limiter RateLimiter) *JsonrpcGuard {
+	g := &JsonrpcGuard{}
+	g.options = options
+	if g.options.SignatureWindow <= 0 {
+		g.options.SignatureWindow = DEFAULT_SIGNATURE_WINDOW
+	}
+	g.limiter = limiter
+	g.now = time.Now
+	g.keys = make(map[string]*apiKey)
+	for _, opts := range options.ApiKeys {
+		if "" == opts.Key {
+			log.Errorf("jsonrpc,api key:%s is ignored without key", opts.Name)
+			continue
+		}
+		key := &apiKey{name: opts.Name, rate: opts.Rate, burst: opts.Burst}
+		if "" != opts.Secret {
+			key.secret = []byte(opts.Secret)
+		}
+		key.scopes = make(map[string]bool)
+		for _, scope := range opts.Scopes {
+			key.scopes[strings.ToLower(scope)] = true
+		}
+		g.keys[opts.Key] = key
+	}
+	return g
+}
+
+func (g *JsonrpcGuard) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxJsonrpcBodyLength+1))
+		if nil != err {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxJsonrpcBodyLength {
+			http.Error(w, errRequestTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		calls, batch := decodeJsonrpcCalls(body)
+
+		key, err := g.authenticate(r, body)
+		if nil != err {
+			writeRejection(w, http.StatusUnauthorized, REJECTED_UNAUTHORIZED, err, calls, batch)
+			return
+		}
+		for _, call := range calls {
+			if !g.permits(key, methodScope(call.Method)) {
+				writeRejection(w, http.StatusForbidden, REJECTED_FORBIDDEN, errScopeNotPermitted, calls, batch)
+				return
+			}
+		}
+
+		cost := len(calls)
+		if cost < 1 {
+			cost = 1
+		}
+		if nil != key {
+			if !allowOrFailOpen(g.limiter, "key_"+key.name, key.rate, key.burst, cost) {
+				writeRejection(w, http.StatusTooManyRequests, REJECTED_RATE_LIMITED, errRateLimited, calls, batch)
+				return
+			}
+		} else if !allowOrFailOpen(g.limiter, "ip_"+g.clientIp(r), g.options.IpRate, g.options.IpBurst, cost) {
+			writeRejection(w, http.StatusTooManyRequests, REJECTED_RATE_LIMITED, errRateLimited, calls, batch)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the api key of request, it's nil for the anonymous requests
+func (g *JsonrpcGuard) authenticate(r *http.Request, body []byte) (*apiKey, error) {
+	keyStr := r.Header.Get(API_KEY_HEADER)
+	if "" == keyStr {
+		return nil, nil
+	}
+	key, exists := g.keys[keyStr]
+	if !exists {
+		return nil, errUnknownApiKey
+	}
+	if nil == key.secret {
+		return key, nil
+	}
+
+	timestampStr := r.Header.Get(API_TIMESTAMP_HEADER)
+	signature := r.Header.Get(API_SIGNATURE_HEADER)
+	if "" == timestampStr || "" == signature {
+		return nil, errMissingSignature
+	}
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if nil != err {
+		return nil, errExpiredTimestamp
+	}
+	if diff := g.now().Unix() - timestamp; diff > g.options.SignatureWindow || diff < -g.options.SignatureWindow {
+		return nil, errExpiredTimestamp
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if nil != err || !hmac.Equal(sig, SignJsonrpcRequest(key.secret, timestampStr, body)) {
+		return nil, errInvalidSignature
+	}
+	return key, nil
+}
+
+func (g *JsonrpcGuard) permits(key *apiKey, scope string) bool {
+	if nil != key {
+		return key.permits(scope)
+	}
+	return SCOPE_READ == scope || (SCOPE_TRADE == scope && !g.options.AuthRequired)
+}
+
+// clientIp uses the header set by proxy if it's configured. The addresses on the left can be sent by the client,
+// so the one appended by the outermost trusted proxy is used, it's the TrustedProxies-th from the right.
+func (g *JsonrpcGuard) clientIp(r *http.Request) string {
+	if "" != g.options.RealIpHeader {
+		var addrs []string
+		for _, value := range r.Header[http.CanonicalHeaderKey(g.options.RealIpHeader)] {
+			addrs = append(addrs, strings.Split(value, ",")...)
+		}
+		hops := g.options.TrustedProxies
+		if hops <= 0 {
+			hops = 1
+		}
+		idx := len(addrs) - hops
+		if idx < 0 {
+			idx = 0
+		}
+		if idx < len(addrs) {
+			if addr := strings.TrimSpace(addrs[idx]); "" != addr {
+				return addr
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if nil != err {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SignJsonrpcRequest returns the hmac-sha256 of timestamp and body, it's sent hex encoded in the signature header
+func SignJsonrpcRequest(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// decodeJsonrpcCalls decodes a single call or a batch of them, it returns whether the request is a batch
+func decodeJsonrpcCalls(data []byte) ([]jsonrpcCall, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && '[' == data[0] {
+		var calls []jsonrpcCall
+		if err := json.Unmarshal(data, &calls); nil == err {
+			return calls, true
+		}
+		return []jsonrpcCall{}, true
+	}
+	var call jsonrpcCall
+	if err := json.Unmarshal(data, &call); nil == err {
+		return []jsonrpcCall{call}, false
+	}
+	return []jsonrpcCall{}, false
+}
+
+// writeRejection responds an error for each call, so the clients of json-rpc can handle it the same as the others
+func writeRejection(w http.ResponseWriter, status int, reason string, err error, calls []jsonrpcCall, batch bool) {
+	metrics.JsonrpcRejections.WithLabelValues(reason).Inc()
+
+	rejections := []jsonrpcRejection{}
+	for _, call := range calls {
+		rejection := jsonrpcRejection{Version: "2.0", Id: call.Id}
+		if len(rejection.Id) == 0 {
+			rejection.Id = json.RawMessage("null")
+		}
+		rejection.Error.Code = rejectionCodes[reason]
+		rejection.Error.Message = err.Error()
+		rejections = append(rejections, rejection)
+	}
+	if len(rejections) == 0 {
+		rejection := jsonrpcRejection{Version: "2.0", Id: json.RawMessage("null")}
+		rejection.Error.Code = rejectionCodes[reason]
+		rejection.Error.Message = err.Error()
+		rejections = append(rejections, rejection)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if http.StatusTooManyRequests == status {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.WriteHeader(status)
+	if batch {
+		json.NewEncoder(w).Encode(rejections)
+	} else {
+		json.NewEncoder(w).Encode(rejections[0])
+	}
+}
@@ -0,0 +1,200 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/gateway"
+	"github.com/Loopring/relay/log"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+}
+
+// countingLimiter allows limit tokens for each key
+type countingLimiter struct {
+	limit int
+	used  map[string]int
+	err   error
+}
+
+func (l *countingLimiter) Allow(key string, rate float64, burst int64, cost int) (bool, error) {
+	if nil != l.err {
+		return false, l.err
+	}
+	if rate <= 0 {
+		return true, nil
+	}
+	if l.used[key]+cost > l.limit {
+		return false, nil
+	}
+	l.used[key] += cost
+	return true, nil
+}
+
+func newGuardedHandler(options config.JsonrpcOptions, limiter gateway.RateLimiter) http.Handler {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"ok"}`))
+	})
+	return gateway.NewJsonrpcGuard(options, limiter).Handler(next)
+}
+
+func post(handler http.Handler, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.RemoteAddr = "10.0.0.1:50000"
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+const (
+	getBalance  = `{"jsonrpc":"2.0","id":1,"method":"loopring_getBalance","params":[]}`
+	submitOrder = `{"jsonrpc":"2.0","id":2,"method":"loopring_submitOrder","params":[]}`
+)
+
+func TestJsonrpcGuardScopes(t *testing.T) {
+	options := config.JsonrpcOptions{
+		AuthRequired: true,
+		ApiKeys: []config.ApiKeyOptions{
+			{Name: "reader", Key: "reader-key", Scopes: []string{"read"}},
+			{Name: "trader", Key: "trader-key", Scopes: []string{"read", "trade"}},
+		},
+	}
+	handler := newGuardedHandler(options, &countingLimiter{used: make(map[string]int)})
+
+	if w := post(handler, getBalance, nil); http.StatusOK != w.Code {
+		t.Fatalf("anonymous request is allowed to read, got:%d", w.Code)
+	}
+	if w := post(handler, submitOrder, nil); http.StatusForbidden != w.Code || !strings.Contains(w.Body.String(), `"id":2`) {
+		t.Fatalf("anonymous request isn't allowed to trade, got:%d %s", w.Code, w.Body.String())
+	}
+	if w := post(handler, "["+getBalance+","+submitOrder+"]", map[string]string{gateway.API_KEY_HEADER: "reader-key"}); http.StatusForbidden != w.Code || !strings.HasPrefix(w.Body.String(), "[") {
+		t.Fatalf("the batch containing trade method should be rejected, got:%d %s", w.Code, w.Body.String())
+	}
+	if w := post(handler, submitOrder, map[string]string{gateway.API_KEY_HEADER: "trader-key"}); http.StatusOK != w.Code {
+		t.Fatalf("trader is allowed to trade, got:%d", w.Code)
+	}
+	if w := post(handler, getBalance, map[string]string{gateway.API_KEY_HEADER: "no-such-key"}); http.StatusUnauthorized != w.Code {
+		t.Fatalf("unknown key should be rejected, got:%d", w.Code)
+	}
+
+	options.AuthRequired = false
+	handler = newGuardedHandler(options, &countingLimiter{used: make(map[string]int)})
+	if w := post(handler, submitOrder, nil); http.StatusOK != w.Code {
+		t.Fatalf("anonymous request is allowed to trade without auth required, got:%d", w.Code)
+	}
+}
+
+func TestJsonrpcGuardSignature(t *testing.T) {
+	options := config.JsonrpcOptions{
+		ApiKeys: []config.ApiKeyOptions{{Name: "signer", Key: "signer-key", Secret: "signer-secret", Scopes: []string{"admin"}}},
+	}
+	handler := newGuardedHandler(options, &countingLimiter{used: make(map[string]int)})
+
+	signed := func(timestamp int64, body string) map[string]string {
+		ts := strconv.FormatInt(timestamp, 10)
+		return map[string]string{
+			gateway.API_KEY_HEADER:       "signer-key",
+			gateway.API_TIMESTAMP_HEADER: ts,
+			gateway.API_SIGNATURE_HEADER: hex.EncodeToString(gateway.SignJsonrpcRequest([]byte("signer-secret"), ts, []byte(body))),
+		}
+	}
+
+	if w := post(handler, submitOrder, signed(time.Now().Unix(), submitOrder)); http.StatusOK != w.Code {
+		t.Fatalf("signed request should be served, got:%d %s", w.Code, w.Body.String())
+	}
+	if w := post(handler, submitOrder, map[string]string{gateway.API_KEY_HEADER: "signer-key"}); http.StatusUnauthorized != w.Code {
+		t.Fatalf("request without signature should be rejected, got:%d", w.Code)
+	}
+	if w := post(handler, submitOrder, signed(time.Now().Unix(), getBalance)); http.StatusUnauthorized != w.Code {
+		t.Fatalf("request with the signature of another body should be rejected, got:%d", w.Code)
+	}
+	if w := post(handler, submitOrder, signed(time.Now().Unix()-600, submitOrder)); http.StatusUnauthorized != w.Code {
+		t.Fatalf("request signed out of window should be rejected, got:%d", w.Code)
+	}
+}
+
+func TestJsonrpcGuardRateLimit(t *testing.T) {
+	options := config.JsonrpcOptions{
+		IpRate:  1,
+		IpBurst: 2,
+		ApiKeys: []config.ApiKeyOptions{{Name: "reader", Key: "reader-key", Scopes: []string{"read"}, Rate: 1, Burst: 2}},
+	}
+	limiter := &countingLimiter{limit: 2, used: make(map[string]int)}
+	handler := newGuardedHandler(options, limiter)
+
+	if w := post(handler, "["+getBalance+","+getBalance+"]", nil); http.StatusOK != w.Code {
+		t.Fatalf("the batch is within the burst, got:%d", w.Code)
+	}
+	w := post(handler, getBalance, nil)
+	if http.StatusTooManyRequests != w.Code || "" == w.Header().Get("Retry-After") {
+		t.Fatalf("the ip should be limited, got:%d", w.Code)
+	}
+	if w := post(handler, getBalance, map[string]string{gateway.API_KEY_HEADER: "reader-key"}); http.StatusOK != w.Code {
+		t.Fatalf("the key is limited apart from the ip, got:%d", w.Code)
+	}
+
+	limiter.err = errors.New("redis is down")
+	if w := post(handler, getBalance, nil); http.StatusOK != w.Code {
+		t.Fatalf("the requests are served if the limiter is unavailable, got:%d", w.Code)
+	}
+}
+
+func TestJsonrpcGuardClientIp(t *testing.T) {
+	options := config.JsonrpcOptions{RealIpHeader: "X-Forwarded-For", IpRate: 1, IpBurst: 1}
+	limiter := &countingLimiter{limit: 1, used: make(map[string]int)}
+	handler := newGuardedHandler(options, limiter)
+
+	if w := post(handler, getBalance, map[string]string{"X-Forwarded-For": "1.1.1.1, 2.2.2.2"}); http.StatusOK != w.Code {
+		t.Fatalf("the first request is allowed, got:%d", w.Code)
+	}
+	if w := post(handler, getBalance, map[string]string{"X-Forwarded-For": "9.9.9.9, 2.2.2.2"}); http.StatusTooManyRequests != w.Code {
+		t.Fatalf("the address sent by the client shouldn't be used, got:%d", w.Code)
+	}
+	if w := post(handler, getBalance, map[string]string{"X-Forwarded-For": "2.2.2.2, 3.3.3.3"}); http.StatusOK != w.Code {
+		t.Fatalf("the address appended by the proxy should be used, got:%d", w.Code)
+	}
+	if w := post(handler, getBalance, nil); http.StatusOK != w.Code {
+		t.Fatalf("the remote address is used without the header, got:%d", w.Code)
+	}
+
+	options.TrustedProxies = 2
+	limiter = &countingLimiter{limit: 10, used: make(map[string]int)}
+	handler = newGuardedHandler(options, limiter)
+	for _, forwarded := range []string{"9.9.9.9, 4.4.4.4, 10.0.0.2", "4.4.4.4, 10.0.0.3", "4.4.4.4"} {
+		post(handler, getBalance, map[string]string{"X-Forwarded-For": forwarded})
+	}
+	if 1 != len(limiter.used) || 3 != limiter.used["ip_4.4.4.4"] {
+		t.Fatalf("the address appended by the outermost proxy should be used, got:%v", limiter.used)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay/crypto"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"time"
+)
+
+const (
+	DEFAULT_MAX_BATCH_ORDERS = 50
+
+	SOFT_CANCEL_WINDOW = 600 //seconds the timestamp of soft cancel request may differ from the relay
+)
+
+type OrderSubmitResult struct {
+	OrderHash string `json:"orderHash"`
+	Success   bool   `json:"success"`
+	Code      string `json:"code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SoftCancelRequest is signed by the owner the same as orders, over the hash of
+// owner, timestamp and the order hashes. The timestamp is in seconds.
+type SoftCancelRequest struct {
+	Owner       string        `json:"owner"`
+	OrderHashes []string      `json:"orderHashes"`
+	Timestamp   int64         `json:"timestamp"`
+	V           uint8         `json:"v"`
+	R           types.Bytes32 `json:"r"`
+	S           types.Bytes32 `json:"s"`
+}
+
+type SoftCancelResult struct {
+	Cancelled []string `json:"cancelled"`
+}
+
+func (req *SoftCancelRequest) Hash() common.Hash {
+	data := [][]byte{
+		common.HexToAddress(req.Owner).Bytes(),
+		common.LeftPadBytes(big.NewInt(req.Timestamp).Bytes(), 32),
+	}
+	for _, orderHash := range req.OrderHashes {
+		data = append(data, common.HexToHash(orderHash).Bytes())
+	}
+	return common.BytesToHash(crypto.GenerateHash(data...))
+}
+
+func (req *SoftCancelRequest) SignerAddress() (common.Address, error) {
+	sig, _ := crypto.VRSToSig(req.V, req.R.Bytes(), req.S.Bytes())
+	addressBytes, err := crypto.SigToAddress(req.Hash().Bytes(), sig)
+	if nil != err {
+		return common.Address{}, err
+	}
+	return common.BytesToAddress(addressBytes), nil
+}
+
+// HandleInputOrders handles the orders one by one the same as HandleInputOrder,
+// a failed order doesn't stop the others.
+func HandleInputOrders(orders []*types.Order) ([]OrderSubmitResult, error) {
+	if len(orders) == 0 {
+		return nil, errors.New("no order submitted")
+	}
+	if len(orders) > gateway.maxBatchOrders {
+		return nil, fmt.Errorf("%d orders can be submitted at most", gateway.maxBatchOrders)
+	}
+
+	results := make([]OrderSubmitResult, len(orders))
+	submitted := make(map[common.Hash]bool)
+	for i, order := range orders {
+		hash := order.GenerateHash()
+		if submitted[hash] {
+			results[i] = OrderSubmitResult{OrderHash: hash.Hex(), Error: "order is duplicated in the batch"}
+			continue
+		}
+		submitted[hash] = true
+
+		orderHash, err := HandleInputOrder(order)
+		results[i] = OrderSubmitResult{OrderHash: orderHash, Success: nil == err}
+		if nil != err {
+			results[i].Error = err.Error()
+			if filterErr, ok := err.(*FilterError); ok {
+				results[i].Code = filterErr.Code
+			}
+		}
+	}
+	return results, nil
+}
+
+// SoftCancelOrders verifies the request is signed by the owner recently,
+// the orders not owned by the owner or closed are ignored.
+func SoftCancelOrders(req *SoftCancelRequest) (SoftCancelResult, error) {
+	res := SoftCancelResult{Cancelled: []string{}}
+	if len(req.OrderHashes) == 0 {
+		return res, errors.New("no order to cancel")
+	}
+	if len(req.OrderHashes) > gateway.maxBatchOrders {
+		return res, fmt.Errorf("%d orders can be cancelled at most", gateway.maxBatchOrders)
+	}
+	if !common.IsHexAddress(req.Owner) {
+		return res, errors.New("invalid owner")
+	}
+	if diff := time.Now().Unix() - req.Timestamp; diff > SOFT_CANCEL_WINDOW || diff < -SOFT_CANCEL_WINDOW {
+		return res, errors.New("the timestamp is out of window")
+	}
+
+	owner := common.HexToAddress(req.Owner)
+	if signer, err := req.SignerAddress(); nil != err {
+		return res, err
+	} else if signer != owner {
+		return res, fmt.Errorf("the signer:%s isn't the owner:%s", signer.Hex(), owner.Hex())
+	}
+
+	orderHashes := []common.Hash{}
+	for _, orderHash := range req.OrderHashes {
+		orderHashes = append(orderHashes, common.HexToHash(orderHash))
+	}
+	cancelled, err := gateway.om.SoftCancelOrders(owner, orderHashes)
+	for _, orderHash := range cancelled {
+		res.Cancelled = append(res.Cancelled, orderHash.Hex())
+	}
+	return res, err
+}
@@ -0,0 +1,63 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/crypto"
+	"github.com/Loopring/relay/gateway"
+	"github.com/Loopring/relay/types"
+	ethCrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSoftCancelRequestSigner(t *testing.T) {
+	crypto.Initialize(crypto.NewKSCrypto(true, nil))
+
+	key, err := ethCrypto.GenerateKey()
+	if nil != err {
+		t.Fatal(err)
+	}
+	owner := ethCrypto.PubkeyToAddress(key.PublicKey)
+
+	req := &gateway.SoftCancelRequest{
+		Owner:       owner.Hex(),
+		OrderHashes: []string{"0x01", "0x02"},
+		Timestamp:   time.Now().Unix(),
+	}
+	//signed the same as eth_sign
+	sig, err := ethCrypto.Sign(ethCrypto.Keccak256([]byte("\x19Ethereum Signed Message:\n32"), req.Hash().Bytes()), key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	v, r, s := crypto.SigToVRS(sig)
+	req.V = v
+	req.R = types.BytesToBytes32(r)
+	req.S = types.BytesToBytes32(s)
+
+	if signer, err := req.SignerAddress(); nil != err || signer != owner {
+		t.Fatalf("the signer should be the owner:%s, got:%s err:%v", owner.Hex(), signer.Hex(), err)
+	}
+
+	req.OrderHashes = append(req.OrderHashes, "0x03")
+	if signer, _ := req.SignerAddress(); signer == owner {
+		t.Fatalf("the signature shouldn't be valid for other orders")
+	}
+}
@@ -0,0 +1,104 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/log"
+	"time"
+)
+
+const RATE_LIMIT_PRE_KEY = "lrc_rate_limit_"
+
+// tokenBucketScript refills the bucket by the milliseconds elapsed and takes cost tokens from it,
+// the bucket is full when it's created or expired.
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + rate * (now - ts) / 1000)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return allowed
+`
+
+type RateLimiter interface {
+	// Allow takes cost tokens from the bucket of key, the bucket holds burst tokens at most and is refilled by rate per second
+	Allow(key string, rate float64, burst int64, cost int) (bool, error)
+}
+
+// RedisRateLimiter shares the buckets between the relays behind the same redis
+type RedisRateLimiter struct {
+	now func() time.Time
+}
+
+func NewRedisRateLimiter() *RedisRateLimiter {
+	l := &RedisRateLimiter{}
+	l.now = time.Now
+	return l
+}
+
+func (l *RedisRateLimiter) Allow(key string, rate float64, burst int64, cost int) (bool, error) {
+	if rate <= 0 {
+		return true, nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	nowMs := l.now().UnixNano() / int64(time.Millisecond)
+	reply, err := cache.Eval(tokenBucketScript, []string{RATE_LIMIT_PRE_KEY + key}, rate, burst, nowMs, cost)
+	if nil != err {
+		return false, err
+	}
+	allowed, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("unexpected reply:%v of the token bucket", reply)
+	}
+	return 1 == allowed, nil
+}
+
+// allowOrFailOpen doesn't reject the requests when the limiter is unavailable,
+// the relay keeps serving with redis down the same as before the limiter is added.
+func allowOrFailOpen(limiter RateLimiter, key string, rate float64, burst int64, cost int) bool {
+	allowed, err := limiter.Allow(key, rate, burst, cost)
+	if nil != err {
+		log.Errorf("jsonrpc,rate limit of %s isn't applied, err:%s", key, err.Error())
+		return true
+	}
+	return allowed
+}
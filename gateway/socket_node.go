@@ -1,58 +0,0 @@
-/*
-
-  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
-
-  Licensed under the Apache License, Version 2.0 (the "License");
-  you may not use this file except in compliance with the License.
-  You may obtain a copy of the License at
-
-  http://www.apache.org/licenses/LICENSE-2.0
-
-  Unless required by applicable law or agreed to in writing, software
-  distributed under the License is distributed on an "AS IS" BASIS,
-  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-  See the License for the specific language governing permissions and
-  limitations under the License.
-
-*/
-
-package gateway
-
-type SocketNode struct {
-	clients    map[*SocketClient]bool
-	broadcast  chan []byte
-	register   chan *SocketClient
-	unregister chan *SocketClient
-}
-
-func newSocketNode() *SocketNode {
-	return &SocketNode{
-		broadcast:  make(chan []byte),
-		register:   make(chan *SocketClient),
-		unregister: make(chan *SocketClient),
-		clients:    make(map[*SocketClient]bool),
-	}
-}
-
-func (h *SocketNode) run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.clients[client] = true
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-		}
-	}
-}
@@ -1,20 +1,24 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/market/util"
 	txtyp "github.com/Loopring/relay/txmanager/types"
 	"github.com/Loopring/relay/types"
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/googollee/go-socket.io"
 	"github.com/robfig/cron"
 	"gopkg.in/googollee/go-engine.io.v1"
+	"gopkg.in/googollee/go-engine.io.v1/transport"
+	engineiows "gopkg.in/googollee/go-engine.io.v1/transport/websocket"
+	"net"
 	"net/http"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
@@ -86,7 +90,9 @@ const (
 	eventKeyTransaction     = "transaction"
 	eventKeyPendingTx       = "pendingTx"
 	eventKeyDepth           = "depth"
+	eventKeyDepthDiff       = "depthDiff"
 	eventKeyTrades          = "trades"
+	eventKeyOrders          = "orders"
 )
 
 var EventTypeRoute = map[string]InvokeInfo{
@@ -102,174 +108,130 @@ var EventTypeRoute = map[string]InvokeInfo{
 	//eventKeyPendingTx:       {"GetPendingTransactions", SingleOwner{}, false, emitTypeByEvent, DefaultCronSpec10Second},
 	//eventKeyDepth:           {"GetDepth", DepthQuery{}, true, emitTypeByEvent, DefaultCronSpec3Second},
 	//eventKeyTrades:          {"GetTrades", FillQuery{}, true, emitTypeByEvent, DefaultCronSpec3Second},
+	//the routes by event are pushed when the events of their scopes are received, the ones by cron have no event
 	eventKeyTickers:         {"GetTickers", SingleMarket{}, true, emitTypeByCron, DefaultCronSpec5Second},
-	eventKeyLoopringTickers: {"GetTicker", nil, true, emitTypeByEvent, DefaultCronSpec5Second},
-	eventKeyTrends:          {"GetTrend", TrendQuery{}, true, emitTypeByEvent, DefaultCronSpec10Second},
+	eventKeyLoopringTickers: {"GetTicker", nil, true, emitTypeByEvent, ""},
+	eventKeyTrends:          {"GetTrend", TrendQuery{}, true, emitTypeByEvent, ""},
 	// portfolio has been remove from loopr2
-	// eventKeyPortfolio:       {"GetPortfolio", SingleOwner{}, false, emitTypeByEvent, DefaultCronSpec3Second},
-	eventKeyPortfolio:   {"GetPortfolio", SingleOwner{}, false, emitTypeByCron, DefaultCronSpec3Second},
+	// the prices of portfolio are refreshed by cron, and the balances by event
+	eventKeyPortfolio:   {"GetPortfolio", SingleOwner{}, false, emitTypeByCron, DefaultCronSpec5Minute},
 	eventKeyMarketCap:   {"GetPriceQuote", PriceQuoteQuery{}, true, emitTypeByCron, DefaultCronSpec5Minute},
-	eventKeyBalance:     {"GetBalance", CommonTokenRequest{}, false, emitTypeByEvent, DefaultCronSpec10Second},
-	eventKeyTransaction: {"GetTransactions", TransactionQuery{}, false, emitTypeByEvent, DefaultCronSpec10Second},
-	eventKeyPendingTx:   {"GetPendingTransactions", SingleOwner{}, false, emitTypeByEvent, DefaultCronSpec10Second},
-	eventKeyDepth:       {"GetDepth", DepthQuery{}, true, emitTypeByEvent, DefaultCronSpec10Second},
-	eventKeyTrades:      {"GetLatestFills", FillQuery{}, true, emitTypeByEvent, DefaultCronSpec10Second},
+	eventKeyBalance:     {"GetBalance", CommonTokenRequest{}, false, emitTypeByEvent, ""},
+	eventKeyTransaction: {"GetTransactions", TransactionQuery{}, false, emitTypeByEvent, ""},
+	eventKeyPendingTx:   {"GetPendingTransactions", SingleOwner{}, false, emitTypeByEvent, ""},
+	eventKeyDepth:       {"GetDepth", DepthQuery{}, true, emitTypeByEvent, ""},
+	eventKeyDepthDiff:   {"GetDepth", DepthQuery{}, true, emitTypeByEvent, ""},
+	eventKeyTrades:      {"GetLatestFills", FillQuery{}, true, emitTypeByEvent, ""},
+	eventKeyOrders:      {"GetOrders", &OrderQuery{}, false, emitTypeByEvent, ""},
 }
 
 type SocketIOService interface {
-	Start(port string)
-	Stop()
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
 }
 
+const socketIOFlushInterval = time.Second //the events of a subscription in the interval are coalesced into one push
+
 type SocketIOServiceImpl struct {
-	port               string
+	options            config.WebsocketOptions
 	walletService      WalletServiceImpl
 	connIdMap          *sync.Map
 	connBusinessKeyMap map[string]socketio.Conn
+	hub                *pushHub
+	adapter            pushAdapter
+	source             string
+	stopAdapter        func()
+	watchers           map[string]*eventemitter.Watcher
 	cron               *cron.Cron
+	stop               chan struct{}
+	server             *socketio.Server
+	httpServer         *http.Server
 }
 
-func NewSocketIOService(port string, walletService WalletServiceImpl) *SocketIOServiceImpl {
+func NewSocketIOService(options config.WebsocketOptions, walletService WalletServiceImpl) *SocketIOServiceImpl {
 	so := &SocketIOServiceImpl{}
-	so.port = port
+	so.options = options
 	so.walletService = walletService
 	so.connBusinessKeyMap = make(map[string]socketio.Conn)
 	so.connIdMap = &sync.Map{}
+	so.hub = newPushHub()
+	hostname, _ := os.Hostname()
+	so.source = fmt.Sprintf("%s_%d", hostname, os.Getpid())
 	so.cron = cron.New()
 
-	// init event watcher
-	//loopringTickerWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.broadcastLoopringTicker}
-	//eventemitter.On(eventemitter.LoopringTickerUpdated, loopringTickerWatcher)
-	//trendsWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.broadcastTrends}
-	//eventemitter.On(eventemitter.TrendUpdated, trendsWatcher)
-	//portfolioWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.handlePortfolioUpdate}
-	//eventemitter.On(eventemitter.PortfolioUpdated, portfolioWatcher)
-	//balanceWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.handleBalanceUpdate}
-	//eventemitter.On(eventemitter.BalanceUpdated, balanceWatcher)
-	//depthWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.broadcastDepth}
-	//eventemitter.On(eventemitter.DepthUpdated, depthWatcher)
-	//transactionWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.handleTransactionUpdate}
-	//eventemitter.On(eventemitter.TransactionEvent, transactionWatcher)
-	//pendingTxWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.handlePendingTransaction}
-	//eventemitter.On(eventemitter.TransactionEvent, pendingTxWatcher)
+	// the watchers only mark the subscriptions of the event, they're queried by the next flush
+	so.watchers = map[string]*eventemitter.Watcher{
+		eventemitter.LoopringTickerUpdated: {Concurrent: false, Handle: so.handleLoopringTickerUpdated},
+		eventemitter.TrendUpdated:          {Concurrent: false, Handle: so.handleTrendUpdated},
+		eventemitter.DepthUpdated:          {Concurrent: false, Handle: so.handleDepthUpdated},
+		eventemitter.DepthDiffUpdated:      {Concurrent: false, Handle: so.broadcastDepthDiff},
+		eventemitter.OrderFilled:           {Concurrent: false, Handle: so.handleOrderFilled},
+		eventemitter.OrderUpdated:          {Concurrent: false, Handle: so.handleOrderUpdated},
+		eventemitter.OrderExpired:          {Concurrent: false, Handle: so.handleOrderExpired},
+		eventemitter.BalanceUpdated:        {Concurrent: false, Handle: so.handleBalanceUpdate},
+		eventemitter.TransactionEvent:      {Concurrent: false, Handle: so.handleTransactionUpdate},
+	}
 	return so
 }
 
-func (so *SocketIOServiceImpl) Start() {
-	server, err := socketio.NewServer(&engineio.Options{
+func (so *SocketIOServiceImpl) Start(ctx context.Context) error {
+	adapter, err := newPushAdapter(so.options.PushAdapter)
+	if nil != err {
+		return err
+	}
+	so.adapter = adapter
+
+	engineOptions := &engineio.Options{
 		PingInterval: time.Second * 60 * 60,
 		PingTimeout:  time.Second * 60 * 60,
-	})
+	}
+	//the polling transport needs all the requests of a session sent to the same relay
+	if so.options.WebsocketOnly {
+		engineOptions.Transports = []transport.Transport{engineiows.Default}
+	}
+	server, err := socketio.NewServer(engineOptions)
 	if err != nil {
-		log.Fatalf(err.Error())
+		return err
 	}
 	server.OnConnect("/", func(s socketio.Conn) error {
 		so.connIdMap.Store(s.ID(), s)
 		return nil
 	})
 	server.OnEvent("/", "test", func(s socketio.Conn, msg string) {
-		fmt.Println("test:", msg)
 		s.Emit("reply", "pong relay msg : "+msg)
-		fmt.Println("emit message finished...")
-		fmt.Println(s.RemoteAddr())
 	})
 
 	for v := range EventTypeRoute {
 		aliasOfV := v
 
 		server.OnEvent("/", aliasOfV+EventPostfixReq, func(s socketio.Conn, msg string) {
-			fmt.Println("input emit msg is ....." + msg)
-			context := make(map[string]string)
-			if s != nil && s.Context() != nil {
-				context = s.Context().(map[string]string)
-			}
-			context[aliasOfV] = msg
-			s.SetContext(context)
-			so.connIdMap.Store(s.ID(), s)
-			//log.Infof("[SOCKETIO-EMIT]response emit by key : %s, connId : %s", aliasOfV, s.ID())
-			fmt.Println("out emit msg is ....." + aliasOfV)
-			so.EmitNowByEventType(aliasOfV, s, msg)
+			so.subscribe(s, aliasOfV, msg)
 		})
 
 		server.OnEvent("/", aliasOfV+EventPostfixEnd, func(s socketio.Conn, msg string) {
-			if s != nil && s.Context() != nil {
-				businesses := s.Context().(map[string]string)
-				delete(businesses, aliasOfV)
-				s.SetContext(businesses)
-			}
+			so.hub.unsubscribe(s.ID(), aliasOfV)
 		})
 	}
 
 	for k, events := range EventTypeRoute {
-		copyOfK := k
-		spec := events.spec
-
-		//if events.emitType != emitTypeByCron {
-		//	log.Infof("no cron emit type %d ", events.emitType)
-		//	continue
-		//}
-
-		switch k {
-		case eventKeyTickers:
-			so.cron.AddFunc(spec, func() {
-				so.broadcastTpTickers(nil)
-			})
-		case eventKeyLoopringTickers:
-			so.cron.AddFunc(spec, func() {
-				so.broadcastLoopringTicker(nil)
-			})
-		case eventKeyDepth:
-			so.cron.AddFunc(spec, func() {
-				//log.Info("start depth broadcast")
-				so.broadcastDepth(nil)
-			})
-		case eventKeyTrades:
-			so.cron.AddFunc(spec, func() {
-				//log.Info("start trades broadcast")
-				so.broadcastTrades(nil)
-			})
-		default:
-			log.Infof("add cron emit %d ", events.emitType)
-			so.cron.AddFunc(spec, func() {
-				so.connIdMap.Range(func(key, value interface{}) bool {
-					v := value.(socketio.Conn)
-					if v.Context() != nil {
-						businesses := v.Context().(map[string]string)
-						eventContext, ok := businesses[copyOfK]
-						if ok {
-							//log.Infof("[SOCKETIO-EMIT]cron emit by key : %s, connId : %s", copyOfK, v.ID())
-							so.EmitNowByEventType(copyOfK, v, eventContext)
-						}
-					}
-					return true
-				})
-			})
-
+		if events.emitType != emitTypeByCron {
+			continue
 		}
+		copyOfK := k
+		so.cron.AddFunc(events.spec, func() {
+			so.hub.markDirty(pushMark{EventKey: copyOfK, All: true})
+		})
 	}
-
-	//so.cron.AddFunc("0/10 * * * * *", func() {
-	//
-	//	for _, v := range so.connIdMap {
-	//		if v.Context() == nil {
-	//			continue
-	//		} else {
-	//			businesses := v.Context().(map[string]string)
-	//			if businesses != nil {
-	//				for bk, bv := range businesses {
-	//					so.EmitNowByEventType(bk, v, bv)
-	//				}
-	//			}
-	//		}
-	//	}
-	//})
+	//the depths subscribed by depthDiff are snapshotted periodically, so the depth feed keeps them
+	so.cron.AddFunc(DefaultCronSpec5Minute, so.keepDepthDiffs)
 	so.cron.Start()
 
 	server.OnError("/", func(e error) {
-		fmt.Println("meet error:", e)
+		log.Debugf("socketio,connection err:%s", e.Error())
 		infos := strings.Split(e.Error(), "SOCKETFORLOOPRING")
 		if len(infos) == 2 {
 			so.connIdMap.Delete(infos[0])
+			so.hub.remove(infos[0])
 		}
 
 	})
@@ -277,16 +239,82 @@ func (so *SocketIOServiceImpl) Start() {
 	server.OnDisconnect("/", func(s socketio.Conn, msg string) {
 		s.Close()
 		so.connIdMap.Delete(s.ID())
-		fmt.Println("closed", msg)
+		so.hub.remove(s.ID())
 	})
+	listener, err := net.Listen("tcp", ":"+so.options.Port)
+	if nil != err {
+		so.cron.Stop()
+		return err
+	}
+	if err := so.subscribeAdapter(); nil != err {
+		listener.Close()
+		so.cron.Stop()
+		return err
+	}
+	for topic, watcher := range so.watchers {
+		eventemitter.On(topic, watcher)
+	}
+	so.stop = make(chan struct{})
+	go so.flush()
 	go server.Serve()
-	defer server.Close()
+	so.server = server
+
+	mux := http.NewServeMux()
+	mux.Handle("/socket.io/", NewServer(*server))
+	so.httpServer = &http.Server{Handler: mux}
+	go func() {
+		if err := so.httpServer.Serve(listener); nil != err && http.ErrServerClosed != err {
+			log.Errorf("socketio,serve err:%s", err.Error())
+		}
+	}()
+	log.Info("Serving at localhost: " + so.options.Port)
+	return nil
+}
 
-	http.Handle("/socket.io/", NewServer(*server))
-	log.Info("Serving at localhost: " + so.port)
-	log.Fatal(http.ListenAndServe(":"+so.port, nil).Error())
-	log.Info("finished listen socket io....")
+// Stop stops pushing to the connections, then closes the listener and the connections
+func (so *SocketIOServiceImpl) Stop(ctx context.Context) error {
+	if nil == so.httpServer {
+		return nil
+	}
+	for topic, watcher := range so.watchers {
+		eventemitter.Un(topic, watcher)
+	}
+	if nil != so.stopAdapter {
+		so.stopAdapter()
+	}
+	so.cron.Stop()
+	close(so.stop)
+	err := so.httpServer.Shutdown(ctx)
+	so.server.Close()
+	return err
+}
 
+// subscribeAdapter receives the marks of the other relays, a connection reconnected to any relay is pushed by
+// the events after its subscriptions are requested again
+func (so *SocketIOServiceImpl) subscribeAdapter() error {
+	if nil == so.adapter {
+		return nil
+	}
+	stopFunc, err := so.adapter.Subscribe(so.handleRemoteMarks)
+	if nil != err {
+		return err
+	}
+	so.stopAdapter = stopFunc
+	return nil
+}
+
+// subscribe responds the result of query now, then pushes it to the connection when the query is marked
+func (so *SocketIOServiceImpl) subscribe(s socketio.Conn, eventKey, msg string) {
+	route := EventTypeRoute[eventKey]
+	query, ctx, scope, err := parsePushQuery(eventKey, route, msg)
+	if nil != err {
+		errJson, _ := json.Marshal(SocketIOJsonResp{Error: err.Error()})
+		s.Emit(eventKey+EventPostfixRes, string(errJson[:]))
+		return
+	}
+	so.connIdMap.Store(s.ID(), s)
+	so.hub.subscribe(s, eventKey, query, ctx, scope)
+	so.handleAfterEmit(eventKey, route.Query, route.MethodName, s, ctx)
 }
 
 func (so *SocketIOServiceImpl) EmitNowByEventType(bk string, v socketio.Conn, bv string) {
@@ -295,6 +323,26 @@ func (so *SocketIOServiceImpl) EmitNowByEventType(bk string, v socketio.Conn, bv
 	}
 }
 
+// flush queries the subscriptions marked once for each, and pushes the result to all the connections of them
+func (so *SocketIOServiceImpl) flush() {
+	ticker := time.NewTicker(socketIOFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-so.stop:
+			return
+		case <-ticker.C:
+			for _, group := range so.hub.takeDirty() {
+				route := EventTypeRoute[group.key.eventKey]
+				result := so.handleWith(group.key.eventKey, route.Query, route.MethodName, group.key.ctx)
+				for _, conn := range group.conns {
+					conn.Emit(group.key.eventKey+EventPostfixRes, result)
+				}
+			}
+		}
+	}
+}
+
 func (so *SocketIOServiceImpl) handleWith(eventType string, query interface{}, methodName string, ctx string) string {
 
 	results := make([]reflect.Value, 0)
@@ -338,416 +386,99 @@ func (so *SocketIOServiceImpl) handleAfterEmit(eventType string, query interface
 	conn.Emit(eventType+EventPostfixRes, result)
 }
 
-func (so *SocketIOServiceImpl) broadcastTpTickers(input eventemitter.EventData) (err error) {
-
-	//log.Infof("[SOCKETIO-RECEIVE-EVENT] tickers input. %s", input)
-	mkts, _ := so.walletService.GetSupportedMarket()
-
-	tickerMap := make(map[string]SocketIOJsonResp)
-
-	for _, mkt := range mkts {
-		ticker, err := so.walletService.GetTickers(SingleMarket{mkt})
-		resp := SocketIOJsonResp{}
-
-		if err != nil {
-			resp = SocketIOJsonResp{Error: err.Error()}
-		} else {
-			resp.Data = ticker
-		}
-		tickerMap[mkt] = resp
-	}
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyLoopringTickers]
-			if ok {
-				var singleMarket SingleMarket
-				err = json.Unmarshal([]byte(ctx), &singleMarket)
-				if err != nil {
-					return true
-				}
-				tks, ok := tickerMap[strings.ToUpper(singleMarket.Market)]
-				if ok {
-					v.Emit(eventKeyTickers+EventPostfixRes, tks)
-				}
-			}
-		}
-		return true
-	})
+func (so *SocketIOServiceImpl) handleLoopringTickerUpdated(input eventemitter.EventData) (err error) {
+	so.mark(pushMark{EventKey: eventKeyLoopringTickers, All: true})
 	return nil
 }
 
-func (so *SocketIOServiceImpl) broadcastLoopringTicker(input eventemitter.EventData) (err error) {
-
-	//log.Infof("[SOCKETIO-RECEIVE-EVENT] loopring ticker input. %s", input)
-
-	resp := SocketIOJsonResp{}
-	tickers, err := so.walletService.GetTicker()
-
-	if err != nil {
-		resp = SocketIOJsonResp{Error: err.Error()}
-	} else {
-		resp.Data = tickers
-	}
-
-	respJson, _ := json.Marshal(resp)
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			_, ok := businesses[eventKeyLoopringTickers]
-			if ok {
-				//log.Info("emit loopring ticker info")
-				v.Emit(eventKeyLoopringTickers+EventPostfixRes, string(respJson[:]))
-			}
-		}
-		return true
-	})
+func (so *SocketIOServiceImpl) handleTrendUpdated(input eventemitter.EventData) (err error) {
+	so.mark(pushMark{EventKey: eventKeyTrends, pushScope: pushScope{Market: input.(string)}})
 	return nil
 }
 
-func (so *SocketIOServiceImpl) broadcastDepth(input eventemitter.EventData) (err error) {
-
-	//log.Infof("[SOCKETIO-RECEIVE-EVENT] loopring depth input. %s", input)
-
-	markets := so.getConnectedMarketForDepth()
-
-	respMap := make(map[string]string, 0)
-	for mk := range markets {
-		mktAndDelegate := strings.Split(mk, "_")
-		delegate := mktAndDelegate[0]
-		mkt := mktAndDelegate[1]
-		resp := SocketIOJsonResp{}
-		depth, err := so.walletService.GetDepth(DepthQuery{delegate, mkt})
-		if err == nil {
-			resp.Data = depth
-		} else {
-			resp = SocketIOJsonResp{Error: err.Error()}
-		}
-		respJson, _ := json.Marshal(resp)
-		respMap[mk] = string(respJson[:])
-	}
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyDepth]
-			if ok {
-				dQuery := &DepthQuery{}
-				err := json.Unmarshal([]byte(ctx), dQuery)
-				if err == nil && len(dQuery.DelegateAddress) > 0 && len(dQuery.Market) > 0 {
-					depthKey := strings.ToLower(dQuery.DelegateAddress) + "_" + strings.ToLower(dQuery.Market)
-					v.Emit(eventKeyDepth+EventPostfixRes, respMap[depthKey])
-				}
-			}
-		}
-		return true
-	})
+func (so *SocketIOServiceImpl) handleDepthUpdated(input eventemitter.EventData) (err error) {
+	event := input.(types.DepthUpdateEvent)
+	so.mark(pushMark{EventKey: eventKeyDepth, pushScope: pushScope{DelegateAddress: event.DelegateAddress, Market: event.Market}})
 	return nil
 }
 
-func (so *SocketIOServiceImpl) broadcastTrades(input eventemitter.EventData) (err error) {
+// broadcastDepthDiff pushes the levels changed to the connections subscribing the depth of the same precision,
+// they should get the snapshot by depthDiff_req first. The diffs aren't coalesced, each of them is pushed in order.
+func (so *SocketIOServiceImpl) broadcastDepthDiff(input eventemitter.EventData) (err error) {
+	diff := input.(*DepthDiff)
+	respJson, _ := json.Marshal(SocketIOJsonResp{Data: diff})
 
-	//log.Infof("[SOCKETIO-RECEIVE-EVENT] loopring depth input. %s", input)
-
-	markets := so.getConnectedMarketForFill()
-
-	respMap := make(map[string]string, 0)
-	for mk := range markets {
-		mktAndDelegate := strings.Split(mk, "_")
-		delegate := mktAndDelegate[0]
-		mkt := mktAndDelegate[1]
-		resp := SocketIOJsonResp{}
-		fills, err := so.walletService.GetLatestFills(FillQuery{DelegateAddress: delegate, Market: mkt, Side: util.SideSell})
-		if err == nil {
-			//log.Infof("fetch fill from wallet %d, %s", len(fills), mkt)
-			resp.Data = fills
-		} else {
-			resp = SocketIOJsonResp{Error: err.Error()}
+	for _, group := range so.hub.groupsOf(eventKeyDepthDiff, marketScope(diff.DelegateAddress, diff.Market)) {
+		query := group.query.(DepthQuery)
+		if diff.Precision != depthPrecisionOrDefault(query.Market, query.Precision) {
+			continue
 		}
-		respJson, _ := json.Marshal(resp)
-		respMap[mk] = string(respJson[:])
-	}
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyTrades]
-			if ok {
-				fQuery := &FillQuery{}
-				err := json.Unmarshal([]byte(ctx), fQuery)
-				if err == nil && len(fQuery.DelegateAddress) > 0 && len(fQuery.Market) > 0 {
-					fillKey := strings.ToLower(fQuery.DelegateAddress) + "_" + strings.ToLower(fQuery.Market)
-					v.Emit(eventKeyTrades+EventPostfixRes, respMap[fillKey])
-				}
-			}
+		for _, conn := range group.conns {
+			conn.Emit(eventKeyDepthDiff+EventPostfixRes, string(respJson[:]))
 		}
-		return true
-	})
+	}
 	return nil
 }
 
-func (so *SocketIOServiceImpl) getConnectedMarketForDepth() map[string]bool {
-	markets := make(map[string]bool, 0)
-	count := 0
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		count++
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			DCtx, ok := businesses[eventKeyDepth]
-			if ok {
-				dQuery := &DepthQuery{}
-				err := json.Unmarshal([]byte(DCtx), dQuery)
-				if err == nil && len(dQuery.DelegateAddress) > 0 && len(dQuery.Market) > 0 {
-					markets[strings.ToLower(dQuery.DelegateAddress)+"_"+strings.ToLower(dQuery.Market)] = true
-				}
-			}
-		}
-		return true
-	})
-	log.Infof("SOCKETIO current conn number is %d", count)
-	return markets
-}
-
-func (so *SocketIOServiceImpl) getConnectedMarketForFill() map[string]bool {
-	markets := make(map[string]bool, 0)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			fCtx, ok := businesses[eventKeyTrades]
-			if ok {
-				fQuery := &FillQuery{}
-				err := json.Unmarshal([]byte(fCtx), fQuery)
-				if err == nil && len(fQuery.DelegateAddress) > 0 && len(fQuery.Market) > 0 {
-					markets[strings.ToLower(fQuery.DelegateAddress)+"_"+strings.ToLower(fQuery.Market)] = true
-				}
-			}
+// keepDepthDiffs snapshots the depths subscribed by depthDiff periodically, so the depth feed keeps them
+func (so *SocketIOServiceImpl) keepDepthDiffs() {
+	for _, query := range so.hub.queries(eventKeyDepthDiff) {
+		if _, err := so.walletService.GetDepth(query.(DepthQuery)); nil != err {
+			log.Debugf("socketio,keep depth of %s err:%s", query.(DepthQuery).Market, err.Error())
 		}
-		return true
-	})
-	return markets
+	}
 }
 
-func (so *SocketIOServiceImpl) broadcastTrends(input eventemitter.EventData) (err error) {
-
-	//log.Infof("[SOCKETIO-RECEIVE-EVENT] trend input. %s", input)
-
-	req := input.(TrendQuery)
-	resp := SocketIOJsonResp{}
-	trends, err := so.walletService.GetTrend(req)
-
-	if err != nil {
-		resp = SocketIOJsonResp{Error: err.Error()}
-	} else {
-		resp.Data = trends
+// handleOrderFilled marks the trades of the market, and the ones of the owner
+func (so *SocketIOServiceImpl) handleOrderFilled(input eventemitter.EventData) (err error) {
+	event := input.(*types.OrderFilledEvent)
+	if event.Status != types.TX_STATUS_SUCCESS {
+		return nil
 	}
-
-	respJson, _ := json.Marshal(resp)
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyTrends]
-
-			if ok {
-				trendQuery := &TrendQuery{}
-				err = json.Unmarshal([]byte(ctx), trendQuery)
-				if err != nil {
-					log.Error("trend query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(req.Market) == strings.ToUpper(trendQuery.Market) &&
-					strings.ToUpper(req.Interval) == strings.ToUpper(trendQuery.Interval) {
-					log.Info("emit trend " + ctx)
-					v.Emit(eventKeyTrends+EventPostfixRes, string(respJson[:]))
-				}
-			}
+	mkt := event.Market
+	if "" == mkt {
+		if mkt, err = util.WrapMarketByAddress(event.TokenS.Hex(), event.TokenB.Hex()); nil != err {
+			return err
 		}
-		return true
-	})
+	}
+	so.mark(pushMark{EventKey: eventKeyTrades, pushScope: pushScope{DelegateAddress: event.DelegateAddress.Hex(), Market: mkt}},
+		pushMark{EventKey: eventKeyTrades, pushScope: pushScope{Owner: event.Owner.Hex(), DelegateAddress: event.DelegateAddress.Hex()}})
 	return nil
 }
 
-// portfolio has removed from loopr2
-func (so *SocketIOServiceImpl) handlePortfolioUpdate(input eventemitter.EventData) (err error) {
+func (so *SocketIOServiceImpl) handleOrderUpdated(input eventemitter.EventData) (err error) {
+	event := input.(types.OrderUpdateEvent)
+	so.markOrders(event.Owner, event.DelegateAddress, event.Market)
 	return nil
 }
 
-func (so *SocketIOServiceImpl) handleBalanceUpdate(input eventemitter.EventData) (err error) {
-
-	log.Infof("[SOCKETIO-RECEIVE-EVENT] balance input. %s", input)
-
-	req := input.(types.BalanceUpdateEvent)
-	if len(req.Owner) == 0 {
-		return errors.New("owner can't be nil")
-	}
-
-	if common.IsHexAddress(req.DelegateAddress) {
-		so.notifyBalanceUpdateByDelegateAddress(req.Owner, req.DelegateAddress)
-	} else {
-		for k := range ethaccessor.DelegateAddresses() {
-			so.notifyBalanceUpdateByDelegateAddress(req.Owner, k.Hex())
-		}
-	}
+func (so *SocketIOServiceImpl) handleOrderExpired(input eventemitter.EventData) (err error) {
+	event := input.(*types.OrderExpiredEvent)
+	so.markOrders(event.Owner.Hex(), event.DelegateAddress.Hex(), event.Market)
 	return nil
 }
 
-func (so *SocketIOServiceImpl) notifyBalanceUpdateByDelegateAddress(owner, delegateAddress string) (err error) {
-	req := CommonTokenRequest{owner, delegateAddress}
-	resp := SocketIOJsonResp{}
-	balance, err := so.walletService.GetBalance(req)
+// markOrders marks the orders of the owner, and the orders of the market queried without owner
+func (so *SocketIOServiceImpl) markOrders(owner, delegateAddress, market string) {
+	so.mark(pushMark{EventKey: eventKeyOrders, pushScope: pushScope{Owner: owner, DelegateAddress: delegateAddress}},
+		pushMark{EventKey: eventKeyOrders, pushScope: pushScope{DelegateAddress: delegateAddress, Market: market}})
+}
 
-	if err != nil {
-		resp = SocketIOJsonResp{Error: err.Error()}
-	} else {
-		resp.Data = balance
+func (so *SocketIOServiceImpl) handleBalanceUpdate(input eventemitter.EventData) (err error) {
+	event := input.(types.BalanceUpdateEvent)
+	if len(event.Owner) == 0 {
+		return errors.New("owner can't be nil")
 	}
 
-	respJson, _ := json.Marshal(resp)
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			_, ok := businesses[eventKeyBalance]
-			if ok {
-				//log.Info("emit balance info")
-				v.Emit(eventKeyBalance+EventPostfixRes, string(respJson[:]))
-			}
-		}
-		return true
-	})
+	//the allowances of the other delegates aren't changed
+	scope := pushScope{Owner: event.Owner, DelegateAddress: event.DelegateAddress}
+	so.mark(pushMark{EventKey: eventKeyBalance, pushScope: scope}, pushMark{EventKey: eventKeyPortfolio, pushScope: scope})
 	return nil
 }
 
-//func (so *SocketIOServiceImpl) broadcastDepth(input eventemitter.EventData) (err error) {
-//
-//	log.Infof("[SOCKETIO-RECEIVE-EVENT] depth input. %s", input)
-//
-//	req := input.(types.DepthUpdateEvent)
-//	resp := SocketIOJsonResp{}
-//	depths, err := so.walletService.GetDepth(DepthQuery{req.DelegateAddress, req.DelegateAddress})
-//
-//	if err != nil {
-//		resp = SocketIOJsonResp{Error: err.Error()}
-//	} else {
-//		resp.Data = depths
-//	}
-//
-//	respJson, _ := json.Marshal(resp)
-//
-//	so.connIdMap.Range(func(key, value interface{}) bool {
-//		v := value.(socketio.Conn)
-//		if v.Context() != nil {
-//			businesses := v.Context().(map[string]string)
-//			ctx, ok := businesses[eventKeyDepth]
-//
-//			if ok {
-//				depthQuery := &DepthQuery{}
-//				err = json.Unmarshal([]byte(ctx), depthQuery)
-//				if err != nil {
-//					log.Error("depth query unmarshal error, " + err.Error())
-//				} else if strings.ToUpper(req.DelegateAddress) == strings.ToUpper(depthQuery.DelegateAddress) &&
-//					strings.ToUpper(req.Market) == strings.ToUpper(depthQuery.Market) {
-//					log.Info("emit trend " + ctx)
-//					v.Emit(eventKeyDepth+EventPostfixRes, string(respJson[:]))
-//				}
-//			}
-//		}
-//		return true
-//	})
-//	return nil
-//}
-
 func (so *SocketIOServiceImpl) handleTransactionUpdate(input eventemitter.EventData) (err error) {
-
-	log.Infof("[SOCKETIO-RECEIVE-EVENT] transaction input. %s", input)
-
-	req := input.(*txtyp.TransactionView)
-	owner := req.Owner.Hex()
-	log.Infof("received owner is %s ", owner)
-	fmt.Println(so.connIdMap)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyTransaction]
-			log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				txQuery := &TransactionQuery{}
-				log.Info("txQuery owner is " + txQuery.Owner)
-				err = json.Unmarshal([]byte(ctx), txQuery)
-				if err != nil {
-					log.Error("tx query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(owner) == strings.ToUpper(txQuery.Owner) {
-					log.Info("emit trend " + ctx)
-
-					txs, err := so.walletService.GetTransactions(*txQuery)
-					resp := SocketIOJsonResp{}
-
-					if err != nil {
-						resp = SocketIOJsonResp{Error: err.Error()}
-					} else {
-						resp.Data = txs
-					}
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyTransaction+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
-
-	return nil
-}
-
-func (so *SocketIOServiceImpl) handlePendingTransaction(input eventemitter.EventData) (err error) {
-
-	log.Infof("[SOCKETIO-RECEIVE-EVENT] transaction input (for pending). %s", input)
-
-	req := input.(*txtyp.TransactionView)
-	owner := req.Owner.Hex()
-	log.Infof("received owner is %s ", owner)
-	fmt.Println(so.connIdMap)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		fmt.Println(key)
-		fmt.Println(value)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyPendingTx]
-			log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				txQuery := &SingleOwner{}
-				err = json.Unmarshal([]byte(ctx), txQuery)
-				log.Info("single owner is: " + txQuery.Owner)
-				if err != nil {
-					log.Error("tx query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(owner) == strings.ToUpper(txQuery.Owner) {
-					log.Info("emit tx pending " + ctx)
-					txs, err := so.walletService.GetPendingTransactions(SingleOwner{owner})
-					resp := SocketIOJsonResp{}
-
-					if err != nil {
-						resp = SocketIOJsonResp{Error: err.Error()}
-					} else {
-						resp.Data = txs
-					}
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyPendingTx+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
-
+	tx := input.(*txtyp.TransactionView)
+	scope := pushScope{Owner: tx.Owner.Hex()}
+	so.mark(pushMark{EventKey: eventKeyTransaction, pushScope: scope}, pushMark{EventKey: eventKeyPendingTx, pushScope: scope})
 	return nil
 }
@@ -0,0 +1,109 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+)
+
+const (
+	PUSH_ADAPTER_LOCAL    = "local"
+	PUSH_ADAPTER_REDIS    = "redis"
+	SOCKETIO_PUSH_CHANNEL = "socketio_push"
+)
+
+// pushAdapter shares the marks between the relays, so the connections of a relay are pushed by the events
+// handled in any other one. All the relays receive the marks, unlike the consumer group of the event bus.
+type pushAdapter interface {
+	Publish(data []byte) error
+	Subscribe(handle func(data []byte)) (func(), error)
+}
+
+// newPushAdapter returns nil for the local adapter, the marks are only used by this relay
+func newPushAdapter(name string) (pushAdapter, error) {
+	switch name {
+	case "", PUSH_ADAPTER_LOCAL:
+		return nil, nil
+	case PUSH_ADAPTER_REDIS:
+		return &redisPushAdapter{channel: SOCKETIO_PUSH_CHANNEL}, nil
+	default:
+		return nil, fmt.Errorf("unsupported push adapter:%s", name)
+	}
+}
+
+type redisPushAdapter struct {
+	channel string
+}
+
+func (a *redisPushAdapter) Publish(data []byte) error {
+	return cache.Publish(a.channel, data)
+}
+
+func (a *redisPushAdapter) Subscribe(handle func(data []byte)) (func(), error) {
+	return cache.Subscribe([]string{a.channel}, func(channel string, message []byte) {
+		handle(message)
+	})
+}
+
+// source is used to skip the marks published by this relay
+type pushEnvelope struct {
+	Source string     `json:"source"`
+	Marks  []pushMark `json:"marks"`
+}
+
+// mark marks the groups of this relay, then publishes the marks to the other relays
+func (so *SocketIOServiceImpl) mark(marks ...pushMark) {
+	for _, m := range marks {
+		so.hub.markDirty(m)
+	}
+	if nil == so.adapter {
+		return
+	}
+	data, err := json.Marshal(&pushEnvelope{Source: so.source, Marks: marks})
+	if nil != err {
+		log.Errorf("socketio,marshal marks err:%s", err.Error())
+		return
+	}
+	if err := so.adapter.Publish(data); nil != err {
+		log.Errorf("socketio,publish marks err:%s", err.Error())
+	}
+}
+
+// handleRemoteMarks marks the groups by the events handled in the other relays. The depth feed of this relay is
+// refreshed by the depth marked, so the depth diffs are pushed to the connections of every relay.
+func (so *SocketIOServiceImpl) handleRemoteMarks(data []byte) {
+	envelope := pushEnvelope{}
+	if err := json.Unmarshal(data, &envelope); nil != err {
+		log.Errorf("socketio,unmarshal marks err:%s", err.Error())
+		return
+	}
+	if envelope.Source == so.source {
+		return
+	}
+	for _, m := range envelope.Marks {
+		so.hub.markDirty(m)
+		if eventKeyDepth == m.EventKey && nil != so.walletService.depthFeed {
+			so.walletService.depthFeed.handleDepthUpdated(types.DepthUpdateEvent{DelegateAddress: m.DelegateAddress, Market: m.Market})
+		}
+	}
+}
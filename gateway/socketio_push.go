@@ -0,0 +1,262 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Loopring/relay/market/util"
+	"github.com/googollee/go-socket.io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type pushKey struct {
+	eventKey string
+	ctx      string
+}
+
+// pushGroup is the connections subscribing the same query of an event, its result is queried once for all of them
+type pushGroup struct {
+	key   pushKey
+	scope pushScope
+	query interface{}
+	conns map[string]socketio.Conn
+}
+
+// pushHub indexes the groups by the owner or market they subscribe, so an event only touches the groups of its
+// scope. The groups marked dirty are coalesced until they're taken by the next flush.
+type pushHub struct {
+	mtx        sync.Mutex
+	groups     map[pushKey]*pushGroup
+	scopes     map[string]map[string]map[pushKey]bool
+	connGroups map[string]map[string]pushKey
+	dirty      map[pushKey]bool
+}
+
+func newPushHub() *pushHub {
+	h := &pushHub{}
+	h.groups = make(map[pushKey]*pushGroup)
+	h.scopes = make(map[string]map[string]map[pushKey]bool)
+	h.connGroups = make(map[string]map[string]pushKey)
+	h.dirty = make(map[pushKey]bool)
+	return h
+}
+
+// pushScope is the fields of queries used to find the groups of an event
+type pushScope struct {
+	Owner           string `json:"owner"`
+	DelegateAddress string `json:"delegateAddress"`
+	Market          string `json:"market"`
+}
+
+// key is the owner for the queries of an account, otherwise it's the delegate and market
+func (s pushScope) key() string {
+	if "" != s.Owner {
+		return ownerScope(s.Owner)
+	}
+	return marketScope(s.DelegateAddress, s.Market)
+}
+
+// pushMark marks the groups of an event in its scope, it's published to the other relays by the adapter.
+// The groups of the owner are filtered by the delegate if both of them are set.
+type pushMark struct {
+	EventKey string `json:"eventKey"`
+	All      bool   `json:"all"`
+	pushScope
+}
+
+func (m pushMark) matches(group *pushGroup) bool {
+	if m.All || "" == m.Owner || "" == m.DelegateAddress || "" == group.scope.DelegateAddress {
+		return true
+	}
+	return strings.EqualFold(m.DelegateAddress, group.scope.DelegateAddress)
+}
+
+func ownerScope(owner string) string {
+	return strings.ToLower(owner)
+}
+
+func marketScope(delegateAddress, market string) string {
+	return strings.ToLower(delegateAddress) + "_" + strings.ToUpper(market)
+}
+
+// parsePushQuery parses ctx as the query of route, the addresses are lower case and the market is upper case,
+// so the same queries are in the same group. The ctx returned is the canonical one of query.
+func parsePushQuery(eventKey string, route InvokeInfo, ctx string) (interface{}, string, pushScope, error) {
+	scope := pushScope{}
+	if nil == route.Query {
+		return nil, "", scope, nil
+	}
+	queryType := reflect.TypeOf(route.Query)
+	queryClone := reflect.New(queryType)
+	if err := json.Unmarshal([]byte(ctx), queryClone.Interface()); nil != err {
+		return nil, "", scope, err
+	}
+	queryValue := queryClone.Elem()
+	fields := queryValue
+	if fields.Kind() == reflect.Ptr {
+		if fields.IsNil() {
+			return nil, "", scope, fmt.Errorf("query of %s can't be null", eventKey)
+		}
+		fields = fields.Elem()
+	}
+	if fields.Kind() == reflect.Struct {
+		for name, normalize := range map[string]func(string) string{"Owner": strings.ToLower, "DelegateAddress": strings.ToLower, "Market": strings.ToUpper} {
+			if field := fields.FieldByName(name); field.IsValid() && field.Kind() == reflect.String {
+				field.SetString(normalize(field.String()))
+			}
+		}
+		//the trades pushed are of the sell side, as the tickers are calculated
+		if side := fields.FieldByName("Side"); eventKeyTrades == eventKey && side.IsValid() && "" == side.String() {
+			side.SetString(util.SideSell)
+		}
+	}
+
+	canonical, err := json.Marshal(queryValue.Interface())
+	if nil != err {
+		return nil, "", scope, err
+	}
+	json.Unmarshal(canonical, &scope)
+	return queryValue.Interface(), string(canonical), scope, nil
+}
+
+// subscribe adds conn to the group of query, the query subscribed before by conn for the event is replaced
+func (h *pushHub) subscribe(conn socketio.Conn, eventKey string, query interface{}, ctx string, scope pushScope) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.unsubscribeLocked(conn.ID(), eventKey)
+
+	key := pushKey{eventKey: eventKey, ctx: ctx}
+	group, exists := h.groups[key]
+	if !exists {
+		group = &pushGroup{key: key, scope: scope, query: query, conns: make(map[string]socketio.Conn)}
+		h.groups[key] = group
+		if _, exists := h.scopes[eventKey]; !exists {
+			h.scopes[eventKey] = make(map[string]map[pushKey]bool)
+		}
+		if _, exists := h.scopes[eventKey][scope.key()]; !exists {
+			h.scopes[eventKey][scope.key()] = make(map[pushKey]bool)
+		}
+		h.scopes[eventKey][scope.key()][key] = true
+	}
+	group.conns[conn.ID()] = conn
+	if _, exists := h.connGroups[conn.ID()]; !exists {
+		h.connGroups[conn.ID()] = make(map[string]pushKey)
+	}
+	h.connGroups[conn.ID()][eventKey] = key
+}
+
+func (h *pushHub) unsubscribe(connId, eventKey string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.unsubscribeLocked(connId, eventKey)
+}
+
+// remove unsubscribes all the events of the connection
+func (h *pushHub) remove(connId string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for eventKey := range h.connGroups[connId] {
+		h.unsubscribeLocked(connId, eventKey)
+	}
+	delete(h.connGroups, connId)
+}
+
+func (h *pushHub) unsubscribeLocked(connId, eventKey string) {
+	key, exists := h.connGroups[connId][eventKey]
+	if !exists {
+		return
+	}
+	delete(h.connGroups[connId], eventKey)
+	group := h.groups[key]
+	delete(group.conns, connId)
+	if len(group.conns) == 0 {
+		delete(h.groups, key)
+		delete(h.dirty, key)
+		scopeKey := group.scope.key()
+		delete(h.scopes[eventKey][scopeKey], key)
+		if len(h.scopes[eventKey][scopeKey]) == 0 {
+			delete(h.scopes[eventKey], scopeKey)
+		}
+	}
+}
+
+// markDirty marks the groups of the scope to be pushed, all the groups of the event are marked if mark.All is set
+func (h *pushHub) markDirty(mark pushMark) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	scope := mark.key()
+	for scopeKey, keys := range h.scopes[mark.EventKey] {
+		if !mark.All && scope != scopeKey {
+			continue
+		}
+		for key := range keys {
+			if mark.matches(h.groups[key]) {
+				h.dirty[key] = true
+			}
+		}
+	}
+}
+
+// takeDirty returns the groups marked since the last time, the connections of them are copied
+func (h *pushHub) takeDirty() []*pushGroup {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	groups := make([]*pushGroup, 0, len(h.dirty))
+	for key := range h.dirty {
+		groups = append(groups, h.groups[key].copy())
+	}
+	h.dirty = make(map[pushKey]bool)
+	return groups
+}
+
+// groupsOf returns the groups of the scope for the events pushed immediately, such as the depth diffs
+func (h *pushHub) groupsOf(eventKey, scope string) []*pushGroup {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	groups := make([]*pushGroup, 0, len(h.scopes[eventKey][scope]))
+	for key := range h.scopes[eventKey][scope] {
+		groups = append(groups, h.groups[key].copy())
+	}
+	return groups
+}
+
+// queries returns the queries subscribed for the event
+func (h *pushHub) queries(eventKey string) []interface{} {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	queries := []interface{}{}
+	for _, keys := range h.scopes[eventKey] {
+		for key := range keys {
+			queries = append(queries, h.groups[key].query)
+		}
+	}
+	return queries
+}
+
+func (g *pushGroup) copy() *pushGroup {
+	c := &pushGroup{key: g.key, scope: g.scope, query: g.query}
+	c.conns = make(map[string]socketio.Conn, len(g.conns))
+	for id, conn := range g.conns {
+		c.conns[id] = conn
+	}
+	return c
+}
@@ -0,0 +1,147 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"testing"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/googollee/go-socket.io"
+)
+
+type pushTestConn struct {
+	socketio.Conn
+	id string
+}
+
+func (c *pushTestConn) ID() string {
+	return c.id
+}
+
+func subscribeForTest(t *testing.T, hub *pushHub, conn socketio.Conn, eventKey, msg string) interface{} {
+	query, ctx, scope, err := parsePushQuery(eventKey, EventTypeRoute[eventKey], msg)
+	if nil != err {
+		t.Fatal(err)
+	}
+	hub.subscribe(conn, eventKey, query, ctx, scope)
+	return query
+}
+
+func TestPushHubCoalesce(t *testing.T) {
+	hub := newPushHub()
+	a, b := &pushTestConn{id: "a"}, &pushTestConn{id: "b"}
+	subscribeForTest(t, hub, a, eventKeyDepth, `{"delegateAddress":"0x17233E07c67d086464fD408148c3ABB56245FA64","market":"lrc-weth"}`)
+	subscribeForTest(t, hub, b, eventKeyDepth, `{"market":"LRC-WETH","delegateAddress":"0x17233e07c67d086464fd408148c3abb56245fa64"}`)
+
+	scope := pushScope{DelegateAddress: "0x17233E07c67d086464fD408148c3ABB56245FA64", Market: "lrc-weth"}
+	hub.markDirty(pushMark{EventKey: eventKeyDepth, pushScope: scope})
+	hub.markDirty(pushMark{EventKey: eventKeyDepth, pushScope: scope})
+	scope.Market = "RDN-WETH"
+	hub.markDirty(pushMark{EventKey: eventKeyDepth, pushScope: scope})
+
+	groups := hub.takeDirty()
+	if len(groups) != 1 || len(groups[0].conns) != 2 {
+		t.Fatalf("the same queries should be pushed once for all the connections, got:%d", len(groups))
+	}
+	if groups := hub.takeDirty(); len(groups) != 0 {
+		t.Errorf("the groups should be taken only once, got:%d", len(groups))
+	}
+
+	hub.remove("a")
+	hub.unsubscribe("b", eventKeyDepth)
+	hub.markDirty(pushMark{EventKey: eventKeyDepth, All: true})
+	if len(hub.groups) != 0 || len(hub.takeDirty()) != 0 {
+		t.Errorf("the groups without connections should be removed, got:%d", len(hub.groups))
+	}
+}
+
+func TestPushHubScope(t *testing.T) {
+	hub := newPushHub()
+	conn := &pushTestConn{id: "a"}
+	owner := "0x1B978a1D302335a6F2Ebe4B8823B5E17c3C84135"
+	subscribeForTest(t, hub, conn, eventKeyBalance, `{"owner":"`+owner+`","delegateAddress":"0x17233e07c67d086464fd408148c3abb56245fa64"}`)
+
+	hub.markDirty(pushMark{EventKey: eventKeyBalance, pushScope: pushScope{Owner: owner, DelegateAddress: "0x5567EE920f7E62274284985D793344351A00142B"}})
+	if groups := hub.takeDirty(); len(groups) != 0 {
+		t.Errorf("the balance of other delegate shouldn't be pushed, got:%d", len(groups))
+	}
+	hub.markDirty(pushMark{EventKey: eventKeyBalance, pushScope: pushScope{Owner: owner}})
+	if groups := hub.takeDirty(); len(groups) != 1 {
+		t.Errorf("the balance of owner should be pushed, got:%d", len(groups))
+	}
+
+	query := subscribeForTest(t, hub, conn, eventKeyTrades, `{"delegateAddress":"0x17233e07c67d086464fd408148c3abb56245fa64","market":"LRC-WETH"}`)
+	if util.SideSell != query.(FillQuery).Side {
+		t.Errorf("the trades should be of sell side, got:%s", query.(FillQuery).Side)
+	}
+	subscribeForTest(t, hub, conn, eventKeyTrades, `{"delegateAddress":"0x17233e07c67d086464fd408148c3abb56245fa64","market":"RDN-WETH"}`)
+	if queries := hub.queries(eventKeyTrades); len(queries) != 1 || "RDN-WETH" != queries[0].(FillQuery).Market {
+		t.Errorf("the query subscribed again should replace the previous one, got:%v", queries)
+	}
+}
+
+type pushTestAdapter struct {
+	handles []func(data []byte)
+}
+
+func (a *pushTestAdapter) Publish(data []byte) error {
+	for _, handle := range a.handles {
+		handle(data)
+	}
+	return nil
+}
+
+func (a *pushTestAdapter) Subscribe(handle func(data []byte)) (func(), error) {
+	a.handles = append(a.handles, handle)
+	return func() {}, nil
+}
+
+func TestPushAdapterShare(t *testing.T) {
+	adapter := &pushTestAdapter{}
+	relays := []*SocketIOServiceImpl{}
+	for _, source := range []string{"relay0", "relay1"} {
+		so := NewSocketIOService(config.WebsocketOptions{}, WalletServiceImpl{})
+		so.source = source
+		so.adapter = adapter
+		if err := so.subscribeAdapter(); nil != err {
+			t.Fatal(err)
+		}
+		relays = append(relays, so)
+	}
+	owner := common.HexToAddress("0x1B978a1D302335a6F2Ebe4B8823B5E17c3C84135")
+	delegateAddress := common.HexToAddress("0x17233e07c67d086464fd408148c3abb56245fa64")
+	subscribeForTest(t, relays[1].hub, &pushTestConn{id: "a"}, eventKeyBalance, `{"owner":"`+owner.Hex()+`","delegateAddress":"`+delegateAddress.Hex()+`"}`)
+	subscribeForTest(t, relays[1].hub, &pushTestConn{id: "b"}, eventKeyTrades, `{"delegateAddress":"`+delegateAddress.Hex()+`","market":"LRC-WETH"}`)
+
+	relays[0].handleBalanceUpdate(types.BalanceUpdateEvent{Owner: owner.Hex(), DelegateAddress: "0x5567EE920f7E62274284985D793344351A00142B"})
+	if groups := relays[1].hub.takeDirty(); len(groups) != 0 {
+		t.Errorf("the balance of other delegate shouldn't be pushed, got:%d", len(groups))
+	}
+	relays[0].handleBalanceUpdate(types.BalanceUpdateEvent{Owner: owner.Hex(), DelegateAddress: delegateAddress.Hex()})
+	filled := &types.OrderFilledEvent{Owner: owner, Market: "LRC-WETH"}
+	filled.DelegateAddress = delegateAddress
+	filled.Status = types.TX_STATUS_SUCCESS
+	relays[0].handleOrderFilled(filled)
+	if groups := relays[1].hub.takeDirty(); len(groups) != 2 {
+		t.Errorf("the events handled by the other relay should be pushed, got:%d", len(groups))
+	}
+}
@@ -0,0 +1,122 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/Loopring/relay/types"
+)
+
+// error codes of the gateway filters
+const (
+	PRICE_60000      = "60000"
+	EXIST_60003      = "60003"
+	POW_60001        = "60001"
+	POW_60002        = "60002"
+	BASE_60101       = "60101" //MinLrcHold
+	BASE_60102       = "60102" //address or hash length
+	BASE_60103       = "60103" //tokenS == tokenB
+	BASE_60104       = "60104" //MaxPrice
+	BASE_60105       = "60105" //MaxValidSinceInterval
+	BASE_60106       = "60106" //ValidUntil
+	BASE_60107       = "60107" //MinSplitPercentage and MaxSplitPercentage
+	BASE_60108       = "60108" //unsupported tokenS
+	BASE_60109       = "60109" //MinTokeSAmount
+	BASE_60110       = "60110" //price of tokenS
+	BASE_60111       = "60111" //MinTokenSUsdAmount
+	SIGN_60201       = "60201"
+	SIGN_60202       = "60202"
+	TOKEN_60301      = "60301"
+	TOKEN_60302      = "60302"
+	CUTOFF_60401     = "60401"
+	BALANCE_60501    = "60501" //balance
+	BALANCE_60502    = "60502" //allowance
+	BALANCE_60503    = "60503" //failed to get balance and allowance
+	OPEN_ORDER_60601 = "60601" //MaxOpenOrders
+	OPEN_ORDER_60602 = "60602" //failed to count the open orders
+	TOKEN_DENY_60701 = "60701" //tokenS
+	TOKEN_DENY_60702 = "60702" //tokenB
+)
+
+// FilterError is returned by the filters, the message is the same as before so HandleInputOrder isn't changed
+type FilterError struct {
+	Code      string
+	Threshold string
+	msg       string
+}
+
+func (e *FilterError) Error() string {
+	return e.msg
+}
+
+func newFilterError(code, threshold string, format string, args ...interface{}) *FilterError {
+	return &FilterError{Code: code, Threshold: threshold, msg: fmt.Sprintf(format, args...)}
+}
+
+type FilterResultJson struct {
+	Filter    string `json:"filter"`
+	Passed    bool   `json:"passed"`
+	Code      string `json:"code"`
+	Error     string `json:"error"`
+	Threshold string `json:"threshold"`
+}
+
+type OrderValidationJson struct {
+	OrderHash string             `json:"orderHash"`
+	Valid     bool               `json:"valid"`
+	Results   []FilterResultJson `json:"results"`
+}
+
+// ValidateOrder runs all the filters without stopping at the first failure, nothing is saved or emitted
+func ValidateOrder(order *types.Order) OrderValidationJson {
+	order.Hash = order.GenerateHash()
+	res := OrderValidationJson{OrderHash: order.Hash.Hex(), Valid: true, Results: []FilterResultJson{}}
+
+	addResult := func(name string, err error) {
+		result := FilterResultJson{Filter: name, Passed: nil == err}
+		if nil != err {
+			res.Valid = false
+			result.Error = err.Error()
+			if filterErr, ok := err.(*FilterError); ok {
+				result.Code = filterErr.Code
+				result.Threshold = filterErr.Threshold
+			}
+		}
+		res.Results = append(res.Results, result)
+	}
+
+	if _, err := gateway.om.GetOrderByHash(order.Hash); nil == err {
+		addResult("Exist", newFilterError(EXIST_60003, "", "order existed, please not submit again"))
+	} else {
+		addResult("Exist", nil)
+	}
+
+	if err := generatePrice(order); nil != err {
+		addResult("Price", newFilterError(PRICE_60000, "", "%s", err.Error()))
+	} else {
+		addResult("Price", nil)
+	}
+
+	for _, v := range gateway.filters {
+		_, err := v.Filter(order)
+		addResult(v.Name(), err)
+	}
+	return res
+}
@@ -0,0 +1,160 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/ordermanager"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type stubFilter struct {
+	name  string
+	err   error
+	calls *[]string
+}
+
+func (f *stubFilter) Name() string {
+	return f.name
+}
+
+func (f *stubFilter) Filter(o *types.Order) (bool, error) {
+	*f.calls = append(*f.calls, f.name)
+	return nil == f.err, f.err
+}
+
+// existOrderManager only implements GetOrderByHash, saving the order panics through the nil OrderManager
+type existOrderManager struct {
+	ordermanager.OrderManager
+	exists bool
+}
+
+func (om *existOrderManager) GetOrderByHash(hash common.Hash) (*types.OrderState, error) {
+	if om.exists {
+		return &types.OrderState{}, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func describeResults(res OrderValidationJson) string {
+	results := []string{}
+	for _, result := range res.Results {
+		results = append(results, fmt.Sprintf("%s:%t:%s:%s", result.Filter, result.Passed, result.Code, result.Threshold))
+	}
+	return strings.Join(results, ",")
+}
+
+func TestValidateOrder(t *testing.T) {
+	allTokens := util.AllTokens
+	saved := gateway
+	defer func() {
+		util.AllTokens = allTokens
+		gateway = saved
+	}()
+	util.AllTokens = map[string]types.Token{
+		"LRC":  {Protocol: filterTestLrc, Symbol: "LRC", Decimals: big.NewInt(1e18)},
+		"WETH": {Protocol: filterTestWeth, Symbol: "WETH", Decimals: big.NewInt(1e18)},
+	}
+
+	emitted := 0
+	watcher := &eventemitter.Watcher{Concurrent: false, Handle: func(eventData eventemitter.EventData) error {
+		emitted++
+		return nil
+	}}
+	eventemitter.On(eventemitter.GatewayNewOrder, watcher)
+	defer eventemitter.Un(eventemitter.GatewayNewOrder, watcher)
+
+	tests := []struct {
+		name    string
+		exists  bool
+		tokenB  common.Address
+		filters []*stubFilter
+		valid   bool
+		results string
+	}{
+		{
+			name:    "valid",
+			tokenB:  filterTestWeth,
+			filters: []*stubFilter{{name: "A"}, {name: "B"}},
+			valid:   true,
+			results: "Exist:true::,Price:true::,A:true::,B:true::",
+		},
+		{
+			name:   "all filters run after a failure",
+			tokenB: filterTestWeth,
+			filters: []*stubFilter{
+				{name: "A", err: newFilterError(BALANCE_60501, "MinRatio:1.000000", "balance")},
+				{name: "B"},
+				{name: "C", err: newFilterError(OPEN_ORDER_60601, "MaxOpenOrders:3", "open orders")},
+				{name: "D", err: errors.New("not a filter error")},
+			},
+			results: "Exist:false:" + EXIST_60003 + ":,Price:true::,A:false:60501:MinRatio:1.000000,B:true::,C:false:60601:MaxOpenOrders:3,D:false::",
+			exists:  true,
+		},
+		{
+			name:    "failed to generate price",
+			tokenB:  common.HexToAddress("0x0123"),
+			filters: []*stubFilter{{name: "A"}},
+			results: "Exist:true::,Price:false:" + PRICE_60000 + ":,A:true::",
+		},
+	}
+
+	for _, test := range tests {
+		calls := []string{}
+		gateway = Gateway{om: &existOrderManager{exists: test.exists}}
+		names := []string{}
+		for _, f := range test.filters {
+			f.calls = &calls
+			gateway.filters = append(gateway.filters, f)
+			names = append(names, f.name)
+		}
+
+		order := &types.Order{TokenS: filterTestLrc, TokenB: test.tokenB, AmountS: big.NewInt(1000), AmountB: big.NewInt(1), ValidSince: big.NewInt(1), ValidUntil: big.NewInt(2), LrcFee: big.NewInt(1)}
+		res := ValidateOrder(order)
+		if res.Valid != test.valid {
+			t.Errorf("%s: valid should be %t", test.name, test.valid)
+		}
+		if results := describeResults(res); results != test.results {
+			t.Errorf("%s: results should be %s, got:%s", test.name, test.results, results)
+		}
+		if strings.Join(calls, ",") != strings.Join(names, ",") {
+			t.Errorf("%s: all the filters should be run in order, got:%v", test.name, calls)
+		}
+		if res.OrderHash != order.GenerateHash().Hex() {
+			t.Errorf("%s: the order hash should be reported", test.name)
+		}
+		for _, result := range res.Results {
+			if !result.Passed && "" == result.Error {
+				t.Errorf("%s: the error of %s should be reported", test.name, result.Filter)
+			}
+		}
+	}
+
+	if 0 != emitted {
+		t.Errorf("the validated orders shouldn't be emitted, got:%d", emitted)
+	}
+}
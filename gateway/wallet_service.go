@@ -29,6 +29,7 @@ import (
 	"github.com/Loopring/relay/market"
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/miner"
 	"github.com/Loopring/relay/ordermanager"
 	"github.com/Loopring/relay/txmanager"
 	txtyp "github.com/Loopring/relay/txmanager/types"
@@ -70,6 +71,8 @@ type PageResult struct {
 type Depth struct {
 	DelegateAddress string `json:"delegateAddress"`
 	Market          string `json:"market"`
+	Precision       int    `json:"precision"`
+	Sequence        int64  `json:"sequence"`
 	Depth           AskBid `json:"depth"`
 }
 
@@ -102,6 +105,15 @@ type TrendQuery struct {
 	Interval string `json:"interval"`
 }
 
+// CandleQuery queries the candles started in [From, To], the times are unix seconds
+// and To defaults to now.
+type CandleQuery struct {
+	Market     string `json:"market"`
+	Resolution string `json:"resolution"`
+	From       int64  `json:"from"`
+	To         int64  `json:"to"`
+}
+
 type SingleOwner struct {
 	Owner string `json:"owner"`
 }
@@ -159,9 +171,13 @@ type OrderQuery struct {
 	OrderType       string `json:"orderType"`
 }
 
+// DepthQuery queries the first Length levels of depth, the prices of levels are aggregated with
+// Precision decimals. The defaults of market are used if they're 0.
 type DepthQuery struct {
 	DelegateAddress string `json:"delegateAddress"`
 	Market          string `json:"market"`
+	Length          int    `json:"length"`
+	Precision       int    `json:"precision"`
 }
 
 type FillQuery struct {
@@ -282,6 +298,36 @@ type P2PRingRequest struct {
 	MakerOrderHash string                  `json:"makerOrderHash"`
 }
 
+type SimulateRingQuery struct {
+	OrderHashes []string `json:"orderHashes"`
+}
+
+type SimulatedFillJsonResult struct {
+	OrderHash    string `json:"orderHash"`
+	FillAmountS  string `json:"fillAmountS"`
+	FillAmountB  string `json:"fillAmountB"`
+	LrcFee       string `json:"lrcFee"`
+	LrcReward    string `json:"lrcReward"`
+	FeeS         string `json:"feeS"`
+	FeeSelection uint8  `json:"feeSelection"`
+}
+
+type RingSimulationJsonResult struct {
+	RingHash     string                    `json:"ringHash"`
+	Success      bool                      `json:"success"`
+	Err          string                    `json:"err"`
+	Fills        []SimulatedFillJsonResult `json:"fills"`
+	LegalFee     string                    `json:"legalFee"`
+	Gas          string                    `json:"gas"`
+	EstimatedGas string                    `json:"estimatedGas"`
+	GasPrice     string                    `json:"gasPrice"`
+}
+
+//it's implemented by the miner, it's nil if the node doesn't mine
+type RingSimulator interface {
+	SimulateRing(orders []*types.OrderState) (*miner.RingSimulation, error)
+}
+
 type WalletServiceImpl struct {
 	trendManager    market.TrendManager
 	orderManager    ordermanager.OrderManager
@@ -290,6 +336,8 @@ type WalletServiceImpl struct {
 	tickerCollector market.CollectorImpl
 	rds             dao.RdsService
 	oldWethAddress  string
+	ringSimulator   RingSimulator
+	depthFeed       *DepthFeed
 }
 
 func NewWalletService(trendManager market.TrendManager, orderManager ordermanager.OrderManager, accountManager market.AccountManager,
@@ -302,8 +350,15 @@ func NewWalletService(trendManager market.TrendManager, orderManager ordermanage
 	w.tickerCollector = collector
 	w.rds = rds
 	w.oldWethAddress = oldWethAddress
+	w.depthFeed = newDepthFeed(w.calculateBook)
+	depthWatcher := &eventemitter.Watcher{Concurrent: true, Handle: w.depthFeed.handleDepthUpdated}
+	eventemitter.On(eventemitter.DepthUpdated, depthWatcher)
 	return w
 }
+func (w *WalletServiceImpl) SetRingSimulator(simulator RingSimulator) {
+	w.ringSimulator = simulator
+}
+
 func (w *WalletServiceImpl) TestPing(input int) (resp []byte, err error) {
 
 	var res string
@@ -488,6 +543,32 @@ func (w *WalletServiceImpl) SubmitOrder(order *types.OrderJsonRequest) (res stri
 	return HandleInputOrder(types.ToOrder(order))
 }
 
+// SubmitOrders returns the result of each order in the same order, it fails only if the batch is invalid
+func (w *WalletServiceImpl) SubmitOrders(orders []*types.OrderJsonRequest) (res []OrderSubmitResult, err error) {
+	inputs := []*types.Order{}
+	for _, order := range orders {
+		if order.OrderType != types.ORDER_TYPE_MARKET && order.OrderType != types.ORDER_TYPE_P2P {
+			order.OrderType = types.ORDER_TYPE_MARKET
+		}
+		inputs = append(inputs, types.ToOrder(order))
+	}
+	return HandleInputOrders(inputs)
+}
+
+// SoftCancelOrders stops matching the orders before they're cancelled on chain
+func (w *WalletServiceImpl) SoftCancelOrders(req SoftCancelRequest) (res SoftCancelResult, err error) {
+	return SoftCancelOrders(&req)
+}
+
+func (w *WalletServiceImpl) ValidateOrder(order *types.OrderJsonRequest) (res OrderValidationJson, err error) {
+
+	if order.OrderType != types.ORDER_TYPE_MARKET && order.OrderType != types.ORDER_TYPE_P2P {
+		order.OrderType = types.ORDER_TYPE_MARKET
+	}
+
+	return ValidateOrder(types.ToOrder(order)), nil
+}
+
 func (w *WalletServiceImpl) GetOrders(query *OrderQuery) (res PageResult, err error) {
 	orderQuery, statusList, pi, ps := convertFromQuery(query)
 	queryRst, err := w.orderManager.GetOrders(orderQuery, statusList, pi, ps)
@@ -561,9 +642,31 @@ func (w *WalletServiceImpl) SubmitRingForP2P(p2pRing P2PRingRequest) (res string
 	return txHashRst, nil
 }
 
-func (w *WalletServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
+func (w *WalletServiceImpl) SimulateRing(query SimulateRingQuery) (res RingSimulationJsonResult, err error) {
+	if nil == w.ringSimulator {
+		return res, errors.New("ring simulation is only supported by the miner")
+	}
+	if len(query.OrderHashes) < 2 {
+		return res, errors.New("a ring needs two order hashes at least")
+	}
+
+	orders := []*types.OrderState{}
+	for _, orderHash := range query.OrderHashes {
+		state, err := w.orderManager.GetOrderByHash(common.HexToHash(orderHash))
+		if err != nil {
+			return res, errors.New("order not found:" + orderHash)
+		}
+		orders = append(orders, state)
+	}
 
-	defaultDepthLength := 50
+	simulation, err := w.ringSimulator.SimulateRing(orders)
+	if err != nil {
+		return res, err
+	}
+	return ringSimulationToJson(simulation), nil
+}
+
+func (w *WalletServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
 
 	mkt := strings.ToUpper(query.Market)
 	delegateAddress := query.DelegateAddress
@@ -581,40 +684,49 @@ func (w *WalletServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
 		return
 	}
 
-	empty := make([][]string, 0)
-
-	for i := range empty {
-		empty[i] = make([]string, 0)
+	length, precision, err := depthOptionsOf(mkt, query.Length, query.Precision)
+	if err != nil {
+		return
 	}
-	askBid := AskBid{Buy: empty, Sell: empty}
-	depth := Depth{DelegateAddress: delegateAddress, Market: mkt, Depth: askBid}
 
-	//(TODO) 考虑到需要聚合的情况，所以每次取2倍的数据，先聚合完了再cut, 不是完美方案，后续再优化
+	key := depthKey{delegateAddress: common.HexToAddress(delegateAddress).Hex(), market: mkt, precision: precision}
+	askBid, sequence, err := w.depthFeed.Snapshot(key, length)
+	depth := Depth{DelegateAddress: delegateAddress, Market: mkt, Precision: precision, Sequence: sequence, Depth: askBid}
+	return depth, err
+}
+
+// calculateBook aggregates the orders of the book into levels, it's called by the depth feed
+func (w *WalletServiceImpl) calculateBook(key depthKey, length int) (askBid AskBid, err error) {
+
+	a, b := util.UnWrap(key.market)
+	askBid = AskBid{Buy: [][]string{}, Sell: [][]string{}}
+
+	//the orders are aggregated before they are cut, so more orders are fetched than the levels
 	asks, askErr := w.orderManager.GetOrderBook(
-		common.HexToAddress(delegateAddress),
+		common.HexToAddress(key.delegateAddress),
 		util.AllTokens[a].Protocol,
-		util.AllTokens[b].Protocol, defaultDepthLength*2)
+		util.AllTokens[b].Protocol, length*depthOrdersPerLevel)
 
 	if askErr != nil {
 		err = errors.New("get depth error , please refresh again")
 		return
 	}
 
-	depth.Depth.Sell = w.calculateDepth(asks, defaultDepthLength, true, util.AllTokens[a].Decimals, util.AllTokens[b].Decimals)
+	askBid.Sell = w.calculateDepth(asks, length, key.precision, true, util.AllTokens[a].Decimals, util.AllTokens[b].Decimals)
 
 	bids, bidErr := w.orderManager.GetOrderBook(
-		common.HexToAddress(delegateAddress),
+		common.HexToAddress(key.delegateAddress),
 		util.AllTokens[b].Protocol,
-		util.AllTokens[a].Protocol, defaultDepthLength*2)
+		util.AllTokens[a].Protocol, length*depthOrdersPerLevel)
 
 	if bidErr != nil {
 		err = errors.New("get depth error , please refresh again")
 		return
 	}
 
-	depth.Depth.Buy = w.calculateDepth(bids, defaultDepthLength, false, util.AllTokens[b].Decimals, util.AllTokens[a].Decimals)
+	askBid.Buy = w.calculateDepth(bids, length, key.precision, false, util.AllTokens[b].Decimals, util.AllTokens[a].Decimals)
 
-	return depth, err
+	return askBid, err
 }
 
 func (w *WalletServiceImpl) GetFills(query FillQuery) (dao.PageResult, error) {
@@ -672,6 +784,10 @@ func (w *WalletServiceImpl) GetTrend(query TrendQuery) (res []market.Trend, err
 	return
 }
 
+func (w *WalletServiceImpl) GetCandles(query CandleQuery) (res []market.Candle, err error) {
+	return w.trendManager.GetCandles(query.Market, query.Resolution, query.From, query.To)
+}
+
 func (w *WalletServiceImpl) GetRingMined(query RingMinedQuery) (res dao.PageResult, err error) {
 	return w.orderManager.RingMinedPageQuery(ringMinedQueryToMap(query))
 }
@@ -941,6 +1057,8 @@ func convertStatus(s string) []types.OrderStatus {
 		return []types.OrderStatus{types.ORDER_CUTOFF}
 	case "ORDER_EXPIRE":
 		return []types.OrderStatus{types.ORDER_EXPIRE}
+	case "ORDER_SOFT_CANCELLED":
+		return []types.OrderStatus{types.ORDER_SOFT_CANCEL}
 	}
 	return []types.OrderStatus{}
 }
@@ -971,11 +1089,13 @@ func getStringStatus(order types.OrderState) string {
 		return "ORDER_PENDING"
 	case types.ORDER_EXPIRE:
 		return "ORDER_EXPIRE"
+	case types.ORDER_SOFT_CANCEL:
+		return "ORDER_SOFT_CANCELLED"
 	}
 	return "ORDER_UNKNOWN"
 }
 
-func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int, isAsk bool, tokenSDecimal, tokenBDecimal *big.Int) [][]string {
+func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length, precision int, isAsk bool, tokenSDecimal, tokenBDecimal *big.Int) [][]string {
 
 	if len(states) == 0 {
 		return [][]string{}
@@ -1045,7 +1165,7 @@ func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int
 
 		if isAsk {
 			price = *price.Inv(&price)
-			priceFloatStr := price.FloatString(10)
+			priceFloatStr := roundPrice(&price, precision, true)
 			if v, ok := depthMap[priceFloatStr]; ok {
 				amount := v.Amount
 				size := v.Size
@@ -1056,7 +1176,7 @@ func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int
 				depthMap[priceFloatStr] = DepthElement{Price: priceFloatStr, Amount: minAmountS, Size: minAmountB}
 			}
 		} else {
-			priceFloatStr := price.FloatString(10)
+			priceFloatStr := roundPrice(&price, precision, false)
 			if v, ok := depthMap[priceFloatStr]; ok {
 				amount := v.Amount
 				size := v.Size
@@ -1075,26 +1195,8 @@ func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int
 		depth = append(depth, []string{k, strconv.FormatFloat(amount, 'f', 10, 64), strconv.FormatFloat(size, 'f', 10, 64)})
 	}
 
-	sort.Slice(depth, func(i, j int) bool {
-		cmpA, _ := strconv.ParseFloat(depth[i][0], 64)
-		cmpB, _ := strconv.ParseFloat(depth[j][0], 64)
-		//if isAsk {
-		//	return cmpA < cmpB
-		//} else {
-		//	return cmpA > cmpB
-		//}
-		return cmpA > cmpB
-
-	})
-
-	if length < len(depth) {
-		if isAsk {
-			return depth[len(depth)-length-1:]
-		} else {
-			return depth[:length]
-		}
-	}
-	return depth
+	sortDepth(depth)
+	return cutDepth(depth, length, isAsk)
 }
 
 func (w *WalletServiceImpl) getAvailableMinAmount(depthAmount *big.Rat, owner, token, spender common.Address, decimal *big.Int) (amount *big.Rat, err error) {
@@ -1209,6 +1311,42 @@ func buildOrderResult(src dao.PageResult) PageResult {
 	return rst
 }
 
+func ringSimulationToJson(src *miner.RingSimulation) RingSimulationJsonResult {
+	rst := RingSimulationJsonResult{}
+	info := src.RingSubmitInfo
+	rst.RingHash = info.Ringhash.Hex()
+	rst.Success = nil == src.Err
+	if nil != src.Err {
+		rst.Err = src.Err.Error()
+	}
+	rst.Fills = make([]SimulatedFillJsonResult, 0)
+	for _, filledOrder := range info.RawRing.Orders {
+		fill := SimulatedFillJsonResult{}
+		fill.OrderHash = filledOrder.OrderState.RawOrder.Hash.Hex()
+		fill.FillAmountS = ratToHex(filledOrder.FillAmountS)
+		fill.FillAmountB = ratToHex(filledOrder.FillAmountB)
+		fill.LrcFee = ratToHex(filledOrder.LrcFee)
+		fill.LrcReward = ratToHex(filledOrder.LrcReward)
+		fill.FeeS = ratToHex(filledOrder.FeeS)
+		fill.FeeSelection = filledOrder.FeeSelection
+		rst.Fills = append(rst.Fills, fill)
+	}
+	if nil != info.RawRing.LegalFee {
+		rst.LegalFee = info.RawRing.LegalFee.FloatString(2)
+	}
+	rst.Gas = types.BigintToHex(info.ProtocolGas)
+	rst.EstimatedGas = types.BigintToHex(src.EstimatedGas)
+	rst.GasPrice = types.BigintToHex(info.ProtocolGasPrice)
+	return rst
+}
+
+func ratToHex(amount *big.Rat) string {
+	if nil == amount {
+		return types.BigintToHex(big.NewInt(0))
+	}
+	return types.BigintToHex(new(big.Int).Quo(amount.Num(), amount.Denom()))
+}
+
 func orderStateToJson(src types.OrderState) OrderJsonResult {
 
 	rst := OrderJsonResult{}
@@ -1237,8 +1375,6 @@ func orderStateToJson(src types.OrderState) OrderJsonResult {
 	rawOrder.WalletAddress = src.RawOrder.WalletAddress.Hex()
 	rawOrder.AuthAddr = src.RawOrder.AuthAddr.Hex()
 	rawOrder.Market = src.RawOrder.Market
-	auth, _ := src.RawOrder.AuthPrivateKey.MarshalText()
-	rawOrder.AuthPrivateKey = string(auth)
 	rawOrder.CreateTime = src.RawOrder.CreateTime
 	rawOrder.Side = src.RawOrder.Side
 	rawOrder.OrderType = src.RawOrder.OrderType
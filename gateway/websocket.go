@@ -19,62 +19,813 @@
 package gateway
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/market"
-	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/market/util"
+	txtyp "github.com/Loopring/relay/txmanager/types"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gorilla/websocket"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WEBSOCKET_PATH            = "/ws"
+	DEFAULT_MAX_SUBSCRIPTIONS = 64
+
+	TOPIC_TICKERS      = "tickers"
+	TOPIC_TRENDS       = "trends"
+	TOPIC_DEPTH        = "depth"
+	TOPIC_TRADES       = "trades"
+	TOPIC_ORDERS       = "orders"
+	TOPIC_BALANCES     = "balances"
+	TOPIC_TRANSACTIONS = "transactions"
+
+	wsMethodSubscribe    = jsonrpcNamespace + "_subscribe"
+	wsMethodUnsubscribe  = jsonrpcNamespace + "_unsubscribe"
+	wsMethodNotification = jsonrpcNamespace + "_subscription"
+
+	wsWriteWait       = 10 * time.Second
+	wsPongWait        = 60 * time.Second
+	wsPingPeriod      = wsPongWait * 9 / 10
+	wsMaxMessageSize  = 4096
+	wsSendBufferSize  = 256             //the messages queued for a connection, the slow one is closed if it's full
+	wsDepthKeepPeriod = 5 * time.Minute //less than depthBookIdleTime, so the books subscribed aren't evicted
+)
+
+// the error codes of json-rpc 2.0, -32000 is the server error of too many subscriptions
+const (
+	wsParseError           = -32700
+	wsInvalidRequest       = -32600
+	wsMethodNotFound       = -32601
+	wsInvalidParams        = -32602
+	wsTooManySubscriptions = -32000
 )
 
 type WebsocketService interface {
-	Start(port string)
-	Stop()
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
 }
 
-type WebsocketServiceImpl struct {
-	port     string
-	upgrader websocket.Upgrader
+type wsRequest struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type wsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type wsResponse struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type wsErrorResponse struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Error   wsError         `json:"error"`
 }
 
-type WebsocketRequest map[string]string
+type wsNotification struct {
+	JsonRpc string               `json:"jsonrpc"`
+	Method  string               `json:"method"`
+	Params  wsNotificationParams `json:"params"`
+}
+
+type wsNotificationParams struct {
+	Subscription string      `json:"subscription"`
+	Topic        string      `json:"topic"`
+	Result       interface{} `json:"result"`
+}
 
-func NewWebsocketService(port string, trendManager market.TrendManager, accountManager market.AccountManager, capProvider marketcap.MarketCapProvider) *WebsocketServiceImpl {
+// wsSubscription holds the results pushed before its snapshot has been sent, so the snapshot is always
+// the first result of a subscription and the ones after it are sent in order.
+type wsSubscription struct {
+	id     string
+	topic  string
+	query  interface{}
+	client *wsClient
+
+	mtx     sync.Mutex
+	ready   bool
+	backlog []interface{}
+}
+
+func (s *wsSubscription) push(result interface{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.ready {
+		s.backlog = append(s.backlog, result)
+		return
+	}
+	s.client.notify(s, result)
+}
+
+// start sends the snapshot and the results held, the held ones are dropped if they're not after the snapshot
+func (s *wsSubscription) start(snapshot interface{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if nil != snapshot {
+		s.client.notify(s, snapshot)
+	}
+	for _, result := range s.backlog {
+		if isAfterSnapshot(snapshot, result) {
+			s.client.notify(s, result)
+		}
+	}
+	s.backlog = nil
+	s.ready = true
+}
+
+func isAfterSnapshot(snapshot, result interface{}) bool {
+	depth, isDepth := snapshot.(Depth)
+	diff, isDiff := result.(*DepthDiff)
+	if isDepth && isDiff {
+		return diff.Sequence > depth.Sequence
+	}
+	return true
+}
+
+type wsClient struct {
+	service       *WebsocketServiceImpl
+	conn          *websocket.Conn
+	send          chan []byte
+	done          chan struct{}
+	closeOnce     sync.Once
+	mtx           sync.Mutex
+	subscriptions map[string]*wsSubscription
+}
+
+// WebsocketServiceImpl serves the json-rpc 2.0 subscriptions over plain websocket, the results are pushed
+// when the events of emitter are received, such as DepthDiffUpdated, OrderFilled and BalanceUpdated.
+type WebsocketServiceImpl struct {
+	options       config.WebsocketOptions
+	walletService *WalletServiceImpl
+	upgrader      websocket.Upgrader
+	httpServer    *http.Server
+
+	mtx           sync.RWMutex
+	clients       map[*wsClient]bool
+	subscriptions map[string]map[string]*wsSubscription
+	watchers      map[string]*eventemitter.Watcher
+	stop          chan struct{}
+}
+
+func NewWebsocketService(options config.WebsocketOptions, walletService *WalletServiceImpl) *WebsocketServiceImpl {
 	l := &WebsocketServiceImpl{}
-	l.port = port
+	l.options = options
+	if l.options.MaxSubscriptions <= 0 {
+		l.options.MaxSubscriptions = DEFAULT_MAX_SUBSCRIPTIONS
+	}
+	l.walletService = walletService
 	l.upgrader = websocket.Upgrader{
-		CheckOrigin:     func(r *http.Request) bool { return true },
+		CheckOrigin:     l.checkOrigin,
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
+	l.clients = make(map[*wsClient]bool)
+	l.subscriptions = make(map[string]map[string]*wsSubscription)
+	//the depth diffs are handled in order, the others are queried again so the latest one is pushed anyway
+	l.watchers = map[string]*eventemitter.Watcher{
+		eventemitter.LoopringTickerUpdated: {Concurrent: true, Handle: l.handleTickerUpdated},
+		eventemitter.TrendUpdated:          {Concurrent: true, Handle: l.handleTrendUpdated},
+		eventemitter.DepthDiffUpdated:      {Concurrent: false, Handle: l.handleDepthDiffUpdated},
+		eventemitter.OrderFilled:           {Concurrent: true, Handle: l.handleOrderFilled},
+		eventemitter.OrderUpdated:          {Concurrent: true, Handle: l.handleOrderUpdated},
+		eventemitter.BalanceUpdated:        {Concurrent: true, Handle: l.handleBalanceUpdated},
+		eventemitter.TransactionEvent:      {Concurrent: true, Handle: l.handleTransactionEvent},
+	}
 	return l
 }
 
-func (ws *WebsocketServiceImpl) Start() {
+func (ws *WebsocketServiceImpl) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", ":"+ws.options.StreamPort)
+	if nil != err {
+		return err
+	}
+	for topic, watcher := range ws.watchers {
+		eventemitter.On(topic, watcher)
+	}
 
-	node := newSocketNode()
-	go node.run()
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		ws.serve(node, w, r)
-	})
+	ws.httpServer = &http.Server{Handler: ws.handler()}
+	ws.stop = make(chan struct{})
+	go func() {
+		if err := ws.httpServer.Serve(listener); nil != err && http.ErrServerClosed != err {
+			log.Errorf("websocket,serve err:%s", err.Error())
+		}
+	}()
+	go ws.keepDepths()
+	log.Infof("websocket,endpoint opened on %s%s", ws.options.StreamPort, WEBSOCKET_PATH)
+
+	return nil
+}
 
-	err := http.ListenAndServe(":"+ws.port, nil)
-	if err != nil {
-		log.Fatal("ListenAndServe Websocket Error : " + err.Error())
+// Stop stops pushing and closes the connections, the clients should subscribe again after reconnected
+func (ws *WebsocketServiceImpl) Stop(ctx context.Context) error {
+	if nil == ws.httpServer {
+		return nil
+	}
+	for topic, watcher := range ws.watchers {
+		eventemitter.Un(topic, watcher)
 	}
+	close(ws.stop)
+	err := ws.httpServer.Shutdown(ctx)
 
-	return
+	ws.mtx.RLock()
+	clients := make([]*wsClient, 0, len(ws.clients))
+	for client := range ws.clients {
+		clients = append(clients, client)
+	}
+	ws.mtx.RUnlock()
+	for _, client := range clients {
+		client.close()
+	}
+	return err
 }
 
-func (ws *WebsocketServiceImpl) serve(node *SocketNode, w http.ResponseWriter, r *http.Request) {
+func (ws *WebsocketServiceImpl) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if "" == origin || len(ws.options.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range ws.options.AllowedOrigins {
+		if "*" == allowed || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ws *WebsocketServiceImpl) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(WEBSOCKET_PATH, ws.serve)
+	return mux
+}
+
+func (ws *WebsocketServiceImpl) serve(w http.ResponseWriter, r *http.Request) {
 	conn, err := ws.upgrader.Upgrade(w, r, nil)
-	fmt.Print(conn.LocalAddr())
-	if err != nil {
-		log.Error("get ws connection error , " + err.Error())
+	if nil != err {
+		log.Debugf("websocket,upgrade connection from %s err:%s", r.RemoteAddr, err.Error())
 		return
 	}
-	client := &SocketClient{node: node, conn: conn}
-	client.node.register <- client
+	client := &wsClient{}
+	client.service = ws
+	client.conn = conn
+	client.send = make(chan []byte, wsSendBufferSize)
+	client.done = make(chan struct{})
+	client.subscriptions = make(map[string]*wsSubscription)
+
+	ws.mtx.Lock()
+	ws.clients[client] = true
+	ws.mtx.Unlock()
+
 	go client.write()
 	go client.read()
 }
+
+func (ws *WebsocketServiceImpl) addSubscription(sub *wsSubscription) {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+	if _, exists := ws.subscriptions[sub.topic]; !exists {
+		ws.subscriptions[sub.topic] = make(map[string]*wsSubscription)
+	}
+	ws.subscriptions[sub.topic][sub.id] = sub
+}
+
+func (ws *WebsocketServiceImpl) removeSubscription(sub *wsSubscription) {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+	delete(ws.subscriptions[sub.topic], sub.id)
+}
+
+func (ws *WebsocketServiceImpl) removeClient(client *wsClient) {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+	delete(ws.clients, client)
+	for _, sub := range client.subscriptions {
+		delete(ws.subscriptions[sub.topic], sub.id)
+	}
+}
+
+func (ws *WebsocketServiceImpl) subscriptionsOf(topic string) []*wsSubscription {
+	ws.mtx.RLock()
+	defer ws.mtx.RUnlock()
+	subs := make([]*wsSubscription, 0, len(ws.subscriptions[topic]))
+	for _, sub := range ws.subscriptions[topic] {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// keepDepths snapshots the depths subscribed periodically, otherwise their books are evicted from the feed
+// and the diffs aren't emitted anymore
+func (ws *WebsocketServiceImpl) keepDepths() {
+	ticker := time.NewTicker(wsDepthKeepPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.stop:
+			return
+		case <-ticker.C:
+			keys := make(map[depthKey]bool)
+			for _, sub := range ws.subscriptionsOf(TOPIC_DEPTH) {
+				keys[depthKeyOf(sub.query.(DepthQuery))] = true
+			}
+			for key := range keys {
+				if _, _, err := ws.walletService.depthFeed.Snapshot(key, 1); nil != err {
+					log.Errorf("websocket,keep depth of %s err:%s", key.market, err.Error())
+				}
+			}
+		}
+	}
+}
+
+// parseQuery validates the query of topic and normalizes it, so it can be matched with the events directly
+func (ws *WebsocketServiceImpl) parseQuery(topic string, data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+	switch topic {
+	case TOPIC_TICKERS:
+		query := SingleMarket{}
+		if err := json.Unmarshal(data, &query); nil != err {
+			return nil, err
+		}
+		query.Market = strings.ToUpper(query.Market)
+		if "" != query.Market && !util.IsSupportedMarket(query.Market) {
+			return nil, fmt.Errorf("unsupported market:%s", query.Market)
+		}
+		return query, nil
+	case TOPIC_TRENDS:
+		query := TrendQuery{}
+		if err := json.Unmarshal(data, &query); nil != err {
+			return nil, err
+		}
+		query.Market = strings.ToUpper(query.Market)
+		if !util.IsSupportedMarket(query.Market) {
+			return nil, fmt.Errorf("unsupported market:%s", query.Market)
+		}
+		if "" == query.Interval {
+			query.Interval = market.OneHour
+		}
+		return query, nil
+	case TOPIC_DEPTH:
+		query := DepthQuery{}
+		if err := json.Unmarshal(data, &query); nil != err {
+			return nil, err
+		}
+		if err := normalizeMarketQuery(&query.DelegateAddress, &query.Market); nil != err {
+			return nil, err
+		}
+		length, precision, err := depthOptionsOf(query.Market, query.Length, query.Precision)
+		if nil != err {
+			return nil, err
+		}
+		query.Length, query.Precision = length, precision
+		return query, nil
+	case TOPIC_TRADES:
+		query := FillQuery{}
+		if err := json.Unmarshal(data, &query); nil != err {
+			return nil, err
+		}
+		if err := normalizeMarketQuery(&query.DelegateAddress, &query.Market); nil != err {
+			return nil, err
+		}
+		return FillQuery{DelegateAddress: query.DelegateAddress, Market: query.Market}, nil
+	case TOPIC_ORDERS:
+		query := OrderQuery{}
+		if err := json.Unmarshal(data, &query); nil != err {
+			return nil, err
+		}
+		if !common.IsHexAddress(query.Owner) {
+			return nil, errors.New("owner must be address")
+		}
+		if "" != query.DelegateAddress && !common.IsHexAddress(query.DelegateAddress) {
+			return nil, errors.New("delegate must be address")
+		}
+		return OrderQuery{
+			Owner:           common.HexToAddress(query.Owner).Hex(),
+			DelegateAddress: hexAddressOrEmpty(query.DelegateAddress),
+			Market:          strings.ToUpper(query.Market),
+		}, nil
+	case TOPIC_BALANCES:
+		query := CommonTokenRequest{}
+		if err := json.Unmarshal(data, &query); nil != err {
+			return nil, err
+		}
+		if !common.IsHexAddress(query.Owner) {
+			return nil, errors.New("owner must be address")
+		}
+		if !common.IsHexAddress(query.DelegateAddress) {
+			return nil, errors.New("delegate must be address")
+		}
+		return CommonTokenRequest{Owner: common.HexToAddress(query.Owner).Hex(), DelegateAddress: common.HexToAddress(query.DelegateAddress).Hex()}, nil
+	case TOPIC_TRANSACTIONS:
+		query := SingleOwner{}
+		if err := json.Unmarshal(data, &query); nil != err {
+			return nil, err
+		}
+		if !common.IsHexAddress(query.Owner) {
+			return nil, errors.New("owner must be address")
+		}
+		return SingleOwner{Owner: common.HexToAddress(query.Owner).Hex()}, nil
+	}
+	return nil, fmt.Errorf("unsupported topic:%s", topic)
+}
+
+func normalizeMarketQuery(delegateAddress, mkt *string) error {
+	if !common.IsHexAddress(*delegateAddress) {
+		return errors.New("delegate must be address")
+	}
+	*delegateAddress = common.HexToAddress(*delegateAddress).Hex()
+	*mkt = strings.ToUpper(*mkt)
+	if !util.IsSupportedMarket(*mkt) {
+		return fmt.Errorf("unsupported market:%s", *mkt)
+	}
+	return nil
+}
+
+func hexAddressOrEmpty(address string) string {
+	if "" == address {
+		return ""
+	}
+	return common.HexToAddress(address).Hex()
+}
+
+func depthKeyOf(query DepthQuery) depthKey {
+	return depthKey{delegateAddress: query.DelegateAddress, market: query.Market, precision: query.Precision}
+}
+
+// snapshot returns the current state of the topics whose events are changes, nil is returned for the others
+func (ws *WebsocketServiceImpl) snapshot(topic string, query interface{}) (interface{}, error) {
+	switch topic {
+	case TOPIC_TICKERS:
+		return ws.tickersOf(query.(SingleMarket))
+	case TOPIC_TRENDS:
+		return ws.walletService.GetTrend(query.(TrendQuery))
+	case TOPIC_DEPTH:
+		return ws.walletService.GetDepth(query.(DepthQuery))
+	case TOPIC_BALANCES:
+		return ws.walletService.GetBalance(query.(CommonTokenRequest))
+	}
+	return nil, nil
+}
+
+func (ws *WebsocketServiceImpl) tickersOf(query SingleMarket) ([]market.Ticker, error) {
+	tickers, err := ws.walletService.GetTicker()
+	if nil != err || "" == query.Market {
+		return tickers, err
+	}
+	res := []market.Ticker{}
+	for _, ticker := range tickers {
+		if strings.ToUpper(ticker.Market) == query.Market {
+			res = append(res, ticker)
+		}
+	}
+	return res, nil
+}
+
+func (ws *WebsocketServiceImpl) handleTickerUpdated(input eventemitter.EventData) error {
+	for _, sub := range ws.subscriptionsOf(TOPIC_TICKERS) {
+		tickers, err := ws.tickersOf(sub.query.(SingleMarket))
+		if nil != err {
+			log.Errorf("websocket,get tickers err:%s", err.Error())
+			return err
+		}
+		sub.push(tickers)
+	}
+	return nil
+}
+
+func (ws *WebsocketServiceImpl) handleTrendUpdated(input eventemitter.EventData) error {
+	mkt := strings.ToUpper(input.(string))
+	trends := make(map[TrendQuery][]market.Trend)
+	for _, sub := range ws.subscriptionsOf(TOPIC_TRENDS) {
+		query := sub.query.(TrendQuery)
+		if query.Market != mkt {
+			continue
+		}
+		if _, exists := trends[query]; !exists {
+			res, err := ws.walletService.GetTrend(query)
+			if nil != err {
+				log.Errorf("websocket,get trends of %s err:%s", mkt, err.Error())
+				return err
+			}
+			trends[query] = res
+		}
+		sub.push(trends[query])
+	}
+	return nil
+}
+
+func (ws *WebsocketServiceImpl) handleDepthDiffUpdated(input eventemitter.EventData) error {
+	diff := input.(*DepthDiff)
+	for _, sub := range ws.subscriptionsOf(TOPIC_DEPTH) {
+		query := sub.query.(DepthQuery)
+		if query.DelegateAddress == diff.DelegateAddress && query.Market == diff.Market && query.Precision == diff.Precision {
+			sub.push(diff)
+		}
+	}
+	return nil
+}
+
+// handleOrderFilled pushes the sell side of fills as trades, as the latest fills are
+func (ws *WebsocketServiceImpl) handleOrderFilled(input eventemitter.EventData) error {
+	event := input.(*types.OrderFilledEvent)
+	if event.Status != types.TX_STATUS_SUCCESS {
+		return nil
+	}
+	subs := ws.subscriptionsOf(TOPIC_TRADES)
+	if len(subs) == 0 {
+		return nil
+	}
+
+	fill := dao.FillEvent{}
+	if err := fill.ConvertDown(event); nil != err {
+		return err
+	}
+	fill.Side = util.GetSide(fill.TokenS, fill.TokenB)
+	if fill.Side != util.SideSell {
+		return nil
+	}
+	mkt := strings.ToUpper(fill.Market)
+	if "" == mkt {
+		var err error
+		if mkt, err = util.WrapMarketByAddress(fill.TokenS, fill.TokenB); nil != err {
+			return err
+		}
+	}
+	trade, err := toLatestFill(fill)
+	if nil != err {
+		return err
+	}
+	for _, sub := range subs {
+		query := sub.query.(FillQuery)
+		if query.DelegateAddress == fill.DelegateAddress && query.Market == mkt {
+			sub.push(trade)
+		}
+	}
+	return nil
+}
+
+func (ws *WebsocketServiceImpl) handleOrderUpdated(input eventemitter.EventData) error {
+	event := input.(types.OrderUpdateEvent)
+	owner := hexAddressOrEmpty(event.Owner)
+	delegateAddress := hexAddressOrEmpty(event.DelegateAddress)
+	mkt := strings.ToUpper(event.Market)
+
+	var order *OrderJsonResult
+	for _, sub := range ws.subscriptionsOf(TOPIC_ORDERS) {
+		query := sub.query.(OrderQuery)
+		if query.Owner != owner ||
+			("" != query.DelegateAddress && query.DelegateAddress != delegateAddress) ||
+			("" != query.Market && query.Market != mkt) {
+			continue
+		}
+		if nil == order {
+			state, err := ws.walletService.orderManager.GetOrderByHash(common.HexToHash(event.OrderHash))
+			if nil != err {
+				log.Errorf("websocket,get order:%s err:%s", event.OrderHash, err.Error())
+				return err
+			}
+			res := orderStateToJson(*state)
+			order = &res
+		}
+		sub.push(*order)
+	}
+	return nil
+}
+
+func (ws *WebsocketServiceImpl) handleBalanceUpdated(input eventemitter.EventData) error {
+	event := input.(types.BalanceUpdateEvent)
+	owner := hexAddressOrEmpty(event.Owner)
+	delegateAddress := hexAddressOrEmpty(event.DelegateAddress)
+
+	balances := make(map[CommonTokenRequest]AccountJson)
+	for _, sub := range ws.subscriptionsOf(TOPIC_BALANCES) {
+		query := sub.query.(CommonTokenRequest)
+		//the allowances of other delegates aren't included
+		if query.Owner != owner || ("" != delegateAddress && query.DelegateAddress != delegateAddress) {
+			continue
+		}
+		if _, exists := balances[query]; !exists {
+			res, err := ws.walletService.GetBalance(query)
+			if nil != err {
+				log.Errorf("websocket,get balance of %s err:%s", owner, err.Error())
+				return err
+			}
+			balances[query] = res
+		}
+		sub.push(balances[query])
+	}
+	return nil
+}
+
+func (ws *WebsocketServiceImpl) handleTransactionEvent(input eventemitter.EventData) error {
+	tx := input.(*txtyp.TransactionView)
+	owner := tx.Owner.Hex()
+	for _, sub := range ws.subscriptionsOf(TOPIC_TRANSACTIONS) {
+		if sub.query.(SingleOwner).Owner == owner {
+			sub.push(txtyp.NewResult(tx))
+		}
+	}
+	return nil
+}
+
+func (c *wsClient) read() {
+	defer c.close()
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if nil != err {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Debugf("websocket,read from %s err:%s", c.conn.RemoteAddr().String(), err.Error())
+			}
+			return
+		}
+		c.handle(data)
+	}
+}
+
+func (c *wsClient) write() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); nil != err {
+				log.Debugf("websocket,write to %s err:%s", c.conn.RemoteAddr().String(), err.Error())
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); nil != err {
+				return
+			}
+		}
+	}
+}
+
+// close unsubscribes all, the connection is closed by the writer after the close message has been sent
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.mtx.Lock()
+		c.service.removeClient(c)
+		c.mtx.Unlock()
+	})
+}
+
+// enqueue doesn't block the emitter, the connection is closed if it can't keep up with the pushes
+func (c *wsClient) enqueue(message interface{}) {
+	data, err := json.Marshal(message)
+	if nil != err {
+		log.Errorf("websocket,marshal message err:%s", err.Error())
+		return
+	}
+	select {
+	case <-c.done:
+	case c.send <- data:
+	default:
+		log.Warnf("websocket,close the slow connection %s", c.conn.RemoteAddr().String())
+		go c.close()
+	}
+}
+
+func (c *wsClient) notify(sub *wsSubscription, result interface{}) {
+	c.enqueue(wsNotification{
+		JsonRpc: "2.0",
+		Method:  wsMethodNotification,
+		Params:  wsNotificationParams{Subscription: sub.id, Topic: sub.topic, Result: result},
+	})
+}
+
+func (c *wsClient) reply(id json.RawMessage, result interface{}) {
+	c.enqueue(wsResponse{JsonRpc: "2.0", Id: id, Result: result})
+}
+
+func (c *wsClient) replyError(id json.RawMessage, code int, message string) {
+	c.enqueue(wsErrorResponse{JsonRpc: "2.0", Id: id, Error: wsError{Code: code, Message: message}})
+}
+
+func (c *wsClient) handle(data []byte) {
+	req := wsRequest{}
+	if err := json.Unmarshal(data, &req); nil != err {
+		c.replyError(nil, wsParseError, "parse error")
+		return
+	}
+	if "2.0" != req.JsonRpc || "" == req.Method {
+		c.replyError(req.Id, wsInvalidRequest, "invalid request")
+		return
+	}
+	switch req.Method {
+	case wsMethodSubscribe:
+		c.subscribe(req)
+	case wsMethodUnsubscribe:
+		c.unsubscribe(req)
+	default:
+		c.replyError(req.Id, wsMethodNotFound, fmt.Sprintf("method:%s not found", req.Method))
+	}
+}
+
+// subscribe handles the params of [topic, query], the result is the subscription id
+func (c *wsClient) subscribe(req wsRequest) {
+	params := []json.RawMessage{}
+	topic := ""
+	if err := json.Unmarshal(req.Params, &params); nil != err || len(params) == 0 || len(params) > 2 {
+		c.replyError(req.Id, wsInvalidParams, "params should be [topic, query]")
+		return
+	}
+	if err := json.Unmarshal(params[0], &topic); nil != err {
+		c.replyError(req.Id, wsInvalidParams, "topic should be string")
+		return
+	}
+	var queryData json.RawMessage
+	if len(params) > 1 {
+		queryData = params[1]
+	}
+	query, err := c.service.parseQuery(topic, queryData)
+	if nil != err {
+		c.replyError(req.Id, wsInvalidParams, err.Error())
+		return
+	}
+
+	sub := &wsSubscription{id: newSubscriptionId(), topic: topic, query: query, client: c}
+	c.mtx.Lock()
+	select {
+	case <-c.done:
+		c.mtx.Unlock()
+		return
+	default:
+	}
+	if len(c.subscriptions) >= c.service.options.MaxSubscriptions {
+		c.mtx.Unlock()
+		c.replyError(req.Id, wsTooManySubscriptions, fmt.Sprintf("subscriptions can't be more than %d", c.service.options.MaxSubscriptions))
+		return
+	}
+	c.subscriptions[sub.id] = sub
+	c.service.addSubscription(sub)
+	c.mtx.Unlock()
+
+	//the pushes after subscribed are held until the snapshot has been sent, so no change is missed
+	snapshot, err := c.service.snapshot(topic, query)
+	if nil != err {
+		log.Errorf("websocket,snapshot %s err:%s", topic, err.Error())
+		snapshot = nil
+	}
+	c.reply(req.Id, sub.id)
+	sub.start(snapshot)
+}
+
+func (c *wsClient) unsubscribe(req wsRequest) {
+	params := []string{}
+	if err := json.Unmarshal(req.Params, &params); nil != err || len(params) != 1 {
+		c.replyError(req.Id, wsInvalidParams, "params should be [subscription]")
+		return
+	}
+	c.mtx.Lock()
+	sub, exists := c.subscriptions[params[0]]
+	if exists {
+		delete(c.subscriptions, sub.id)
+		c.service.removeSubscription(sub)
+	}
+	c.mtx.Unlock()
+	c.reply(req.Id, exists)
+}
+
+func newSubscriptionId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hexutil.Encode(id)
+}
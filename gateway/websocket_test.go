@@ -0,0 +1,158 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	txtyp "github.com/Loopring/relay/txmanager/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+)
+
+type wsTestMessage struct {
+	Id     *int            `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *wsError        `json:"error"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Topic        string          `json:"topic"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+func dialWebsocket(t *testing.T, ws *WebsocketServiceImpl) *websocket.Conn {
+	server := httptest.NewServer(ws.handler())
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+WEBSOCKET_PATH, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func call(t *testing.T, conn *websocket.Conn, request string) wsTestMessage {
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(request)); nil != err {
+		t.Fatal(err)
+	}
+	return receive(t, conn)
+}
+
+func receive(t *testing.T, conn *websocket.Conn) wsTestMessage {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg := wsTestMessage{}
+	if err := conn.ReadJSON(&msg); nil != err {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestWebsocketSubscription(t *testing.T) {
+	ws := NewWebsocketService(config.WebsocketOptions{MaxSubscriptions: 1}, &WalletServiceImpl{})
+	conn := dialWebsocket(t, ws)
+	owner := common.HexToAddress("0x1b978a1d302335a6f2ebe4b8823b5e17c3c84135")
+
+	res := call(t, conn, `{"jsonrpc":"2.0","id":1,"method":"loopring_subscribe","params":["transactions",{"owner":"`+strings.ToLower(owner.Hex())+`"}]}`)
+	id := ""
+	if nil != res.Error || nil != json.Unmarshal(res.Result, &id) || "" == id {
+		t.Fatalf("the subscription id should be returned, got:%+v", res)
+	}
+
+	res = call(t, conn, `{"jsonrpc":"2.0","id":2,"method":"loopring_subscribe","params":["transactions",{"owner":"`+owner.Hex()+`"}]}`)
+	if nil == res.Error || wsTooManySubscriptions != res.Error.Code {
+		t.Errorf("the subscriptions should be limited, got:%+v", res)
+	}
+
+	ws.handleTransactionEvent(&txtyp.TransactionView{Owner: common.HexToAddress("0x01"), Amount: big.NewInt(1), Nonce: big.NewInt(0)})
+	ws.handleTransactionEvent(&txtyp.TransactionView{Owner: owner, Symbol: "LRC", Amount: big.NewInt(1), Nonce: big.NewInt(0)})
+	notification := receive(t, conn)
+	tx := txtyp.TransactionJsonResult{}
+	if wsMethodNotification != notification.Method || id != notification.Params.Subscription || TOPIC_TRANSACTIONS != notification.Params.Topic {
+		t.Fatalf("the transaction of owner should be pushed, got:%+v", notification)
+	}
+	if err := json.Unmarshal(notification.Params.Result, &tx); nil != err || owner != tx.Owner || "LRC" != tx.Symbol {
+		t.Errorf("the transaction pushed is wrong:%+v err:%v", tx, err)
+	}
+
+	res = call(t, conn, `{"jsonrpc":"2.0","id":3,"method":"loopring_unsubscribe","params":["`+id+`"]}`)
+	if "true" != string(res.Result) {
+		t.Errorf("the subscription should be removed, got:%+v", res)
+	}
+	res = call(t, conn, `{"jsonrpc":"2.0","id":4,"method":"loopring_unsubscribe","params":["`+id+`"]}`)
+	if "false" != string(res.Result) {
+		t.Errorf("the subscription should have been removed, got:%+v", res)
+	}
+	if subs := ws.subscriptionsOf(TOPIC_TRANSACTIONS); len(subs) != 0 {
+		t.Errorf("no subscription should be kept, got:%d", len(subs))
+	}
+}
+
+func TestWebsocketInvalidRequests(t *testing.T) {
+	ws := NewWebsocketService(config.WebsocketOptions{}, &WalletServiceImpl{})
+	conn := dialWebsocket(t, ws)
+
+	expected := map[string]int{
+		`{"jsonrpc":"2.0","id":1,`: wsParseError,
+		`{"id":1,"method":"loopring_subscribe","params":["transactions"]}`:                         wsInvalidRequest,
+		`{"jsonrpc":"2.0","id":1,"method":"loopring_getDepth","params":[]}`:                        wsMethodNotFound,
+		`{"jsonrpc":"2.0","id":1,"method":"loopring_subscribe","params":["candles"]}`:              wsInvalidParams,
+		`{"jsonrpc":"2.0","id":1,"method":"loopring_subscribe","params":["transactions",{}]}`:      wsInvalidParams,
+		`{"jsonrpc":"2.0","id":1,"method":"loopring_subscribe","params":{"topic":"transactions"}}`: wsInvalidParams,
+	}
+	for request, code := range expected {
+		if res := call(t, conn, request); nil == res.Error || code != res.Error.Code {
+			t.Errorf("request:%s should be failed with %d, got:%+v", request, code, res)
+		}
+	}
+}
+
+func TestWebsocketSnapshotFirst(t *testing.T) {
+	ws := NewWebsocketService(config.WebsocketOptions{}, &WalletServiceImpl{})
+	client := &wsClient{service: ws, send: make(chan []byte, 4), done: make(chan struct{})}
+	sub := &wsSubscription{id: "0x01", topic: TOPIC_DEPTH, client: client}
+
+	sub.push(&DepthDiff{PrevSequence: 1, Sequence: 2})
+	sub.push(&DepthDiff{PrevSequence: 2, Sequence: 3})
+	sub.start(Depth{Sequence: 2})
+	sub.push(&DepthDiff{PrevSequence: 3, Sequence: 4})
+
+	sequences := []int64{}
+	for len(client.send) > 0 {
+		notification := struct {
+			Params struct {
+				Result struct {
+					Sequence int64 `json:"sequence"`
+				} `json:"result"`
+			} `json:"params"`
+		}{}
+		json.Unmarshal(<-client.send, &notification)
+		sequences = append(sequences, notification.Params.Result.Sequence)
+	}
+	if len(sequences) != 3 || sequences[0] != 2 || sequences[1] != 3 || sequences[2] != 4 {
+		t.Errorf("the snapshot should be sent first, then the diffs after it, got:%v", sequences)
+	}
+}
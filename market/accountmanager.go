@@ -375,11 +375,7 @@ type ChangedOfBlock struct {
 }
 
 func (b *ChangedOfBlock) saveBalanceKey(owner, token common.Address) error {
-	err := rcache.SAdd(b.cacheBalanceKey(), int64(0), b.cacheBalanceField(owner, token))
-	if err == nil {
-		eventemitter.Emit(eventemitter.BalanceUpdated, types.BalanceUpdateEvent{Owner: owner.Hex()})
-	}
-	return err
+	return rcache.SAdd(b.cacheBalanceKey(), int64(0), b.cacheBalanceField(owner, token))
 }
 
 func (b *ChangedOfBlock) cacheBalanceKey() string {
@@ -412,11 +408,7 @@ func (b *ChangedOfBlock) parseCacheAllowanceField(data []byte) (owner, token, sp
 }
 
 func (b *ChangedOfBlock) saveAllowanceKey(owner, token, spender common.Address) error {
-	err := rcache.SAdd(b.cacheAllowanceKey(), int64(0), b.cacheAllowanceField(owner, token, spender))
-	if err == nil {
-		eventemitter.Emit(eventemitter.BalanceUpdated, types.BalanceUpdateEvent{Owner: owner.Hex(), DelegateAddress: spender.Hex()})
-	}
-	return err
+	return rcache.SAdd(b.cacheAllowanceKey(), int64(0), b.cacheAllowanceField(owner, token, spender))
 }
 
 func removeExpiredBlock(blockNumber, duration *big.Int) error {
@@ -455,8 +447,10 @@ func (b *ChangedOfBlock) syncAndSaveBalances() error {
 			accounts[req.Owner].Balances[req.Token] = balance
 		}
 	}
-	for _, balances := range accounts {
+	//BalanceUpdated is emitted after saved, so the balances are the synced ones if queried by watchers
+	for owner, balances := range accounts {
 		balances.save(int64(0))
+		eventemitter.Emit(eventemitter.BalanceUpdated, types.BalanceUpdateEvent{Owner: owner.Hex()})
 	}
 
 	return nil
@@ -535,8 +529,17 @@ func (b *ChangedOfBlock) syncAndSaveAllowances() error {
 			accountAllowances[req.Owner].Allowances[req.Token][req.Spender] = allowance
 		}
 	}
-	for _, allowances := range accountAllowances {
+	for owner, allowances := range accountAllowances {
 		allowances.save(int64(0))
+		spenders := make(map[common.Address]bool)
+		for _, tokenAllowances := range allowances.Allowances {
+			for spender := range tokenAllowances {
+				spenders[spender] = true
+			}
+		}
+		for spender := range spenders {
+			eventemitter.Emit(eventemitter.BalanceUpdated, types.BalanceUpdateEvent{Owner: owner.Hex(), DelegateAddress: spender.Hex()})
+		}
 	}
 
 	return nil
@@ -580,7 +583,7 @@ func (accountManager *AccountManager) Start() {
 	eventemitter.On(eventemitter.Block_New, blockNewWatcher)
 	eventemitter.On(eventemitter.WethDeposit, wethDepositWatcher)
 	eventemitter.On(eventemitter.WethWithdrawal, wethWithdrawalWatcher)
-	eventemitter.On(eventemitter.ChainForkDetected, blockForkWatcher)
+	eventemitter.On(eventemitter.ChainReorg, blockForkWatcher)
 
 }
 
@@ -770,11 +773,11 @@ func (a *AccountManager) HasUnlocked(owner string) (exists bool, err error) {
 }
 
 func (a *AccountManager) handleBlockFork(input eventemitter.EventData) (err error) {
-	event := input.(*types.ForkedEvent)
+	event := input.(*types.ChainReorgEvent)
 	log.Infof("the eth network may be forked. flush all cache, detectedBlock:%s", event.DetectedBlock.String())
 
 	i := new(big.Int).Set(event.DetectedBlock)
-	for i.Cmp(event.ForkBlock) >= 0 {
+	for i.Cmp(event.CommonAncestor) >= 0 {
 		changedOfBlock := &ChangedOfBlock{}
 		changedOfBlock.currentBlockNumber = i
 		changedOfBlock.syncAndSaveBalances()
@@ -0,0 +1,454 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package market
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_CANDLES = 1000 //candles returned by a query at most
+
+	maxBaseCandles  = 20000 //base candles rolled up by a query at most
+	candlePrecision = 18
+	backfillPeriod  = tsOneDay
+)
+
+// the candles stored, the others are rolled up from the largest one dividing them
+var baseResolutions = []int64{60, 300, 900, 1800}
+
+var resolutionPattern = regexp.MustCompile(`^(\d+)(m|h|d|w)$`)
+
+var resolutionUnits = map[string]int64{
+	"m": 60,
+	"h": tsOneHour,
+	"d": tsOneDay,
+	"w": tsOneWeek,
+}
+
+type Candle struct {
+	Market     string `json:"market"`
+	Resolution int64  `json:"resolution"`
+	Start      int64  `json:"start"`
+	Open       string `json:"open"`
+	High       string `json:"high"`
+	Low        string `json:"low"`
+	Close      string `json:"close"`
+	Vol        string `json:"vol"`
+	Amount     string `json:"amount"`
+	Count      int64  `json:"count"`
+}
+
+func convertCandle(src dao.Candle) Candle {
+	return Candle{
+		Market:     src.Market,
+		Resolution: src.Resolution,
+		Start:      src.Start,
+		Open:       src.Open,
+		High:       src.High,
+		Low:        src.Low,
+		Close:      src.Close,
+		Vol:        src.Vol,
+		Amount:     src.Amount,
+		Count:      src.Count,
+	}
+}
+
+// ParseResolution returns the seconds of resolution, such as "1m", "15m", "4h", "1d", "1w",
+// the intervals of trends and seconds are accepted too. It must be a multiple of minute.
+func ParseResolution(resolution string) (int64, error) {
+	resolution = strings.TrimSpace(resolution)
+	seconds := getTsInterval(resolution)
+	if 0 == seconds {
+		if matches := resolutionPattern.FindStringSubmatch(strings.ToLower(resolution)); nil != matches {
+			n, _ := strconv.ParseInt(matches[1], 10, 64)
+			seconds = n * resolutionUnits[matches[2]]
+		} else if n, err := strconv.ParseInt(resolution, 10, 64); nil == err {
+			seconds = n
+		}
+	}
+	if seconds <= 0 || seconds%60 != 0 {
+		return 0, fmt.Errorf("unsupported resolution:%s", resolution)
+	}
+	return seconds, nil
+}
+
+// baseResolutionOf returns the largest base resolution dividing resolution
+func baseResolutionOf(resolution int64) int64 {
+	base := baseResolutions[0]
+	for _, res := range baseResolutions {
+		if resolution%res == 0 {
+			base = res
+		}
+	}
+	return base
+}
+
+type candleTrade struct {
+	market string
+	time   int64
+	price  *big.Rat
+	vol    *big.Rat
+	amount *big.Rat
+}
+
+// fillToTrade returns the trade of a sell fill in decimals, the buy fill of the same ring is
+// skipped the same as ticker so a trade is counted once.
+func fillToTrade(fill dao.FillEvent) (candleTrade, bool) {
+	trade := candleTrade{time: fill.CreateTime}
+	side := fill.Side
+	if "" == side {
+		side = util.GetSide(fill.TokenS, fill.TokenB)
+	}
+	if util.SideBuy == side {
+		return trade, false
+	}
+
+	trade.market = fill.Market
+	if "" == trade.market {
+		market, err := util.WrapMarketByAddress(fill.TokenS, fill.TokenB)
+		if nil != err {
+			return trade, false
+		}
+		trade.market = market
+	}
+
+	tokenS, err := util.AddressToToken(common.HexToAddress(fill.TokenS))
+	if nil != err {
+		return trade, false
+	}
+	tokenB, err := util.AddressToToken(common.HexToAddress(fill.TokenB))
+	if nil != err {
+		return trade, false
+	}
+	amountS, ok := new(big.Int).SetString(fill.AmountS, 0)
+	if !ok || amountS.Sign() <= 0 {
+		return trade, false
+	}
+	amountB, ok := new(big.Int).SetString(fill.AmountB, 0)
+	if !ok || amountB.Sign() <= 0 {
+		return trade, false
+	}
+
+	trade.amount = new(big.Rat).SetFrac(amountS, tokenS.Decimals)
+	trade.vol = new(big.Rat).SetFrac(amountB, tokenB.Decimals)
+	trade.price = new(big.Rat).Quo(trade.vol, trade.amount)
+	return trade, true
+}
+
+type candleBuilder struct {
+	start  int64
+	open   *big.Rat
+	high   *big.Rat
+	low    *big.Rat
+	close  *big.Rat
+	vol    *big.Rat
+	amount *big.Rat
+	count  int64
+}
+
+func newCandleBuilder(start int64) *candleBuilder {
+	return &candleBuilder{start: start, vol: new(big.Rat), amount: new(big.Rat)}
+}
+
+// add merges the ohlcv in order of time
+func (b *candleBuilder) add(open, high, low, close, vol, amount *big.Rat, count int64) {
+	if nil == b.open {
+		b.open = open
+		b.high = high
+		b.low = low
+	}
+	if high.Cmp(b.high) > 0 {
+		b.high = high
+	}
+	if low.Cmp(b.low) < 0 {
+		b.low = low
+	}
+	b.close = close
+	b.vol.Add(b.vol, vol)
+	b.amount.Add(b.amount, amount)
+	b.count += count
+}
+
+func (b *candleBuilder) addTrade(trade candleTrade) {
+	b.add(trade.price, trade.price, trade.price, trade.price, trade.vol, trade.amount, 1)
+}
+
+func (b *candleBuilder) addCandle(candle dao.Candle) error {
+	values := []string{candle.Open, candle.High, candle.Low, candle.Close, candle.Vol, candle.Amount}
+	rats := make([]*big.Rat, len(values))
+	for i, value := range values {
+		rat, ok := new(big.Rat).SetString(value)
+		if !ok {
+			return fmt.Errorf("invalid decimal:%s in candle of %s at %d", value, candle.Market, candle.Start)
+		}
+		rats[i] = rat
+	}
+	b.add(rats[0], rats[1], rats[2], rats[3], rats[4], rats[5], candle.Count)
+	return nil
+}
+
+func (b *candleBuilder) toCandle(market string, resolution int64) dao.Candle {
+	return dao.Candle{
+		Market:     market,
+		Resolution: resolution,
+		Start:      b.start,
+		Open:       formatDecimal(b.open),
+		High:       formatDecimal(b.high),
+		Low:        formatDecimal(b.low),
+		Close:      formatDecimal(b.close),
+		Vol:        formatDecimal(b.vol),
+		Amount:     formatDecimal(b.amount),
+		Count:      b.count,
+		UpdateTime: time.Now().Unix(),
+	}
+}
+
+func formatDecimal(r *big.Rat) string {
+	s := r.FloatString(candlePrecision)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if "-0" == s {
+		s = "0"
+	}
+	return s
+}
+
+// buildCandles returns the candles of trades of a market, the buckets without trades are skipped
+func buildCandles(market string, trades []candleTrade, resolution int64) []dao.Candle {
+	sort.SliceStable(trades, func(i, j int) bool {
+		return trades[i].time < trades[j].time
+	})
+	candles := []dao.Candle{}
+	var builder *candleBuilder
+	for _, trade := range trades {
+		start := trade.time - trade.time%resolution
+		if nil != builder && builder.start != start {
+			candles = append(candles, builder.toCandle(market, resolution))
+			builder = nil
+		}
+		if nil == builder {
+			builder = newCandleBuilder(start)
+		}
+		builder.addTrade(trade)
+	}
+	if nil != builder {
+		candles = append(candles, builder.toCandle(market, resolution))
+	}
+	return candles
+}
+
+// RollupCandles rolls the candles of a market up to resolution, which must be a multiple of theirs.
+// The candles must be in order of start, the buckets are aligned to the unix epoch.
+func RollupCandles(candles []dao.Candle, resolution int64) ([]dao.Candle, error) {
+	rolled := []dao.Candle{}
+	var builder *candleBuilder
+	market := ""
+	for _, candle := range candles {
+		if resolution <= 0 || resolution%candle.Resolution != 0 {
+			return nil, fmt.Errorf("the resolution:%d can't be rolled up to %d", candle.Resolution, resolution)
+		}
+		start := candle.Start - candle.Start%resolution
+		if nil != builder && builder.start != start {
+			rolled = append(rolled, builder.toCandle(market, resolution))
+			builder = nil
+		}
+		if nil == builder {
+			builder = newCandleBuilder(start)
+			market = candle.Market
+		}
+		if err := builder.addCandle(candle); nil != err {
+			return nil, err
+		}
+	}
+	if nil != builder {
+		rolled = append(rolled, builder.toCandle(market, resolution))
+	}
+	return rolled, nil
+}
+
+// GetCandles returns the candles of market started in [from, to] in order of start, the
+// latest MAX_CANDLES are returned if there are more. to defaults to now.
+func (t *TrendManager) GetCandles(market, resolution string, from, to int64) ([]Candle, error) {
+	res, err := ParseResolution(resolution)
+	if nil != err {
+		return nil, err
+	}
+	market = strings.ToUpper(market)
+	if !util.IsSupportedMarket(market) {
+		return nil, errors.New("unsupported market:" + market)
+	}
+	if to <= 0 {
+		to = time.Now().Unix()
+	}
+	base := baseResolutionOf(res)
+	end := to - to%res + res
+	if limit := end - MAX_CANDLES*res; from < limit {
+		from = limit
+	}
+	if limit := end - maxBaseCandles*base; from < limit {
+		from = limit
+	}
+	if from >= end {
+		return []Candle{}, nil
+	}
+	start := from - from%res
+
+	baseCandles, err := t.rds.GetCandles(market, base, start, end)
+	if nil != err {
+		return nil, err
+	}
+	rolled, err := RollupCandles(baseCandles, res)
+	if nil != err {
+		return nil, err
+	}
+	candles := make([]Candle, len(rolled))
+	for i, candle := range rolled {
+		candles[i] = convertCandle(candle)
+	}
+	return candles, nil
+}
+
+// handleCandleFill rebuilds the minute candle of the fill from the fills table and rolls the
+// other base candles up from minutes. The fill is merged in case it hasn't been saved yet.
+func (t *TrendManager) handleCandleFill(input eventemitter.EventData) error {
+	event := input.(*types.OrderFilledEvent)
+	if event.Status != types.TX_STATUS_SUCCESS {
+		return nil
+	}
+	fill := dao.FillEvent{}
+	if err := fill.ConvertDown(event); nil != err {
+		return err
+	}
+	trade, ok := fillToTrade(fill)
+	if !ok {
+		return nil
+	}
+
+	minute := baseResolutions[0]
+	start := trade.time - trade.time%minute
+	fills, err := t.rds.GetFillsByTime(trade.market, start, start+minute)
+	if nil != err {
+		log.Errorf("trend manager,get fills of candle err:%s", err.Error())
+		return err
+	}
+	saved := false
+	for _, f := range fills {
+		if strings.ToLower(f.TxHash) == strings.ToLower(fill.TxHash) && f.FillIndex == fill.FillIndex {
+			saved = true
+			break
+		}
+	}
+	if !saved {
+		fills = append(fills, fill)
+	}
+
+	trades := []candleTrade{}
+	for _, f := range fills {
+		if trade, ok := fillToTrade(f); ok {
+			trades = append(trades, trade)
+		}
+	}
+	for _, candle := range buildCandles(trade.market, trades, minute) {
+		if err := t.rds.SaveCandle(&candle); nil != err {
+			log.Errorf("trend manager,save candle of %s err:%s", trade.market, err.Error())
+			return err
+		}
+	}
+
+	for _, res := range baseResolutions[1:] {
+		resStart := trade.time - trade.time%res
+		minutes, err := t.rds.GetCandles(trade.market, minute, resStart, resStart+res)
+		if nil != err {
+			return err
+		}
+		rolled, err := RollupCandles(minutes, res)
+		if nil != err {
+			return err
+		}
+		for _, candle := range rolled {
+			if err := t.rds.SaveCandle(&candle); nil != err {
+				log.Errorf("trend manager,save candle of %s err:%s", trade.market, err.Error())
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BackfillCandles rebuilds the base candles of market, or all the markets if it's empty, in
+// [from, to] from the fills table, it's used after forks or outages of the relay.
+// The range is extended to the largest base resolution and it returns the candles saved.
+func BackfillCandles(rds dao.RdsService, market string, from, to int64) (int, error) {
+	if from > to {
+		return 0, fmt.Errorf("from:%d is after to:%d", from, to)
+	}
+	market = strings.ToUpper(market)
+	largest := baseResolutions[len(baseResolutions)-1]
+	from = from - from%largest
+	to = to - to%largest + largest
+
+	saved := 0
+	for start := from; start < to; start += backfillPeriod {
+		end := start + backfillPeriod
+		if end > to {
+			end = to
+		}
+		fills, err := rds.GetFillsByTime(market, start, end)
+		if nil != err {
+			return saved, err
+		}
+		trades := make(map[string][]candleTrade)
+		for _, fill := range fills {
+			if trade, ok := fillToTrade(fill); ok {
+				trades[trade.market] = append(trades[trade.market], trade)
+			}
+		}
+
+		for _, res := range baseResolutions {
+			if err := rds.DeleteCandles(market, res, start, end); nil != err {
+				return saved, err
+			}
+			for mkt, mktTrades := range trades {
+				for _, candle := range buildCandles(mkt, mktTrades, res) {
+					if err := rds.SaveCandle(&candle); nil != err {
+						return saved, err
+					}
+					saved++
+				}
+			}
+		}
+		log.Debugf("trend manager,backfilled candles in [%d, %d) of %d markets", start, end, len(trades))
+	}
+	return saved, nil
+}
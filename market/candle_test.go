@@ -0,0 +1,77 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package market_test
+
+import (
+	"testing"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/market"
+)
+
+func TestParseResolution(t *testing.T) {
+	valid := map[string]int64{
+		"1m":    60,
+		"15m":   900,
+		"4H":    4 * 3600,
+		"1d":    86400,
+		"1w":    7 * 86400,
+		"1Hr":   3600,
+		"1Week": 7 * 86400,
+		"120":   120,
+	}
+	for s, expected := range valid {
+		if res, err := market.ParseResolution(s); nil != err || res != expected {
+			t.Errorf("resolution:%s should be %d, got:%d err:%v", s, expected, res, err)
+		}
+	}
+	for _, s := range []string{"", "0m", "30", "1y", "-60"} {
+		if _, err := market.ParseResolution(s); nil == err {
+			t.Errorf("resolution:%s should be invalid", s)
+		}
+	}
+}
+
+func TestRollupCandles(t *testing.T) {
+	minutes := []dao.Candle{
+		{Market: "LRC-WETH", Resolution: 60, Start: 3540, Open: "1", High: "1", Low: "1", Close: "1", Vol: "1", Amount: "1", Count: 1},
+		{Market: "LRC-WETH", Resolution: 60, Start: 3600, Open: "0.1", High: "0.3", Low: "0.1", Close: "0.2", Vol: "0.1", Amount: "1", Count: 2},
+		{Market: "LRC-WETH", Resolution: 60, Start: 3720, Open: "0.2", High: "0.25", Low: "0.05", Close: "0.15", Vol: "0.2", Amount: "2", Count: 3},
+	}
+	candles, err := market.RollupCandles(minutes, 3600)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(candles) != 2 {
+		t.Fatalf("the minutes should be rolled up to 2 hours, got:%d", len(candles))
+	}
+	if candles[0].Start != 0 || candles[0].Count != 1 {
+		t.Errorf("the first hour is wrong:%+v", candles[0])
+	}
+	expected := dao.Candle{Market: "LRC-WETH", Resolution: 3600, Start: 3600, Open: "0.1", High: "0.3", Low: "0.05", Close: "0.15", Vol: "0.3", Amount: "3", Count: 5}
+	second := candles[1]
+	second.UpdateTime = 0
+	if second != expected {
+		t.Errorf("the second hour should be %+v, got:%+v", expected, second)
+	}
+
+	if _, err := market.RollupCandles(minutes, 90); nil == err {
+		t.Errorf("the minutes can't be rolled up to 90 seconds")
+	}
+}
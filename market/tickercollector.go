@@ -251,6 +251,10 @@ func (c *CollectorImpl) Start() {
 	}
 }
 
+func (c *CollectorImpl) Stop() {
+	c.cron.Stop()
+}
+
 func (c *CollectorImpl) GetTickers(market string) ([]Ticker, error) {
 
 	result := make([]Ticker, 0)
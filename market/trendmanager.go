@@ -117,6 +117,8 @@ func NewTrendManager(dao dao.RdsService, cronJobLock bool) TrendManager {
 		trendManager.LoadCache()
 		if cronJobLock {
 			trendManager.startScheduleUpdate()
+			candleWatcher := &eventemitter.Watcher{Concurrent: false, Handle: trendManager.handleCandleFill}
+			eventemitter.On(eventemitter.OrderFilled, candleWatcher)
 		}
 		fillOrderWatcher := &eventemitter.Watcher{Concurrent: false, Handle: trendManager.HandleOrderFilled}
 		eventemitter.On(eventemitter.OrderFilled, fillOrderWatcher)
@@ -1113,8 +1115,8 @@ func setTrendCache(interval, market string, mktCache Cache, ttl int64) {
 	if err != nil {
 		log.Info("marshal ticker json error " + err.Error())
 	} else {
-		eventemitter.Emit(eventemitter.TrendUpdated, market)
 		redisCache.Set(cacheKey, tickerByte, ttl)
+		eventemitter.Emit(eventemitter.TrendUpdated, market)
 	}
 }
 
@@ -1123,9 +1125,9 @@ func setLprTickerCache(tickers map[string]Ticker, ttl int64) {
 	if err != nil {
 		log.Info("marshal ticker json error " + err.Error())
 	} else {
-		eventemitter.Emit(eventemitter.LoopringTickerUpdated, nil)
 		//log.Info("[TICKER]ticker key set in setLprTickerCache")
 		redisCache.Set(tickerKey, tickerByte, ttl)
+		eventemitter.Emit(eventemitter.LoopringTickerUpdated, nil)
 	}
 }
 
@@ -0,0 +1,107 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	//the same as the limit of the http server of go-ethereum rpc
+	maxJsonrpcBodyLength = 1024 * 128
+
+	UNKNOWN_METHOD = "unknown"
+)
+
+type jsonrpcMessage struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Error  json.RawMessage `json:"error"`
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// JsonrpcHandler observes the latency and errors of the json-rpc methods served by next,
+// the methods not in knownMethods are counted as unknown so the labels can't be flooded by the clients.
+func JsonrpcHandler(next http.Handler, knownMethods map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if http.MethodPost != r.Method || r.ContentLength > maxJsonrpcBodyLength {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxJsonrpcBodyLength+1))
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		if nil != err || len(body) > maxJsonrpcBodyLength {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+		elapsed := time.Since(start).Seconds()
+
+		failed := make(map[string]bool)
+		for _, res := range decodeJsonrpcMessages(recorder.body.Bytes()) {
+			if len(res.Error) > 0 && "null" != string(res.Error) {
+				failed[string(res.Id)] = true
+			}
+		}
+		for _, req := range decodeJsonrpcMessages(body) {
+			method := req.Method
+			if !knownMethods[method] {
+				method = UNKNOWN_METHOD
+			}
+			JsonrpcDuration.WithLabelValues(method).Observe(elapsed)
+			if failed[string(req.Id)] {
+				JsonrpcErrors.WithLabelValues(method).Inc()
+			}
+		}
+	})
+}
+
+// decodeJsonrpcMessages decodes a single message or a batch of them
+func decodeJsonrpcMessages(data []byte) []jsonrpcMessage {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && '[' == data[0] {
+		var msgs []jsonrpcMessage
+		if err := json.Unmarshal(data, &msgs); nil == err {
+			return msgs
+		}
+		return nil
+	}
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(data, &msg); nil != err {
+		return nil
+	}
+	return []jsonrpcMessage{msg}
+}
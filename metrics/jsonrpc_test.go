@@ -0,0 +1,90 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package metrics_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Loopring/relay/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); nil != err {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func histogramCount(t *testing.T, o prometheus.Observer) uint64 {
+	m := &dto.Metric{}
+	if err := o.(prometheus.Metric).Write(m); nil != err {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestJsonrpcHandler(t *testing.T) {
+	//echoes a result for the first call and an error for the others
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasPrefix(string(body), "[") {
+			w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"error":{"code":-32000,"message":"failed"}}]`))
+		} else {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"not found"}}`))
+		}
+	})
+	handler := metrics.JsonrpcHandler(next, map[string]bool{"loopring_getBalance": true, "loopring_submitOrder": true})
+
+	post := func(body string) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return w.Body.String()
+	}
+
+	getBalanceErrors := counterValue(t, metrics.JsonrpcErrors.WithLabelValues("loopring_getBalance"))
+	submitOrderErrors := counterValue(t, metrics.JsonrpcErrors.WithLabelValues("loopring_submitOrder"))
+	unknownErrors := counterValue(t, metrics.JsonrpcErrors.WithLabelValues(metrics.UNKNOWN_METHOD))
+	getBalanceCalls := histogramCount(t, metrics.JsonrpcDuration.WithLabelValues("loopring_getBalance"))
+
+	res := post(`[{"jsonrpc":"2.0","id":1,"method":"loopring_getBalance"},{"jsonrpc":"2.0","id":2,"method":"loopring_submitOrder"}]`)
+	if !strings.Contains(res, `"id":2`) {
+		t.Fatalf("the response isn't written, got:%s", res)
+	}
+	post(`{"jsonrpc":"2.0","id":3,"method":"loopring_noSuchMethod"}`)
+
+	if v := histogramCount(t, metrics.JsonrpcDuration.WithLabelValues("loopring_getBalance")); v != getBalanceCalls+1 {
+		t.Fatalf("calls of loopring_getBalance should be %d, got:%d", getBalanceCalls+1, v)
+	}
+	if v := counterValue(t, metrics.JsonrpcErrors.WithLabelValues("loopring_getBalance")); v != getBalanceErrors {
+		t.Fatalf("loopring_getBalance shouldn't be counted as error")
+	}
+	if v := counterValue(t, metrics.JsonrpcErrors.WithLabelValues("loopring_submitOrder")); v != submitOrderErrors+1 {
+		t.Fatalf("errors of loopring_submitOrder should be %f, got:%f", submitOrderErrors+1, v)
+	}
+	if v := counterValue(t, metrics.JsonrpcErrors.WithLabelValues(metrics.UNKNOWN_METHOD)); v != unknownErrors+1 {
+		t.Fatalf("errors of the unknown method should be %f, got:%f", unknownErrors+1, v)
+	}
+}
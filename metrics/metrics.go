@@ -0,0 +1,203 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "relay"
+
+// the collectors are registered to the default registry of prometheus, they can be used before the server starts
+var (
+	JsonrpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "jsonrpc",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the json-rpc methods.",
+	}, []string{"method"})
+
+	JsonrpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "jsonrpc",
+		Name:      "errors_total",
+		Help:      "Number of the json-rpc calls responded with error.",
+	}, []string{"method"})
+
+	JsonrpcRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "jsonrpc",
+		Name:      "rejections_total",
+		Help:      "Number of the json-rpc requests rejected before served, by reason.",
+	}, []string{"reason"})
+
+	GatewayFilterRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "filter_rejections_total",
+		Help:      "Number of the orders rejected by each filter of gateway.",
+	}, []string{"filter"})
+
+	GatewayOrders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "orders_total",
+		Help:      "Number of the orders accepted by gateway in each market.",
+	}, []string{"market"})
+
+	MatcherRoundDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "matcher",
+		Name:      "round_duration_seconds",
+		Help:      "Duration of the matching rounds.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"matcher"})
+
+	MatcherCandidateRings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "matcher",
+		Name:      "candidate_rings_total",
+		Help:      "Number of the candidate rings found in each market, the rings of multi hop are counted in market multi_hop.",
+	}, []string{"market"})
+
+	RingsSubmitted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "miner",
+		Name:      "rings_submitted_total",
+		Help:      "Number of the rings sent to ethereum.",
+	})
+
+	RingsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "miner",
+		Name:      "rings_failed_total",
+		Help:      "Number of the failed rings, stage is one of simulate, send and execute.",
+	}, []string{"stage"})
+
+	RingsMined = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "miner",
+		Name:      "rings_mined_total",
+		Help:      "Number of the rings mined successfully.",
+	})
+
+	RingsGasUsed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "miner",
+		Name:      "gas_used_total",
+		Help:      "Gas used by the mined transactions of submitting rings, including the failed ones.",
+	})
+
+	ExtractorBlockNumber = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "extractor",
+		Name:      "block_number",
+		Help:      "The latest block extracted.",
+	})
+
+	ExtractorChainBlockNumber = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "extractor",
+		Name:      "chain_block_number",
+		Help:      "The latest block number returned by the ethereum node.",
+	})
+
+	ExtractorBlockLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "extractor",
+		Name:      "block_lag",
+		Help:      "Number of blocks between the ethereum node and the latest block extracted.",
+	})
+
+	ExtractorReorgs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "extractor",
+		Name:      "reorgs_total",
+		Help:      "Number of the chain reorgs detected.",
+	})
+
+	ExtractorOrphanedBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "extractor",
+		Name:      "orphaned_blocks_total",
+		Help:      "Number of the blocks orphaned by chain reorgs.",
+	})
+
+	RedisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "command_duration_seconds",
+		Help:      "Latency of the redis commands.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	RedisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "errors_total",
+		Help:      "Number of the redis commands returned error.",
+	}, []string{"command"})
+
+	RdsDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rds",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of the queries of mysql, operation is one of create, query, update, delete and row_query.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation", "table"})
+
+	RdsErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rds",
+		Name:      "errors_total",
+		Help:      "Number of the queries of mysql returned error, record not found isn't counted.",
+	}, []string{"operation", "table"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		JsonrpcDuration,
+		JsonrpcErrors,
+		JsonrpcRejections,
+		GatewayFilterRejections,
+		GatewayOrders,
+		MatcherRoundDuration,
+		MatcherCandidateRings,
+		RingsSubmitted,
+		RingsFailed,
+		RingsMined,
+		RingsGasUsed,
+		ExtractorBlockNumber,
+		ExtractorChainBlockNumber,
+		ExtractorBlockLag,
+		ExtractorReorgs,
+		ExtractorOrphanedBlocks,
+		RedisDuration,
+		RedisErrors,
+		RdsDuration,
+		RdsErrors,
+	)
+}
+
+// ObserveSince observes the seconds elapsed since start
+func ObserveSince(observer prometheus.Observer, start time.Time) {
+	observer.Observe(time.Since(start).Seconds())
+}
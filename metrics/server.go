@@ -0,0 +1,74 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package metrics
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const DEFAULT_PATH = "/metrics"
+
+// Server exposes the metrics to prometheus by http
+type Server struct {
+	options config.MetricsOptions
+	mux     *http.ServeMux
+	server  *http.Server
+}
+
+func NewServer(options config.MetricsOptions) *Server {
+	s := &Server{}
+	s.options = options
+	if "" == s.options.Path {
+		s.options.Path = DEFAULT_PATH
+	}
+	s.mux = http.NewServeMux()
+	s.mux.Handle(s.options.Path, promhttp.Handler())
+	return s
+}
+
+// Handle adds another handler to the server, it must be called before Start
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", ":"+s.options.Port)
+	if nil != err {
+		return err
+	}
+	s.server = &http.Server{Handler: s.mux}
+	go func() {
+		if err := s.server.Serve(listener); nil != err && http.ErrServerClosed != err {
+			log.Errorf("metrics,serve err:%s", err.Error())
+		}
+	}()
+	log.Infof("metrics,http endpoint opened on %s%s", s.options.Port, s.options.Path)
+	return nil
+}
+
+func (s *Server) Stop() {
+	if nil != s.server {
+		s.server.Close()
+	}
+}
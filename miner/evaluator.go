@@ -83,7 +83,42 @@ func (e *Evaluator) ComputeRing(ringState *types.Ring) error {
 	}
 
 	ringState.ReducedRate = ReducedRate(ringState)
+	rates := []*big.Rat{}
+	for range ringState.Orders {
+		rates = append(rates, ringState.ReducedRate)
+	}
+
+	return e.computeRingWithRates(ringState, rates)
+}
+
+// ComputeRingAtPrice settles the two orders of ringState at the same price,
+// price is the amount of Orders[0].TokenB per Orders[0].TokenS, and it can't be worse than the prices of orders.
+func (e *Evaluator) ComputeRingAtPrice(ringState *types.Ring, price *big.Rat) error {
+	if len(ringState.Orders) != 2 {
+		return fmt.Errorf("only the ring of two orders can be settled at an uniform price, ringhash:%s", ringState.Hash.Hex())
+	}
+	if nil == price || price.Sign() <= 0 {
+		return errors.New("the uniform price must be positive")
+	}
+
+	ringState.ReducedRate = ReducedRate(ringState)
+	first := ringState.Orders[0].OrderState.RawOrder
+	second := ringState.Orders[1].OrderState.RawOrder
+	//the rate is the new sell price divided by the sell price of order
+	firstRate := new(big.Rat).SetFrac(first.AmountB, first.AmountS)
+	firstRate.Quo(firstRate, price)
+	secondRate := new(big.Rat).SetFrac(second.AmountB, second.AmountS)
+	secondRate.Mul(secondRate, price)
+	one := big.NewRat(int64(1), int64(1))
+	if firstRate.Cmp(one) > 0 || secondRate.Cmp(one) > 0 {
+		return fmt.Errorf("price:%s is out of the range of orders", price.FloatString(10))
+	}
+
+	return e.computeRingWithRates(ringState, []*big.Rat{firstRate, secondRate})
+}
 
+// the sell price of each order is reduced by the rate of the same index
+func (e *Evaluator) computeRingWithRates(ringState *types.Ring, rates []*big.Rat) error {
 	//todo:get the fee for select the ring of mix income
 	//LRC等比例下降，首先需要计算fillAmountS
 	//分润的fee，首先需要计算fillAmountS，fillAmountS取决于整个环路上的完全匹配的订单
@@ -91,7 +126,7 @@ func (e *Evaluator) ComputeRing(ringState *types.Ring) error {
 	minVolumeIdx := 0
 
 	for idx, filledOrder := range ringState.Orders {
-		filledOrder.SPrice.Mul(filledOrder.SPrice, ringState.ReducedRate)
+		filledOrder.SPrice.Mul(filledOrder.SPrice, rates[idx])
 
 		filledOrder.BPrice.Inv(filledOrder.SPrice)
 
@@ -101,7 +136,7 @@ func (e *Evaluator) ComputeRing(ringState *types.Ring) error {
 		//根据用户设置，判断是以卖还是买为基准
 		//买入不超过amountB
 		filledOrder.RateAmountS = new(big.Rat).Set(amountS)
-		filledOrder.RateAmountS.Mul(amountS, ringState.ReducedRate)
+		filledOrder.RateAmountS.Mul(amountS, rates[idx])
 		//if BuyNoMoreThanAmountB , AvailableAmountS need to be reduced by the ratePrice
 		//recompute availabeAmountS and availableAmountB by the latest price
 		if filledOrder.OrderState.RawOrder.BuyNoMoreThanAmountB {
@@ -165,7 +200,7 @@ func (e *Evaluator) ComputeRing(ringState *types.Ring) error {
 	}
 
 	//compute the fee of this ring and orders, and set the feeSelection
-	if err := e.computeFeeOfRingAndOrder(ringState); nil != err {
+	if err := e.computeFeeOfRingAndOrder(ringState, rates); nil != err {
 		return err
 	}
 
@@ -186,7 +221,7 @@ func (e *Evaluator) ComputeRing(ringState *types.Ring) error {
 
 }
 
-func (e *Evaluator) computeFeeOfRingAndOrder(ringState *types.Ring) error {
+func (e *Evaluator) computeFeeOfRingAndOrder(ringState *types.Ring, rates []*big.Rat) error {
 
 	var err error
 	var feeReceiptLrcAvailableAmount *big.Rat
@@ -203,7 +238,7 @@ func (e *Evaluator) computeFeeOfRingAndOrder(ringState *types.Ring) error {
 	}
 
 	ringState.LegalFee = big.NewRat(int64(0), int64(1))
-	for _, filledOrder := range ringState.Orders {
+	for idx, filledOrder := range ringState.Orders {
 		legalAmountOfSaving := new(big.Rat)
 		if filledOrder.OrderState.RawOrder.BuyNoMoreThanAmountB {
 			amountS := new(big.Rat).SetInt(filledOrder.OrderState.RawOrder.AmountS)
@@ -220,7 +255,7 @@ func (e *Evaluator) computeFeeOfRingAndOrder(ringState *types.Ring) error {
 			}
 		} else {
 			savingAmount := new(big.Rat).Set(filledOrder.FillAmountB)
-			savingAmount.Mul(savingAmount, ringState.ReducedRate)
+			savingAmount.Mul(savingAmount, rates[idx])
 			savingAmount.Sub(filledOrder.FillAmountB, savingAmount)
 			filledOrder.FeeS = savingAmount
 			legalAmountOfSaving, err = e.getLegalCurrency(filledOrder.OrderState.RawOrder.TokenB, filledOrder.FeeS)
@@ -317,6 +352,17 @@ func PriceValid(a2BOrder *types.OrderState, b2AOrder *types.OrderState) bool {
 	return amountS.Cmp(amountB) >= 0
 }
 
+//多个订单成环时，所有订单amountS的乘积不小于amountB的乘积才可能成交
+func RingPriceValid(orders ...*types.OrderState) bool {
+	productAmountS := big.NewInt(int64(1))
+	productAmountB := big.NewInt(int64(1))
+	for _, order := range orders {
+		productAmountS.Mul(productAmountS, order.RawOrder.AmountS)
+		productAmountB.Mul(productAmountB, order.RawOrder.AmountB)
+	}
+	return productAmountS.Cmp(productAmountB) >= 0
+}
+
 func PriceRateCVSquare(ringState *types.Ring) (*big.Int, error) {
 	rateRatios := []*big.Int{}
 	scale, _ := new(big.Int).SetString("10000", 0)
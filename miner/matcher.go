@@ -19,12 +19,74 @@
 package miner
 
 import (
+	"fmt"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	marketLib "github.com/Loopring/relay/market"
+	"github.com/Loopring/relay/ordermanager"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
+	"sync"
 )
 
 type Matcher interface {
 	Start()
+	// Stop waits for the round in process, so the rings found are submitted before exit
 	Stop()
+	// the rounds are skipped while paused, the submitted rings are still watched
+	Pause()
+	Resume()
+	Paused() bool
 	GetAccountAvailableAmount(address, tokenAddress, spender common.Address) (*big.Rat, error)
 }
+
+const DefaultMatcherName = "timing"
+
+//the dependencies shared by all matchers
+type MatcherContext struct {
+	Options        config.MinerOptions
+	Submitter      *RingSubmitter
+	Evaluator      *Evaluator
+	OrderManager   ordermanager.OrderManager
+	AccountManager *marketLib.AccountManager
+	Rds            dao.RdsService
+}
+
+type MatcherCreator func(ctx *MatcherContext) (Matcher, error)
+
+var matcherCreators map[string]MatcherCreator
+var matcherMtx sync.RWMutex
+
+// RegisterMatcher makes a matcher strategy available by name, it's usually called in init of the package of matcher.
+func RegisterMatcher(name string, creator MatcherCreator) {
+	matcherMtx.Lock()
+	defer matcherMtx.Unlock()
+	if nil == matcherCreators {
+		matcherCreators = make(map[string]MatcherCreator)
+	}
+	matcherCreators[name] = creator
+}
+
+func RegisteredMatchers() []string {
+	matcherMtx.RLock()
+	defer matcherMtx.RUnlock()
+	names := []string{}
+	for name := range matcherCreators {
+		names = append(names, name)
+	}
+	return names
+}
+
+// NewMatcher creates the matcher registered by name, the timing matcher is used if name is empty.
+func NewMatcher(name string, ctx *MatcherContext) (Matcher, error) {
+	if "" == name {
+		name = DefaultMatcherName
+	}
+	matcherMtx.RLock()
+	creator, exists := matcherCreators[name]
+	matcherMtx.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("matcher:%s hasn't been registered", name)
+	}
+	return creator(ctx)
+}
@@ -39,6 +39,18 @@ func (minerInstance *Miner) Stop() {
 	minerInstance.submitter.stop()
 }
 
+func (minerInstance *Miner) Pause() {
+	minerInstance.matcher.Pause()
+}
+
+func (minerInstance *Miner) Resume() {
+	minerInstance.matcher.Resume()
+}
+
+func (minerInstance *Miner) Paused() bool {
+	return minerInstance.matcher.Paused()
+}
+
 func NewMiner(submitter *RingSubmitter, matcher Matcher, evaluator *Evaluator, marketCapProvider marketcap.MarketCapProvider) *Miner {
 	return &Miner{
 		marketCapProvider: marketCapProvider,
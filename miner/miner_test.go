@@ -99,7 +99,7 @@ func TestMatch(t *testing.T) {
 	submitter, _ := miner.NewSubmitter(cfg.Miner, rdsService, marketCapProvider)
 	evaluator := miner.NewEvaluator(marketCapProvider, cfg.Miner)
 	rds := test.GenerateDaoService()
-	matcher := timing_matcher.NewTimingMatcher(cfg.Miner.TimingMatcher, submitter, evaluator, om, &accountManager, rds)
+	matcher := timing_matcher.NewTimingMatcher(cfg.Miner.TimingMatcher, cfg.Miner.RingMaxLength, submitter, evaluator, om, &accountManager, rds)
 	evaluator.SetMatcher(matcher)
 
 	m := miner.NewMiner(submitter, matcher, evaluator, marketCapProvider)
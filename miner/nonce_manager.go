@@ -0,0 +1,442 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	NonceHighWaterPrefix = "miner_nonce_highwater_"
+	NonceOwnerPrefix     = "miner_nonce_owner_"
+	gapFillGas           = 21000
+	//the lease of sender is renewed on each check, it's taken over by another relay after the owner has stopped for this long
+	nonceLeaseTtl = 2 * time.Minute
+)
+
+// claimSenderScript takes or renews the lease of sender for the owner, it fails if the lease is held by another owner
+const claimSenderScript = `
+local owner = redis.call("GET", KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`
+
+const unclaimSenderScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+return 1
+`
+
+// saveHighWaterScript saves the high-water mark only if the lease of sender is still held by the owner
+const saveHighWaterScript = `
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[2])
+return 1
+`
+
+// NonceStore keeps the high-water marks of senders. A sender belongs to one relay at a time,
+// the relay holding its lease is the only one allowed to allocate its nonces.
+type NonceStore interface {
+	// Claim takes or renews the lease of address for owner, it returns false if the lease is held by another owner
+	Claim(address common.Address, owner string, ttl time.Duration) (bool, error)
+	// Unclaim gives up the lease of address if it's held by owner
+	Unclaim(address common.Address, owner string) error
+	LoadHighWater(address common.Address) (*big.Int, error)
+	// SaveHighWater fails if the lease of address isn't held by owner any more
+	SaveHighWater(address common.Address, owner string, highWater *big.Int) error
+}
+
+type senderNonce struct {
+	address       common.Address
+	gasPriceLimit *big.Int
+	owned         bool                //the lease of sender is held by this relay
+	next          *big.Int            //the next nonce will be allocated
+	allocated     map[uint64]*big.Int //nonce -> the block number when it was allocated
+}
+
+// NonceManager allocates the nonces of sender addresses locally, so that several goroutines can send txs at the same time.
+// The high-water mark is kept in redis, and the gaps left by dropped or failed txs are filled with self-transfers on each new block,
+// which is got from Block_New or by polling the block number in case the process runs no extractor.
+// Each sender is leased to one relay, the nonces of the senders leased to other relays can't be allocated.
+type NonceManager struct {
+	mtx                sync.Mutex
+	store              NonceStore
+	owner              string
+	senders            map[common.Address]*senderNonce
+	currentBlockNumber *big.Int
+	transactionCount   func(address common.Address, tag string) (*big.Int, error)
+	stopFuncs          []func()
+}
+
+func NewNonceManager() *NonceManager {
+	manager := &NonceManager{}
+	manager.mtx = sync.Mutex{}
+	manager.store = &RedisNonceStore{}
+	hostname, _ := os.Hostname()
+	manager.owner = fmt.Sprintf("%s_%d_%d", hostname, os.Getpid(), time.Now().UnixNano())
+	manager.senders = make(map[common.Address]*senderNonce)
+	manager.currentBlockNumber = big.NewInt(0)
+	manager.transactionCount = getTransactionCount
+	manager.stopFuncs = []func(){}
+	return manager
+}
+
+// AddSender claims address for this relay, the gaps can only be filled when gasPriceLimit is set.
+// If address is used by another relay, it's claimed again on each check.
+func (manager *NonceManager) AddSender(address common.Address, gasPriceLimit *big.Int) {
+	manager.mtx.Lock()
+	if _, exists := manager.senders[address]; exists {
+		manager.mtx.Unlock()
+		return
+	}
+	sender := &senderNonce{}
+	sender.address = address
+	sender.gasPriceLimit = gasPriceLimit
+	sender.next = big.NewInt(0)
+	sender.allocated = make(map[uint64]*big.Int)
+	manager.senders[address] = sender
+	manager.mtx.Unlock()
+
+	manager.claim(address)
+}
+
+// claim renews the lease of address. When the lease is taken, the nonce is loaded again,
+// it uses the larger one of the pending nonce and the high-water mark left by the last owner.
+func (manager *NonceManager) claim(address common.Address) bool {
+	claimed, err := manager.store.Claim(address, manager.owner, nonceLeaseTtl)
+	if nil != err {
+		log.Errorf("nonceManager, claim %s, err:%s", address.Hex(), err.Error())
+		return manager.isOwned(address)
+	}
+	if !claimed {
+		manager.mtx.Lock()
+		if sender, exists := manager.senders[address]; exists && sender.owned {
+			log.Errorf("nonceManager, %s has been claimed by another relay", address.Hex())
+			sender.owned = false
+		}
+		manager.mtx.Unlock()
+		return false
+	}
+	if manager.isOwned(address) {
+		return true
+	}
+
+	pendingNonce, err := manager.transactionCount(address, "pending")
+	if nil != err {
+		log.Errorf("nonceManager, get nonce of %s, err:%s", address.Hex(), err.Error())
+		return false
+	}
+	highWater, err := manager.store.LoadHighWater(address)
+	if nil != err {
+		log.Errorf("nonceManager, load high-water of %s, err:%s", address.Hex(), err.Error())
+		return false
+	}
+
+	manager.mtx.Lock()
+	defer manager.mtx.Unlock()
+	sender, exists := manager.senders[address]
+	if !exists {
+		return false
+	}
+	sender.next = new(big.Int).Set(pendingNonce)
+	sender.allocated = make(map[uint64]*big.Int)
+	if nil != highWater && highWater.Cmp(sender.next) > 0 {
+		//the nonces between them were allocated before restart, they are regarded as gaps if they aren't in the txpool
+		for nonce := new(big.Int).Set(sender.next); nonce.Cmp(highWater) < 0; nonce.Add(nonce, big.NewInt(1)) {
+			sender.allocated[nonce.Uint64()] = big.NewInt(0)
+		}
+		sender.next.Set(highWater)
+	}
+	sender.owned = true
+	log.Infof("nonceManager, %s has been claimed, next nonce:%s", address.Hex(), sender.next.String())
+	return true
+}
+
+func (manager *NonceManager) isOwned(address common.Address) bool {
+	manager.mtx.Lock()
+	defer manager.mtx.Unlock()
+	sender, exists := manager.senders[address]
+	return exists && sender.owned
+}
+
+// Allocate returns the next nonce of address, the nonce should be released if the tx isn't sent successfully.
+// It fails if address is used by another relay, or the high-water mark can't be saved.
+func (manager *NonceManager) Allocate(address common.Address) (*big.Int, error) {
+	manager.mtx.Lock()
+	defer manager.mtx.Unlock()
+
+	sender, exists := manager.senders[address]
+	if !exists {
+		return nil, errors.New("nonceManager, unknown sender:" + address.Hex())
+	}
+	if !sender.owned {
+		return nil, errors.New("nonceManager, sender:" + address.Hex() + " is used by another relay")
+	}
+	nonce := new(big.Int).Set(sender.next)
+	next := new(big.Int).Add(nonce, big.NewInt(1))
+	if err := manager.store.SaveHighWater(address, manager.owner, next); nil != err {
+		return nil, fmt.Errorf("nonceManager, save high-water of %s, err:%s", address.Hex(), err.Error())
+	}
+	sender.next = next
+	sender.allocated[nonce.Uint64()] = new(big.Int).Set(manager.currentBlockNumber)
+	return nonce, nil
+}
+
+// Release gives back a nonce whose tx failed to be sent. Only the last allocated one can be reused directly,
+// the others will be filled as gaps.
+func (manager *NonceManager) Release(address common.Address, nonce *big.Int) {
+	manager.mtx.Lock()
+	defer manager.mtx.Unlock()
+
+	sender, exists := manager.senders[address]
+	if !exists || !sender.owned || nil == nonce {
+		return
+	}
+	last := new(big.Int).Sub(sender.next, big.NewInt(1))
+	if last.Cmp(nonce) == 0 {
+		delete(sender.allocated, nonce.Uint64())
+		sender.next.Set(nonce)
+		if err := manager.store.SaveHighWater(address, manager.owner, sender.next); nil != err {
+			log.Errorf("nonceManager, save high-water of %s, err:%s", address.Hex(), err.Error())
+		}
+	}
+}
+
+func (manager *NonceManager) Start() {
+	watcher := &eventemitter.Watcher{
+		Concurrent: true,
+		Handle: func(eventData eventemitter.EventData) error {
+			e := eventData.(*types.BlockEvent)
+			manager.checkGaps(e.BlockNumber)
+			return nil
+		},
+	}
+	eventemitter.On(eventemitter.Block_New, watcher)
+	manager.stopFuncs = append(manager.stopFuncs, func() {
+		eventemitter.Un(eventemitter.Block_New, watcher)
+	})
+
+	//the blocks already checked by Block_New are skipped
+	stopChan := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-time.After(blockPollInterval):
+				var blockNumber types.Big
+				if err := ethaccessor.BlockNumber(&blockNumber); nil != err {
+					log.Errorf("nonceManager, get block number, err:%s", err.Error())
+				} else {
+					manager.checkGaps(blockNumber.BigInt())
+				}
+			}
+		}
+	}()
+	manager.stopFuncs = append(manager.stopFuncs, func() {
+		close(stopChan)
+	})
+}
+
+// Stop gives up the leases, so that the senders can be claimed by other relays at once
+func (manager *NonceManager) Stop() {
+	for _, stop := range manager.stopFuncs {
+		stop()
+	}
+
+	manager.mtx.Lock()
+	addresses := []common.Address{}
+	for address, sender := range manager.senders {
+		if sender.owned {
+			sender.owned = false
+			addresses = append(addresses, address)
+		}
+	}
+	manager.mtx.Unlock()
+	for _, address := range addresses {
+		if err := manager.store.Unclaim(address, manager.owner); nil != err {
+			log.Errorf("nonceManager, unclaim %s, err:%s", address.Hex(), err.Error())
+		}
+	}
+}
+
+type nonceGap struct {
+	address       common.Address
+	nonce         *big.Int
+	gasPriceLimit *big.Int
+}
+
+func (manager *NonceManager) checkGaps(blockNumber *big.Int) {
+	manager.mtx.Lock()
+	if nil == blockNumber || blockNumber.Cmp(manager.currentBlockNumber) <= 0 {
+		manager.mtx.Unlock()
+		return
+	}
+	manager.currentBlockNumber = new(big.Int).Set(blockNumber)
+	addresses := []common.Address{}
+	for address := range manager.senders {
+		addresses = append(addresses, address)
+	}
+	manager.mtx.Unlock()
+
+	gaps := []*nonceGap{}
+	for _, address := range addresses {
+		if !manager.claim(address) {
+			continue
+		}
+		minedNonce, err := manager.transactionCount(address, "latest")
+		if nil != err {
+			log.Errorf("nonceManager, get nonce of %s, err:%s", address.Hex(), err.Error())
+			continue
+		}
+		pendingNonce, err := manager.transactionCount(address, "pending")
+		if nil != err {
+			log.Errorf("nonceManager, get nonce of %s, err:%s", address.Hex(), err.Error())
+			continue
+		}
+		if gap := manager.syncSender(address, minedNonce, pendingNonce, blockNumber); nil != gap {
+			gaps = append(gaps, gap)
+		}
+	}
+
+	for _, gap := range gaps {
+		manager.fillGap(gap)
+	}
+}
+
+// syncSender compares the local nonces with the node. The pending nonce of node is the first nonce that isn't in the txpool,
+// if it has been allocated before this block, the tx using it was dropped or never sent.
+func (manager *NonceManager) syncSender(address common.Address, minedNonce, pendingNonce, blockNumber *big.Int) *nonceGap {
+	manager.mtx.Lock()
+	defer manager.mtx.Unlock()
+
+	sender, exists := manager.senders[address]
+	if !exists || !sender.owned {
+		return nil
+	}
+	for nonce := range sender.allocated {
+		if minedNonce.Cmp(new(big.Int).SetUint64(nonce)) > 0 {
+			delete(sender.allocated, nonce)
+		}
+	}
+	//the address was used out of the relays
+	if pendingNonce.Cmp(sender.next) > 0 {
+		log.Infof("nonceManager, nonce of %s is moved from %s to %s", address.Hex(), sender.next.String(), pendingNonce.String())
+		sender.next.Set(pendingNonce)
+		if err := manager.store.SaveHighWater(address, manager.owner, sender.next); nil != err {
+			log.Errorf("nonceManager, save high-water of %s, err:%s", address.Hex(), err.Error())
+		}
+		return nil
+	}
+	if pendingNonce.Cmp(sender.next) == 0 {
+		return nil
+	}
+	allocatedBlock, allocated := sender.allocated[pendingNonce.Uint64()]
+	if allocated && allocatedBlock.Cmp(blockNumber) >= 0 {
+		return nil
+	}
+	log.Infof("nonceManager, found gap of %s, nonce:%s, next:%s", address.Hex(), pendingNonce.String(), sender.next.String())
+	return &nonceGap{address: address, nonce: new(big.Int).Set(pendingNonce), gasPriceLimit: sender.gasPriceLimit}
+}
+
+func (manager *NonceManager) fillGap(gap *nonceGap) {
+	if nil == gap.gasPriceLimit || gap.gasPriceLimit.Sign() <= 0 {
+		log.Errorf("nonceManager, gap of %s at nonce:%s can't be filled without gasPriceLimit", gap.address.Hex(), gap.nonce.String())
+		return
+	}
+	gasPrice := ethaccessor.EstimateGasPrice(nil, gap.gasPriceLimit)
+	if txHash, err := ethaccessor.SignAndSendTransactionWithNonce(gap.address, gap.address, big.NewInt(gapFillGas), gasPrice, big.NewInt(0), gap.nonce, []byte{}); nil != err {
+		log.Errorf("nonceManager, fill gap of %s at nonce:%s, err:%s", gap.address.Hex(), gap.nonce.String(), err.Error())
+	} else {
+		log.Infof("nonceManager, gap of %s at nonce:%s has been filled by tx:%s", gap.address.Hex(), gap.nonce.String(), txHash)
+	}
+}
+
+func getTransactionCount(address common.Address, tag string) (*big.Int, error) {
+	var count types.Big
+	if err := ethaccessor.GetTransactionCount(&count, address, tag); nil != err {
+		return nil, err
+	}
+	return count.BigInt(), nil
+}
+
+// RedisNonceStore shares the leases and high-water marks between the relays behind the same redis
+type RedisNonceStore struct{}
+
+func (store *RedisNonceStore) Claim(address common.Address, owner string, ttl time.Duration) (bool, error) {
+	reply, err := cache.Eval(claimSenderScript, []string{NonceOwnerPrefix + address.Hex()}, owner, int64(ttl/time.Millisecond))
+	if nil != err {
+		return false, err
+	}
+	claimed, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("unexpected reply:%v of claiming sender", reply)
+	}
+	return 1 == claimed, nil
+}
+
+func (store *RedisNonceStore) Unclaim(address common.Address, owner string) error {
+	_, err := cache.Eval(unclaimSenderScript, []string{NonceOwnerPrefix + address.Hex()}, owner)
+	return err
+}
+
+func (store *RedisNonceStore) LoadHighWater(address common.Address) (*big.Int, error) {
+	key := NonceHighWaterPrefix + address.Hex()
+	if exists, err := cache.Exists(key); nil != err || !exists {
+		return nil, err
+	}
+	data, err := cache.Get(key)
+	if nil != err {
+		return nil, err
+	}
+	highWater, ok := new(big.Int).SetString(string(data), 10)
+	if !ok {
+		return nil, errors.New("invalid nonce high-water:" + string(data))
+	}
+	return highWater, nil
+}
+
+func (store *RedisNonceStore) SaveHighWater(address common.Address, owner string, highWater *big.Int) error {
+	reply, err := cache.Eval(saveHighWaterScript, []string{NonceOwnerPrefix + address.Hex(), NonceHighWaterPrefix + address.Hex()}, owner, highWater.String())
+	if nil != err {
+		return err
+	}
+	if saved, ok := reply.(int64); !ok || 1 != saved {
+		return errors.New("the lease of sender has been lost")
+	}
+	return nil
+}
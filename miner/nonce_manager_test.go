@@ -0,0 +1,300 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+}
+
+var testSender = common.HexToAddress("0x0100")
+
+type memNonceStore struct {
+	owners     map[common.Address]string
+	highWaters map[common.Address]*big.Int
+}
+
+func newMemNonceStore() *memNonceStore {
+	return &memNonceStore{owners: map[common.Address]string{}, highWaters: map[common.Address]*big.Int{}}
+}
+
+func (store *memNonceStore) Claim(address common.Address, owner string, ttl time.Duration) (bool, error) {
+	if current, exists := store.owners[address]; exists && current != owner {
+		return false, nil
+	}
+	store.owners[address] = owner
+	return true, nil
+}
+
+func (store *memNonceStore) Unclaim(address common.Address, owner string) error {
+	if store.owners[address] == owner {
+		delete(store.owners, address)
+	}
+	return nil
+}
+
+func (store *memNonceStore) LoadHighWater(address common.Address) (*big.Int, error) {
+	if highWater, exists := store.highWaters[address]; exists {
+		return new(big.Int).Set(highWater), nil
+	}
+	return nil, nil
+}
+
+func (store *memNonceStore) SaveHighWater(address common.Address, owner string, highWater *big.Int) error {
+	if store.owners[address] != owner {
+		return errors.New("the lease of sender has been lost")
+	}
+	store.highWaters[address] = new(big.Int).Set(highWater)
+	return nil
+}
+
+func (store *memNonceStore) highWater() int64 {
+	if highWater, exists := store.highWaters[testSender]; exists {
+		return highWater.Int64()
+	}
+	return -1
+}
+
+// newTestNonceManager returns a manager whose node reports the nonces of testSender in counts
+func newTestNonceManager(store *memNonceStore, owner string, counts map[string]int64) *NonceManager {
+	manager := NewNonceManager()
+	manager.store = store
+	manager.owner = owner
+	manager.transactionCount = func(address common.Address, tag string) (*big.Int, error) {
+		count, exists := counts[tag]
+		if !exists {
+			return nil, errors.New("no nonce of " + tag)
+		}
+		return big.NewInt(count), nil
+	}
+	return manager
+}
+
+func allocatedNonces(manager *NonceManager) string {
+	nonces := []int{}
+	for nonce := range manager.senders[testSender].allocated {
+		nonces = append(nonces, int(nonce))
+	}
+	sort.Ints(nonces)
+	return fmt.Sprint(nonces)
+}
+
+func TestNonceManager_Allocate(t *testing.T) {
+	tests := []struct {
+		name      string
+		pending   int64
+		highWater int64  //the high-water mark left before restart, it's ignored if it's 0
+		owner     string //the relay holding the lease before AddSender
+		loseLease bool   //the lease is taken by another relay after AddSender
+		count     int
+		nonces    string
+		allocated string
+		err       bool
+		next      int64
+	}{
+		{name: "new sender", pending: 5, count: 3, nonces: "[5 6 7]", allocated: "[5 6 7]", next: 8},
+		{name: "high-water after restart", pending: 6, highWater: 8, count: 1, nonces: "[8]", allocated: "[6 7 8]", next: 9},
+		{name: "high-water below pending nonce", pending: 6, highWater: 4, count: 1, nonces: "[6]", allocated: "[6]", next: 7},
+		{name: "same relay after restart", pending: 5, owner: "relay1", count: 1, nonces: "[5]", allocated: "[5]", next: 6},
+		{name: "used by another relay", pending: 5, highWater: 7, owner: "relay2", count: 1, err: true, allocated: "[]", next: 7},
+		{name: "lease lost", pending: 5, loseLease: true, count: 1, err: true, allocated: "[]", next: -1},
+	}
+
+	for _, test := range tests {
+		store := newMemNonceStore()
+		if test.highWater > 0 {
+			store.highWaters[testSender] = big.NewInt(test.highWater)
+		}
+		if "" != test.owner {
+			store.owners[testSender] = test.owner
+		}
+		manager := newTestNonceManager(store, "relay1", map[string]int64{"pending": test.pending})
+		manager.AddSender(testSender, nil)
+		if test.loseLease {
+			store.owners[testSender] = "relay2"
+		}
+
+		nonces := []int64{}
+		var err error
+		for i := 0; i < test.count; i++ {
+			var nonce *big.Int
+			if nonce, err = manager.Allocate(testSender); nil != err {
+				break
+			}
+			nonces = append(nonces, nonce.Int64())
+		}
+		if test.err != (nil != err) {
+			t.Errorf("%s: unexpected err:%v", test.name, err)
+			continue
+		}
+		if !test.err && fmt.Sprint(nonces) != test.nonces {
+			t.Errorf("%s: nonces should be %s, got:%v", test.name, test.nonces, nonces)
+		}
+		if allocated := allocatedNonces(manager); allocated != test.allocated {
+			t.Errorf("%s: allocated nonces should be %s, got:%s", test.name, test.allocated, allocated)
+		}
+		if highWater := store.highWater(); highWater != test.next {
+			t.Errorf("%s: high-water should be %d, got:%d", test.name, test.next, highWater)
+		}
+	}
+
+	if _, err := newTestNonceManager(newMemNonceStore(), "relay1", nil).Allocate(testSender); nil == err {
+		t.Errorf("the nonce of unknown sender shouldn't be allocated")
+	}
+}
+
+func TestNonceManager_Release(t *testing.T) {
+	tests := []struct {
+		name     string
+		releases []int64 //-1 is nil
+		next     int64
+	}{
+		{name: "last one", releases: []int64{7}, next: 7},
+		{name: "in the middle", releases: []int64{6}, next: 8},
+		{name: "last two in reverse order", releases: []int64{7, 6}, next: 6},
+		{name: "last two in order", releases: []int64{6, 7}, next: 7},
+		{name: "not allocated", releases: []int64{8}, next: 8},
+		{name: "nil", releases: []int64{-1}, next: 8},
+	}
+
+	for _, test := range tests {
+		store := newMemNonceStore()
+		manager := newTestNonceManager(store, "relay1", map[string]int64{"pending": 5})
+		manager.AddSender(testSender, nil)
+		for i := 0; i < 3; i++ {
+			manager.Allocate(testSender)
+		}
+		for _, nonce := range test.releases {
+			if nonce < 0 {
+				manager.Release(testSender, nil)
+			} else {
+				manager.Release(testSender, big.NewInt(nonce))
+			}
+		}
+		if highWater := store.highWater(); highWater != test.next {
+			t.Errorf("%s: high-water should be %d, got:%d", test.name, test.next, highWater)
+		}
+		if nonce, err := manager.Allocate(testSender); nil != err || nonce.Int64() != test.next {
+			t.Errorf("%s: the next nonce should be %d, got:%v, err:%v", test.name, test.next, nonce, err)
+		}
+	}
+}
+
+func TestNonceManager_SyncSender(t *testing.T) {
+	//the nonces 5, 6, 7 are allocated in block 10
+	allocate := func(manager *NonceManager, store *memNonceStore) {
+		manager.currentBlockNumber = big.NewInt(10)
+		for i := 0; i < 3; i++ {
+			manager.Allocate(testSender)
+		}
+	}
+	tests := []struct {
+		name      string
+		highWater int64
+		setup     func(manager *NonceManager, store *memNonceStore)
+		mined     int64
+		pending   int64
+		block     int64
+		gap       int64 //-1 means no gap
+		next      int64
+		allocated string
+	}{
+		{name: "all in txpool", setup: allocate, mined: 5, pending: 8, block: 11, gap: -1, next: 8, allocated: "[5 6 7]"},
+		{name: "mined", setup: allocate, mined: 8, pending: 8, block: 11, gap: -1, next: 8, allocated: "[]"},
+		{name: "dropped", setup: allocate, mined: 5, pending: 6, block: 11, gap: 6, next: 8, allocated: "[5 6 7]"},
+		{name: "dropped after the mined ones", setup: allocate, mined: 7, pending: 7, block: 11, gap: 7, next: 8, allocated: "[7]"},
+		{name: "allocated in this block", setup: allocate, mined: 5, pending: 6, block: 10, gap: -1, next: 8, allocated: "[5 6 7]"},
+		{name: "used out of the relays", setup: allocate, mined: 5, pending: 12, block: 11, gap: -1, next: 12, allocated: "[5 6 7]"},
+		{name: "idle", mined: 5, pending: 5, block: 11, gap: -1, next: 5, allocated: "[]"},
+		{name: "high-water after restart", highWater: 8, mined: 5, pending: 5, block: 1, gap: 5, next: 8, allocated: "[5 6 7]"},
+		{name: "high-water after restart and mined", highWater: 8, mined: 8, pending: 8, block: 1, gap: -1, next: 8, allocated: "[]"},
+		{
+			name:  "used by another relay",
+			setup: func(manager *NonceManager, store *memNonceStore) { manager.senders[testSender].owned = false },
+			mined: 3, pending: 3, block: 11, gap: -1, next: -1, allocated: "[]",
+		},
+	}
+
+	for _, test := range tests {
+		store := newMemNonceStore()
+		if test.highWater > 0 {
+			store.highWaters[testSender] = big.NewInt(test.highWater)
+		}
+		//the pending nonce of node is 5 when the sender is added
+		manager := newTestNonceManager(store, "relay1", map[string]int64{"pending": 5})
+		manager.AddSender(testSender, nil)
+		if nil != test.setup {
+			test.setup(manager, store)
+		}
+
+		gap := manager.syncSender(testSender, big.NewInt(test.mined), big.NewInt(test.pending), big.NewInt(test.block))
+		if test.gap < 0 && nil != gap {
+			t.Errorf("%s: no gap should be found, got:%s", test.name, gap.nonce.String())
+		}
+		if test.gap >= 0 && (nil == gap || gap.nonce.Int64() != test.gap) {
+			t.Errorf("%s: the gap should be %d, got:%v", test.name, test.gap, gap)
+		}
+		if test.next >= 0 && manager.senders[testSender].next.Int64() != test.next {
+			t.Errorf("%s: next nonce should be %d, got:%s", test.name, test.next, manager.senders[testSender].next.String())
+		}
+		if allocated := allocatedNonces(manager); allocated != test.allocated {
+			t.Errorf("%s: allocated nonces should be %s, got:%s", test.name, test.allocated, allocated)
+		}
+	}
+}
+
+func TestNonceManager_Claim(t *testing.T) {
+	store := newMemNonceStore()
+	counts := map[string]int64{"latest": 5, "pending": 5}
+	relay1 := newTestNonceManager(store, "relay1", counts)
+	relay2 := newTestNonceManager(store, "relay2", counts)
+	relay1.AddSender(testSender, nil)
+	relay2.AddSender(testSender, nil)
+
+	if nonce, err := relay1.Allocate(testSender); nil != err || 5 != nonce.Int64() {
+		t.Fatalf("the nonce should be allocated by the owner, nonce:%v, err:%v", nonce, err)
+	}
+	relay2.checkGaps(big.NewInt(1))
+	if _, err := relay2.Allocate(testSender); nil == err {
+		t.Fatalf("the nonce shouldn't be allocated by another relay")
+	}
+
+	//the sender is taken over after the owner stopped, the nonce allocated by the last owner isn't used again
+	relay1.Stop()
+	if _, err := relay1.Allocate(testSender); nil == err {
+		t.Fatalf("the nonce shouldn't be allocated after stopped")
+	}
+	relay2.checkGaps(big.NewInt(2))
+	if nonce, err := relay2.Allocate(testSender); nil != err || 6 != nonce.Int64() {
+		t.Fatalf("the nonce should be allocated after the high-water of the last owner, nonce:%v, err:%v", nonce, err)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	SIMULATE_DROP       = "drop"       //the failed ring is dropped, it can be matched again in the next round
+	SIMULATE_QUARANTINE = "quarantine" //the failed ring is regarded as a failed submission, it won't be matched after failing several times
+)
+
+//the selector of Error(string), which is used by revert(reason)
+var revertSelector = common.FromHex("0x08c379a0")
+
+type RingRevertedError struct {
+	Reason string
+}
+
+func (e *RingRevertedError) Error() string {
+	if "" == e.Reason {
+		return "ring reverted in simulation"
+	}
+	return "ring reverted in simulation, reason:" + e.Reason
+}
+
+// RingSimulation is the result of dry-running a ring, Err is set if the ring will be reverted
+type RingSimulation struct {
+	RingSubmitInfo *types.RingSubmitInfo
+	EstimatedGas   *big.Int
+	Err            error
+}
+
+// SimulateRingSubmitInfo runs the submitRing tx by eth_call against the pending block,
+// it returns the estimated gas or a RingRevertedError.
+func (submitter *RingSubmitter) SimulateRingSubmitInfo(ringSubmitInfo *types.RingSubmitInfo) (*big.Int, error) {
+	callArg := &ethaccessor.CallArg{}
+	callArg.From = ringSubmitInfo.Miner
+	callArg.To = ringSubmitInfo.ProtocolAddress
+	callArg.Data = common.ToHex(ringSubmitInfo.ProtocolData)
+	if nil != ringSubmitInfo.ProtocolGas {
+		callArg.Gas = new(types.Big).SetInt(ringSubmitInfo.ProtocolGas)
+	}
+	if nil != ringSubmitInfo.ProtocolGasPrice {
+		callArg.GasPrice = new(types.Big).SetInt(ringSubmitInfo.ProtocolGasPrice)
+	}
+
+	var result string
+	if err := submitter.callContract(&result, callArg, "pending"); nil != err {
+		return nil, &RingRevertedError{Reason: err.Error()}
+	}
+	if reason, reverted := decodeRevertReason(common.FromHex(result)); reverted {
+		return nil, &RingRevertedError{Reason: reason}
+	}
+
+	//the gas is omitted, otherwise it only tells whether the given gas is enough
+	callArg.Gas = types.Big{}
+	var gas types.Big
+	if err := submitter.estimateGasOfCall(&gas, callArg, "pending"); nil != err {
+		return nil, &RingRevertedError{Reason: err.Error()}
+	}
+	return gas.BigInt(), nil
+}
+
+// SimulateRing builds the ring of orders in the given sequence and dry-runs it,
+// the error is returned only if the ring can't be built.
+func (minerInstance *Miner) SimulateRing(orders []*types.OrderState) (*RingSimulation, error) {
+	if len(orders) < 2 {
+		return nil, errors.New("a ring needs two orders at least")
+	}
+	filledOrders := []*types.FilledOrder{}
+	for idx, order := range orders {
+		next := orders[(idx+1)%len(orders)]
+		if order.RawOrder.TokenB != next.RawOrder.TokenS {
+			return nil, fmt.Errorf("the tokenB of order:%s isn't the tokenS of order:%s", order.RawOrder.Hash.Hex(), next.RawOrder.Hash.Hex())
+		}
+		impl, exists := ethaccessor.ProtocolAddresses()[order.RawOrder.Protocol]
+		if !exists {
+			return nil, fmt.Errorf("the protocol:%s of order:%s isn't supported", order.RawOrder.Protocol.Hex(), order.RawOrder.Hash.Hex())
+		}
+		lrcBalance, err := minerInstance.matcher.GetAccountAvailableAmount(order.RawOrder.Owner, impl.LrcTokenAddress, impl.DelegateAddress)
+		if nil != err {
+			return nil, err
+		}
+		tokenSBalance, err := minerInstance.matcher.GetAccountAvailableAmount(order.RawOrder.Owner, order.RawOrder.TokenS, impl.DelegateAddress)
+		if nil != err {
+			return nil, err
+		}
+		filledOrders = append(filledOrders, types.ConvertOrderStateToFilledOrder(*order, lrcBalance, tokenSBalance, impl.LrcTokenAddress))
+	}
+
+	ring := NewRing(filledOrders)
+	if err := minerInstance.evaluator.ComputeRing(ring); nil != err {
+		return nil, err
+	}
+	ringSubmitInfo, err := minerInstance.submitter.GenerateRingSubmitInfo(ring)
+	if nil != err {
+		return nil, err
+	}
+
+	simulation := &RingSimulation{RingSubmitInfo: ringSubmitInfo}
+	simulation.EstimatedGas, simulation.Err = minerInstance.submitter.SimulateRingSubmitInfo(ringSubmitInfo)
+	return simulation, nil
+}
+
+// decodeRevertReason decodes the return data of revert(reason), the layout is
+// selector(4 bytes) + offset(32 bytes) + length(32 bytes) + reason
+func decodeRevertReason(data []byte) (string, bool) {
+	if len(data) < len(revertSelector) || !bytes.Equal(data[:len(revertSelector)], revertSelector) {
+		return "", false
+	}
+	data = data[len(revertSelector):]
+	if len(data) < 64 {
+		return "", true
+	}
+	offset := new(big.Int).SetBytes(data[:32])
+	if !offset.IsInt64() || offset.Int64()+32 > int64(len(data)) {
+		return "", true
+	}
+	start := offset.Int64() + 32
+	length := new(big.Int).SetBytes(data[offset.Int64():start])
+	if !length.IsInt64() || start+length.Int64() > int64(len(data)) {
+		return "", true
+	}
+	return string(data[start : start+length.Int64()]), true
+}
@@ -0,0 +1,152 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// revertData is the return data of revert(reason)
+func revertData(reason string) []byte {
+	data := append([]byte{}, revertSelector...)
+	data = append(data, common.LeftPadBytes(big.NewInt(32).Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(reason))).Bytes(), 32)...)
+	padded := make([]byte, (len(reason)+31)/32*32)
+	copy(padded, reason)
+	return append(data, padded...)
+}
+
+func withOffset(data []byte, offset *big.Int) []byte {
+	data = append([]byte{}, data...)
+	copy(data[len(revertSelector):], common.LeftPadBytes(offset.Bytes(), 32))
+	return data
+}
+
+func TestDecodeRevertReason(t *testing.T) {
+	full := revertData("order cancelled")
+	tests := []struct {
+		name     string
+		data     []byte
+		reason   string
+		reverted bool
+	}{
+		{name: "Error(string)", data: full, reason: "order cancelled", reverted: true},
+		{name: "empty reason", data: revertData(""), reason: "", reverted: true},
+		{name: "long reason", data: revertData(string(make([]byte, 70))), reason: string(make([]byte, 70)), reverted: true},
+		{name: "selector only", data: revertSelector, reason: "", reverted: true},
+		{name: "short data", data: full[:40], reason: "", reverted: true},
+		{name: "offset out of data", data: withOffset(full, big.NewInt(0xff)), reason: "", reverted: true},
+		{name: "huge offset", data: withOffset(full, new(big.Int).Lsh(big.NewInt(1), 64)), reason: "", reverted: true},
+		{name: "length out of data", data: full[:len(full)-20], reason: "", reverted: true},
+		{name: "empty output", data: []byte{}, reason: "", reverted: false},
+		{name: "nil output", data: nil, reason: "", reverted: false},
+		{name: "garbage shorter than selector", data: []byte{0x08, 0xc3}, reason: "", reverted: false},
+		{name: "another selector", data: append(common.FromHex("0x12345678"), full[4:]...), reason: "", reverted: false},
+		{name: "normal output", data: common.LeftPadBytes([]byte{1}, 32), reason: "", reverted: false},
+	}
+
+	for _, test := range tests {
+		reason, reverted := decodeRevertReason(test.data)
+		if reason != test.reason || reverted != test.reverted {
+			t.Errorf("%s: expected reason:%q reverted:%t, got reason:%q reverted:%t", test.name, test.reason, test.reverted, reason, reverted)
+		}
+	}
+}
+
+func TestRingSubmitter_SimulateRing(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		callErr     error
+		callResult  []byte
+		estimateErr error
+		simulated   bool
+		status      types.TxStatus
+		reason      string //the reason of RingRevertedError, "-" means the error isn't RingRevertedError
+	}{
+		{name: "not simulated", mode: "", callErr: errors.New("reverted"), status: types.TX_STATUS_FAILED, reason: "-"},
+		{name: "drop reverted ring", mode: SIMULATE_DROP, callResult: revertData("order cancelled"), simulated: true, status: types.TX_STATUS_UNKNOWN, reason: "order cancelled"},
+		{name: "drop failed call", mode: SIMULATE_DROP, callErr: errors.New("execution error"), simulated: true, status: types.TX_STATUS_UNKNOWN, reason: "execution error"},
+		{name: "quarantine reverted ring", mode: SIMULATE_QUARANTINE, callResult: revertData("order cancelled"), simulated: true, status: types.TX_STATUS_FAILED, reason: "order cancelled"},
+		{name: "quarantine failed estimation", mode: SIMULATE_QUARANTINE, estimateErr: errors.New("out of gas"), simulated: true, status: types.TX_STATUS_FAILED, reason: "out of gas"},
+		//the ring passed the simulation is sent, it fails because the sender isn't added to the nonce manager
+		{name: "drop passed ring", mode: SIMULATE_DROP, simulated: true, status: types.TX_STATUS_FAILED, reason: "-"},
+		{name: "quarantine passed ring", mode: SIMULATE_QUARANTINE, simulated: true, status: types.TX_STATUS_FAILED, reason: "-"},
+	}
+
+	for _, test := range tests {
+		options := config.MinerOptions{FeeReceipt: "0x750aD4351bB728ceC7d639A9511F9D6488f1E259", SimulateRing: test.mode}
+		submitter, err := NewSubmitter(options, nil, nil)
+		if nil != err {
+			t.Fatal(err.Error())
+		}
+		simulated := false
+		submitter.callContract = func(result interface{}, callArg *ethaccessor.CallArg, blockNumber string) error {
+			simulated = true
+			if callArg.Gas.BigInt().Int64() != 500000 || "pending" != blockNumber {
+				t.Errorf("%s: the ring should be called with its gas against the pending block", test.name)
+			}
+			*result.(*string) = common.ToHex(test.callResult)
+			return test.callErr
+		}
+		submitter.estimateGasOfCall = func(result interface{}, callArg *ethaccessor.CallArg, blockNumber string) error {
+			if callArg.Gas.BigInt().Sign() != 0 {
+				t.Errorf("%s: the gas should be omitted in estimation", test.name)
+			}
+			*result.(*types.Big) = *types.NewBigWithInt(300000)
+			return test.estimateErr
+		}
+
+		ringSubmitInfo := &types.RingSubmitInfo{RawRing: &types.Ring{}, Miner: testSender, ProtocolGas: big.NewInt(500000)}
+		_, status, err := submitter.submitRing(ringSubmitInfo)
+		if simulated != test.simulated {
+			t.Errorf("%s: simulated should be %t", test.name, test.simulated)
+		}
+		if status != test.status {
+			t.Errorf("%s: status should be %d, got:%d", test.name, test.status, status)
+		}
+		revertedErr, reverted := err.(*RingRevertedError)
+		if "-" == test.reason && reverted {
+			t.Errorf("%s: the ring shouldn't be reverted, err:%s", test.name, err.Error())
+		}
+		if "-" != test.reason && (!reverted || revertedErr.Reason != test.reason) {
+			t.Errorf("%s: the ring should be reverted by %q, err:%v", test.name, test.reason, err)
+		}
+	}
+
+	submitter, _ := NewSubmitter(config.MinerOptions{FeeReceipt: "0x750aD4351bB728ceC7d639A9511F9D6488f1E259"}, nil, nil)
+	submitter.callContract = func(result interface{}, callArg *ethaccessor.CallArg, blockNumber string) error {
+		*result.(*string) = "0x"
+		return nil
+	}
+	submitter.estimateGasOfCall = func(result interface{}, callArg *ethaccessor.CallArg, blockNumber string) error {
+		*result.(*types.Big) = *types.NewBigWithInt(300000)
+		return nil
+	}
+	if gas, err := submitter.SimulateRingSubmitInfo(&types.RingSubmitInfo{Miner: testSender}); nil != err || 300000 != gas.Int64() {
+		t.Errorf("the estimated gas should be returned, gas:%v, err:%v", gas, err)
+	}
+}
@@ -0,0 +1,306 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//the node rejects a replacement whose gas price isn't raised by 10 percent at least
+const MinGasPriceBumpPercent = 10
+
+//Block_New is only emitted by the extractor, the miner without it or the event bus polls the block number
+const blockPollInterval = 15 * time.Second
+
+type pendingRingTx struct {
+	info              *dao.RingSubmitInfo
+	nonce             *big.Int
+	gasPrice          *big.Int
+	submitBlockNumber *big.Int
+	checking          bool //it's checked without the lock of tracker
+}
+
+// SubmitTracker watches the ring txs sent by normal miners,
+// the tx still pending after MaxPendingTtl blocks will be sent again with the same nonce and a higher gas price.
+// It's driven by Block_New, and by polling the block number in case the process runs no extractor.
+type SubmitTracker struct {
+	mtx                sync.Mutex
+	dbService          dao.RdsService
+	senders            map[common.Address]*NormalSenderAddress
+	pendingTxs         map[common.Hash]*pendingRingTx
+	currentBlockNumber *big.Int
+	stopFuncs          []func()
+}
+
+func NewSubmitTracker(dbService dao.RdsService, senders []*NormalSenderAddress) *SubmitTracker {
+	tracker := &SubmitTracker{}
+	tracker.mtx = sync.Mutex{}
+	tracker.dbService = dbService
+	tracker.senders = make(map[common.Address]*NormalSenderAddress)
+	for _, sender := range senders {
+		tracker.senders[sender.Address] = sender
+	}
+	tracker.pendingTxs = make(map[common.Hash]*pendingRingTx)
+	tracker.currentBlockNumber = big.NewInt(0)
+	tracker.stopFuncs = []func(){}
+	return tracker
+}
+
+// Track adds a ring tx that has been saved
+func (tracker *SubmitTracker) Track(info *dao.RingSubmitInfo) {
+	if sender, exists := tracker.senders[common.HexToAddress(info.Miner)]; !exists || sender.MaxPendingTtl <= 0 {
+		return
+	}
+	nonce, ok := new(big.Int).SetString(info.ProtocolNonce, 0)
+	if !ok {
+		log.Errorf("submitTracker, can't track tx:%s without nonce", info.ProtocolTxHash)
+		return
+	}
+
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+	tracker.pendingTxs[common.HexToHash(info.ProtocolTxHash)] = &pendingRingTx{
+		info:              info,
+		nonce:             nonce,
+		gasPrice:          gasPriceOf(info),
+		submitBlockNumber: new(big.Int).Set(tracker.currentBlockNumber),
+	}
+}
+
+func (tracker *SubmitTracker) Start() {
+	//the txs pending before restart are tracked again, their ttl begins from the next block
+	for address := range tracker.senders {
+		if infos, err := tracker.dbService.GetPendingRingSubmitInfos(address); nil != err {
+			log.Errorf("submitTracker, load pending txs of %s, err:%s", address.Hex(), err.Error())
+		} else {
+			for _, info := range infos {
+				tracker.Track(info)
+			}
+		}
+	}
+
+	watcher := &eventemitter.Watcher{
+		Concurrent: true,
+		Handle: func(eventData eventemitter.EventData) error {
+			e := eventData.(*types.BlockEvent)
+			tracker.checkPendingTxs(e.BlockNumber)
+			return nil
+		},
+	}
+	eventemitter.On(eventemitter.Block_New, watcher)
+	tracker.stopFuncs = append(tracker.stopFuncs, func() {
+		eventemitter.Un(eventemitter.Block_New, watcher)
+	})
+
+	//the blocks already checked by Block_New are skipped
+	stopChan := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-time.After(blockPollInterval):
+				var blockNumber types.Big
+				if err := ethaccessor.BlockNumber(&blockNumber); nil != err {
+					log.Errorf("submitTracker, get block number, err:%s", err.Error())
+				} else {
+					tracker.checkPendingTxs(blockNumber.BigInt())
+				}
+			}
+		}
+	}()
+	tracker.stopFuncs = append(tracker.stopFuncs, func() {
+		close(stopChan)
+	})
+}
+
+func (tracker *SubmitTracker) Stop() {
+	for _, stop := range tracker.stopFuncs {
+		stop()
+	}
+}
+
+// checkPendingTxs removes the mined txs and replaces the ones pending for MaxPendingTtl blocks,
+// the rpcs are called without the lock so that Track and the other blocks aren't blocked by a slow node.
+func (tracker *SubmitTracker) checkPendingTxs(blockNumber *big.Int) {
+	checkingTxs := tracker.startChecking(blockNumber)
+
+	minedNonces := make(map[common.Address]*big.Int)
+	for txHash, pendingTx := range checkingTxs {
+		sender := tracker.senders[common.HexToAddress(pendingTx.info.Miner)]
+
+		minedNonce, exists := minedNonces[sender.Address]
+		if !exists {
+			var txCount types.Big
+			if err := ethaccessor.GetTransactionCount(&txCount, sender.Address, "latest"); nil != err {
+				log.Errorf("submitTracker, get nonce of %s, err:%s", sender.Address.Hex(), err.Error())
+				tracker.finishChecking(txHash, pendingTx, false, nil, blockNumber)
+				continue
+			}
+			minedNonce = txCount.BigInt()
+			minedNonces[sender.Address] = minedNonce
+		}
+		//this tx or one of its replacements has been mined
+		if pendingTx.nonce.Cmp(minedNonce) < 0 {
+			tracker.finishChecking(txHash, pendingTx, true, nil, blockNumber)
+			continue
+		}
+
+		pendingBlocks := new(big.Int).Sub(blockNumber, pendingTx.submitBlockNumber)
+		if pendingBlocks.Int64() < int64(sender.MaxPendingTtl) {
+			tracker.finishChecking(txHash, pendingTx, false, nil, blockNumber)
+			continue
+		}
+		if replacedInfo, err := tracker.replace(sender, txHash, pendingTx); nil != err {
+			log.Errorf("submitTracker, replace tx:%s, err:%s", txHash.Hex(), err.Error())
+			//retry after another MaxPendingTtl blocks
+			pendingTx.submitBlockNumber.Set(blockNumber)
+			tracker.finishChecking(txHash, pendingTx, false, nil, blockNumber)
+		} else {
+			tracker.finishChecking(txHash, pendingTx, true, replacedInfo, blockNumber)
+		}
+	}
+}
+
+// startChecking returns the txs to be checked in blockNumber, they are skipped by the other blocks until finishChecking
+func (tracker *SubmitTracker) startChecking(blockNumber *big.Int) map[common.Hash]*pendingRingTx {
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	checkingTxs := make(map[common.Hash]*pendingRingTx)
+	if nil == blockNumber || blockNumber.Cmp(tracker.currentBlockNumber) <= 0 {
+		return checkingTxs
+	}
+	tracker.currentBlockNumber = new(big.Int).Set(blockNumber)
+
+	for txHash, pendingTx := range tracker.pendingTxs {
+		//it's still being checked in the last block
+		if pendingTx.checking {
+			continue
+		}
+		//it was tracked before the first block arrived
+		if pendingTx.submitBlockNumber.Sign() <= 0 {
+			pendingTx.submitBlockNumber.Set(blockNumber)
+			continue
+		}
+		pendingTx.checking = true
+		checkingTxs[txHash] = pendingTx
+	}
+	return checkingTxs
+}
+
+// finishChecking removes the tx if it has been mined or replaced, the replacement is tracked from blockNumber
+func (tracker *SubmitTracker) finishChecking(txHash common.Hash, pendingTx *pendingRingTx, removed bool, replacedInfo *dao.RingSubmitInfo, blockNumber *big.Int) {
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	pendingTx.checking = false
+	if removed {
+		delete(tracker.pendingTxs, txHash)
+	}
+	if nil != replacedInfo {
+		tracker.pendingTxs[common.HexToHash(replacedInfo.ProtocolTxHash)] = &pendingRingTx{
+			info:              replacedInfo,
+			nonce:             pendingTx.nonce,
+			gasPrice:          gasPriceOf(replacedInfo),
+			submitBlockNumber: new(big.Int).Set(blockNumber),
+		}
+	}
+}
+
+func (tracker *SubmitTracker) replace(sender *NormalSenderAddress, txHash common.Hash, pendingTx *pendingRingTx) (*dao.RingSubmitInfo, error) {
+	gasPrice, err := bumpGasPrice(pendingTx.gasPrice, sender.GasPriceLimit, sender.GasPriceBumpPercent)
+	if nil != err {
+		return nil, err
+	}
+	gas, ok := new(big.Int).SetString(pendingTx.info.ProtocolGas, 0)
+	if !ok {
+		return nil, errors.New("invalid gas:" + pendingTx.info.ProtocolGas)
+	}
+
+	newTxHash, err := ethaccessor.SignAndSendTransactionWithNonce(sender.Address,
+		common.HexToAddress(pendingTx.info.ProtocolAddress),
+		gas,
+		gasPrice,
+		nil,
+		pendingTx.nonce,
+		common.FromHex(pendingTx.info.ProtocolData))
+	if nil != err {
+		return nil, err
+	}
+	log.Infof("submitTracker, ringhash:%s, tx:%s has been replaced by tx:%s, nonce:%s, gasPrice:%s", pendingTx.info.RingHash, txHash.Hex(), newTxHash, pendingTx.nonce.String(), gasPrice.String())
+
+	info := &dao.RingSubmitInfo{}
+	*info = *pendingTx.info
+	info.ID = 0
+	info.ProtocolTxHash = newTxHash
+	info.ProtocolGasPrice = gasPrice.String()
+	info.ReplacedTxHash = txHash.Hex()
+	info.Err = ""
+	info.CreateTime = time.Now()
+	if err := tracker.dbService.ReplaceRingSubmitInfo(info); nil != err {
+		log.Errorf("submitTracker, insert replaced tx:%s, err:%s", newTxHash, err.Error())
+	}
+	return info, nil
+}
+
+func gasPriceOf(info *dao.RingSubmitInfo) *big.Int {
+	gasPrice, ok := new(big.Int).SetString(info.ProtocolGasPrice, 0)
+	if !ok {
+		return big.NewInt(0)
+	}
+	return gasPrice
+}
+
+// bumpGasPrice raises the gas price by bumpPercent and caps it at limit,
+// it returns an error if the capped price is too low to replace the pending tx.
+func bumpGasPrice(gasPrice, limit *big.Int, bumpPercent int64) (*big.Int, error) {
+	if bumpPercent < MinGasPriceBumpPercent {
+		bumpPercent = MinGasPriceBumpPercent
+	}
+	minGasPrice := new(big.Int).Mul(gasPrice, big.NewInt(100+MinGasPriceBumpPercent))
+	minGasPrice.Div(minGasPrice, big.NewInt(100))
+	if minGasPrice.Cmp(gasPrice) <= 0 {
+		minGasPrice.Add(gasPrice, big.NewInt(1))
+	}
+
+	newGasPrice := new(big.Int).Mul(gasPrice, big.NewInt(100+bumpPercent))
+	newGasPrice.Div(newGasPrice, big.NewInt(100))
+	if newGasPrice.Cmp(minGasPrice) < 0 {
+		newGasPrice.Set(minGasPrice)
+	}
+	if nil != limit && limit.Sign() > 0 && newGasPrice.Cmp(limit) > 0 {
+		newGasPrice.Set(limit)
+	}
+	if newGasPrice.Cmp(minGasPrice) < 0 {
+		return nil, fmt.Errorf("gasPrice:%s has reached the limit:%s", gasPrice.String(), limit.String())
+	}
+	return newGasPrice, nil
+}
@@ -0,0 +1,71 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestBumpGasPrice(t *testing.T) {
+	gwei := int64(1000000000)
+	tests := []struct {
+		name        string
+		gasPrice    int64
+		limit       int64 //-1 is nil
+		bumpPercent int64
+		expected    int64 //-1 means an error is returned
+	}{
+		{name: "bumped", gasPrice: 100, limit: 0, bumpPercent: 20, expected: 120},
+		{name: "nil limit", gasPrice: 100, limit: -1, bumpPercent: 20, expected: 120},
+		{name: "gwei", gasPrice: 20 * gwei, limit: 30 * gwei, bumpPercent: 12, expected: 22400000000},
+		{name: "bump percent below floor", gasPrice: 100, limit: 0, bumpPercent: 5, expected: 110},
+		{name: "zero bump percent", gasPrice: 100, limit: 0, bumpPercent: 0, expected: 110},
+		{name: "floor rounded down", gasPrice: 15, limit: 0, bumpPercent: 10, expected: 16},
+		{name: "capped at limit", gasPrice: 100, limit: 115, bumpPercent: 20, expected: 115},
+		{name: "capped at floor", gasPrice: 100, limit: 110, bumpPercent: 50, expected: 110},
+		{name: "limit below floor", gasPrice: 100, limit: 109, bumpPercent: 20, expected: -1},
+		{name: "limit below gas price", gasPrice: 100, limit: 50, bumpPercent: 20, expected: -1},
+		{name: "limit equals gas price", gasPrice: 100, limit: 100, bumpPercent: 20, expected: -1},
+		{name: "zero wei", gasPrice: 0, limit: 0, bumpPercent: 10, expected: 1},
+		{name: "zero wei with limit", gasPrice: 0, limit: 1, bumpPercent: 100, expected: 1},
+		{name: "one wei", gasPrice: 1, limit: 0, bumpPercent: 10, expected: 2},
+		{name: "one wei with big bump", gasPrice: 1, limit: 0, bumpPercent: 250, expected: 3},
+		{name: "one wei at limit", gasPrice: 1, limit: 1, bumpPercent: 10, expected: -1},
+	}
+
+	for _, test := range tests {
+		gasPrice := big.NewInt(test.gasPrice)
+		var limit *big.Int
+		if test.limit >= 0 {
+			limit = big.NewInt(test.limit)
+		}
+		newGasPrice, err := bumpGasPrice(gasPrice, limit, test.bumpPercent)
+		if test.expected < 0 {
+			if nil == err {
+				t.Errorf("%s: the gas price shouldn't be bumped over the limit, got:%s", test.name, newGasPrice.String())
+			}
+		} else if nil != err || newGasPrice.Int64() != test.expected {
+			t.Errorf("%s: gas price should be %d, got:%v, err:%v", test.name, test.expected, newGasPrice, err)
+		}
+		if gasPrice.Int64() != test.gasPrice {
+			t.Errorf("%s: the pending gas price shouldn't be changed, got:%s", test.name, gasPrice.String())
+		}
+	}
+}
@@ -23,13 +23,16 @@ import (
 	"math/big"
 
 	"encoding/json"
+	"fmt"
 	"github.com/Loopring/relay/cache"
 	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/crypto"
 	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/ethaccessor"
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/metrics"
 	"github.com/Loopring/relay/types"
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
@@ -39,6 +42,13 @@ import (
 
 const SubmitRingMethod_LastId = "submitringmethod_lastid"
 
+//the stages of the failed rings in metrics
+const (
+	RING_FAILED_SIMULATE = "simulate"
+	RING_FAILED_SEND     = "send"
+	RING_FAILED_EXECUTE  = "execute"
+)
+
 //保存ring，并将ring发送到区块链，同样需要分为待完成和已完成
 type RingSubmitter struct {
 	minerAccountForSign accounts.Account
@@ -46,8 +56,9 @@ type RingSubmitter struct {
 	feeReceipt       common.Address
 	currentBlockTime int64
 
-	maxGasLimit *big.Int
-	minGasLimit *big.Int
+	//the bounds of the gas price, the gas of ring is the one estimated by the evaluator
+	maxGasPrice *big.Int
+	minGasPrice *big.Int
 
 	normalMinerAddresses  []*NormalSenderAddress
 	percentMinerAddresses []*SplitMinerAddress
@@ -55,6 +66,12 @@ type RingSubmitter struct {
 	dbService         dao.RdsService
 	marketCapProvider marketcap.MarketCapProvider
 	matcher           Matcher
+	tracker           *SubmitTracker
+	nonceManager      *NonceManager
+	simulateMode      string
+	//eth_call and eth_estimateGas used by the simulation
+	callContract      func(result interface{}, callArg *ethaccessor.CallArg, blockNumber string) error
+	estimateGasOfCall func(result interface{}, callArg *ethaccessor.CallArg, blockNumber string) error
 
 	stopFuncs []func()
 }
@@ -66,45 +83,49 @@ type RingSubmitFailed struct {
 
 func NewSubmitter(options config.MinerOptions, dbService dao.RdsService, marketCapProvider marketcap.MarketCapProvider) (*RingSubmitter, error) {
 	submitter := &RingSubmitter{}
-	submitter.maxGasLimit = big.NewInt(options.MaxGasLimit)
-	submitter.minGasLimit = big.NewInt(options.MinGasLimit)
+	submitter.maxGasPrice = big.NewInt(options.MaxGasLimit)
+	submitter.minGasPrice = big.NewInt(options.MinGasLimit)
 	if common.IsHexAddress(options.FeeReceipt) {
 		submitter.feeReceipt = common.HexToAddress(options.FeeReceipt)
 	} else {
 		return submitter, errors.New("miner.feeReceipt must be a address")
 	}
 
+	switch options.SimulateRing {
+	case "", SIMULATE_DROP, SIMULATE_QUARANTINE:
+		submitter.simulateMode = options.SimulateRing
+	default:
+		return submitter, errors.New("miner.simulateRing must be drop or quarantine")
+	}
+	submitter.callContract = ethaccessor.Call
+	submitter.estimateGasOfCall = ethaccessor.EstimateGasOfCall
+
+	submitter.nonceManager = NewNonceManager()
 	for _, addr := range options.NormalMiners {
-		var nonce types.Big
 		normalAddr := common.HexToAddress(addr.Address)
-		if err := ethaccessor.GetTransactionCount(&nonce, normalAddr, "pending"); nil != err {
-			log.Errorf("err:%s", err.Error())
-		}
 		miner := &NormalSenderAddress{}
 		miner.Address = normalAddr
 		miner.GasPriceLimit = big.NewInt(addr.GasPriceLimit)
 		miner.MaxPendingCount = addr.MaxPendingCount
 		miner.MaxPendingTtl = addr.MaxPendingTtl
-		miner.Nonce = nonce.BigInt()
+		miner.GasPriceBumpPercent = addr.GasPriceBumpPercent
+		submitter.nonceManager.AddSender(miner.Address, miner.GasPriceLimit)
 		submitter.normalMinerAddresses = append(submitter.normalMinerAddresses, miner)
 	}
 
 	for _, addr := range options.PercentMiners {
-		var nonce types.Big
 		normalAddr := common.HexToAddress(addr.Address)
-		if err := ethaccessor.GetTransactionCount(&nonce, normalAddr, "pending"); nil != err {
-			log.Errorf("err:%s", err.Error())
-		}
 		miner := &SplitMinerAddress{}
-		miner.Nonce = nonce.BigInt()
 		miner.Address = normalAddr
 		miner.FeePercent = addr.FeePercent
 		miner.StartFee = addr.StartFee
+		submitter.nonceManager.AddSender(miner.Address, nil)
 		submitter.percentMinerAddresses = append(submitter.percentMinerAddresses, miner)
 	}
 
 	submitter.dbService = dbService
 	submitter.marketCapProvider = marketCapProvider
+	submitter.tracker = NewSubmitTracker(dbService, submitter.normalMinerAddresses)
 
 	submitter.stopFuncs = []func(){}
 	return submitter, nil
@@ -112,9 +133,12 @@ func NewSubmitter(options config.MinerOptions, dbService dao.RdsService, marketC
 
 func (submitter *RingSubmitter) listenBlockNew() {
 	blockEventChan := make(chan *types.BlockEvent)
+	stopChan := make(chan bool)
 	go func() {
 		for {
 			select {
+			case <-stopChan:
+				return
 			case blockEvent := <-blockEventChan:
 				submitter.currentBlockTime = blockEvent.BlockTime
 			}
@@ -126,14 +150,17 @@ func (submitter *RingSubmitter) listenBlockNew() {
 		Handle: func(eventData eventemitter.EventData) error {
 			e := eventData.(*types.BlockEvent)
 			log.Debugf("submitter.listenBlockNew blockNumber:%s, blocktime:%d", e.BlockNumber.String(), e.BlockTime)
-			blockEventChan <- e
+			select {
+			case blockEventChan <- e:
+			case <-stopChan:
+			}
 			return nil
 		},
 	}
 	eventemitter.On(eventemitter.Block_New, watcher)
 	submitter.stopFuncs = append(submitter.stopFuncs, func() {
-		close(blockEventChan)
 		eventemitter.Un(eventemitter.Block_New, watcher)
+		close(stopChan)
 	})
 }
 
@@ -183,6 +210,9 @@ func (submitter *RingSubmitter) listenNewRings() {
 					if err := submitter.dbService.Add(daoInfo); nil != err {
 						log.Errorf("Miner submitter,insert new ring err:%s", err.Error())
 					} else {
+						if nil == err1 {
+							submitter.tracker.Track(daoInfo)
+						}
 						for _, filledOrder := range ringState.RawRing.Orders {
 							daoOrder := &dao.FilledOrder{}
 							daoOrder.ConvertDown(filledOrder, ringState.Ringhash)
@@ -217,17 +247,40 @@ func (submitter *RingSubmitter) submitRing(ringSubmitInfo *types.RingSubmitInfo)
 	txHash := types.NilHash
 	var err error
 
+	if "" != submitter.simulateMode {
+		if _, err = submitter.SimulateRingSubmitInfo(ringSubmitInfo); nil != err {
+			log.Errorf("submitring hash:%s, simulate err:%s", ringSubmitInfo.Ringhash.Hex(), err.Error())
+			metrics.RingsFailed.WithLabelValues(RING_FAILED_SIMULATE).Inc()
+			//the unknown status only releases the matched ring, the failed one will be counted by the matcher
+			if SIMULATE_DROP == submitter.simulateMode {
+				status = types.TX_STATUS_UNKNOWN
+			} else {
+				status = types.TX_STATUS_FAILED
+			}
+			return txHash, status, err
+		}
+	}
+
+	var nonce *big.Int
+	nonce, err = submitter.nonceManager.Allocate(ringSubmitInfo.Miner)
+
 	if nil == err {
 		txHashStr := "0x"
-		txHashStr, err = ethaccessor.SignAndSendTransaction(ringSubmitInfo.Miner, ringSubmitInfo.ProtocolAddress, ringSubmitInfo.ProtocolGas, ringSubmitInfo.ProtocolGasPrice, nil, ringSubmitInfo.ProtocolData, false)
+		txHashStr, err = ethaccessor.SignAndSendTransactionWithNonce(ringSubmitInfo.Miner, ringSubmitInfo.ProtocolAddress, ringSubmitInfo.ProtocolGas, ringSubmitInfo.ProtocolGasPrice, nil, nonce, ringSubmitInfo.ProtocolData)
 		if nil != err {
 			log.Errorf("submitring hash:%s, err:%s", ringSubmitInfo.Ringhash.Hex(), err.Error())
 			status = types.TX_STATUS_FAILED
+			submitter.nonceManager.Release(ringSubmitInfo.Miner, nonce)
+			metrics.RingsFailed.WithLabelValues(RING_FAILED_SEND).Inc()
+		} else {
+			ringSubmitInfo.ProtocolNonce = nonce
+			metrics.RingsSubmitted.Inc()
 		}
 		txHash = common.HexToHash(txHashStr)
 	} else {
 		log.Errorf("submitring hash:%s, protocol:%s, err:%s", ringSubmitInfo.Ringhash.Hex(), ringSubmitInfo.ProtocolAddress.Hex(), err.Error())
 		status = types.TX_STATUS_FAILED
+		metrics.RingsFailed.WithLabelValues(RING_FAILED_SEND).Inc()
 	}
 
 	return txHash, status, err
@@ -261,6 +314,7 @@ func (submitter *RingSubmitter) listenSubmitRingMethodEventFromMysql() {
 					if infos, err := submitter.dbService.GetRingHashesByTxHash(evt.TxHash); nil != err {
 						log.Errorf("err:%s", err.Error())
 					} else {
+						observeMinedRings(evt, len(infos))
 						var err1 error
 						if nil != evt.Err {
 							err1 = evt.Err
@@ -341,6 +395,18 @@ func (submitter *RingSubmitter) listenSubmitRingMethodEventFromMysql() {
 //	})
 //}
 
+func observeMinedRings(evt *types.RingMinedEvent, ringCount int) {
+	if nil != evt.GasUsed {
+		metrics.RingsGasUsed.Add(float64(evt.GasUsed.Uint64()))
+	}
+	switch evt.Status {
+	case types.TX_STATUS_SUCCESS:
+		metrics.RingsMined.Add(float64(ringCount))
+	case types.TX_STATUS_FAILED:
+		metrics.RingsFailed.WithLabelValues(RING_FAILED_EXECUTE).Add(float64(ringCount))
+	}
+}
+
 func (submitter *RingSubmitter) submitResult(ringhash, uniqeId, txhash common.Hash, status types.TxStatus, ringIndex, blockNumber, usedGas *big.Int, err error) {
 	resultEvt := &types.RingSubmitResultEvent{
 		RingHash:     ringhash,
@@ -370,6 +436,23 @@ func (submitter *RingSubmitter) submitResult(ringhash, uniqeId, txhash common.Ha
 //	}
 //}
 
+// openAuthPrivateKeys decrypts the auth private keys of the orders loaded from db, it's the only place
+// the sealed keys are opened and the keys are only kept in the ring to be signed.
+func openAuthPrivateKeys(ringState *types.Ring) error {
+	for _, filledOrder := range ringState.Orders {
+		order := &filledOrder.OrderState.RawOrder
+		if "" == order.SealedAuthPrivateKey {
+			continue
+		}
+		authKey, err := crypto.OpenAuthPrivateKey(order.Hash, order.SealedAuthPrivateKey)
+		if nil != err {
+			return fmt.Errorf("open auth private key of order:%s error:%s", order.Hash.Hex(), err.Error())
+		}
+		order.AuthPrivateKey = authKey
+	}
+	return nil
+}
+
 func (submitter *RingSubmitter) GenerateRingSubmitInfo(ringState *types.Ring) (*types.RingSubmitInfo, error) {
 	//todo:change to advice protocolAddress
 	protocolAddress := ringState.Orders[0].OrderState.RawOrder.Protocol
@@ -394,6 +477,9 @@ func (submitter *RingSubmitter) GenerateRingSubmitInfo(ringState *types.Ring) (*
 		ringSubmitInfo.Miner = senderAddress
 	}
 	//submitter.computeReceivedAndSelectMiner(ringSubmitInfo)
+	if err := openAuthPrivateKeys(ringState); nil != err {
+		return nil, err
+	}
 	if protocolData, err := ethaccessor.GenerateSubmitRingMethodInputsData(ringState, submitter.feeReceipt, protocolAbi); nil != err {
 		return nil, err
 	} else {
@@ -417,11 +503,11 @@ func (submitter *RingSubmitter) GenerateRingSubmitInfo(ringState *types.Ring) (*
 	//if nil != err {
 	//	return nil, err
 	//}
-	if submitter.maxGasLimit.Sign() > 0 && ringSubmitInfo.ProtocolGas.Cmp(submitter.maxGasLimit) > 0 {
-		ringSubmitInfo.ProtocolGas.Set(submitter.maxGasLimit)
+	if submitter.maxGasPrice.Sign() > 0 && ringSubmitInfo.ProtocolGasPrice.Cmp(submitter.maxGasPrice) > 0 {
+		ringSubmitInfo.ProtocolGasPrice = new(big.Int).Set(submitter.maxGasPrice)
 	}
-	if submitter.minGasLimit.Sign() > 0 && ringSubmitInfo.ProtocolGas.Cmp(submitter.minGasLimit) < 0 {
-		ringSubmitInfo.ProtocolGas.Set(submitter.minGasLimit)
+	if submitter.minGasPrice.Sign() > 0 && ringSubmitInfo.ProtocolGasPrice.Cmp(submitter.minGasPrice) < 0 {
+		ringSubmitInfo.ProtocolGasPrice = new(big.Int).Set(submitter.minGasPrice)
 	}
 	return ringSubmitInfo, nil
 }
@@ -430,12 +516,16 @@ func (submitter *RingSubmitter) stop() {
 	for _, stop := range submitter.stopFuncs {
 		stop()
 	}
+	submitter.tracker.Stop()
+	submitter.nonceManager.Stop()
 }
 
 func (submitter *RingSubmitter) start() {
 	submitter.listenNewRings()
 	submitter.listenSubmitRingMethodEventFromMysql()
 	submitter.listenBlockNew()
+	submitter.nonceManager.Start()
+	submitter.tracker.Start()
 	//submitter.listenSubmitRingMethodEvent()
 }
 
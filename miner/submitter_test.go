@@ -0,0 +1,74 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"bytes"
+	"github.com/Loopring/relay/crypto"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"testing"
+)
+
+func TestOpenAuthPrivateKeys(t *testing.T) {
+	defer crypto.InitializeAuthKeyCipher(nil)
+	c, err := crypto.NewEnvelopeCipher(bytes.Repeat([]byte{1}, 32))
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	crypto.InitializeAuthKeyCipher(c)
+
+	authKey, err := crypto.NewPrivateKeyCrypto(false, "0xd1d194d90e52aeae4cd3a727b1dbb6ea5f1de8d5379827acc5f358bf1b0acba9")
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	newRing := func(orderHash common.Hash, sealedHash common.Hash) *types.Ring {
+		sealed, err := crypto.SealAuthPrivateKey(sealedHash, authKey)
+		if nil != err {
+			t.Fatal(err.Error())
+		}
+		filled := &types.FilledOrder{}
+		filled.OrderState.RawOrder.Hash = orderHash
+		filled.OrderState.RawOrder.SealedAuthPrivateKey = sealed
+		return &types.Ring{Orders: []*types.FilledOrder{filled, {}}}
+	}
+
+	ring := newRing(common.HexToHash("0x01"), common.HexToHash("0x01"))
+	if err := openAuthPrivateKeys(ring); nil != err {
+		t.Fatal(err.Error())
+	}
+	if ring.Orders[0].OrderState.RawOrder.AuthPrivateKey.Address() != authKey.Address() {
+		t.Fatalf("the auth private key should be opened")
+	}
+
+	//the key sealed for another order can't be used to sign this ring
+	if err := openAuthPrivateKeys(newRing(common.HexToHash("0x01"), common.HexToHash("0x02"))); nil == err {
+		t.Fatalf("the key sealed for another order shouldn't be opened")
+	}
+
+	ring = newRing(common.HexToHash("0x01"), common.HexToHash("0x01"))
+	other, err := crypto.NewEnvelopeCipher(bytes.Repeat([]byte{2}, 32))
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	crypto.InitializeAuthKeyCipher(other)
+	if err := openAuthPrivateKeys(ring); nil == err {
+		t.Fatalf("the key sealed by another master key shouldn't be opened")
+	}
+}
@@ -0,0 +1,260 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	"errors"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/metrics"
+	"github.com/Loopring/relay/miner"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+每轮对每个market计算统一的成交价格（成交量最大的价格），
+价格优于该价格的订单都按照该价格成交，订单按价格优先两两成环
+*/
+
+func init() {
+	miner.RegisterMatcher(MATCHER_BATCH_AUCTION, func(ctx *miner.MatcherContext) (miner.Matcher, error) {
+		if nil == ctx.Options.TimingMatcher {
+			return nil, errors.New("the options of TimingMatcher must be set")
+		}
+		return NewBatchAuctionMatcher(NewTimingMatcher(ctx.Options.TimingMatcher, ctx.Options.RingMaxLength, ctx.Submitter, ctx.Evaluator, ctx.OrderManager, ctx.AccountManager, ctx.Rds)), nil
+	})
+}
+
+type BatchAuctionMatcher struct {
+	*TimingMatcher
+}
+
+func NewBatchAuctionMatcher(timingMatcher *TimingMatcher) *BatchAuctionMatcher {
+	matcher := &BatchAuctionMatcher{}
+	matcher.TimingMatcher = timingMatcher
+	return matcher
+}
+
+func (matcher *BatchAuctionMatcher) Start() {
+	matcher.listenSubmitEvent()
+	matcher.listenOrderReady()
+	matcher.listenAuctionRound()
+	matcher.cleanMissedCache()
+}
+
+func (matcher *BatchAuctionMatcher) listenAuctionRound() {
+	stopChan := make(chan bool)
+
+	auctionFunc := func() {
+		matcher.roundMtx.Lock()
+		defer matcher.roundMtx.Unlock()
+		if matcher.stopped || !matcher.isOrdersReady || matcher.Paused() {
+			return
+		}
+		start := time.Now()
+		matcher.lastRoundNumber = big.NewInt(start.UnixNano() / 1e6)
+		var wg sync.WaitGroup
+		for _, market := range matcher.markets {
+			wg.Add(1)
+			go func(m *Market) {
+				defer func() {
+					wg.Add(-1)
+				}()
+				m.auction()
+			}(market)
+		}
+		wg.Wait()
+		metrics.ObserveSince(metrics.MatcherRoundDuration.WithLabelValues(MATCHER_BATCH_AUCTION), start)
+	}
+	go func() {
+		auctionFunc()
+		for {
+			select {
+			case <-time.After(time.Duration(matcher.duration.Int64()) * time.Millisecond):
+				auctionFunc()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	matcher.stopFuncs = append(matcher.stopFuncs, func() {
+		stopChan <- true
+		close(stopChan)
+	})
+}
+
+func (market *Market) auction() {
+	market.getOrdersForMatching(market.protocolImpl.DelegateAddress)
+	asks := market.ordersForAuction(market.AtoBOrders)
+	bids := market.ordersForAuction(market.BtoAOrders)
+
+	price := clearingPrice(asks, bids)
+	if nil == price {
+		return
+	}
+	log.Debugf("auction round:%s, market: %s -> %s, clearing price:%s", market.matcher.lastRoundNumber.String(), market.TokenA.Hex(), market.TokenB.Hex(), price.FloatString(10))
+
+	//the better price the earlier to be matched
+	sort.Slice(asks, func(i, j int) bool { return askPrice(asks[i]).Cmp(askPrice(asks[j])) < 0 })
+	sort.Slice(bids, func(i, j int) bool { return bidPrice(bids[i]).Cmp(bidPrice(bids[j])) > 0 })
+
+	//the rings at the clearing price are the candidates of auction
+	ringSubmitInfos := []*types.RingSubmitInfo{}
+	defer func() {
+		metrics.MatcherCandidateRings.WithLabelValues(market.Name()).Add(float64(len(ringSubmitInfos)))
+	}()
+	for _, ask := range asks {
+		if askPrice(ask).Cmp(price) > 0 {
+			break
+		}
+		for _, bid := range bids {
+			if market.om.IsOrderFullFinished(ask) {
+				break
+			}
+			if bidPrice(bid).Cmp(price) < 0 {
+				break
+			}
+			//todo:move it after contract fix bug
+			if market.om.IsOrderFullFinished(bid) || ask.RawOrder.Owner == bid.RawOrder.Owner {
+				continue
+			}
+			ringForSubmit, err := market.generateRingSubmitInfoAtPrice(price, ask, bid)
+			if nil != err {
+				log.Debugf("auction, generate RingSubmitInfo err:%s", err.Error())
+				continue
+			}
+			if exists, err := CachedMatchedRing(ringForSubmit.Ringhash); nil != err || exists {
+				if nil != err {
+					log.Error(err.Error())
+				} else {
+					log.Errorf("ringhash:%s has been submitted", ringForSubmit.Ringhash.Hex())
+				}
+				continue
+			}
+			uniqueId := ringForSubmit.RawRing.GenerateUniqueId()
+			if failedCount, err := RingExecuteFailedCount(uniqueId); nil == err && failedCount > market.matcher.maxFailedCount {
+				log.Debugf("ringSubmitInfo.UniqueId:%s , ringhash: %s , has been failed to submit %d times", uniqueId.Hex(), ringForSubmit.Ringhash.Hex(), failedCount)
+				continue
+			}
+			if ringForSubmit.RawRing.Received.Sign() <= 0 {
+				log.Debugf("ring:%s will not be submitted,because of received:%s", ringForSubmit.RawRing.Hash.Hex(), ringForSubmit.RawRing.Received.String())
+				continue
+			}
+
+			for _, filledOrder := range ringForSubmit.RawRing.Orders {
+				orderState := market.reduceAmountAfterFilled(filledOrder)
+				if market.om.IsOrderFullFinished(orderState) {
+					market.excludeNextRound(orderState)
+				}
+			}
+			AddMinedRing(ringForSubmit)
+			ringSubmitInfos = append(ringSubmitInfos, ringForSubmit)
+		}
+	}
+
+	if len(ringSubmitInfos) > 0 {
+		eventemitter.Emit(eventemitter.Miner_NewRing, ringSubmitInfos)
+	}
+}
+
+func (market *Market) ordersForAuction(orders map[common.Hash]*types.OrderState) []*types.OrderState {
+	res := []*types.OrderState{}
+	for _, order := range orders {
+		if failedCount, err := OrderExecuteFailedCount(order.RawOrder.Hash); nil == err && failedCount > market.matcher.maxFailedCount {
+			log.Debugf("orderhash:%s has been failed to submit %d times", order.RawOrder.Hash.Hex(), failedCount)
+			continue
+		}
+		res = append(res, order)
+	}
+	return res
+}
+
+//the min price that the ask accepts, the price is the amount of tokenB per tokenA of market
+func askPrice(ask *types.OrderState) *big.Rat {
+	return new(big.Rat).SetFrac(ask.RawOrder.AmountB, ask.RawOrder.AmountS)
+}
+
+//the max price that the bid accepts
+func bidPrice(bid *types.OrderState) *big.Rat {
+	return new(big.Rat).SetFrac(bid.RawOrder.AmountS, bid.RawOrder.AmountB)
+}
+
+// clearingPrice returns the price that maximizes the amount of tokenA traded,
+// if several prices trade the same amount, the one with the least imbalance between supply and demand is used.
+// asks sell tokenA for tokenB and bids sell tokenB for tokenA, it returns nil if they don't cross.
+func clearingPrice(asks, bids []*types.OrderState) *big.Rat {
+	candidates := []*big.Rat{}
+	for _, ask := range asks {
+		candidates = append(candidates, askPrice(ask))
+	}
+	for _, bid := range bids {
+		candidates = append(candidates, bidPrice(bid))
+	}
+
+	var (
+		bestPrice     *big.Rat
+		bestVolume    = new(big.Rat)
+		bestImbalance *big.Rat
+	)
+	for _, price := range candidates {
+		supply := new(big.Rat)
+		for _, ask := range asks {
+			if askPrice(ask).Cmp(price) <= 0 {
+				remainedAmountS, _ := ask.RemainedAmount()
+				supply.Add(supply, remainedAmountS)
+			}
+		}
+		demand := new(big.Rat)
+		for _, bid := range bids {
+			if bidPrice(bid).Cmp(price) >= 0 {
+				remainedAmountS, remainedAmountB := bid.RemainedAmount()
+				if bid.RawOrder.BuyNoMoreThanAmountB {
+					demand.Add(demand, remainedAmountB)
+				} else {
+					demand.Add(demand, new(big.Rat).Quo(remainedAmountS, price))
+				}
+			}
+		}
+
+		volume := supply
+		if demand.Cmp(supply) < 0 {
+			volume = demand
+		}
+		if volume.Sign() <= 0 {
+			continue
+		}
+		imbalance := new(big.Rat).Sub(supply, demand)
+		imbalance.Abs(imbalance)
+		cmp := volume.Cmp(bestVolume)
+		if nil == bestPrice || cmp > 0 ||
+			(cmp == 0 && imbalance.Cmp(bestImbalance) < 0) ||
+			(cmp == 0 && imbalance.Cmp(bestImbalance) == 0 && price.Cmp(bestPrice) < 0) {
+			bestPrice = price
+			bestVolume = volume
+			bestImbalance = imbalance
+		}
+	}
+	return bestPrice
+}
@@ -0,0 +1,65 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	"github.com/Loopring/relay/types"
+	"math/big"
+	"testing"
+)
+
+func newAuctionOrder(hash, tokenS, tokenB string, amountS, amountB int64) *types.OrderState {
+	order := newTestOrder(hash, hash, tokenS, tokenB, amountS, amountB)
+	order.DealtAmountS = big.NewInt(0)
+	order.DealtAmountB = big.NewInt(0)
+	order.CancelledAmountS = big.NewInt(0)
+	order.CancelledAmountB = big.NewInt(0)
+	order.SplitAmountS = big.NewInt(0)
+	order.SplitAmountB = big.NewInt(0)
+	return order
+}
+
+func TestClearingPrice(t *testing.T) {
+	lrc, weth := "0x01", "0x02"
+	asks := []*types.OrderState{
+		//sell 100 lrc at 1 weth per lrc
+		newAuctionOrder("0x11", lrc, weth, 100, 100),
+		//sell 100 lrc at 2 weth per lrc
+		newAuctionOrder("0x12", lrc, weth, 100, 200),
+	}
+	bids := []*types.OrderState{
+		//buy 100 lrc at 3 weth per lrc
+		newAuctionOrder("0x21", weth, lrc, 300, 100),
+		//buy 100 lrc at 1.5 weth per lrc
+		newAuctionOrder("0x22", weth, lrc, 150, 100),
+	}
+
+	price := clearingPrice(asks, bids)
+	if nil == price {
+		t.Fatal("the orders should be crossed")
+	}
+	//at 2, 200 lrc is supplied and 300 weth buys 150 lrc, the other prices trade 100 lrc only
+	if price.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Fatalf("expected clearing price 2, got %s", price.FloatString(4))
+	}
+
+	if price := clearingPrice(asks[1:], bids[1:]); nil != price {
+		t.Fatalf("the orders shouldn't be crossed, got price %s", price.FloatString(4))
+	}
+}
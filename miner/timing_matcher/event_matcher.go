@@ -0,0 +1,147 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	"errors"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/metrics"
+	"github.com/Loopring/relay/miner"
+	"github.com/Loopring/relay/types"
+	"math/big"
+	"sync"
+	"time"
+)
+
+/**
+收到Miner_NewOrderState后立即匹配该订单所在的market，不再等待定时的round
+*/
+
+func init() {
+	miner.RegisterMatcher(MATCHER_EVENT_DRIVEN, func(ctx *miner.MatcherContext) (miner.Matcher, error) {
+		if nil == ctx.Options.TimingMatcher {
+			return nil, errors.New("the options of TimingMatcher must be set")
+		}
+		return NewEventDrivenMatcher(NewTimingMatcher(ctx.Options.TimingMatcher, ctx.Options.RingMaxLength, ctx.Submitter, ctx.Evaluator, ctx.OrderManager, ctx.AccountManager, ctx.Rds)), nil
+	})
+}
+
+type EventDrivenMatcher struct {
+	*TimingMatcher
+
+	mtx            sync.Mutex
+	pendingMarkets map[*Market]bool
+	notifyChan     chan bool
+}
+
+func NewEventDrivenMatcher(timingMatcher *TimingMatcher) *EventDrivenMatcher {
+	matcher := &EventDrivenMatcher{}
+	matcher.TimingMatcher = timingMatcher
+	matcher.mtx = sync.Mutex{}
+	matcher.pendingMarkets = make(map[*Market]bool)
+	matcher.notifyChan = make(chan bool, 1)
+	return matcher
+}
+
+func (matcher *EventDrivenMatcher) Start() {
+	matcher.listenSubmitEvent()
+	matcher.listenOrderReady()
+	matcher.listenNewOrderState()
+	matcher.cleanMissedCache()
+}
+
+func (matcher *EventDrivenMatcher) listenNewOrderState() {
+	stopChan := make(chan bool)
+
+	//the markets are matched one by one, the orders of a market arrived during matching only trigger it once more
+	matchFunc := func() {
+		matcher.roundMtx.Lock()
+		defer matcher.roundMtx.Unlock()
+		//the pending markets are kept until the orders are ready and the matcher is resumed
+		if matcher.stopped || !matcher.isOrdersReady || matcher.Paused() {
+			return
+		}
+		for _, market := range matcher.popPendingMarkets() {
+			start := time.Now()
+			matcher.lastRoundNumber = big.NewInt(start.UnixNano() / 1e6)
+			log.Debugf("event driven matcher, match round:%s, market:%s-%s", matcher.lastRoundNumber.String(), market.TokenA.Hex(), market.TokenB.Hex())
+			market.match()
+			metrics.ObserveSince(metrics.MatcherRoundDuration.WithLabelValues(MATCHER_EVENT_DRIVEN), start)
+		}
+	}
+	go func() {
+		for {
+			select {
+			case <-matcher.notifyChan:
+				matchFunc()
+			case <-time.After(10 * time.Second):
+				matchFunc()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	watcher := &eventemitter.Watcher{
+		Concurrent: false,
+		Handle: func(eventData eventemitter.EventData) error {
+			state := eventData.(*types.OrderState)
+			matcher.pushMarketsOfOrder(state)
+			return nil
+		},
+	}
+	eventemitter.On(eventemitter.Miner_NewOrderState, watcher)
+	matcher.stopFuncs = append(matcher.stopFuncs, func() {
+		eventemitter.Un(eventemitter.Miner_NewOrderState, watcher)
+		close(stopChan)
+	})
+}
+
+func (matcher *EventDrivenMatcher) pushMarketsOfOrder(state *types.OrderState) {
+	matcher.mtx.Lock()
+	defer matcher.mtx.Unlock()
+
+	tokenS := state.RawOrder.TokenS
+	tokenB := state.RawOrder.TokenB
+	for _, market := range matcher.markets {
+		if market.protocolImpl.DelegateAddress != state.RawOrder.DelegateAddress {
+			continue
+		}
+		if (market.TokenA == tokenS && market.TokenB == tokenB) || (market.TokenA == tokenB && market.TokenB == tokenS) {
+			matcher.pendingMarkets[market] = true
+		}
+	}
+	select {
+	case matcher.notifyChan <- true:
+	default:
+	}
+}
+
+func (matcher *EventDrivenMatcher) popPendingMarkets() []*Market {
+	matcher.mtx.Lock()
+	defer matcher.mtx.Unlock()
+
+	markets := []*Market{}
+	for market := range matcher.pendingMarkets {
+		markets = append(markets, market)
+	}
+	matcher.pendingMarkets = make(map[*Market]bool)
+	return markets
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/Loopring/relay/ethaccessor"
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/metrics"
 	"github.com/Loopring/relay/types"
 	"math/big"
 	"sync"
@@ -122,11 +123,14 @@ func (matcher *TimingMatcher) listenTimingRound() {
 	stopChan := make(chan bool)
 
 	matchFunc := func() {
-		if !matcher.isOrdersReady {
+		matcher.roundMtx.Lock()
+		defer matcher.roundMtx.Unlock()
+		if matcher.stopped || !matcher.isOrdersReady || matcher.Paused() {
 			return
 		}
 		//if ethaccessor.Synced() {
-		matcher.lastRoundNumber = big.NewInt(time.Now().UnixNano() / 1e6)
+		start := time.Now()
+		matcher.lastRoundNumber = big.NewInt(start.UnixNano() / 1e6)
 		//matcher.rounds.appendNewRoundState(matcher.lastRoundNumber)
 		var wg sync.WaitGroup
 		for _, market := range matcher.markets {
@@ -139,6 +143,10 @@ func (matcher *TimingMatcher) listenTimingRound() {
 			}(market)
 		}
 		wg.Wait()
+		if matcher.ringMaxLength > MinRingLength {
+			matcher.matchMultiHop()
+		}
+		metrics.ObserveSince(metrics.MatcherRoundDuration.WithLabelValues(MATCHER_TIMING), start)
 		//}
 	}
 	go func() {
@@ -161,9 +169,12 @@ func (matcher *TimingMatcher) listenTimingRound() {
 
 func (matcher *TimingMatcher) listenSubmitEvent() {
 	submitEventChan := make(chan *types.RingSubmitResultEvent)
+	stopChan := make(chan bool)
 	go func() {
 		for {
 			select {
+			case <-stopChan:
+				return
 			case minedEvent := <-submitEventChan:
 				if minedEvent.Status == types.TX_STATUS_FAILED || minedEvent.Status == types.TX_STATUS_SUCCESS || minedEvent.Status == types.TX_STATUS_UNKNOWN {
 					log.Debugf("received mined event, this round the related cache will be removed, ringhash:%s, status:%d", minedEvent.RingHash.Hex(), uint8(minedEvent.Status))
@@ -199,7 +210,10 @@ func (matcher *TimingMatcher) listenSubmitEvent() {
 		Concurrent: false,
 		Handle: func(eventData eventemitter.EventData) error {
 			minedEvent := eventData.(*types.RingSubmitResultEvent)
-			submitEventChan <- minedEvent
+			select {
+			case submitEventChan <- minedEvent:
+			case <-stopChan:
+			}
 			return nil
 		},
 	}
@@ -209,6 +223,6 @@ func (matcher *TimingMatcher) listenSubmitEvent() {
 	matcher.stopFuncs = append(matcher.stopFuncs, func() {
 		//eventemitter.Un(eventemitter.OrderManagerExtractorRingMined, submitWatcher)
 		eventemitter.Un(eventemitter.Miner_RingSubmitResult, submitResultWatcher)
-		close(submitEventChan)
+		close(stopChan)
 	})
 }
@@ -23,6 +23,8 @@ import (
 	"github.com/Loopring/relay/ethaccessor"
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/metrics"
 	"github.com/Loopring/relay/miner"
 	"github.com/Loopring/relay/ordermanager"
 	"github.com/Loopring/relay/types"
@@ -80,6 +82,7 @@ func (market *Market) match() {
 	}
 
 	log.Debugf("match round:%s, market: %s -> %s , candidateRingList.length:%d", market.matcher.lastRoundNumber, market.TokenA.Hex(), market.TokenB.Hex(), len(candidateRingList))
+	metrics.MatcherCandidateRings.WithLabelValues(market.Name()).Add(float64(len(candidateRingList)))
 	//the ring that can get max received
 	list := candidateRingList
 	for {
@@ -292,6 +295,7 @@ func (market *Market) GenerateCandidateRing(orders ...*types.OrderState) (*Candi
 	} else {
 		candidateRing := &CandidateRing{cost: ringTmp.LegalCost, received: ringTmp.Received, filledOrders: make(map[common.Hash]*big.Rat)}
 		for _, filledOrder := range ringTmp.Orders {
+			candidateRing.orderhashes = append(candidateRing.orderhashes, filledOrder.OrderState.RawOrder.Hash)
 			log.Debugf("match, orderhash:%s, filledOrder.FilledAmountS:%s", filledOrder.OrderState.RawOrder.Hash.Hex(), filledOrder.FillAmountS.FloatString(3))
 			candidateRing.filledOrders[filledOrder.OrderState.RawOrder.Hash] = filledOrder.FillAmountS
 		}
@@ -320,7 +324,7 @@ func (market *Market) generateFilledOrder(order *types.OrderState) (*types.Fille
 	return types.ConvertOrderStateToFilledOrder(*order, lrcTokenBalance, tokenSBalance, market.protocolImpl.LrcTokenAddress), nil
 }
 
-func (market *Market) generateRingSubmitInfo(orders ...*types.OrderState) (*types.RingSubmitInfo, error) {
+func (market *Market) generateFilledOrders(orders ...*types.OrderState) ([]*types.FilledOrder, error) {
 	filledOrders := []*types.FilledOrder{}
 	//miner will received nothing, if miner set FeeSelection=1 and he doesn't have enough lrc
 	for _, order := range orders {
@@ -331,6 +335,14 @@ func (market *Market) generateRingSubmitInfo(orders ...*types.OrderState) (*type
 			filledOrders = append(filledOrders, filledOrder)
 		}
 	}
+	return filledOrders, nil
+}
+
+func (market *Market) generateRingSubmitInfo(orders ...*types.OrderState) (*types.RingSubmitInfo, error) {
+	filledOrders, err := market.generateFilledOrders(orders...)
+	if nil != err {
+		return nil, err
+	}
 
 	ringTmp := miner.NewRing(filledOrders)
 	if err := market.matcher.evaluator.ComputeRing(ringTmp); nil != err {
@@ -341,6 +353,22 @@ func (market *Market) generateRingSubmitInfo(orders ...*types.OrderState) (*type
 	}
 }
 
+//the orders are settled at the same price, it's used by the batch auction
+func (market *Market) generateRingSubmitInfoAtPrice(price *big.Rat, orders ...*types.OrderState) (*types.RingSubmitInfo, error) {
+	filledOrders, err := market.generateFilledOrders(orders...)
+	if nil != err {
+		return nil, err
+	}
+
+	ringTmp := miner.NewRing(filledOrders)
+	if err := market.matcher.evaluator.ComputeRingAtPrice(ringTmp, price); nil != err {
+		return nil, err
+	} else {
+		res, err := market.matcher.submitter.GenerateRingSubmitInfo(ringTmp)
+		return res, err
+	}
+}
+
 func NewMarket(protocolAddress *ethaccessor.ProtocolAddress, tokenS, tokenB common.Address, matcher *TimingMatcher, om ordermanager.OrderManager) *Market {
 
 	m := &Market{}
@@ -354,6 +382,14 @@ func NewMarket(protocolAddress *ethaccessor.ProtocolAddress, tokenS, tokenB comm
 	return m
 }
 
+// Name returns the name of market used by metrics, it's the addresses of tokens if the market isn't supported
+func (market *Market) Name() string {
+	if name, err := util.WrapMarketByAddress(market.TokenA.Hex(), market.TokenB.Hex()); nil == err {
+		return name
+	}
+	return market.TokenA.Hex() + "-" + market.TokenB.Hex()
+}
+
 func ratToInt(rat *big.Rat) *big.Int {
 	return new(big.Int).Div(rat.Num(), rat.Denom())
 }
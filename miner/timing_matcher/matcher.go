@@ -19,6 +19,7 @@
 package timing_matcher
 
 import (
+	"errors"
 	"github.com/Loopring/relay/miner"
 	"github.com/Loopring/relay/ordermanager"
 	"github.com/ethereum/go-ethereum/common"
@@ -31,12 +32,28 @@ import (
 	marketLib "github.com/Loopring/relay/market"
 	marketUtilLib "github.com/Loopring/relay/market/util"
 	"strings"
+	"sync"
 )
 
 /**
 定时从ordermanager中拉取n条order数据进行匹配成环，如果成环则通过调用evaluator进行费用估计，然后提交到submitter进行提交到以太坊
 */
 
+const (
+	MATCHER_TIMING        = "timing"
+	MATCHER_EVENT_DRIVEN  = "event_driven"
+	MATCHER_BATCH_AUCTION = "batch_auction"
+)
+
+func init() {
+	miner.RegisterMatcher(MATCHER_TIMING, func(ctx *miner.MatcherContext) (miner.Matcher, error) {
+		if nil == ctx.Options.TimingMatcher {
+			return nil, errors.New("the options of TimingMatcher must be set")
+		}
+		return NewTimingMatcher(ctx.Options.TimingMatcher, ctx.Options.RingMaxLength, ctx.Submitter, ctx.Evaluator, ctx.OrderManager, ctx.AccountManager, ctx.Rds), nil
+	})
+}
+
 type TimingMatcher struct {
 	//rounds          *RoundStates
 	markets         []*Market
@@ -48,6 +65,7 @@ type TimingMatcher struct {
 	roundOrderCount int
 	reservedTime    int64
 	maxFailedCount  int64
+	ringMaxLength   int
 
 	maxCacheRoundsLength int
 	delayedNumber        int64
@@ -56,9 +74,16 @@ type TimingMatcher struct {
 	db                   dao.RdsService
 
 	stopFuncs []func()
+
+	pauseMtx sync.RWMutex
+	paused   bool
+
+	//the rounds hold it while matching, Stop waits for the round in process
+	roundMtx sync.Mutex
+	stopped  bool
 }
 
-func NewTimingMatcher(matcherOptions *config.TimingMatcher, submitter *miner.RingSubmitter, evaluator *miner.Evaluator, om ordermanager.OrderManager, accountManager *marketLib.AccountManager, rds dao.RdsService) *TimingMatcher {
+func NewTimingMatcher(matcherOptions *config.TimingMatcher, ringMaxLength int, submitter *miner.RingSubmitter, evaluator *miner.Evaluator, om ordermanager.OrderManager, accountManager *marketLib.AccountManager, rds dao.RdsService) *TimingMatcher {
 	matcher := &TimingMatcher{}
 	matcher.submitter = submitter
 	matcher.evaluator = evaluator
@@ -79,6 +104,15 @@ func NewTimingMatcher(matcherOptions *config.TimingMatcher, submitter *miner.Rin
 		matcher.maxFailedCount = 3
 	}
 
+	//the length of ring is limited by the gas evaluated in evaluator
+	if ringMaxLength > MaxRingLength {
+		matcher.ringMaxLength = MaxRingLength
+	} else if ringMaxLength < MinRingLength {
+		matcher.ringMaxLength = MinRingLength
+	} else {
+		matcher.ringMaxLength = ringMaxLength
+	}
+
 	matcher.markets = []*Market{}
 	matcher.duration = big.NewInt(matcherOptions.Duration)
 	matcher.delayedNumber = matcherOptions.DelayedNumber
@@ -151,10 +185,36 @@ func (matcher *TimingMatcher) Start() {
 	//})
 }
 
+// Stop returns after the round in process, the rings found in it have been sent to submitter
 func (matcher *TimingMatcher) Stop() {
+	matcher.roundMtx.Lock()
+	matcher.stopped = true
+	matcher.roundMtx.Unlock()
+
 	for _, stop := range matcher.stopFuncs {
 		stop()
 	}
+	log.Infof("matcher,stopped")
+}
+
+func (matcher *TimingMatcher) Pause() {
+	matcher.pauseMtx.Lock()
+	defer matcher.pauseMtx.Unlock()
+	matcher.paused = true
+	log.Infof("matcher,paused")
+}
+
+func (matcher *TimingMatcher) Resume() {
+	matcher.pauseMtx.Lock()
+	defer matcher.pauseMtx.Unlock()
+	matcher.paused = false
+	log.Infof("matcher,resumed")
+}
+
+func (matcher *TimingMatcher) Paused() bool {
+	matcher.pauseMtx.RLock()
+	defer matcher.pauseMtx.RUnlock()
+	return matcher.paused
 }
 
 func (matcher *TimingMatcher) GetAccountAvailableAmount(address, tokenAddress, spender common.Address) (*big.Rat, error) {
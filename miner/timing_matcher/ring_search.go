@@ -0,0 +1,297 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	"bytes"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/metrics"
+	"github.com/Loopring/relay/miner"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+)
+
+const (
+	MinRingLength = 2
+	MaxRingLength = 4 //the gas of ring is only evaluated up to 4 orders
+
+	MULTI_HOP_MARKET = "multi_hop" //the market of the multi-hop rings in metrics
+
+	MaxRingsPerOrder   = 8      //the rings searched from an order in a round
+	MaxRingSearchSteps = 100000 //the paths walked for the rings of a protocol in a round
+)
+
+/**
+每轮各market匹配完两个订单的环路后，使用所有market剩余的订单构建token图，
+在同一个protocol下查找长度为3到ringMaxLength的环路
+*/
+
+type tokenGraph struct {
+	market *Market //used to evaluate the rings of this protocol
+	orders map[common.Hash]*types.OrderState
+	rates  map[common.Hash]*big.Rat               //amountS/amountB of the orders
+	edges  map[common.Address][]*types.OrderState //tokenS -> orders
+}
+
+func newTokenGraph(market *Market) *tokenGraph {
+	graph := &tokenGraph{}
+	graph.market = market
+	graph.orders = make(map[common.Hash]*types.OrderState)
+	graph.rates = make(map[common.Hash]*big.Rat)
+	graph.edges = make(map[common.Address][]*types.OrderState)
+	return graph
+}
+
+func (graph *tokenGraph) addOrder(order *types.OrderState) {
+	if _, exists := graph.orders[order.RawOrder.Hash]; exists {
+		return
+	}
+	if nil == order.RawOrder.AmountS || nil == order.RawOrder.AmountB || order.RawOrder.AmountB.Sign() <= 0 {
+		return
+	}
+	graph.orders[order.RawOrder.Hash] = order
+	graph.rates[order.RawOrder.Hash] = new(big.Rat).SetFrac(order.RawOrder.AmountS, order.RawOrder.AmountB)
+	graph.edges[order.RawOrder.TokenS] = append(graph.edges[order.RawOrder.TokenS], order)
+}
+
+// cycles returns the rings whose length is between 3 and maxLength.
+// Each ring starts with the order of the smallest hash, so a ring is only found once.
+func (graph *tokenGraph) cycles(maxLength int) [][]*types.OrderState {
+	search := newRingSearch(graph, maxLength)
+	search.run()
+	return search.res
+}
+
+// ringSearch walks the paths of the graph in a round. The paths which can't be closed at a valid price are pruned,
+// and the rings found from an order and the paths walked in the round are limited, so a busy round can't stall the matcher.
+type ringSearch struct {
+	graph        *tokenGraph
+	maxLength    int
+	maxSteps     int
+	maxRings     int
+	maxRate      *big.Rat //the best rate of the orders, it's at least 1
+	steps        int
+	ringsOfOrder int
+	res          [][]*types.OrderState
+}
+
+func newRingSearch(graph *tokenGraph, maxLength int) *ringSearch {
+	search := &ringSearch{graph: graph, maxLength: maxLength, maxSteps: MaxRingSearchSteps, maxRings: MaxRingsPerOrder}
+	search.res = [][]*types.OrderState{}
+	search.maxRate = big.NewRat(1, 1)
+	for _, rate := range graph.rates {
+		if rate.Cmp(search.maxRate) > 0 {
+			search.maxRate = rate
+		}
+	}
+	//the orders of better rate are walked first, so the walk stops at the first order can't close the ring
+	for _, orders := range graph.edges {
+		sort.Slice(orders, func(i, j int) bool {
+			return graph.rates[orders[i].RawOrder.Hash].Cmp(graph.rates[orders[j].RawOrder.Hash]) > 0
+		})
+	}
+	return search
+}
+
+func (search *ringSearch) run() {
+	for _, orders := range search.graph.edges {
+		for _, order := range orders {
+			if search.steps >= search.maxSteps {
+				log.Debugf("multi-hop ring search stopped after %d steps, rings:%d", search.steps, len(search.res))
+				return
+			}
+			search.ringsOfOrder = 0
+			search.walk([]*types.OrderState{order}, search.graph.rates[order.RawOrder.Hash])
+		}
+	}
+}
+
+// walk appends the orders to path, rate is the product of the rates of path
+func (search *ringSearch) walk(path []*types.OrderState, rate *big.Rat) {
+	first := path[0]
+	last := path[len(path)-1]
+	for _, next := range search.graph.edges[last.RawOrder.TokenB] {
+		if search.ringsOfOrder >= search.maxRings || search.steps >= search.maxSteps {
+			return
+		}
+		nextRate := new(big.Rat).Mul(rate, search.graph.rates[next.RawOrder.Hash])
+		if !search.reachable(nextRate, search.maxLength-len(path)-1) {
+			return
+		}
+		if bytes.Compare(next.RawOrder.Hash.Bytes(), first.RawOrder.Hash.Bytes()) <= 0 || !canAppendToPath(path, next) {
+			continue
+		}
+		search.steps++
+		ring := append(append([]*types.OrderState{}, path...), next)
+		if next.RawOrder.TokenB == first.RawOrder.TokenS {
+			if len(ring) > MinRingLength && miner.RingPriceValid(ring...) {
+				search.res = append(search.res, ring)
+				search.ringsOfOrder++
+			}
+		} else if len(ring) < search.maxLength {
+			search.walk(ring, nextRate)
+		}
+	}
+}
+
+// reachable returns whether a path of rate can be closed with at most hops orders,
+// the product of the rates of a valid ring is at least 1
+func (search *ringSearch) reachable(rate *big.Rat, hops int) bool {
+	best := new(big.Rat).Set(rate)
+	for i := 0; i < hops; i++ {
+		best.Mul(best, search.maxRate)
+	}
+	return best.Cmp(big.NewRat(1, 1)) >= 0
+}
+
+// the tokens and owners in a ring should be different
+func canAppendToPath(path []*types.OrderState, next *types.OrderState) bool {
+	for idx, order := range path {
+		//todo:move it after contract fix bug
+		if order.RawOrder.Owner == next.RawOrder.Owner {
+			return false
+		}
+		if idx > 0 && order.RawOrder.TokenS == next.RawOrder.TokenB {
+			return false
+		}
+	}
+	return true
+}
+
+func ringUniqueId(orders []*types.OrderState) common.Hash {
+	ring := &types.Ring{}
+	for _, order := range orders {
+		ring.Orders = append(ring.Orders, &types.FilledOrder{OrderState: *order})
+	}
+	return ring.GenerateUniqueId()
+}
+
+func (matcher *TimingMatcher) matchMultiHop() {
+	graphs := make(map[common.Address]*tokenGraph)
+	orderMarkets := make(map[common.Hash]*Market)
+	for _, market := range matcher.markets {
+		protocol := market.protocolImpl.ContractAddress
+		graph, exists := graphs[protocol]
+		if !exists {
+			graph = newTokenGraph(market)
+			graphs[protocol] = graph
+		}
+		for _, orders := range []map[common.Hash]*types.OrderState{market.AtoBOrders, market.BtoAOrders} {
+			for orderhash, order := range orders {
+				if market.om.IsOrderFullFinished(order) {
+					continue
+				}
+				if failedCount, err := OrderExecuteFailedCount(orderhash); nil == err && failedCount > matcher.maxFailedCount {
+					continue
+				}
+				graph.addOrder(order)
+				orderMarkets[orderhash] = market
+			}
+		}
+	}
+
+	for _, graph := range graphs {
+		matcher.matchTokenGraph(graph, orderMarkets)
+	}
+}
+
+func (matcher *TimingMatcher) matchTokenGraph(graph *tokenGraph, orderMarkets map[common.Hash]*Market) {
+	ringSubmitInfos := []*types.RingSubmitInfo{}
+	candidateRingList := CandidateRingList{}
+	uniqueIds := make(map[common.Hash]bool)
+
+	for _, orders := range graph.cycles(matcher.ringMaxLength) {
+		uniqueId := ringUniqueId(orders)
+		if _, exists := uniqueIds[uniqueId]; exists {
+			continue
+		}
+		uniqueIds[uniqueId] = true
+		if failedCount, err := RingExecuteFailedCount(uniqueId); nil == err && failedCount > matcher.maxFailedCount {
+			log.Debugf("ring.UniqueId:%s has been failed to submit %d times", uniqueId.Hex(), failedCount)
+			continue
+		}
+		if candidateRing, err := graph.market.GenerateCandidateRing(orders...); nil != err {
+			log.Debugf("multi-hop ring, uniqueId:%s, err:%s", uniqueId.Hex(), err.Error())
+		} else if candidateRing.received.Sign() > 0 {
+			candidateRingList = append(candidateRingList, *candidateRing)
+		} else {
+			log.Debugf("multi-hop ring received not enough, received:%s, cost:%s ", candidateRing.received.FloatString(0), candidateRing.cost.FloatString(0))
+		}
+	}
+
+	log.Debugf("multi-hop match round:%s, protocol:%s, candidateRingList.length:%d", matcher.lastRoundNumber, graph.market.protocolImpl.ContractAddress.Hex(), len(candidateRingList))
+	metrics.MatcherCandidateRings.WithLabelValues(MULTI_HOP_MARKET).Add(float64(len(candidateRingList)))
+
+	list := candidateRingList
+	for len(list) > 0 {
+		sort.Sort(list)
+		candidateRing := list[0]
+		list = list[1:]
+		orders := []*types.OrderState{}
+		for _, orderhash := range candidateRing.orderhashes {
+			orders = append(orders, graph.orders[orderhash])
+		}
+		ringForSubmit, err := graph.market.generateRingSubmitInfo(orders...)
+		if nil != err {
+			log.Debugf("generate RingSubmitInfo err:%s", err.Error())
+			continue
+		}
+		if exists, err := CachedMatchedRing(ringForSubmit.Ringhash); nil != err || exists {
+			if nil != err {
+				log.Error(err.Error())
+			} else {
+				log.Errorf("ringhash:%s has been submitted", ringForSubmit.Ringhash.Hex())
+			}
+			continue
+		}
+		if ringForSubmit.RawRing.Received.Sign() <= 0 {
+			log.Debugf("ring:%s will not be submitted,because of received:%s", ringForSubmit.RawRing.Hash.Hex(), ringForSubmit.RawRing.Received.String())
+			continue
+		}
+
+		for _, filledOrder := range ringForSubmit.RawRing.Orders {
+			orderhash := filledOrder.OrderState.RawOrder.Hash
+			orderState := graph.orders[orderhash]
+			orderState.DealtAmountB.Add(orderState.DealtAmountB, ratToInt(filledOrder.FillAmountB))
+			orderState.DealtAmountS.Add(orderState.DealtAmountS, ratToInt(filledOrder.FillAmountS))
+			isFullFilled := graph.market.om.IsOrderFullFinished(orderState)
+			if isFullFilled {
+				orderMarkets[orderhash].excludeNextRound(orderState)
+			}
+			list = graph.market.reduceReceivedOfCandidateRing(list, filledOrder, isFullFilled)
+		}
+		AddMinedRing(ringForSubmit)
+		ringSubmitInfos = append(ringSubmitInfos, ringForSubmit)
+	}
+
+	if len(ringSubmitInfos) > 0 {
+		eventemitter.Emit(eventemitter.Miner_NewRing, ringSubmitInfos)
+	}
+}
+
+func (market *Market) excludeNextRound(orderState *types.OrderState) {
+	if orderState.RawOrder.TokenS == market.TokenA {
+		market.AtoBOrderHashesExcludeNextRound = append(market.AtoBOrderHashesExcludeNextRound, orderState.RawOrder.Hash)
+	} else {
+		market.BtoAOrderHashesExcludeNextRound = append(market.BtoAOrderHashesExcludeNextRound, orderState.RawOrder.Hash)
+	}
+}
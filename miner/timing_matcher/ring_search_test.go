@@ -0,0 +1,127 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+	"math/big"
+	"testing"
+)
+
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+}
+
+func newTestOrder(hash, owner, tokenS, tokenB string, amountS, amountB int64) *types.OrderState {
+	order := &types.OrderState{}
+	order.RawOrder.Hash = common.HexToHash(hash)
+	order.RawOrder.Owner = common.HexToAddress(owner)
+	order.RawOrder.TokenS = common.HexToAddress(tokenS)
+	order.RawOrder.TokenB = common.HexToAddress(tokenB)
+	order.RawOrder.AmountS = big.NewInt(amountS)
+	order.RawOrder.AmountB = big.NewInt(amountB)
+	return order
+}
+
+func TestTokenGraph_Cycles(t *testing.T) {
+	lrc, weth, dai, rdn := "0x01", "0x02", "0x03", "0x04"
+	graph := newTokenGraph(nil)
+	graph.addOrder(newTestOrder("0x11", "0xa1", lrc, weth, 100, 10))
+	graph.addOrder(newTestOrder("0x12", "0xa2", weth, dai, 10, 100))
+	graph.addOrder(newTestOrder("0x13", "0xa3", dai, lrc, 100, 100))
+	//the price of this order is too high to close the ring with 0x11 and 0x12
+	graph.addOrder(newTestOrder("0x14", "0xa4", dai, lrc, 100, 1000))
+	//a ring of four orders
+	graph.addOrder(newTestOrder("0x15", "0xa5", dai, rdn, 100, 100))
+	graph.addOrder(newTestOrder("0x16", "0xa6", rdn, lrc, 100, 100))
+	//two orders belong to the same owner
+	graph.addOrder(newTestOrder("0x17", "0xa1", dai, lrc, 100, 10))
+
+	cycles := graph.cycles(3)
+	if len(cycles) != 1 {
+		t.Fatalf("expected 1 ring of 3 orders, got %d", len(cycles))
+	}
+	if cycles[0][0].RawOrder.Hash != common.HexToHash("0x11") || cycles[0][2].RawOrder.Hash != common.HexToHash("0x13") {
+		t.Fatalf("unexpected ring %s -> %s", cycles[0][0].RawOrder.Hash.Hex(), cycles[0][2].RawOrder.Hash.Hex())
+	}
+
+	cycles = graph.cycles(4)
+	if len(cycles) != 2 {
+		t.Fatalf("expected 2 rings, got %d", len(cycles))
+	}
+	for _, ring := range cycles {
+		for idx, order := range ring {
+			next := ring[(idx+1)%len(ring)]
+			if order.RawOrder.TokenB != next.RawOrder.TokenS {
+				t.Fatalf("order:%s can't be followed by order:%s", order.RawOrder.Hash.Hex(), next.RawOrder.Hash.Hex())
+			}
+		}
+	}
+}
+
+func TestRingUniqueId(t *testing.T) {
+	a := newTestOrder("0x11", "0xa1", "0x01", "0x02", 1, 1)
+	b := newTestOrder("0x12", "0xa2", "0x02", "0x03", 1, 1)
+	c := newTestOrder("0x13", "0xa3", "0x03", "0x01", 1, 1)
+	if ringUniqueId([]*types.OrderState{a, b, c}) != ringUniqueId([]*types.OrderState{b, c, a}) {
+		t.Fatalf("the uniqueId of a ring should not depend on the first order")
+	}
+}
+
+func TestRingSearch_Bounded(t *testing.T) {
+	lrc, weth, dai := "0x01", "0x02", "0x03"
+	graph := newTokenGraph(nil)
+	idx := 0
+	for _, pair := range [][2]string{{lrc, weth}, {weth, dai}, {dai, lrc}} {
+		for i := 0; i < 20; i++ {
+			idx++
+			hash := common.BigToHash(big.NewInt(int64(idx))).Hex()
+			owner := common.BigToAddress(big.NewInt(int64(0xa000 + idx))).Hex()
+			graph.addOrder(newTestOrder(hash, owner, pair[0], pair[1], 100, 100))
+		}
+	}
+	search := newRingSearch(graph, 3)
+	search.run()
+	//8000 rings are in the graph, only the first ones of each order are searched
+	if len(search.res) == 0 || len(search.res) > MaxRingsPerOrder*len(graph.orders) {
+		t.Fatalf("the rings of each order should be limited, got %d", len(search.res))
+	}
+
+	search = newRingSearch(graph, 3)
+	search.maxSteps = 10
+	search.run()
+	if search.steps > 10 {
+		t.Fatalf("the paths walked in a round should be limited, got %d", search.steps)
+	}
+
+	//the rate of any ring is 100/101^3, no path should be walked
+	graph = newTokenGraph(nil)
+	for i, pair := range [][2]string{{lrc, weth}, {weth, dai}, {dai, lrc}} {
+		graph.addOrder(newTestOrder(common.BigToHash(big.NewInt(int64(i+1))).Hex(), common.BigToAddress(big.NewInt(int64(i+1))).Hex(), pair[0], pair[1], 100, 101))
+	}
+	search = newRingSearch(graph, 3)
+	search.run()
+	if search.steps != 0 || len(search.res) != 0 {
+		t.Fatalf("the paths can't be closed at a valid price should be pruned, got steps:%d, rings:%d", search.steps, len(search.res))
+	}
+}
@@ -237,6 +237,7 @@ import (
 //}
 
 type CandidateRing struct {
+	orderhashes  []common.Hash
 	filledOrders map[common.Hash]*big.Rat
 	received     *big.Rat
 	cost         *big.Rat
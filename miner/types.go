@@ -25,20 +25,17 @@ import (
 )
 
 type NormalSenderAddress struct {
-	Address         common.Address
-	GasPriceLimit   *big.Int
-	MaxPendingTtl   int
-	MaxPendingCount int64
-
-	Nonce *big.Int
+	Address             common.Address
+	GasPriceLimit       *big.Int
+	MaxPendingTtl       int
+	MaxPendingCount     int64
+	GasPriceBumpPercent int64
 }
 
 type SplitMinerAddress struct {
 	Address    common.Address
 	FeePercent float64
 	StartFee   float64
-
-	Nonce *big.Int
 }
 
 func NewRing(filledOrders []*types.FilledOrder) *types.Ring {
@@ -0,0 +1,152 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package node
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+)
+
+const (
+	ADMIN_PATH = "/admin/"
+
+	REDACTED = "******"
+)
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+type pauseState struct {
+	Paused bool `json:"paused"`
+}
+
+// redactedConfig hides the log options, the encoders of zap can't be marshaled
+type redactedConfig struct {
+	*config.GlobalConfig
+	Log *struct{} `json:"Log,omitempty"`
+}
+
+// AdminHandler serves the admin api with the routes of mux after the bearer token is verified
+type AdminHandler struct {
+	token []byte
+	mux   *http.ServeMux
+}
+
+func NewAdminHandler(token string) *AdminHandler {
+	h := &AdminHandler{}
+	h.token = []byte(token)
+	h.mux = http.NewServeMux()
+	return h
+}
+
+func (h *AdminHandler) Handle(method, path string, handler http.HandlerFunc) {
+	h.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if method != r.Method {
+			w.Header().Set("Allow", method)
+			writeJson(w, http.StatusMethodNotAllowed, adminError{Error: "method not allowed"})
+			return
+		}
+		handler(w, r)
+	})
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || 1 != subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), h.token) {
+		writeJson(w, http.StatusUnauthorized, adminError{Error: "unauthorized"})
+		return
+	}
+	log.Infof("admin,%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	h.mux.ServeHTTP(w, r)
+}
+
+// registerAdmin serves the admin api with the metrics, the routes of matcher and extractor
+// are only available in the mode running them.
+func (n *Node) registerAdmin() {
+	if nil == n.metricsServer || "" == n.globalConfig.Admin.Token {
+		return
+	}
+	h := NewAdminHandler(n.globalConfig.Admin.Token)
+
+	h.Handle(http.MethodGet, ADMIN_PATH+"config", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, RedactConfig(n.globalConfig))
+	})
+	h.Handle(http.MethodGet, ADMIN_PATH+"eth_clients", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, ethaccessor.Clients())
+	})
+
+	if nil != n.mineNode {
+		miner := n.mineNode.miner
+		h.Handle(http.MethodPost, ADMIN_PATH+"matcher/pause", func(w http.ResponseWriter, r *http.Request) {
+			miner.Pause()
+			writeJson(w, http.StatusOK, pauseState{Paused: miner.Paused()})
+		})
+		h.Handle(http.MethodPost, ADMIN_PATH+"matcher/resume", func(w http.ResponseWriter, r *http.Request) {
+			miner.Resume()
+			writeJson(w, http.StatusOK, pauseState{Paused: miner.Paused()})
+		})
+	}
+
+	if nil != n.relayNode {
+		extractorService := n.relayNode.extractorService
+		h.Handle(http.MethodPost, ADMIN_PATH+"extractor/pause", func(w http.ResponseWriter, r *http.Request) {
+			extractorService.Pause()
+			writeJson(w, http.StatusOK, pauseState{Paused: extractorService.Paused()})
+		})
+		h.Handle(http.MethodPost, ADMIN_PATH+"extractor/resume", func(w http.ResponseWriter, r *http.Request) {
+			extractorService.Resume()
+			writeJson(w, http.StatusOK, pauseState{Paused: extractorService.Paused()})
+		})
+
+		trendManager := &n.relayNode.trendManager
+		h.Handle(http.MethodPost, ADMIN_PATH+"trend/proofread", func(w http.ResponseWriter, r *http.Request) {
+			go trendManager.ProofRead()
+			writeJson(w, http.StatusAccepted, struct{}{})
+		})
+	}
+
+	n.metricsServer.Handle(ADMIN_PATH, h)
+}
+
+// RedactConfig returns a copy of the config without the passwords and tokens
+func RedactConfig(globalConfig *config.GlobalConfig) interface{} {
+	cfg := *globalConfig
+	redact := func(secret *string) {
+		if "" != *secret {
+			*secret = REDACTED
+		}
+	}
+	redact(&cfg.Mysql.Password)
+	redact(&cfg.Redis.Password)
+	redact(&cfg.OrderAuth.KeystorePassphrase)
+	redact(&cfg.Admin.Token)
+	cfg.Jsonrpc.ApiKeys = make([]config.ApiKeyOptions, len(globalConfig.Jsonrpc.ApiKeys))
+	for i, apiKey := range globalConfig.Jsonrpc.ApiKeys {
+		redact(&apiKey.Key)
+		redact(&apiKey.Secret)
+		cfg.Jsonrpc.ApiKeys[i] = apiKey
+	}
+	return redactedConfig{GlobalConfig: &cfg}
+}
@@ -0,0 +1,157 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/ethaccessor"
+)
+
+const (
+	HEALTH_PATH    = "/healthz"
+	READINESS_PATH = "/readyz"
+
+	STATUS_OK          = "ok"
+	STATUS_UNAVAILABLE = "unavailable"
+
+	healthCheckTimeout = 5 * time.Second
+)
+
+// HealthCheck returns nil if the dependency is available
+type HealthCheck func() error
+
+type CheckResult struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// registerHealth serves the liveness and readiness of the node with the metrics,
+// the relay is ready only after the extractor has caught up with the chain, and it isn't ready once stopping.
+func (n *Node) registerHealth() {
+	if nil == n.metricsServer {
+		return
+	}
+	n.metricsServer.Handle(HEALTH_PATH, HealthHandler(n.healthChecks()))
+	n.metricsServer.Handle(READINESS_PATH, HealthHandler(n.readinessChecks()))
+}
+
+func (n *Node) healthChecks() map[string]HealthCheck {
+	return map[string]HealthCheck{
+		"mysql":     n.rdsService.Ping,
+		"redis":     cache.Ping,
+		"eth_nodes": checkEthNodes,
+	}
+}
+
+func (n *Node) readinessChecks() map[string]HealthCheck {
+	checks := n.healthChecks()
+	checks["node"] = func() error {
+		if n.Stopping() {
+			return errors.New("stopping")
+		}
+		return nil
+	}
+	if nil != n.relayNode {
+		checks["extractor"] = func() error {
+			if !n.relayNode.extractorService.Synced() {
+				return errors.New("syncing blocks")
+			}
+			return nil
+		}
+	}
+	return checks
+}
+
+func checkEthNodes() error {
+	clients := ethaccessor.Clients()
+	for _, client := range clients {
+		if !client.Downed {
+			return nil
+		}
+	}
+	return fmt.Errorf("all of the %d eth nodes are down", len(clients))
+}
+
+// RunHealthChecks runs the checks concurrently, the ones not returned in time are failed
+func RunHealthChecks(checks map[string]HealthCheck) HealthReport {
+	type checkReply struct {
+		name string
+		err  error
+	}
+	replies := make(chan checkReply, len(checks))
+	for name, check := range checks {
+		go func(name string, check HealthCheck) {
+			replies <- checkReply{name: name, err: check()}
+		}(name, check)
+	}
+
+	report := HealthReport{Status: STATUS_OK, Checks: make(map[string]CheckResult)}
+	for name := range checks {
+		report.Checks[name] = CheckResult{Error: "timeout"}
+	}
+	timeout := time.After(healthCheckTimeout)
+collect:
+	for i := 0; i < len(checks); i++ {
+		select {
+		case reply := <-replies:
+			if nil == reply.err {
+				report.Checks[reply.name] = CheckResult{Ok: true}
+			} else {
+				report.Checks[reply.name] = CheckResult{Error: reply.err.Error()}
+			}
+		case <-timeout:
+			break collect
+		}
+	}
+	for _, result := range report.Checks {
+		if !result.Ok {
+			report.Status = STATUS_UNAVAILABLE
+		}
+	}
+	return report
+}
+
+// HealthHandler responds 503 if any of the checks failed, so the load balancers can take the node out
+func HealthHandler(checks map[string]HealthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := RunHealthChecks(checks)
+		status := http.StatusOK
+		if STATUS_OK != report.Status {
+			status = http.StatusServiceUnavailable
+		}
+		writeJson(w, status, report)
+	})
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,110 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package node_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/node"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ok := func() error { return nil }
+	down := func() error { return errors.New("connection refused") }
+
+	get := func(checks map[string]node.HealthCheck) (int, node.HealthReport) {
+		w := httptest.NewRecorder()
+		node.HealthHandler(checks).ServeHTTP(w, httptest.NewRequest(http.MethodGet, node.HEALTH_PATH, nil))
+		var report node.HealthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); nil != err {
+			t.Fatal(err)
+		}
+		return w.Code, report
+	}
+
+	if code, report := get(map[string]node.HealthCheck{"mysql": ok, "redis": ok}); http.StatusOK != code || node.STATUS_OK != report.Status {
+		t.Fatalf("all the checks passed, got code:%d, status:%s", code, report.Status)
+	}
+
+	code, report := get(map[string]node.HealthCheck{"mysql": ok, "redis": down})
+	if http.StatusServiceUnavailable != code || node.STATUS_UNAVAILABLE != report.Status {
+		t.Fatalf("redis is down, got code:%d, status:%s", code, report.Status)
+	}
+	if !report.Checks["mysql"].Ok || report.Checks["redis"].Ok || "connection refused" != report.Checks["redis"].Error {
+		t.Fatalf("unexpected checks:%+v", report.Checks)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	h := node.NewAdminHandler("secret")
+	h.Handle(http.MethodPost, node.ADMIN_PATH+"matcher/pause", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(method, token string) int {
+		r := httptest.NewRequest(method, node.ADMIN_PATH+"matcher/pause", nil)
+		if "" != token {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve(http.MethodPost, ""); http.StatusUnauthorized != code {
+		t.Fatalf("request without token should be rejected, got:%d", code)
+	}
+	if code := serve(http.MethodPost, "wrong"); http.StatusUnauthorized != code {
+		t.Fatalf("request with wrong token should be rejected, got:%d", code)
+	}
+	if code := serve(http.MethodGet, "secret"); http.StatusMethodNotAllowed != code {
+		t.Fatalf("pause only accepts post, got:%d", code)
+	}
+	if code := serve(http.MethodPost, "secret"); http.StatusOK != code {
+		t.Fatalf("request with token should be served, got:%d", code)
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	cfg := &config.GlobalConfig{}
+	cfg.Mysql.Password = "mysql-password"
+	cfg.Admin.Token = "secret"
+	cfg.Mysql.Hostname = "127.0.0.1"
+	cfg.Jsonrpc.ApiKeys = []config.ApiKeyOptions{{Name: "market-maker", Key: "api-key", Secret: "api-secret"}}
+
+	data, err := json.Marshal(node.RedactConfig(cfg))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "mysql-password") || strings.Contains(string(data), "secret") || strings.Contains(string(data), "api-key") {
+		t.Fatalf("the secrets aren't redacted:%s", string(data))
+	}
+	if !strings.Contains(string(data), "127.0.0.1") {
+		t.Fatalf("the config isn't dumped:%s", string(data))
+	}
+	if "mysql-password" != cfg.Mysql.Password || "api-secret" != cfg.Jsonrpc.ApiKeys[0].Secret {
+		t.Fatalf("the original config shouldn't be changed")
+	}
+}
@@ -0,0 +1,204 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay/log"
+)
+
+const DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+// Service is a component managed by the node, Start shouldn't block and Stop waits
+// for the work in process until ctx is done.
+type Service interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+type serviceFuncs struct {
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+func (s *serviceFuncs) Start(ctx context.Context) error {
+	if nil == s.start {
+		return nil
+	}
+	return s.start(ctx)
+}
+
+func (s *serviceFuncs) Stop(ctx context.Context) error {
+	if nil == s.stop {
+		return nil
+	}
+	return s.stop(ctx)
+}
+
+func ServiceFuncs(start, stop func(ctx context.Context) error) Service {
+	return &serviceFuncs{start: start, stop: stop}
+}
+
+// NewService adapts the components started and stopped synchronously,
+// Stop returns the error of ctx if stop hasn't returned when ctx is done.
+func NewService(start, stop func()) Service {
+	s := &serviceFuncs{}
+	if nil != start {
+		s.start = func(ctx context.Context) error {
+			start()
+			return nil
+		}
+	}
+	if nil != stop {
+		s.stop = func(ctx context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				stop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return s
+}
+
+type managedService struct {
+	name      string
+	service   Service
+	dependsOn []string
+}
+
+// ServiceManager starts the services after their dependencies and stops them in reverse order,
+// the services without dependency between them are started in order of registration.
+type ServiceManager struct {
+	mtx      sync.Mutex
+	services []*managedService
+	started  []*managedService
+}
+
+func NewServiceManager() *ServiceManager {
+	m := &ServiceManager{}
+	m.services = []*managedService{}
+	m.started = []*managedService{}
+	return m
+}
+
+func (m *ServiceManager) Register(name string, service Service, dependsOn ...string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.services = append(m.services, &managedService{name: name, service: service, dependsOn: dependsOn})
+}
+
+// order sorts the services topologically, it fails if a dependency isn't registered or they depend on each other
+func (m *ServiceManager) order() ([]*managedService, error) {
+	registered := make(map[string]bool)
+	for _, s := range m.services {
+		if registered[s.name] {
+			return nil, fmt.Errorf("service:%s has been registered", s.name)
+		}
+		registered[s.name] = true
+	}
+	for _, s := range m.services {
+		for _, dep := range s.dependsOn {
+			if !registered[dep] {
+				return nil, fmt.Errorf("service:%s depends on %s, which hasn't been registered", s.name, dep)
+			}
+		}
+	}
+
+	ordered := []*managedService{}
+	added := make(map[string]bool)
+	for len(ordered) < len(m.services) {
+		progressed := false
+		for _, s := range m.services {
+			if added[s.name] {
+				continue
+			}
+			ready := true
+			for _, dep := range s.dependsOn {
+				if !added[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, s)
+				added[s.name] = true
+				progressed = true
+				break
+			}
+		}
+		if !progressed {
+			return nil, fmt.Errorf("there is a dependency cycle in the services")
+		}
+	}
+	return ordered, nil
+}
+
+// Start starts the services in order of dependencies, the ones started are stopped if any of them fails
+func (m *ServiceManager) Start(ctx context.Context) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	ordered, err := m.order()
+	if nil != err {
+		return err
+	}
+	for _, s := range ordered {
+		log.Infof("node,starting service:%s", s.name)
+		if err := s.service.Start(ctx); nil != err {
+			m.stop(ctx)
+			return fmt.Errorf("failed to start service:%s, err:%s", s.name, err.Error())
+		}
+		m.started = append(m.started, s)
+	}
+	return nil
+}
+
+// Stop stops the services started in reverse order, it goes on after a service fails and returns the first error
+func (m *ServiceManager) Stop(ctx context.Context) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.stop(ctx)
+}
+
+func (m *ServiceManager) stop(ctx context.Context) error {
+	var firstErr error
+	for i := len(m.started) - 1; i >= 0; i-- {
+		s := m.started[i]
+		log.Infof("node,stopping service:%s", s.name)
+		if err := s.service.Stop(ctx); nil != err {
+			log.Errorf("node,stop service:%s err:%s", s.name, err.Error())
+			if nil == firstErr {
+				firstErr = fmt.Errorf("failed to stop service:%s, err:%s", s.name, err.Error())
+			}
+		}
+	}
+	m.started = []*managedService{}
+	return firstErr
+}
@@ -0,0 +1,114 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package node_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/node"
+)
+
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) service(name string, startErr error) node.Service {
+	return node.ServiceFuncs(func(ctx context.Context) error {
+		r.events = append(r.events, "start "+name)
+		return startErr
+	}, func(ctx context.Context) error {
+		r.events = append(r.events, "stop "+name)
+		return nil
+	})
+}
+
+func TestServiceManagerOrder(t *testing.T) {
+	r := &recorder{}
+	m := node.NewServiceManager()
+	m.Register("extractor", r.service("extractor", nil), "order_manager", "tx_manager")
+	m.Register("order_manager", r.service("order_manager", nil))
+	m.Register("jsonrpc", r.service("jsonrpc", nil), "order_manager")
+	m.Register("tx_manager", r.service("tx_manager", nil))
+
+	if err := m.Start(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if err := m.Stop(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"start order_manager", "start jsonrpc", "start tx_manager", "start extractor",
+		"stop extractor", "stop tx_manager", "stop jsonrpc", "stop order_manager",
+	}
+	if !reflect.DeepEqual(expected, r.events) {
+		t.Fatalf("expected:%v, got:%v", expected, r.events)
+	}
+}
+
+func TestServiceManagerStartFailed(t *testing.T) {
+	r := &recorder{}
+	m := node.NewServiceManager()
+	m.Register("order_manager", r.service("order_manager", nil))
+	m.Register("extractor", r.service("extractor", errors.New("no eth node")), "order_manager")
+	m.Register("jsonrpc", r.service("jsonrpc", nil), "extractor")
+
+	if err := m.Start(context.Background()); nil == err {
+		t.Fatalf("start should fail")
+	}
+	expected := []string{"start order_manager", "start extractor", "stop order_manager"}
+	if !reflect.DeepEqual(expected, r.events) {
+		t.Fatalf("expected:%v, got:%v", expected, r.events)
+	}
+}
+
+func TestServiceManagerDependencies(t *testing.T) {
+	r := &recorder{}
+	m := node.NewServiceManager()
+	m.Register("a", r.service("a", nil), "b")
+	m.Register("b", r.service("b", nil), "a")
+	if err := m.Start(context.Background()); nil == err {
+		t.Fatalf("the cycle should be detected")
+	}
+
+	m = node.NewServiceManager()
+	m.Register("a", r.service("a", nil), "c")
+	if err := m.Start(context.Background()); nil == err {
+		t.Fatalf("the dependency not registered should be detected")
+	}
+	if len(r.events) > 0 {
+		t.Fatalf("nothing should be started, got:%v", r.events)
+	}
+}
+
+func TestNewServiceStopTimeout(t *testing.T) {
+	release := make(chan bool)
+	defer close(release)
+	s := node.NewService(nil, func() {
+		<-release
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Stop(ctx); context.DeadlineExceeded != err {
+		t.Fatalf("stop should return when ctx is done, err:%v", err)
+	}
+}
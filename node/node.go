@@ -19,6 +19,7 @@
 package node
 
 import (
+	"context"
 	"sync"
 
 	"fmt"
@@ -27,19 +28,23 @@ import (
 	"github.com/Loopring/relay/crypto"
 	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/extractor"
 	"github.com/Loopring/relay/gateway"
 	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/market"
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/metrics"
 	"github.com/Loopring/relay/miner"
-	"github.com/Loopring/relay/miner/timing_matcher"
+	_ "github.com/Loopring/relay/miner/timing_matcher"
 	"github.com/Loopring/relay/ordermanager"
 	"github.com/Loopring/relay/txmanager"
 	"github.com/Loopring/relay/usermanager"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
 	"go.uber.org/zap"
+	"os"
 )
 
 const (
@@ -57,10 +62,13 @@ type Node struct {
 	accountManager    market.AccountManager
 	relayNode         *RelayNode
 	mineNode          *MineNode
+	metricsServer     *metrics.Server
+	services          *ServiceManager
 
-	stop   chan struct{}
-	lock   sync.RWMutex
-	logger *zap.Logger
+	stop     chan struct{}
+	stopping bool
+	lock     sync.RWMutex
+	logger   *zap.Logger
 }
 
 type RelayNode struct {
@@ -74,42 +82,22 @@ type RelayNode struct {
 	txManager        txmanager.TransactionManager
 }
 
-func (n *RelayNode) Start() {
-	n.txManager.Start()
-	n.extractorService.Start()
-
-	//gateway.NewJsonrpcService("8080").Start()
-	fmt.Println("step in relay node start")
-	n.tickerCollector.Start()
-	go n.jsonRpcService.Start()
-	//n.websocketService.Start()
-	go n.socketIOService.Start()
-
-}
-
-func (n *RelayNode) Stop() {
-	n.txManager.Stop()
-}
-
 type MineNode struct {
 	miner *miner.Miner
 }
 
-func (n *MineNode) Start() {
-	n.miner.Start()
-}
-func (n *MineNode) Stop() {
-	n.miner.Stop()
-}
-
 func NewNode(logger *zap.Logger, globalConfig *config.GlobalConfig) *Node {
 	n := &Node{}
 	n.logger = logger
 	n.globalConfig = globalConfig
+	n.stop = make(chan struct{})
 
 	// register
+	n.registerMetrics()
 	n.registerMysql()
+	n.registerOrderAuth()
 	cache.NewCache(n.globalConfig.Redis)
+	n.registerEventBus()
 
 	util.Initialize(n.globalConfig.Market)
 	n.registerMarketCap()
@@ -128,6 +116,9 @@ func NewNode(logger *zap.Logger, globalConfig *config.GlobalConfig) *Node {
 		n.registerMineNode()
 		n.registerRelayNode()
 	}
+	n.registerServices()
+	n.registerHealth()
+	n.registerAdmin()
 
 	return n
 }
@@ -152,44 +143,94 @@ func (n *Node) registerMineNode() {
 	n.registerMiner()
 }
 
-func (n *Node) Start() {
-	n.orderManager.Start()
-	n.marketCapProvider.Start()
+// registerServices registers the services in order of start, the event bus starts after all the watchers are registered.
+// They are stopped in reverse order on shutdown, so the servers stop taking requests first, then the extractor finishes
+// the block in process and the matcher finishes the round in process.
+func (n *Node) registerServices() {
+	n.services = NewServiceManager()
+	n.services.Register("market_cap", NewService(n.marketCapProvider.Start, n.marketCapProvider.Stop))
+	n.services.Register("order_manager", NewService(n.orderManager.Start, n.orderManager.Stop))
+	n.services.Register("gas_price_evaluator", NewService(ethaccessor.IncludeGasPriceEvaluator, ethaccessor.ExcludeGasPriceEvaluator))
+	if nil != n.relayNode {
+		n.services.Register("account_manager", NewService(n.accountManager.Start, nil))
+		n.services.Register("tx_manager", NewService(n.relayNode.txManager.Start, n.relayNode.txManager.Stop))
+		n.services.Register("ticker_collector", NewService(n.relayNode.tickerCollector.Start, n.relayNode.tickerCollector.Stop))
+	}
+	if nil != n.mineNode {
+		n.services.Register("miner", NewService(n.mineNode.miner.Start, n.mineNode.miner.Stop), "order_manager", "market_cap", "gas_price_evaluator")
+	}
+	if nil != n.relayNode {
+		//the consumers of the events extracted must be started before it
+		extractorDeps := []string{"order_manager", "account_manager", "tx_manager"}
+		if nil != n.mineNode {
+			extractorDeps = append(extractorDeps, "miner")
+		}
+		n.services.Register("extractor", n.relayNode.extractorService, extractorDeps...)
+		n.services.Register("jsonrpc", &n.relayNode.jsonRpcService, "order_manager", "account_manager", "market_cap")
+		n.services.Register("socketio", &n.relayNode.socketIOService, "order_manager", "account_manager", "market_cap")
+		if "" != n.globalConfig.Websocket.StreamPort {
+			n.services.Register("websocket", &n.relayNode.websocketService, "order_manager", "account_manager", "market_cap")
+		}
+	}
+
+	busDeps := []string{}
+	for _, s := range n.services.services {
+		busDeps = append(busDeps, s.name)
+	}
+	n.services.Register("event_bus", ServiceFuncs(func(ctx context.Context) error {
+		return eventemitter.StartBus()
+	}, func(ctx context.Context) error {
+		eventemitter.StopBus()
+		return nil
+	}), busDeps...)
+}
 
-	if n.globalConfig.Mode != MODEL_MINER {
-		n.accountManager.Start()
-		n.relayNode.Start()
-		go ethaccessor.IncludeGasPriceEvaluator()
+func (n *Node) Start() {
+	if nil != n.metricsServer {
+		if err := n.metricsServer.Start(); nil != err {
+			log.Fatalf("failed to start metrics server, err:%s", err.Error())
+		}
 	}
-	if n.globalConfig.Mode != MODEL_RELAY {
-		n.mineNode.Start()
-		ethaccessor.IncludeGasPriceEvaluator()
+	if err := n.services.Start(context.Background()); nil != err {
+		log.Fatalf("failed to start node, err:%s", err.Error())
 	}
 }
 
+// Wait blocks until the node has been stopped
 func (n *Node) Wait() {
-	n.lock.RLock()
-
-	// TODO(fk): states should be judged
-
-	stop := n.stop
-	n.lock.RUnlock()
+	<-n.stop
+}
 
-	<-stop
+// Stop stops the services in reverse order of start and waits for the event handlers in process,
+// it returns the error of ctx if they haven't finished when ctx is done.
+func (n *Node) Stop(ctx context.Context) error {
+	n.lock.Lock()
+	if n.stopping {
+		n.lock.Unlock()
+		return nil
+	}
+	n.stopping = true
+	n.lock.Unlock()
+
+	err := n.services.Stop(ctx)
+	if drainErr := eventemitter.Drain(ctx); nil != drainErr {
+		log.Errorf("node,drain event handlers err:%s", drainErr.Error())
+		if nil == err {
+			err = drainErr
+		}
+	}
+	//the health checks are served until the end
+	if nil != n.metricsServer {
+		n.metricsServer.Stop()
+	}
+	close(n.stop)
+	return err
 }
 
-func (n *Node) Stop() {
+func (n *Node) Stopping() bool {
 	n.lock.RLock()
-	n.mineNode.Stop()
-	//
-	//n.p2pListener.Stop()
-	//n.chainListener.Stop()
-	//n.orderbook.Stop()
-	//n.miner.Stop()
-
-	//close(n.stop)
-
-	n.lock.RUnlock()
+	defer n.lock.RUnlock()
+	return n.stopping
 }
 
 func (n *Node) registerCrypto(ks *keystore.KeyStore) {
@@ -197,11 +238,71 @@ func (n *Node) registerCrypto(ks *keystore.KeyStore) {
 	crypto.Initialize(c)
 }
 
+func (n *Node) registerMetrics() {
+	if "" == n.globalConfig.Metrics.Port {
+		return
+	}
+	n.metricsServer = metrics.NewServer(n.globalConfig.Metrics)
+}
+
 func (n *Node) registerMysql() {
 	n.rdsService = dao.NewRdsService(n.globalConfig.Mysql)
 	n.rdsService.Prepare()
 }
 
+func (n *Node) registerOrderAuth() {
+	masterKey, err := LoadOrderAuthMasterKey(n.globalConfig)
+	if nil != err {
+		log.Fatalf("load master key of order auth private keys error:%s", err.Error())
+	}
+	if nil == masterKey {
+		log.Warnf("the master key of order auth private keys isn't configured, they are saved as plaintext")
+		return
+	}
+	c, err := crypto.NewEnvelopeCipher(masterKey)
+	if nil != err {
+		log.Fatalf("create cipher of order auth private keys error:%s", err.Error())
+	}
+	crypto.InitializeAuthKeyCipher(c)
+}
+
+// LoadOrderAuthMasterKey returns nil if neither the key file nor the keystore account is configured
+func LoadOrderAuthMasterKey(globalConfig *config.GlobalConfig) ([]byte, error) {
+	options := globalConfig.OrderAuth
+	if "" != options.MasterKeyFile {
+		return crypto.LoadMasterKeyFile(options.MasterKeyFile)
+	}
+	if "" != options.KeystoreAccount {
+		if !common.IsHexAddress(options.KeystoreAccount) {
+			return nil, fmt.Errorf("invalid keystore account:%s", options.KeystoreAccount)
+		}
+		return crypto.LoadKeystoreMasterKey(globalConfig.Keystore.Keydir, common.HexToAddress(options.KeystoreAccount), options.KeystorePassphrase)
+	}
+	return nil, nil
+}
+
+func (n *Node) registerEventBus() {
+	options := n.globalConfig.EventBus
+	if "" == options.Transport || len(options.Topics) <= 0 {
+		return
+	}
+	//each host receives all the events by default, and resumes from its pending events after restart
+	hostname, _ := os.Hostname()
+	if "" == options.Group {
+		options.Group = fmt.Sprintf("%s_%s", n.globalConfig.Mode, hostname)
+	}
+	if "" == options.Consumer {
+		options.Consumer = hostname
+	}
+	transport, err := eventemitter.NewTransport(options)
+	if nil != err {
+		log.Fatalf("err:%s", err.Error())
+	}
+	if err := eventemitter.InitializeBus(transport, options); nil != err {
+		log.Fatalf("err:%s", err.Error())
+	}
+}
+
 func (n *Node) registerAccessor() {
 	err := ethaccessor.Initialize(n.globalConfig.Accessor, n.globalConfig.Common, util.WethTokenAddress())
 	if nil != err {
@@ -218,7 +319,12 @@ func (n *Node) registerIPFSSubService() {
 }
 
 func (n *Node) registerOrderManager() {
-	n.orderManager = ordermanager.NewOrderManager(&n.globalConfig.OrderManager, n.rdsService, n.userManager, n.marketCapProvider)
+	options := n.globalConfig.OrderManager
+	//the in-memory order book is only updated by the gateway and extractor, which don't run in miner mode
+	if "miner" == n.globalConfig.Mode {
+		options.OrderBookFromDb = true
+	}
+	n.orderManager = ordermanager.NewOrderManager(&options, n.rdsService, n.userManager, n.marketCapProvider)
 }
 
 func (n *Node) registerTrendManager() {
@@ -240,18 +346,21 @@ func (n *Node) registerTickerCollector() {
 func (n *Node) registerWalletService() {
 	n.relayNode.walletService = *gateway.NewWalletService(n.relayNode.trendManager, n.orderManager,
 		n.accountManager, n.marketCapProvider, n.relayNode.tickerCollector, n.rdsService, n.globalConfig.Market.OldVersionWethAddress)
+	if nil != n.mineNode {
+		n.relayNode.walletService.SetRingSimulator(n.mineNode.miner)
+	}
 }
 
 func (n *Node) registerJsonRpcService() {
-	n.relayNode.jsonRpcService = *gateway.NewJsonrpcService(n.globalConfig.Jsonrpc.Port, &n.relayNode.walletService)
+	n.relayNode.jsonRpcService = *gateway.NewJsonrpcService(n.globalConfig.Jsonrpc, &n.relayNode.walletService)
 }
 
 func (n *Node) registerWebsocketService() {
-	n.relayNode.websocketService = *gateway.NewWebsocketService(n.globalConfig.Websocket.Port, n.relayNode.trendManager, n.accountManager, n.marketCapProvider)
+	n.relayNode.websocketService = *gateway.NewWebsocketService(n.globalConfig.Websocket, &n.relayNode.walletService)
 }
 
 func (n *Node) registerSocketIOService() {
-	n.relayNode.socketIOService = *gateway.NewSocketIOService(n.globalConfig.Websocket.Port, n.relayNode.walletService)
+	n.relayNode.socketIOService = *gateway.NewSocketIOService(n.globalConfig.Websocket, n.relayNode.walletService)
 }
 
 func (n *Node) registerMiner() {
@@ -261,7 +370,18 @@ func (n *Node) registerMiner() {
 		log.Fatalf("failed to init submitter, error:%s", err.Error())
 	}
 	evaluator := miner.NewEvaluator(n.marketCapProvider, n.globalConfig.Miner)
-	matcher := timing_matcher.NewTimingMatcher(n.globalConfig.Miner.TimingMatcher, submitter, evaluator, n.orderManager, &n.accountManager, n.rdsService)
+	matcherContext := &miner.MatcherContext{
+		Options:        n.globalConfig.Miner,
+		Submitter:      submitter,
+		Evaluator:      evaluator,
+		OrderManager:   n.orderManager,
+		AccountManager: &n.accountManager,
+		Rds:            n.rdsService,
+	}
+	matcher, err := miner.NewMatcher(n.globalConfig.Miner.Matcher, matcherContext)
+	if nil != err {
+		log.Fatalf("failed to init matcher, error:%s", err.Error())
+	}
 	evaluator.SetMatcher(matcher)
 	n.mineNode.miner = miner.NewMiner(submitter, matcher, evaluator, n.marketCapProvider)
 }
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/marketcap"
 	"github.com/Loopring/relay/types"
@@ -72,7 +73,9 @@ func newOrderEntity(state *types.OrderState, mc marketcap.MarketCapProvider, blo
 	if err != nil {
 		return nil, fmt.Errorf("order manager,newOrderEntity error:%s", err.Error())
 	}
-	model.ConvertDown(state)
+	if err := model.ConvertDown(state); err != nil {
+		return nil, fmt.Errorf("order manager,newOrderEntity error:%s", err.Error())
+	}
 
 	return model, nil
 }
@@ -183,3 +186,22 @@ func getCancelledAndDealtAmount(protocol common.Address, orderhash common.Hash,
 
 	return cancelled, dealt, nil
 }
+
+// emitOrdersUpdated emits OrderUpdated for each order and DepthUpdated once for each market of the orders,
+// after the orders have been saved and the book has been changed
+func emitOrdersUpdated(models ...dao.Order) {
+	depths := make(map[types.DepthUpdateEvent]bool)
+	for _, model := range models {
+		eventemitter.Emit(eventemitter.OrderUpdated, types.OrderUpdateEvent{
+			OrderHash:       model.OrderHash,
+			Owner:           model.Owner,
+			DelegateAddress: model.DelegateAddress,
+			Market:          model.Market,
+		})
+		depth := types.DepthUpdateEvent{DelegateAddress: model.DelegateAddress, Market: model.Market}
+		if !depths[depth] {
+			depths[depth] = true
+			eventemitter.Emit(eventemitter.DepthUpdated, depth)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"time"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const defaultExpireSweepBatchSize = 500
+
+//the orders with these status are moved to ORDER_EXPIRE after ValidUntil
+var expirableStatus = []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL, types.ORDER_PENDING, types.ORDER_PENDING_FOR_P2P}
+
+// ExpireSweeper marks the orders past ValidUntil as ORDER_EXPIRE in batches.
+// Each order is updated only if its status hasn't been changed, so only one of the relays sharing the db emits OrderExpired for it.
+type ExpireSweeper struct {
+	rds       dao.RdsService
+	book      *OrderBook
+	interval  int64
+	batchSize int
+	stopChan  chan bool
+}
+
+func NewExpireSweeper(rds dao.RdsService, book *OrderBook, interval int64, batchSize int) *ExpireSweeper {
+	sweeper := &ExpireSweeper{}
+	sweeper.rds = rds
+	sweeper.book = book
+	sweeper.interval = interval
+	sweeper.batchSize = batchSize
+	if sweeper.batchSize <= 0 {
+		sweeper.batchSize = defaultExpireSweepBatchSize
+	}
+	return sweeper
+}
+
+func (sweeper *ExpireSweeper) Start() {
+	if sweeper.interval <= 0 {
+		return
+	}
+
+	sweeper.stopChan = make(chan bool)
+	go func(stopChan chan bool) {
+		for {
+			select {
+			case <-time.After(time.Duration(sweeper.interval) * time.Second):
+				sweeper.Sweep()
+			case <-stopChan:
+				return
+			}
+		}
+	}(sweeper.stopChan)
+}
+
+func (sweeper *ExpireSweeper) Stop() {
+	if nil != sweeper.stopChan {
+		close(sweeper.stopChan)
+		sweeper.stopChan = nil
+	}
+}
+
+// Sweep expires the orders batch by batch until there isn't any expired one
+func (sweeper *ExpireSweeper) Sweep() {
+	expireTime := time.Now().Unix()
+	total := 0
+	for {
+		models, err := sweeper.rds.GetExpiredOrders(expireTime, expirableStatus, sweeper.batchSize)
+		if nil != err {
+			log.Errorf("order manager,sweep expired orders error:%s", err.Error())
+			break
+		}
+
+		expiredHashes := []common.Hash{}
+		for _, model := range models {
+			orderHash := common.HexToHash(model.OrderHash)
+			if expired, err := sweeper.rds.ExpireOrder(orderHash, expirableStatus, expireTime); nil != err {
+				log.Errorf("order manager,expire order:%s error:%s", model.OrderHash, err.Error())
+				continue
+			} else if !expired {
+				continue
+			}
+			expiredHashes = append(expiredHashes, orderHash)
+			eventemitter.Emit(eventemitter.OrderExpired, &types.OrderExpiredEvent{
+				OrderHash:       orderHash,
+				Owner:           common.HexToAddress(model.Owner),
+				DelegateAddress: common.HexToAddress(model.DelegateAddress),
+				Market:          model.Market,
+			})
+		}
+		sweeper.book.Remove(expiredHashes...)
+		total += len(expiredHashes)
+
+		//the others have been updated by other relays or failed, they will be swept next time
+		if len(models) < sweeper.batchSize || len(expiredHashes) == 0 {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Debugf("order manager,%d orders have been expired", total)
+	}
+}
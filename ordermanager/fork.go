@@ -50,9 +50,9 @@ func NewForkProcess(rds dao.RdsService, mc marketcap.MarketCapProvider) *ForkPro
 //   c.处理cutoff,合约里cutoff可以重复提交,而在ordermanager中,所有cutoff事件都会被存储,但是更新订单时,同一个订单不会被多次cutoff
 //     那么,在回滚时,我们需要知道某一个订单以前是否也cutoff过,在dao/cutoff中我们存储了orderhashList,可以将这些订单取出并按照订单量重置状态
 //   d.处理cutoffPair,同cutoff
-func (p *ForkProcessor) Fork(event *types.ForkedEvent) error {
-	from := event.ForkBlock.Int64()
-	to := event.DetectedBlock.Int64()
+func (p *ForkProcessor) Fork(event *types.ChainReorgEvent) error {
+	from := event.CommonAncestor.Int64()
+	to := event.OrphanedTo.Int64()
 
 	list, _ := p.GetForkEvents(from, to)
 	if list.Len() == 0 {
@@ -101,8 +101,12 @@ func (p *ForkProcessor) RollBackSingleFill(evt *types.OrderFilledEvent) error {
 
 	log.Debugf("fork fill event, orderhash:%s,dealAmountS:%s,dealtAmountB:%s", state.RawOrder.Hash.Hex(), state.DealtAmountS.String(), state.DealtAmountB.String())
 
-	// update order status
+	// update order status, the soft cancelled order isn't opened again by the rollback
+	softCancelled := state.Status == types.ORDER_SOFT_CANCEL
 	settleOrderStatus(state, p.mc, ORDER_FROM_FILL)
+	if softCancelled {
+		state.Status = types.ORDER_SOFT_CANCEL
+	}
 
 	// update rds.Order
 	model.ConvertDown(state)
@@ -132,8 +136,12 @@ func (p *ForkProcessor) RollBackSingleCancel(evt *types.OrderCancelledEvent) err
 		log.Debugf("fork order cancelled event,order:%s cancelled amounts:%s", state.RawOrder.Hash.Hex(), state.CancelledAmountS.String())
 	}
 
-	// update order status
+	// update order status, the soft cancelled order isn't opened again by the rollback
+	softCancelled := state.Status == types.ORDER_SOFT_CANCEL
 	settleOrderStatus(state, p.mc, ORDER_FROM_FILL)
+	if softCancelled {
+		state.Status = types.ORDER_SOFT_CANCEL
+	}
 	state.UpdatedBlock = evt.BlockNumber
 
 	// update rds.Order
@@ -46,8 +46,8 @@ func TestForkProcessor_RollBack(t *testing.T) {
 	p := ordermanager.NewForkProcess(db, mc)
 
 	forkBlock := big.NewInt(8787)
-	detectBlock := big.NewInt(8801)
-	event := &types.ForkedEvent{ForkBlock: forkBlock, DetectedBlock: detectBlock}
+	orphanedTo := big.NewInt(8800)
+	event := &types.ChainReorgEvent{CommonAncestor: forkBlock, OrphanedFrom: new(big.Int).Add(forkBlock, big.NewInt(1)), OrphanedTo: orphanedTo}
 	if err := p.Fork(event); err != nil {
 		t.Fatalf(err.Error())
 	}
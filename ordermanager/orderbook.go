@@ -0,0 +1,385 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//the orders with these status will never be matched again
+var bookFilterStatus = []types.OrderStatus{types.ORDER_FINISHED, types.ORDER_CUTOFF, types.ORDER_CANCEL, types.ORDER_EXPIRE, types.ORDER_SOFT_CANCEL}
+
+type bookKey struct {
+	delegate common.Address
+	tokenS   common.Address
+	tokenB   common.Address
+}
+
+// OrderBook keeps the orders that can be matched in memory, the orders of each (delegate, tokenS, tokenB)
+// are sorted by price desc just as the db queries. The orders are kept as dao.Order, so each read returns a new OrderState.
+// It's only updated by the gateway and the extractor of this process, the process without them reads db if fromDb is set.
+type OrderBook struct {
+	mtx    sync.RWMutex
+	rds    dao.RdsService
+	fromDb bool
+	books  map[bookKey][]*dao.Order
+	orders map[common.Hash]*dao.Order
+
+	//the orders changed after the reconciliation began are kept
+	version uint64
+	touched map[common.Hash]uint64
+
+	checkInterval int64
+	stopChan      chan bool
+}
+
+func NewOrderBook(rds dao.RdsService, checkInterval int64, fromDb bool) *OrderBook {
+	book := &OrderBook{}
+	book.mtx = sync.RWMutex{}
+	book.rds = rds
+	book.fromDb = fromDb
+	book.books = make(map[bookKey][]*dao.Order)
+	book.orders = make(map[common.Hash]*dao.Order)
+	book.touched = make(map[common.Hash]uint64)
+	book.checkInterval = checkInterval
+	return book
+}
+
+// Start loads all the orders from db and reconciles them periodically
+func (book *OrderBook) Start() {
+	if book.fromDb {
+		return
+	}
+	if err := book.Load(); nil != err {
+		log.Errorf("orderBook, load orders err:%s", err.Error())
+	}
+	if book.checkInterval <= 0 {
+		return
+	}
+
+	book.stopChan = make(chan bool)
+	go func(stopChan chan bool) {
+		for {
+			select {
+			case <-time.After(time.Duration(book.checkInterval) * time.Second):
+				if err := book.Reconcile(); nil != err {
+					log.Errorf("orderBook, reconcile err:%s", err.Error())
+				}
+			case <-stopChan:
+				return
+			}
+		}
+	}(book.stopChan)
+}
+
+func (book *OrderBook) Stop() {
+	if nil != book.stopChan {
+		close(book.stopChan)
+		book.stopChan = nil
+	}
+}
+
+// Load replaces all the orders with the ones in db
+func (book *OrderBook) Load() error {
+	models, err := book.rds.GetOrdersForBook(bookFilterStatus)
+	if nil != err {
+		return err
+	}
+
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	book.books = make(map[bookKey][]*dao.Order)
+	book.orders = make(map[common.Hash]*dao.Order)
+	book.touched = make(map[common.Hash]uint64)
+	for idx := range models {
+		book.put(&models[idx])
+	}
+	log.Infof("orderBook, %d orders have been loaded", len(book.orders))
+	return nil
+}
+
+// Reconcile compares the orders with db and corrects the differences,
+// the orders updated during reconciliation are regarded as newer than db.
+func (book *OrderBook) Reconcile() error {
+	book.mtx.RLock()
+	snapshot := book.version
+	book.mtx.RUnlock()
+
+	models, err := book.rds.GetOrdersForBook(bookFilterStatus)
+	if nil != err {
+		return err
+	}
+
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	diffCount := 0
+	inDb := make(map[common.Hash]bool)
+	for idx := range models {
+		model := &models[idx]
+		orderHash := common.HexToHash(model.OrderHash)
+		inDb[orderHash] = true
+		if version, exists := book.touched[orderHash]; exists && version > snapshot {
+			continue
+		}
+		if current, exists := book.orders[orderHash]; !exists || !sameBookOrder(current, model) {
+			diffCount++
+			book.put(model)
+		}
+	}
+	for orderHash := range book.orders {
+		if version, exists := book.touched[orderHash]; exists && version > snapshot {
+			continue
+		}
+		if !inDb[orderHash] {
+			diffCount++
+			book.remove(orderHash)
+		}
+	}
+	for orderHash, version := range book.touched {
+		if version <= snapshot {
+			delete(book.touched, orderHash)
+		}
+	}
+
+	if diffCount > 0 {
+		log.Infof("orderBook, %d orders are different from db and have been corrected", diffCount)
+	}
+	return nil
+}
+
+// Put adds or updates the order saved in db, the order will be removed if it can't be matched any more
+func (book *OrderBook) Put(model *dao.Order) {
+	if book.fromDb {
+		return
+	}
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	book.touch(common.HexToHash(model.OrderHash))
+	book.put(model)
+}
+
+func (book *OrderBook) Remove(orderHashes ...common.Hash) {
+	if book.fromDb {
+		return
+	}
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	for _, orderHash := range orderHashes {
+		book.touch(orderHash)
+		book.remove(orderHash)
+	}
+}
+
+func (book *OrderBook) MarkMinerOrders(orderHashes []common.Hash, blockNumber int64) {
+	if book.fromDb {
+		return
+	}
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	for _, orderHash := range orderHashes {
+		if model, exists := book.orders[orderHash]; exists {
+			book.touch(orderHash)
+			model.MinerBlockMark = blockNumber
+		}
+	}
+}
+
+// MinerOrders is the same as dao.GetOrdersForMiner
+func (book *OrderBook) MinerOrders(delegate, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64) []*types.OrderState {
+	if book.fromDb {
+		return book.minerOrdersFromDb(delegate, tokenS, tokenB, length, reservedTime, startBlockNumber, endBlockNumber)
+	}
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	list := []*types.OrderState{}
+	nowtime := time.Now().Unix()
+	for _, model := range book.books[bookKey{delegate: delegate, tokenS: tokenS, tokenB: tokenB}] {
+		if len(list) >= length {
+			break
+		}
+		if model.ValidSince >= nowtime || model.ValidUntil < nowtime+reservedTime {
+			continue
+		}
+		if model.MinerBlockMark < startBlockNumber || model.MinerBlockMark > endBlockNumber {
+			continue
+		}
+		state := &types.OrderState{}
+		if err := model.ConvertUp(state); nil != err {
+			log.Errorf("orderBook, convert order:%s err:%s", model.OrderHash, err.Error())
+			continue
+		}
+		list = append(list, state)
+	}
+	return list
+}
+
+// GetOrderBook is the same as dao.GetOrderBook
+func (book *OrderBook) GetOrderBook(delegate, tokenS, tokenB common.Address, length int) []types.OrderState {
+	if book.fromDb {
+		return book.orderBookFromDb(delegate, tokenS, tokenB, length)
+	}
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	list := []types.OrderState{}
+	nowtime := time.Now().Unix()
+	for _, model := range book.books[bookKey{delegate: delegate, tokenS: tokenS, tokenB: tokenB}] {
+		if len(list) >= length {
+			break
+		}
+		if model.Status != uint8(types.ORDER_NEW) && model.Status != uint8(types.ORDER_PARTIAL) {
+			continue
+		}
+		if model.ValidSince >= nowtime || model.ValidUntil < nowtime {
+			continue
+		}
+		var state types.OrderState
+		if err := model.ConvertUp(&state); nil != err {
+			continue
+		}
+		list = append(list, state)
+	}
+	return list
+}
+
+func (book *OrderBook) minerOrdersFromDb(delegate, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64) []*types.OrderState {
+	list := []*types.OrderState{}
+	models, err := book.rds.GetOrdersForMiner(delegate.Hex(), tokenS.Hex(), tokenB.Hex(), length, bookFilterStatus, reservedTime, startBlockNumber, endBlockNumber)
+	if nil != err {
+		log.Errorf("orderBook, get orders for miner err:%s", err.Error())
+		return list
+	}
+	for _, model := range models {
+		state := &types.OrderState{}
+		if err := model.ConvertUp(state); nil != err {
+			log.Errorf("orderBook, convert order:%s err:%s", model.OrderHash, err.Error())
+			continue
+		}
+		list = append(list, state)
+	}
+	return list
+}
+
+func (book *OrderBook) orderBookFromDb(delegate, tokenS, tokenB common.Address, length int) []types.OrderState {
+	list := []types.OrderState{}
+	models, err := book.rds.GetOrderBook(delegate, tokenS, tokenB, length)
+	if nil != err {
+		log.Errorf("orderBook, get order book err:%s", err.Error())
+		return list
+	}
+	for _, model := range models {
+		var state types.OrderState
+		if err := model.ConvertUp(&state); nil != err {
+			continue
+		}
+		list = append(list, state)
+	}
+	return list
+}
+
+func (book *OrderBook) touch(orderHash common.Hash) {
+	book.version++
+	book.touched[orderHash] = book.version
+}
+
+func (book *OrderBook) put(model *dao.Order) {
+	orderHash := common.HexToHash(model.OrderHash)
+	book.remove(orderHash)
+	if !canBeMatched(model) {
+		return
+	}
+
+	order := &dao.Order{}
+	*order = *model
+	key := keyOfBookOrder(order)
+	orders := book.books[key]
+	idx := sort.Search(len(orders), func(i int) bool {
+		return orders[i].Price < order.Price || (orders[i].Price == order.Price && orders[i].ID > order.ID)
+	})
+	orders = append(orders, nil)
+	copy(orders[idx+1:], orders[idx:])
+	orders[idx] = order
+	book.books[key] = orders
+	book.orders[orderHash] = order
+}
+
+func (book *OrderBook) remove(orderHash common.Hash) {
+	order, exists := book.orders[orderHash]
+	if !exists {
+		return
+	}
+	delete(book.orders, orderHash)
+	key := keyOfBookOrder(order)
+	orders := book.books[key]
+	for idx, o := range orders {
+		if o == order {
+			orders = append(orders[:idx], orders[idx+1:]...)
+			break
+		}
+	}
+	if len(orders) > 0 {
+		book.books[key] = orders
+	} else {
+		delete(book.books, key)
+	}
+}
+
+func keyOfBookOrder(model *dao.Order) bookKey {
+	return bookKey{
+		delegate: common.HexToAddress(model.DelegateAddress),
+		tokenS:   common.HexToAddress(model.TokenS),
+		tokenB:   common.HexToAddress(model.TokenB),
+	}
+}
+
+func canBeMatched(model *dao.Order) bool {
+	if model.OrderType != types.ORDER_TYPE_MARKET {
+		return false
+	}
+	for _, status := range bookFilterStatus {
+		if model.Status == uint8(status) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameBookOrder(o1, o2 *dao.Order) bool {
+	return o1.Status == o2.Status &&
+		o1.DealtAmountS == o2.DealtAmountS &&
+		o1.DealtAmountB == o2.DealtAmountB &&
+		o1.CancelledAmountS == o2.CancelledAmountS &&
+		o1.CancelledAmountB == o2.CancelledAmountB &&
+		o1.SplitAmountS == o2.SplitAmountS &&
+		o1.SplitAmountB == o2.SplitAmountB &&
+		o1.MinerBlockMark == o2.MinerBlockMark
+}
@@ -0,0 +1,308 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/ordermanager"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+}
+
+var (
+	bookDelegate      = common.HexToAddress("0x17233e07c67d086464fD408148c3ABB56245FA64")
+	bookOtherDelegate = common.HexToAddress("0x5567ee920f7E62274284985D793344351A00142B")
+	bookLrc           = common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")
+	bookWeth          = common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+)
+
+func newBookRds(t *testing.T) (dao.RdsService, func()) {
+	dir, err := ioutil.TempDir("", "relay_orderbook")
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	options := config.MysqlOptions{Driver: dao.DRIVER_SQLITE, DbName: filepath.Join(dir, "relay.db"), TablePrefix: "lpr_", AutoMigrate: true}
+	rds := dao.NewRdsService(options)
+	rds.Prepare()
+	return rds, func() { os.RemoveAll(dir) }
+}
+
+// newBookOrder returns an order of LRC-WETH whose hash can be verified by ConvertUp, seq makes the hash unique
+func newBookOrder(seq int64, delegate, tokenS, tokenB common.Address, status types.OrderStatus, validSince, validUntil int64, price float64) *dao.Order {
+	raw := &types.Order{}
+	raw.DelegateAddress = delegate
+	raw.Owner = common.BigToAddress(big.NewInt(seq))
+	raw.TokenS = tokenS
+	raw.TokenB = tokenB
+	raw.AmountS = big.NewInt(1000000 + seq)
+	raw.AmountB = big.NewInt(10000)
+	raw.ValidSince = big.NewInt(validSince)
+	raw.ValidUntil = big.NewInt(validUntil)
+	raw.LrcFee = big.NewInt(10)
+	raw.Hash = raw.GenerateHash()
+
+	order := &dao.Order{}
+	order.Protocol = delegate.Hex()
+	order.DelegateAddress = delegate.Hex()
+	order.Owner = raw.Owner.Hex()
+	order.OrderHash = raw.Hash.Hex()
+	order.TokenS = tokenS.Hex()
+	order.TokenB = tokenB.Hex()
+	order.AmountS = raw.AmountS.String()
+	order.AmountB = raw.AmountB.String()
+	order.LrcFee = raw.LrcFee.String()
+	order.DealtAmountS = "0"
+	order.DealtAmountB = "0"
+	order.CancelledAmountS = "0"
+	order.CancelledAmountB = "0"
+	order.SplitAmountS = "0"
+	order.SplitAmountB = "0"
+	order.CreateTime = validSince
+	order.ValidSince = validSince
+	order.ValidUntil = validUntil
+	order.Price = price
+	order.Status = uint8(status)
+	order.Market = "LRC-WETH"
+	order.Side = "sell"
+	order.OrderType = types.ORDER_TYPE_MARKET
+	return order
+}
+
+// addBookOrders saves the orders covering all the conditions of the queries, the orders are returned by seq
+func addBookOrders(t *testing.T, rds dao.RdsService) map[int64]*dao.Order {
+	now := time.Now().Unix()
+	orders := map[int64]*dao.Order{
+		1:  newBookOrder(1, bookDelegate, bookLrc, bookWeth, types.ORDER_NEW, now-3600, now+3600, 0.010),
+		2:  newBookOrder(2, bookDelegate, bookLrc, bookWeth, types.ORDER_PARTIAL, now-3600, now+3600, 0.009),
+		3:  newBookOrder(3, bookDelegate, bookLrc, bookWeth, types.ORDER_NEW, now-3600, now+3600, 0.008),
+		4:  newBookOrder(4, bookDelegate, bookLrc, bookWeth, types.ORDER_PENDING, now-3600, now+3600, 0.007),
+		5:  newBookOrder(5, bookDelegate, bookLrc, bookWeth, types.ORDER_FINISHED, now-3600, now+3600, 0.0095),
+		6:  newBookOrder(6, bookDelegate, bookLrc, bookWeth, types.ORDER_SOFT_CANCEL, now-3600, now+3600, 0.0085),
+		7:  newBookOrder(7, bookDelegate, bookLrc, bookWeth, types.ORDER_NEW, now-3600, now+300, 0.012),
+		8:  newBookOrder(8, bookDelegate, bookLrc, bookWeth, types.ORDER_NEW, now+3600, now+7200, 0.011),
+		9:  newBookOrder(9, bookDelegate, bookLrc, bookWeth, types.ORDER_NEW, now-3600, now+3600, 0.0125),
+		10: newBookOrder(10, bookDelegate, bookWeth, bookLrc, types.ORDER_NEW, now-3600, now+3600, 0.006),
+		11: newBookOrder(11, bookOtherDelegate, bookLrc, bookWeth, types.ORDER_NEW, now-3600, now+3600, 0.0055),
+		12: newBookOrder(12, bookDelegate, bookLrc, bookWeth, types.ORDER_NEW, now-3600, now-10, 0.005),
+	}
+	orders[3].MinerBlockMark = 100
+	orders[9].OrderType = types.ORDER_TYPE_P2P
+	for seq := int64(1); seq <= int64(len(orders)); seq++ {
+		if err := rds.Add(orders[seq]); nil != err {
+			t.Fatal(err.Error())
+		}
+	}
+	return orders
+}
+
+func describeOrder(state *types.OrderState) string {
+	return fmt.Sprintf("%s:%d:%s:%s:%s", state.RawOrder.Hash.Hex()[:10], state.Status, state.DealtAmountS.String(), state.CancelledAmountS.String(), state.SplitAmountS.String())
+}
+
+func describeMinerOrders(list []*types.OrderState) string {
+	descs := []string{}
+	for _, state := range list {
+		descs = append(descs, describeOrder(state))
+	}
+	return strings.Join(descs, ",")
+}
+
+func describeOrderBook(list []types.OrderState) string {
+	descs := []string{}
+	for idx := range list {
+		descs = append(descs, describeOrder(&list[idx]))
+	}
+	return strings.Join(descs, ",")
+}
+
+// compareBooks compares the orders read from book with the ones queried from db by dbBook
+func compareBooks(t *testing.T, name string, book, dbBook *ordermanager.OrderBook) {
+	keys := [][3]common.Address{
+		{bookDelegate, bookLrc, bookWeth},
+		{bookDelegate, bookWeth, bookLrc},
+		{bookOtherDelegate, bookLrc, bookWeth},
+		{bookOtherDelegate, bookWeth, bookLrc},
+	}
+	blockRanges := [][2]int64{{0, 0}, {0, 100}, {100, 100}, {1, 1000}, {0, 1000}}
+	for _, key := range keys {
+		for _, length := range []int{1, 3, 100} {
+			for _, reservedTime := range []int64{0, 600} {
+				for _, blockRange := range blockRanges {
+					expected := describeMinerOrders(dbBook.MinerOrders(key[0], key[1], key[2], length, reservedTime, blockRange[0], blockRange[1]))
+					got := describeMinerOrders(book.MinerOrders(key[0], key[1], key[2], length, reservedTime, blockRange[0], blockRange[1]))
+					if expected != got {
+						t.Errorf("%s: miner orders of %s-%s length:%d reserved:%d blocks:%v should be [%s], got:[%s]", name, key[1].Hex()[:6], key[2].Hex()[:6], length, reservedTime, blockRange, expected, got)
+					}
+				}
+			}
+
+			expected := describeOrderBook(dbBook.GetOrderBook(key[0], key[1], key[2], length))
+			got := describeOrderBook(book.GetOrderBook(key[0], key[1], key[2], length))
+			if expected != got {
+				t.Errorf("%s: order book of %s-%s length:%d should be [%s], got:[%s]", name, key[1].Hex()[:6], key[2].Hex()[:6], length, expected, got)
+			}
+		}
+	}
+}
+
+func bookHashes(list []types.OrderState) string {
+	hashes := []string{}
+	for _, state := range list {
+		hashes = append(hashes, state.RawOrder.Hash.Hex())
+	}
+	return strings.Join(hashes, ",")
+}
+
+func seqHashes(orders map[int64]*dao.Order, seqs ...int64) string {
+	hashes := []string{}
+	for _, seq := range seqs {
+		hashes = append(hashes, orders[seq].OrderHash)
+	}
+	return strings.Join(hashes, ",")
+}
+
+func TestOrderBook_Load(t *testing.T) {
+	rds, clean := newBookRds(t)
+	defer clean()
+	orders := addBookOrders(t, rds)
+
+	book := ordermanager.NewOrderBook(rds, 0, false)
+	if err := book.Load(); nil != err {
+		t.Fatal(err.Error())
+	}
+	dbBook := ordermanager.NewOrderBook(rds, 0, true)
+	compareBooks(t, "loaded", book, dbBook)
+
+	//the comparison is meaningless if both are empty
+	if hashes := bookHashes(book.GetOrderBook(bookDelegate, bookLrc, bookWeth, 100)); hashes != seqHashes(orders, 7, 1, 2, 3) {
+		t.Fatalf("the order book should be sorted by price desc, got:%s", hashes)
+	}
+	if 5 != len(book.MinerOrders(bookDelegate, bookLrc, bookWeth, 100, 0, 0, 1000)) {
+		t.Fatalf("the pending order should be returned to miner")
+	}
+}
+
+func TestOrderBook_Reconcile(t *testing.T) {
+	rds, clean := newBookRds(t)
+	defer clean()
+	orders := addBookOrders(t, rds)
+
+	book := ordermanager.NewOrderBook(rds, 0, false)
+	if err := book.Load(); nil != err {
+		t.Fatal(err.Error())
+	}
+	dbBook := ordermanager.NewOrderBook(rds, 0, true)
+
+	//db is updated by another relay
+	blockNumber := big.NewInt(10)
+	rds.UpdateOrderWhileFill(common.HexToHash(orders[1].OrderHash), types.ORDER_PARTIAL, big.NewInt(100), big.NewInt(1), big.NewInt(2), big.NewInt(0), blockNumber)
+	rds.UpdateOrderWhileFill(common.HexToHash(orders[2].OrderHash), types.ORDER_FINISHED, big.NewInt(1000002), big.NewInt(10000), big.NewInt(0), big.NewInt(0), blockNumber)
+	rds.UpdateOrderWhileCancel(common.HexToHash(orders[7].OrderHash), types.ORDER_CANCEL, big.NewInt(1000007), big.NewInt(10000), blockNumber)
+	rds.UpdateOrderWhileCancel(common.HexToHash(orders[5].OrderHash), types.ORDER_PARTIAL, big.NewInt(0), big.NewInt(0), blockNumber)
+	rds.MarkMinerOrders([]string{orders[3].OrderHash, orders[4].OrderHash}, 200)
+	rds.Del(orders[11])
+	now := time.Now().Unix()
+	added := newBookOrder(13, bookDelegate, bookLrc, bookWeth, types.ORDER_NEW, now-3600, now+3600, 0.0105)
+	if err := rds.Add(added); nil != err {
+		t.Fatal(err.Error())
+	}
+
+	if hashes := bookHashes(book.GetOrderBook(bookDelegate, bookLrc, bookWeth, 100)); hashes != seqHashes(orders, 7, 1, 2, 3) {
+		t.Fatalf("the book shouldn't be changed before reconciled, got:%s", hashes)
+	}
+	if err := book.Reconcile(); nil != err {
+		t.Fatal(err.Error())
+	}
+	compareBooks(t, "reconciled", book, dbBook)
+	orders[13] = added
+	if hashes := bookHashes(book.GetOrderBook(bookDelegate, bookLrc, bookWeth, 100)); hashes != seqHashes(orders, 13, 1, 5, 3) {
+		t.Fatalf("the book should be reconciled with db, got:%s", hashes)
+	}
+}
+
+func TestOrderBook_PutAndRemove(t *testing.T) {
+	rds, clean := newBookRds(t)
+	defer clean()
+	orders := addBookOrders(t, rds)
+
+	book := ordermanager.NewOrderBook(rds, 0, false)
+	if err := book.Load(); nil != err {
+		t.Fatal(err.Error())
+	}
+	dbBook := ordermanager.NewOrderBook(rds, 0, true)
+
+	//the order manager updates db, then the book
+	blockNumber := big.NewInt(10)
+	cancelled := common.HexToHash(orders[1].OrderHash)
+	rds.UpdateOrderWhileCancel(cancelled, types.ORDER_CANCEL, big.NewInt(1000001), big.NewInt(10000), blockNumber)
+	book.Remove(cancelled)
+
+	filled := common.HexToHash(orders[2].OrderHash)
+	rds.UpdateOrderWhileFill(filled, types.ORDER_PARTIAL, big.NewInt(500), big.NewInt(5), big.NewInt(1), big.NewInt(0), blockNumber)
+	model, err := rds.GetOrderByHash(filled)
+	if nil != err {
+		t.Fatal(err.Error())
+	}
+	book.Put(model)
+
+	finished := common.HexToHash(orders[3].OrderHash)
+	rds.UpdateOrderWhileFill(finished, types.ORDER_FINISHED, big.NewInt(1000003), big.NewInt(10000), big.NewInt(0), big.NewInt(0), blockNumber)
+	if model, err = rds.GetOrderByHash(finished); nil != err {
+		t.Fatal(err.Error())
+	}
+	book.Put(model)
+
+	now := time.Now().Unix()
+	added := newBookOrder(13, bookDelegate, bookLrc, bookWeth, types.ORDER_NEW, now-3600, now+3600, 0.0105)
+	if err := rds.Add(added); nil != err {
+		t.Fatal(err.Error())
+	}
+	book.Put(added)
+
+	marked := []common.Hash{common.HexToHash(orders[4].OrderHash), common.HexToHash(orders[7].OrderHash)}
+	rds.MarkMinerOrders([]string{orders[4].OrderHash, orders[7].OrderHash}, 300)
+	book.MarkMinerOrders(marked, 300)
+	compareBooks(t, "updated", book, dbBook)
+
+	//nothing is changed by reconciliation after the book has been updated with db
+	if err := book.Reconcile(); nil != err {
+		t.Fatal(err.Error())
+	}
+	compareBooks(t, "reconciled", book, dbBook)
+	orders[13] = added
+	if hashes := bookHashes(book.GetOrderBook(bookDelegate, bookLrc, bookWeth, 100)); hashes != seqHashes(orders, 7, 13, 2) {
+		t.Fatalf("the book should be updated, got:%s", hashes)
+	}
+}
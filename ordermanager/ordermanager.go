@@ -29,6 +29,7 @@ import (
 	"github.com/Loopring/relay/types"
 	"github.com/Loopring/relay/usermanager"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
 	"math/big"
 )
 
@@ -49,6 +50,8 @@ type OrderManager interface {
 	IsValueDusted(tokenAddress common.Address, value *big.Rat) bool
 	GetFrozenAmount(owner common.Address, token common.Address, statusSet []types.OrderStatus, delegateAddress common.Address) (*big.Int, error)
 	GetFrozenLRCFee(owner common.Address, statusSet []types.OrderStatus) (*big.Int, error)
+	GetOpenOrderCount(owner common.Address) (int, error)
+	SoftCancelOrders(owner common.Address, orderHashes []common.Hash) ([]common.Hash, error)
 }
 
 type OrderManagerImpl struct {
@@ -58,6 +61,8 @@ type OrderManagerImpl struct {
 	um                 usermanager.UserManager
 	mc                 marketcap.MarketCapProvider
 	cutoffCache        *CutoffCache
+	book               *OrderBook
+	sweeper            *ExpireSweeper
 	newOrderWatcher    *eventemitter.Watcher
 	ringMinedWatcher   *eventemitter.Watcher
 	fillOrderWatcher   *eventemitter.Watcher
@@ -68,6 +73,7 @@ type OrderManagerImpl struct {
 	//syncWatcher             *eventemitter.Watcher
 	warningWatcher          *eventemitter.Watcher
 	submitRingMethodWatcher *eventemitter.Watcher
+	softCancelWatcher       *eventemitter.Watcher
 	//ordersValidForMiner     bool
 }
 
@@ -84,6 +90,8 @@ func NewOrderManager(
 	om.um = userManager
 	om.mc = market
 	om.cutoffCache = NewCutoffCache(options.CutoffCacheCleanTime)
+	om.book = NewOrderBook(rds, options.OrderBookCheckInterval, options.OrderBookFromDb)
+	om.sweeper = NewExpireSweeper(rds, om.book, options.ExpireSweepInterval, options.ExpireSweepBatchSize)
 	//om.ordersValidForMiner = false
 
 	dustOrderValue = om.options.DustOrderValue
@@ -103,6 +111,11 @@ func (om *OrderManagerImpl) Start() {
 	om.forkWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleFork}
 	om.warningWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleWarning}
 	om.submitRingMethodWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleSubmitRingMethod}
+	om.softCancelWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleOrderSoftCancelled}
+
+	//the orders are reloaded after fork
+	om.book.Start()
+	om.sweeper.Start()
 
 	eventemitter.On(eventemitter.NewOrder, om.newOrderWatcher)
 	eventemitter.On(eventemitter.RingMined, om.ringMinedWatcher)
@@ -111,9 +124,10 @@ func (om *OrderManagerImpl) Start() {
 	eventemitter.On(eventemitter.CutoffAll, om.cutoffOrderWatcher)
 	eventemitter.On(eventemitter.CutoffPair, om.cutoffPairWatcher)
 	//eventemitter.On(eventemitter.SyncChainComplete, om.syncWatcher)
-	eventemitter.On(eventemitter.ChainForkDetected, om.forkWatcher)
+	eventemitter.On(eventemitter.ChainReorg, om.forkWatcher)
 	eventemitter.On(eventemitter.ExtractorWarning, om.warningWatcher)
 	eventemitter.On(eventemitter.Miner_SubmitRing_Method, om.submitRingMethodWatcher)
+	eventemitter.On(eventemitter.OrderSoftCancelled, om.softCancelWatcher)
 }
 
 func (om *OrderManagerImpl) Stop() {
@@ -123,9 +137,12 @@ func (om *OrderManagerImpl) Stop() {
 	eventemitter.Un(eventemitter.CancelOrder, om.cancelOrderWatcher)
 	eventemitter.Un(eventemitter.CutoffAll, om.cutoffOrderWatcher)
 	//eventemitter.Un(eventemitter.SyncChainComplete, om.syncWatcher)
-	eventemitter.Un(eventemitter.ChainForkDetected, om.forkWatcher)
+	eventemitter.Un(eventemitter.ChainReorg, om.forkWatcher)
 	eventemitter.Un(eventemitter.ExtractorWarning, om.warningWatcher)
 	eventemitter.Un(eventemitter.Miner_SubmitRing_Method, om.submitRingMethodWatcher)
+	eventemitter.Un(eventemitter.OrderSoftCancelled, om.softCancelWatcher)
+	om.book.Stop()
+	om.sweeper.Stop()
 
 	//om.ordersValidForMiner = false
 }
@@ -143,7 +160,7 @@ func (om *OrderManagerImpl) handleFork(input eventemitter.EventData) error {
 	log.Debugf("order manager processing chain fork......")
 
 	om.Stop()
-	if err := om.processor.Fork(input.(*types.ForkedEvent)); err != nil {
+	if err := om.processor.Fork(input.(*types.ChainReorgEvent)); err != nil {
 		log.Fatalf("order manager,handle fork error:%s", err.Error())
 	}
 	om.Start()
@@ -192,8 +209,13 @@ func (om *OrderManagerImpl) handleGatewayOrder(input eventemitter.EventData) err
 		return err
 	}
 
-	eventemitter.Emit(eventemitter.DepthUpdated, types.DepthUpdateEvent{DelegateAddress: model.DelegateAddress, Market: model.Market})
-	return om.rds.Add(model)
+	if err := om.rds.Add(model); nil != err {
+		return err
+	}
+	om.book.Put(model)
+	emitOrdersUpdated(*model)
+	eventemitter.Emit(eventemitter.Miner_NewOrderState, state)
+	return nil
 }
 
 func (om *OrderManagerImpl) handleRingMined(input eventemitter.EventData) error {
@@ -237,6 +259,10 @@ func (om *OrderManagerImpl) handleOrderFilled(input eventemitter.EventData) erro
 	// get rds.Order and types.OrderState
 	state := &types.OrderState{UpdatedBlock: event.BlockNumber}
 	model, err := om.rds.GetOrderByHash(event.OrderHash)
+	if gorm.ErrRecordNotFound == err {
+		log.Debugf("order manager,handle order filled event,order %s isn't submitted to this relay", event.OrderHash.Hex())
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -269,8 +295,12 @@ func (om *OrderManagerImpl) handleOrderFilled(input eventemitter.EventData) erro
 
 	log.Debugf("order manager,handle order filled event orderhash:%s,dealAmountS:%s,dealtAmountB:%s", state.RawOrder.Hash.Hex(), state.DealtAmountS.String(), state.DealtAmountB.String())
 
-	// update order status
+	// update order status, the expired or soft cancelled order keeps its status unless it's finished
+	keptStatus := state.Status
 	settleOrderStatus(state, om.mc, ORDER_FROM_FILL)
+	if (keptStatus == types.ORDER_EXPIRE || keptStatus == types.ORDER_SOFT_CANCEL) && state.Status != types.ORDER_FINISHED {
+		state.Status = keptStatus
+	}
 
 	// update rds.Order
 	if err := model.ConvertDown(state); err != nil {
@@ -280,6 +310,8 @@ func (om *OrderManagerImpl) handleOrderFilled(input eventemitter.EventData) erro
 	if err := om.rds.UpdateOrderWhileFill(state.RawOrder.Hash, state.Status, state.DealtAmountS, state.DealtAmountB, state.SplitAmountS, state.SplitAmountB, state.UpdatedBlock); err != nil {
 		return err
 	}
+	om.book.Put(model)
+	emitOrdersUpdated(*model)
 
 	return nil
 }
@@ -307,6 +339,10 @@ func (om *OrderManagerImpl) handleOrderCancelled(input eventemitter.EventData) e
 	// get rds.Order and types.OrderState
 	state := &types.OrderState{}
 	model, err := om.rds.GetOrderByHash(event.OrderHash)
+	if gorm.ErrRecordNotFound == err {
+		log.Debugf("order manager,handle order cancelled event,order %s isn't submitted to this relay", event.OrderHash.Hex())
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -323,8 +359,12 @@ func (om *OrderManagerImpl) handleOrderCancelled(input eventemitter.EventData) e
 		log.Debugf("order manager,handle order cancelled event,order:%s cancelled amounts:%s", state.RawOrder.Hash.Hex(), state.CancelledAmountS.String())
 	}
 
-	// update order status
+	// update order status, the expired or soft cancelled order keeps its status unless it's cancelled
+	keptStatus := state.Status
 	settleOrderStatus(state, om.mc, ORDER_FROM_CANCEL)
+	if (keptStatus == types.ORDER_EXPIRE || keptStatus == types.ORDER_SOFT_CANCEL) && state.Status != types.ORDER_CANCEL {
+		state.Status = keptStatus
+	}
 	state.UpdatedBlock = event.BlockNumber
 
 	// update rds.Order
@@ -334,6 +374,8 @@ func (om *OrderManagerImpl) handleOrderCancelled(input eventemitter.EventData) e
 	if err := om.rds.UpdateOrderWhileCancel(state.RawOrder.Hash, state.Status, state.CancelledAmountS, state.CancelledAmountB, state.UpdatedBlock); err != nil {
 		return err
 	}
+	om.book.Put(model)
+	emitOrdersUpdated(*model)
 
 	return nil
 }
@@ -369,6 +411,8 @@ func (om *OrderManagerImpl) handleCutoff(input eventemitter.EventData) error {
 				orderHashList = append(orderHashList, state.RawOrder.Hash)
 			}
 			om.rds.SetCutOffOrders(orderHashList, evt.BlockNumber)
+			om.book.Remove(orderHashList...)
+			emitOrdersUpdated(orders...)
 		}
 		log.Debugf("order manager,handle cutoff event, owner:%s, cutoffTimestamp:%s", evt.Owner.Hex(), evt.Cutoff.String())
 	}
@@ -411,6 +455,8 @@ func (om *OrderManagerImpl) handleCutoffPair(input eventemitter.EventData) error
 				orderHashList = append(orderHashList, state.RawOrder.Hash)
 			}
 			om.rds.SetCutOffOrders(orderHashList, evt.BlockNumber)
+			om.book.Remove(orderHashList...)
+			emitOrdersUpdated(orders...)
 		}
 		log.Debugf("order manager,handle cutoffPair event, owner:%s, token1:%s, token2:%s, cutoffTimestamp:%s", evt.Owner.Hex(), evt.Token1.Hex(), evt.Token2.Hex(), evt.Cutoff.String())
 	}
@@ -444,11 +490,7 @@ func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address,
 	//	return list
 	//}
 
-	var (
-		modelList    []*dao.Order
-		err          error
-		filterStatus = []types.OrderStatus{types.ORDER_FINISHED, types.ORDER_CUTOFF, types.ORDER_CANCEL}
-	)
+	var err error
 
 	for _, orderDelay := range filterOrderHashLists {
 		orderHashes := []string{}
@@ -458,19 +500,14 @@ func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address,
 		if len(orderHashes) > 0 && orderDelay.DelayedCount != 0 {
 			if err = om.rds.MarkMinerOrders(orderHashes, orderDelay.DelayedCount); err != nil {
 				log.Debugf("order manager,provide orders for miner error:%s", err.Error())
+			} else {
+				om.book.MarkMinerOrders(orderDelay.OrderHash, orderDelay.DelayedCount)
 			}
 		}
 	}
 
-	// 从数据库获取订单
-	if modelList, err = om.rds.GetOrdersForMiner(protocol.Hex(), tokenS.Hex(), tokenB.Hex(), length, filterStatus, reservedTime, startBlockNumber, endBlockNumber); err != nil {
-		log.Errorf("err:%s", err.Error())
-		return list
-	}
-
-	for _, v := range modelList {
-		state := &types.OrderState{}
-		v.ConvertUp(state)
+	// 从内存订单簿获取订单
+	for _, state := range om.book.MinerOrders(protocol, tokenS, tokenB, length, reservedTime, startBlockNumber, endBlockNumber) {
 		if om.um.InWhiteList(state.RawOrder.Owner) {
 			list = append(list, state)
 		} else {
@@ -482,21 +519,7 @@ func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address,
 }
 
 func (om *OrderManagerImpl) GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]types.OrderState, error) {
-	var list []types.OrderState
-	models, err := om.rds.GetOrderBook(protocol, tokenS, tokenB, length)
-	if err != nil {
-		return list, err
-	}
-
-	for _, v := range models {
-		var state types.OrderState
-		if err := v.ConvertUp(&state); err != nil {
-			continue
-		}
-		list = append(list, state)
-	}
-
-	return list, nil
+	return om.book.GetOrderBook(protocol, tokenS, tokenB, length), nil
 }
 
 func (om *OrderManagerImpl) GetOrders(query map[string]interface{}, statusList []types.OrderStatus, pageIndex, pageSize int) (dao.PageResult, error) {
@@ -567,13 +590,16 @@ func (om *OrderManagerImpl) IsOrderCutoff(protocol, owner, token1, token2 common
 }
 
 func (om *OrderManagerImpl) GetFrozenAmount(owner common.Address, token common.Address, statusSet []types.OrderStatus, delegateAddress common.Address) (*big.Int, error) {
+	totalAmount := big.NewInt(0)
+	statusSet = unexpiredStatus(statusSet)
+	if len(statusSet) == 0 {
+		return totalAmount, nil
+	}
 	orderList, err := om.rds.GetFrozenAmount(owner, token, statusSet, delegateAddress)
 	if err != nil {
 		return nil, err
 	}
 
-	totalAmount := big.NewInt(0)
-
 	if len(orderList) == 0 {
 		return totalAmount, nil
 	}
@@ -591,13 +617,16 @@ func (om *OrderManagerImpl) GetFrozenAmount(owner common.Address, token common.A
 }
 
 func (om *OrderManagerImpl) GetFrozenLRCFee(owner common.Address, statusSet []types.OrderStatus) (*big.Int, error) {
+	totalAmount := big.NewInt(0)
+	statusSet = unexpiredStatus(statusSet)
+	if len(statusSet) == 0 {
+		return totalAmount, nil
+	}
 	orderList, err := om.rds.GetFrozenLrcFee(owner, statusSet)
 	if err != nil {
 		return nil, err
 	}
 
-	totalAmount := big.NewInt(0)
-
 	if len(orderList) == 0 {
 		return totalAmount, nil
 	}
@@ -609,3 +638,19 @@ func (om *OrderManagerImpl) GetFrozenLRCFee(owner common.Address, statusSet []ty
 
 	return totalAmount, nil
 }
+
+// GetOpenOrderCount returns the number of orders that can still be filled or are pending
+func (om *OrderManagerImpl) GetOpenOrderCount(owner common.Address) (int, error) {
+	return om.rds.CountOpenOrders(owner, expirableStatus)
+}
+
+// the expired orders don't freeze any amount, neither do the orders past ValidUntil which haven't been swept
+func unexpiredStatus(statusSet []types.OrderStatus) []types.OrderStatus {
+	list := []types.OrderStatus{}
+	for _, status := range statusSet {
+		if status != types.ORDER_EXPIRE {
+			list = append(list, status)
+		}
+	}
+	return list
+}
@@ -0,0 +1,72 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SoftCancelOrders marks the opened orders of owner as ORDER_SOFT_CANCEL and removes them from the book,
+// it returns the orders cancelled, the others aren't owned by owner, don't exist or have been closed.
+// The status is kept until the order is cancelled or finished on chain.
+func (om *OrderManagerImpl) SoftCancelOrders(owner common.Address, orderHashes []common.Hash) ([]common.Hash, error) {
+	cancelledHashes := []common.Hash{}
+	cancelledModels := []dao.Order{}
+	for _, orderHash := range orderHashes {
+		model, err := om.rds.GetOrderByHash(orderHash)
+		if nil != err {
+			log.Debugf("order manager,soft cancel order:%s error:%s", orderHash.Hex(), err.Error())
+			continue
+		}
+		cancelled, err := om.rds.SoftCancelOrder(orderHash, owner, expirableStatus)
+		if nil != err {
+			return cancelledHashes, err
+		} else if !cancelled {
+			continue
+		}
+
+		cancelledHashes = append(cancelledHashes, orderHash)
+		om.book.Remove(orderHash)
+		cancelledModels = append(cancelledModels, *model)
+		eventemitter.Emit(eventemitter.OrderSoftCancelled, &types.OrderSoftCancelledEvent{
+			OrderHash:       orderHash,
+			Owner:           owner,
+			DelegateAddress: common.HexToAddress(model.DelegateAddress),
+			Market:          model.Market,
+		})
+	}
+
+	emitOrdersUpdated(cancelledModels...)
+	if len(cancelledHashes) > 0 {
+		log.Debugf("order manager,%d orders of %s have been soft cancelled", len(cancelledHashes), owner.Hex())
+	}
+	return cancelledHashes, nil
+}
+
+// handleOrderSoftCancelled removes the order from the book of relays sharing the event bus,
+// the others remove it when the book is reconciled with db.
+func (om *OrderManagerImpl) handleOrderSoftCancelled(input eventemitter.EventData) error {
+	event := input.(*types.OrderSoftCancelledEvent)
+	om.book.Remove(event.OrderHash)
+	return nil
+}
@@ -84,7 +84,7 @@ func (tm *TransactionManager) Start() {
 	eventemitter.On(eventemitter.OrderFilled, tm.orderFilledEventWatcher)
 
 	tm.forkDetectedEventWatcher = &eventemitter.Watcher{Concurrent: false, Handle: tm.ForkProcess}
-	eventemitter.On(eventemitter.ChainForkDetected, tm.forkDetectedEventWatcher)
+	eventemitter.On(eventemitter.ChainReorg, tm.forkDetectedEventWatcher)
 }
 
 func (tm *TransactionManager) Stop() {
@@ -97,7 +97,7 @@ func (tm *TransactionManager) Stop() {
 	eventemitter.Un(eventemitter.Transfer, tm.transferEventWatcher)
 	eventemitter.Un(eventemitter.EthTransferEvent, tm.ethTransferEventWatcher)
 	eventemitter.Un(eventemitter.OrderFilled, tm.orderFilledEventWatcher)
-	eventemitter.Un(eventemitter.ChainForkDetected, tm.forkDetectedEventWatcher)
+	eventemitter.Un(eventemitter.ChainReorg, tm.forkDetectedEventWatcher)
 }
 
 // todo: check and test
@@ -105,9 +105,9 @@ func (tm *TransactionManager) ForkProcess(input eventemitter.EventData) error {
 	log.Debugf("txmanager,processing chain fork......")
 
 	tm.Stop()
-	forkEvent := input.(*types.ForkedEvent)
-	from := forkEvent.ForkBlock.Int64()
-	to := forkEvent.DetectedBlock.Int64()
+	forkEvent := input.(*types.ChainReorgEvent)
+	from := forkEvent.CommonAncestor.Int64()
+	to := forkEvent.OrphanedTo.Int64()
 	if err := tm.db.RollBackTxEntity(from, to); err != nil {
 		log.Debugf("txmanager,process fork error:%s", err.Error())
 	}
@@ -387,7 +387,7 @@ func (tm *TransactionManager) addView(tx *txtyp.TransactionView) error {
 		return err
 	}
 
-	eventemitter.Emit(eventemitter.TransactionEvent, &tx)
+	eventemitter.Emit(eventemitter.TransactionEvent, tx)
 	return nil
 }
 
@@ -30,6 +30,8 @@ const (
 	TX_STATUS_PENDING TxStatus = 1
 	TX_STATUS_SUCCESS TxStatus = 2
 	TX_STATUS_FAILED  TxStatus = 3
+
+	TX_STATUS_REPLACED TxStatus = 4 //the pending tx has been replaced by another one of the same nonce
 )
 
 func StatusStr(status TxStatus) string {
@@ -41,6 +43,8 @@ func StatusStr(status TxStatus) string {
 		ret = "success"
 	case TX_STATUS_FAILED:
 		ret = "failed"
+	case TX_STATUS_REPLACED:
+		ret = "replaced"
 	default:
 		ret = "unknown"
 	}
@@ -57,6 +61,8 @@ func StrToTxStatus(txType string) TxStatus {
 		ret = TX_STATUS_SUCCESS
 	case "failed":
 		ret = TX_STATUS_FAILED
+	case "replaced":
+		ret = TX_STATUS_REPLACED
 	default:
 		ret = TX_STATUS_UNKNOWN
 	}
@@ -65,8 +71,8 @@ func StrToTxStatus(txType string) TxStatus {
 }
 
 type TxInfo struct {
-	Protocol        common.Address `json:"from"`
-	DelegateAddress common.Address `json:"to"`
+	Protocol        common.Address `json:"protocol"`
+	DelegateAddress common.Address `json:"delegate_address"`
 	From            common.Address `json:"from"`
 	To              common.Address `json:"to"`
 	BlockHash       common.Hash    `json:"block_hash"`
@@ -208,11 +214,17 @@ type RingSubmitResultEvent struct {
 	Err          error
 }
 
-type ForkedEvent struct {
-	DetectedBlock *big.Int
-	DetectedHash  common.Hash
-	ForkBlock     *big.Int
-	ForkHash      common.Hash
+// ChainReorgEvent is emitted once for each reorg, the blocks in (CommonAncestor, OrphanedTo] have been orphaned
+// and CanonicalBlocks replace them, they are extracted again after the event has been handled.
+type ChainReorgEvent struct {
+	CommonAncestor     *big.Int
+	CommonAncestorHash common.Hash
+	OrphanedFrom       *big.Int
+	OrphanedTo         *big.Int
+	OrphanedBlocks     []Block
+	CanonicalBlocks    []Block
+	DetectedBlock      *big.Int
+	DetectedHash       common.Hash
 }
 
 type BlockEvent struct {
@@ -232,6 +244,27 @@ type DepthUpdateEvent struct {
 	Market          string
 }
 
+type OrderUpdateEvent struct {
+	OrderHash       string
+	Owner           string
+	DelegateAddress string
+	Market          string
+}
+
+type OrderExpiredEvent struct {
+	OrderHash       common.Hash
+	Owner           common.Address
+	DelegateAddress common.Address
+	Market          string
+}
+
+type OrderSoftCancelledEvent struct {
+	OrderHash       common.Hash
+	Owner           common.Address
+	DelegateAddress common.Address
+	Market          string
+}
+
 type BalanceUpdateEvent struct {
 	DelegateAddress string
 	Owner           string
@@ -38,6 +38,7 @@ const (
 	ORDER_EXPIRE   OrderStatus = 6
 	ORDER_PENDING  OrderStatus = 7
 	ORDER_PENDING_FOR_P2P  OrderStatus = 17
+	ORDER_SOFT_CANCEL OrderStatus = 18 // cancelled by the owner off-chain, it isn't matched any more until the cancel on chain lands
 	//ORDER_BALANCE_INSUFFICIENT   OrderStatus = 7
 	//ORDER_ALLOWANCE_INSUFFICIENT OrderStatus = 8
 
@@ -51,6 +52,7 @@ type Order struct {
 	DelegateAddress       common.Address             `json:"delegateAddress" gencodec:"required"` // 智能合约地址
 	AuthAddr              common.Address             `json:"authAddr" gencodec:"required"`        //
 	AuthPrivateKey        crypto.EthPrivateKeyCrypto `json:"authPrivateKey" gencodec:"required"`  //
+	SealedAuthPrivateKey  string                     `json:"-"`                                   // auth private key loaded from db, it's opened only before signing the ring
 	WalletAddress         common.Address             `json:"walletAddress" gencodec:"required"`
 	TokenS                common.Address             `json:"tokenS" gencodec:"required"`     // 卖出erc20代币智能合约地址
 	TokenB                common.Address             `json:"tokenB" gencodec:"required"`     // 买入erc20代币智能合约地址
@@ -178,6 +178,7 @@ type RingSubmitInfo struct {
 	ProtocolGas      *big.Int
 	ProtocolUsedGas  *big.Int
 	ProtocolGasPrice *big.Int
+	ProtocolNonce    *big.Int
 
 	SubmitTxHash common.Hash
 }